@@ -5,6 +5,7 @@ import (
 	"flag"
 	"fmt"
 	"io"
+	"math"
 	"net"
 	"os"
 	"strconv"
@@ -19,8 +20,66 @@ var (
 	defaultLogDir   = "./"
 	defaultLogLevel = "info"
 	defaultShardNum = 1024
+	defaultDir      = "./"
+	// script time limit in milliseconds
+	defaultLuaTimeLimit = 5000
+
+	// thresholds of the compact encodings of small collections, the same as redis
+	defaultHashMaxListpackEntries = 128
+	defaultHashMaxListpackValue   = 64
+	defaultSetMaxIntsetEntries    = 512
+	defaultSetMaxListpackEntries  = 128
+	defaultSetMaxListpackValue    = 64
+	defaultZSetMaxListpackEntries = 128
+	defaultZSetMaxListpackValue   = 64
+	// a positive size is the max number of entries in a list node, -1 to -5 limit the node to 4kb to 64kb
+	defaultListMaxListpackSize = -2
+	// number of nodes at each end of a list that are not compressed, 0 disables the compression
+	defaultListCompressDepth = 0
+
+	// limits of the client protocol and buffers in bytes, the same as redis
+	defaultProtoMaxBulkLen        = 512 << 20
+	defaultClientQueryBufferLimit = 1 << 30
+
+	// connection management, the same as redis.
+	// timeout is the idle seconds before a client is closed, 0 disables it, tcp-keepalive is in seconds
+	defaultMaxClients   = 10000
+	defaultTimeout      = 0
+	defaultTCPKeepAlive = 300
+	defaultTCPBacklog   = 511
+
+	// commands slower than this number of microseconds are logged in the slow log, a negative number disables it
+	defaultSlowlogLogSlowerThan = 10000
+	defaultSlowlogMaxLen        = 128
 )
 
+// OutputBufferLimit is the output buffer limit of a class of clients in bytes.
+// A client is disconnected once its output buffer exceeds Hard, or exceeds Soft for SoftSeconds seconds.
+// 0 disables a limit.
+type OutputBufferLimit struct {
+	Hard        int
+	Soft        int
+	SoftSeconds int
+}
+
+// client classes of client-output-buffer-limit.
+// Only the normal class is enforced: there are no replica or pubsub clients yet,
+// so the limits of those classes are parsed and kept but never applied.
+const (
+	ClientClassNormal  = "normal"
+	ClientClassReplica = "replica"
+	ClientClassPubSub  = "pubsub"
+)
+
+// defaultOutputBufferLimits returns the default output buffer limits of redis
+func defaultOutputBufferLimits() map[string]OutputBufferLimit {
+	return map[string]OutputBufferLimit{
+		ClientClassNormal:  {},
+		ClientClassReplica: {Hard: 256 << 20, Soft: 64 << 20, SoftSeconds: 60},
+		ClientClassPubSub:  {Hard: 32 << 20, Soft: 8 << 20, SoftSeconds: 60},
+	}
+}
+
 type Config struct {
 	ConfFile string
 	Host     string
@@ -28,6 +87,51 @@ type Config struct {
 	LogDir   string
 	LogLevel string
 	ShardNum int
+	// directory of the files kept across restarts, such as function libraries
+	Dir string
+
+	// max execution time of a lua script in milliseconds, 0 means no limit
+	LuaTimeLimit int
+
+	// collections are stored in compact encodings until they have more entries
+	// or longer values than these thresholds
+	HashMaxListpackEntries int
+	HashMaxListpackValue   int
+	SetMaxIntsetEntries    int
+	SetMaxListpackEntries  int
+	SetMaxListpackValue    int
+	ZSetMaxListpackEntries int
+	ZSetMaxListpackValue   int
+
+	// lists are stored as linked nodes of compact arrays limited by ListMaxListpackSize,
+	// the nodes more than ListCompressDepth away from both ends are compressed
+	ListMaxListpackSize int
+	ListCompressDepth   int
+
+	// max length of a bulk string in a request, and max size of a request being read
+	ProtoMaxBulkLen        int
+	ClientQueryBufferLimit int
+	// output buffer limits of the client classes, see OutputBufferLimit.
+	// The replica and pubsub classes are accepted like redis so a redis config file loads,
+	// but they are ignored since all the clients are normal ones for now
+	ClientOutputBufferLimits map[string]OutputBufferLimit
+
+	// max number of connected clients, seconds before an idle client is closed (0 disables it),
+	// period of the TCP keepalive probes in seconds (0 disables them) and backlog of the listening socket
+	MaxClients   int
+	Timeout      int
+	TCPKeepAlive int
+	TCPBacklog   int
+
+	// commands running longer than SlowlogLogSlowerThan microseconds are kept in the slow log
+	// of SlowlogMaxLen entries, a negative threshold disables it and 0 logs every command
+	SlowlogLogSlowerThan int
+	SlowlogMaxLen        int
+
+	// active-active replication
+	ActiveActive bool
+	NodeID       string
+	Peers        []string
 }
 
 type CfgError struct {
@@ -55,6 +159,31 @@ func Setup() (*Config, error) {
 		LogDir:   defaultLogDir,
 		LogLevel: defaultLogLevel,
 		ShardNum: defaultShardNum,
+		Dir:      defaultDir,
+
+		LuaTimeLimit: defaultLuaTimeLimit,
+
+		HashMaxListpackEntries: defaultHashMaxListpackEntries,
+		HashMaxListpackValue:   defaultHashMaxListpackValue,
+		SetMaxIntsetEntries:    defaultSetMaxIntsetEntries,
+		SetMaxListpackEntries:  defaultSetMaxListpackEntries,
+		SetMaxListpackValue:    defaultSetMaxListpackValue,
+		ZSetMaxListpackEntries: defaultZSetMaxListpackEntries,
+		ZSetMaxListpackValue:   defaultZSetMaxListpackValue,
+		ListMaxListpackSize:    defaultListMaxListpackSize,
+		ListCompressDepth:      defaultListCompressDepth,
+
+		ProtoMaxBulkLen:          defaultProtoMaxBulkLen,
+		ClientQueryBufferLimit:   defaultClientQueryBufferLimit,
+		ClientOutputBufferLimits: defaultOutputBufferLimits(),
+
+		MaxClients:   defaultMaxClients,
+		Timeout:      defaultTimeout,
+		TCPKeepAlive: defaultTCPKeepAlive,
+		TCPBacklog:   defaultTCPBacklog,
+
+		SlowlogLogSlowerThan: defaultSlowlogLogSlowerThan,
+		SlowlogMaxLen:        defaultSlowlogMaxLen,
 	}
 	flagInit(cfg)
 	flag.Parse()
@@ -76,6 +205,9 @@ func Setup() (*Config, error) {
 			return nil, portErr
 		}
 	}
+	if cfg.NodeID == "" {
+		cfg.NodeID = net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port))
+	}
 	Configures = cfg
 	return cfg, nil
 }
@@ -136,6 +268,75 @@ func (cfg *Config) Parse(cfgFile string) error {
 					fmt.Println("ShardNum should be a number. Get: ", fields[1])
 					panic(err)
 				}
+			} else if cfgName == "dir" {
+				cfg.Dir = fields[1]
+			} else if cfgName == "lua-time-limit" {
+				limit, err := strconv.Atoi(fields[1])
+				if err != nil || limit < 0 {
+					return &CfgError{
+						message: fmt.Sprintf("lua-time-limit should be a non-negative number, but %s is given.", fields[1]),
+					}
+				}
+				cfg.LuaTimeLimit = limit
+			} else if cfgName == "list-max-listpack-size" {
+				size, err := strconv.Atoi(fields[1])
+				if err != nil || size == 0 || size < -5 {
+					return &CfgError{
+						message: fmt.Sprintf("list-max-listpack-size should be a positive number or -1 to -5, but %s is given.", fields[1]),
+					}
+				}
+				cfg.ListMaxListpackSize = size
+			} else if cfgName == "proto-max-bulk-len" || cfgName == "client-query-buffer-limit" {
+				size, err := ParseMemory(fields[1])
+				if err != nil || size < 1<<20 {
+					return &CfgError{
+						message: fmt.Sprintf("%s should be at least 1mb, but %s is given.", cfgName, fields[1]),
+					}
+				}
+				if cfgName == "proto-max-bulk-len" {
+					cfg.ProtoMaxBulkLen = size
+				} else {
+					cfg.ClientQueryBufferLimit = size
+				}
+			} else if cfgName == "client-output-buffer-limit" {
+				if err := cfg.parseOutputBufferLimits(fields[1:]); err != nil {
+					return err
+				}
+			} else if cfgName == "maxclients" {
+				n, err := strconv.Atoi(fields[1])
+				if err != nil || n < 1 {
+					return &CfgError{
+						message: fmt.Sprintf("maxclients should be a positive number, but %s is given.", fields[1]),
+					}
+				}
+				cfg.MaxClients = n
+			} else if cfgName == "slowlog-log-slower-than" {
+				n, err := strconv.Atoi(fields[1])
+				if err != nil {
+					return &CfgError{
+						message: fmt.Sprintf("slowlog-log-slower-than should be a number, but %s is given.", fields[1]),
+					}
+				}
+				cfg.SlowlogLogSlowerThan = n
+			} else if limit, ok := cfg.nonNegativeOption(cfgName); ok {
+				n, err := strconv.Atoi(fields[1])
+				if err != nil || n < 0 {
+					return &CfgError{
+						message: fmt.Sprintf("%s should be a non-negative number, but %s is given.", cfgName, fields[1]),
+					}
+				}
+				*limit = n
+			} else if cfgName == "active-active" {
+				cfg.ActiveActive = strings.ToLower(fields[1]) == "yes"
+			} else if cfgName == "node-id" {
+				cfg.NodeID = fields[1]
+			} else if cfgName == "peer" {
+				if _, _, err := net.SplitHostPort(fields[1]); err != nil {
+					return &CfgError{
+						message: fmt.Sprintf("Given peer address %s is invalid", fields[1]),
+					}
+				}
+				cfg.Peers = append(cfg.Peers, fields[1])
 			}
 		}
 		if ioErr == io.EOF {
@@ -144,3 +345,95 @@ func (cfg *Config) Parse(cfgFile string) error {
 	}
 	return nil
 }
+
+// parseOutputBufferLimits parses the groups of <class> <hard> <soft> <seconds> of client-output-buffer-limit.
+// The replica and pubsub groups are validated and stored, but only the normal one is enforced.
+func (cfg *Config) parseOutputBufferLimits(args []string) error {
+	if len(args)%4 != 0 {
+		return &CfgError{
+			message: "client-output-buffer-limit should be groups of <class> <hard limit> <soft limit> <soft seconds>.",
+		}
+	}
+	if cfg.ClientOutputBufferLimits == nil {
+		cfg.ClientOutputBufferLimits = defaultOutputBufferLimits()
+	}
+	for i := 0; i < len(args); i += 4 {
+		class := strings.ToLower(args[i])
+		if class == "slave" {
+			class = ClientClassReplica
+		}
+		if _, ok := cfg.ClientOutputBufferLimits[class]; !ok {
+			return &CfgError{
+				message: fmt.Sprintf("Invalid client class %s in client-output-buffer-limit.", args[i]),
+			}
+		}
+		hard, err := ParseMemory(args[i+1])
+		if err != nil {
+			return &CfgError{message: fmt.Sprintf("Invalid hard limit %s in client-output-buffer-limit.", args[i+1])}
+		}
+		soft, err := ParseMemory(args[i+2])
+		if err != nil {
+			return &CfgError{message: fmt.Sprintf("Invalid soft limit %s in client-output-buffer-limit.", args[i+2])}
+		}
+		seconds, err := strconv.Atoi(args[i+3])
+		if err != nil || seconds < 0 {
+			return &CfgError{message: fmt.Sprintf("Invalid soft seconds %s in client-output-buffer-limit.", args[i+3])}
+		}
+		cfg.ClientOutputBufferLimits[class] = OutputBufferLimit{Hard: hard, Soft: soft, SoftSeconds: seconds}
+	}
+	return nil
+}
+
+// ParseMemory parses a non-negative size with an optional unit like redis,
+// k, m and g are powers of 1000, kb, mb and gb are powers of 1024
+func ParseMemory(s string) (int, error) {
+	lower := strings.ToLower(s)
+	units := []struct {
+		suffix string
+		mul    int
+	}{{"kb", 1 << 10}, {"mb", 1 << 20}, {"gb", 1 << 30}, {"k", 1000}, {"m", 1000 * 1000}, {"g", 1000 * 1000 * 1000}, {"b", 1}}
+	mul := 1
+	for _, unit := range units {
+		if strings.HasSuffix(lower, unit.suffix) {
+			lower, mul = strings.TrimSuffix(lower, unit.suffix), unit.mul
+			break
+		}
+	}
+	n, err := strconv.Atoi(lower)
+	if err != nil || n < 0 || n > math.MaxInt/mul {
+		return 0, fmt.Errorf("invalid memory size %s", s)
+	}
+	return n * mul, nil
+}
+
+// nonNegativeOption returns the field of a non-negative number option named name in the config file,
+// such as the threshold of a compact encoding
+func (cfg *Config) nonNegativeOption(name string) (*int, bool) {
+	switch name {
+	case "hash-max-listpack-entries":
+		return &cfg.HashMaxListpackEntries, true
+	case "hash-max-listpack-value":
+		return &cfg.HashMaxListpackValue, true
+	case "set-max-intset-entries":
+		return &cfg.SetMaxIntsetEntries, true
+	case "set-max-listpack-entries":
+		return &cfg.SetMaxListpackEntries, true
+	case "set-max-listpack-value":
+		return &cfg.SetMaxListpackValue, true
+	case "zset-max-listpack-entries":
+		return &cfg.ZSetMaxListpackEntries, true
+	case "zset-max-listpack-value":
+		return &cfg.ZSetMaxListpackValue, true
+	case "list-compress-depth":
+		return &cfg.ListCompressDepth, true
+	case "timeout":
+		return &cfg.Timeout, true
+	case "tcp-keepalive":
+		return &cfg.TCPKeepAlive, true
+	case "tcp-backlog":
+		return &cfg.TCPBacklog, true
+	case "slowlog-max-len":
+		return &cfg.SlowlogMaxLen, true
+	}
+	return nil, false
+}
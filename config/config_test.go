@@ -27,4 +27,35 @@ func TestConfig_Parse(t *testing.T) {
 	if cfg.ShardNum != 1024 {
 		t.Error(fmt.Sprintf("cfg.ShardNum == %d, expect 1024", cfg.ShardNum))
 	}
+	if cfg.HashMaxListpackEntries != 64 {
+		t.Error(fmt.Sprintf("cfg.HashMaxListpackEntries == %d, expect 64", cfg.HashMaxListpackEntries))
+	}
+	if cfg.ProtoMaxBulkLen != 64<<20 {
+		t.Error(fmt.Sprintf("cfg.ProtoMaxBulkLen == %d, expect 64mb", cfg.ProtoMaxBulkLen))
+	}
+	limits := cfg.ClientOutputBufferLimits
+	if limits[ClientClassNormal] != (OutputBufferLimit{Hard: 1 << 20, Soft: 512 << 10, SoftSeconds: 10}) ||
+		limits[ClientClassPubSub] != (OutputBufferLimit{Hard: 64 << 20, Soft: 16000000, SoftSeconds: 30}) ||
+		limits[ClientClassReplica] != (OutputBufferLimit{Hard: 256 << 20, Soft: 64 << 20, SoftSeconds: 60}) {
+		t.Error(fmt.Sprintf("cfg.ClientOutputBufferLimits == %v", limits))
+	}
+	if cfg.MaxClients != 128 || cfg.Timeout != 300 {
+		t.Error(fmt.Sprintf("cfg.MaxClients == %d, cfg.Timeout == %d, expect 128 and 300", cfg.MaxClients, cfg.Timeout))
+	}
+	if cfg.SlowlogLogSlowerThan != -1 {
+		t.Error(fmt.Sprintf("cfg.SlowlogLogSlowerThan == %d, expect -1", cfg.SlowlogLogSlowerThan))
+	}
+}
+
+func TestParseMemory(t *testing.T) {
+	for s, expect := range map[string]int{"100": 100, "1k": 1000, "1KB": 1024, "2mb": 2 << 20, "3g": 3e9, "5b": 5} {
+		if n, err := ParseMemory(s); err != nil || n != expect {
+			t.Errorf("ParseMemory(%s) == %d, %v, expect %d", s, n, err, expect)
+		}
+	}
+	for _, s := range []string{"", "mb", "-1", "1tb", "9999999999gb", "9223372036854775807k"} {
+		if _, err := ParseMemory(s); err == nil {
+			t.Errorf("ParseMemory(%s) should fail", s)
+		}
+	}
 }
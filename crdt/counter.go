@@ -0,0 +1,107 @@
+package crdt
+
+// CounterEntry is the state of one replica inside a PNCounter.
+// P is the total of increments and N the total of decrements made by Node.
+type CounterEntry struct {
+	Node string
+	P, N int64
+}
+
+// PNCounter is a state based positive-negative counter.
+// Every replica only grows its own P and N totals, so merging is a per replica max and
+// delivering the same entry twice is harmless.
+// Reset is observed-remove: it only cancels the totals the resetting replica has seen,
+// increments made concurrently by other replicas survive the reset.
+type PNCounter struct {
+	p, n   map[string]int64
+	rp, rn map[string]int64
+}
+
+func NewPNCounter() *PNCounter {
+	return &PNCounter{
+		p:  make(map[string]int64),
+		n:  make(map[string]int64),
+		rp: make(map[string]int64),
+		rn: make(map[string]int64),
+	}
+}
+
+// Incr returns the entry of node after adding delta, without changing the counter.
+// The entry is meant to be applied with Merge on every replica including the local one.
+func (c *PNCounter) Incr(node string, delta int64) CounterEntry {
+	e := CounterEntry{Node: node, P: c.p[node], N: c.n[node]}
+	if delta >= 0 {
+		e.P += delta
+	} else {
+		e.N -= delta
+	}
+	return e
+}
+
+// Merge merges the entry of a replica, keeping the max of each total.
+func (c *PNCounter) Merge(e CounterEntry) {
+	if e.P > c.p[e.Node] {
+		c.p[e.Node] = e.P
+	}
+	if e.N > c.n[e.Node] {
+		c.n[e.Node] = e.N
+	}
+}
+
+// Snapshot returns the entries observed by this replica, it is the argument of Reset.
+func (c *PNCounter) Snapshot() []CounterEntry {
+	res := make([]CounterEntry, 0, len(c.p)+len(c.n))
+	seen := make(map[string]struct{})
+	for node := range c.p {
+		seen[node] = struct{}{}
+	}
+	for node := range c.n {
+		seen[node] = struct{}{}
+	}
+	for node := range seen {
+		res = append(res, CounterEntry{Node: node, P: c.p[node], N: c.n[node]})
+	}
+	return res
+}
+
+// Reset cancels the observed entries.
+func (c *PNCounter) Reset(observed []CounterEntry) {
+	for _, e := range observed {
+		if e.P > c.rp[e.Node] {
+			c.rp[e.Node] = e.P
+		}
+		if e.N > c.rn[e.Node] {
+			c.rn[e.Node] = e.N
+		}
+	}
+}
+
+func (c *PNCounter) Value() int64 {
+	var res int64
+	for node, p := range c.p {
+		if p > c.rp[node] {
+			res += p - c.rp[node]
+		}
+	}
+	for node, n := range c.n {
+		if n > c.rn[node] {
+			res -= n - c.rn[node]
+		}
+	}
+	return res
+}
+
+// Live returns true if the counter has increments or decrements that were not reset.
+func (c *PNCounter) Live() bool {
+	for node, p := range c.p {
+		if p > c.rp[node] {
+			return true
+		}
+	}
+	for node, n := range c.n {
+		if n > c.rn[node] {
+			return true
+		}
+	}
+	return false
+}
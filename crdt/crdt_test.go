@@ -0,0 +1,254 @@
+package crdt
+
+import (
+	"easyRedis/config"
+	"easyRedis/logger"
+	"easyRedis/resp"
+	"net"
+	"os"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestClock(t *testing.T) {
+	c := NewClock("a")
+	c.physical = func() int64 { return 100 }
+	t1 := c.Now()
+	t2 := c.Now()
+	if t2.Compare(t1) <= 0 {
+		t.Error("clock is not monotonic")
+	}
+
+	// a remote timestamp ahead of the physical time pushes the clock forward
+	remote := Timestamp{Wall: 200, Logical: 5, Node: "b"}
+	c.Update(remote)
+	if t3 := c.Now(); t3.Compare(remote) <= 0 {
+		t.Errorf("clock %v is not after remote %v", t3, remote)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	r := &Register{}
+	ts1 := Timestamp{Wall: 1, Node: "a"}
+	ts2 := Timestamp{Wall: 1, Node: "b"}
+	if !r.Set([]byte("b"), ts2) || r.Set([]byte("a"), ts1) {
+		t.Error("older write should lose")
+	}
+	if string(r.Value) != "b" {
+		t.Errorf("register value %s, expect b", r.Value)
+	}
+	if r.Delete(ts1) || !r.Exist() {
+		t.Error("older delete should lose")
+	}
+	if !r.Delete(Timestamp{Wall: 2, Node: "a"}) || r.Exist() {
+		t.Error("newer delete should win")
+	}
+}
+
+func TestPNCounter(t *testing.T) {
+	a, b := NewPNCounter(), NewPNCounter()
+	ea := a.Incr("a", 3)
+	eb := b.Incr("b", -1)
+	a.Merge(ea)
+	b.Merge(eb)
+
+	// concurrent reset on a and increment on b
+	observed := a.Snapshot()
+	eb2 := b.Incr("b", 5)
+	b.Merge(eb2)
+	a.Reset(observed)
+
+	// deliver everything twice in different orders
+	for i := 0; i < 2; i++ {
+		a.Merge(eb)
+		a.Merge(eb2)
+		b.Reset(observed)
+		b.Merge(ea)
+	}
+	if a.Value() != b.Value() {
+		t.Errorf("counters diverged: %d != %d", a.Value(), b.Value())
+	}
+	if a.Value() != 4 {
+		t.Errorf("counter value %d, expect 4", a.Value())
+	}
+}
+
+func TestORSet(t *testing.T) {
+	a, b := NewORSet(), NewORSet()
+	// the tags whose add was received by a and b
+	addedA, addedB := map[string]bool{}, map[string]bool{}
+	add := func(s *ORSet, added map[string]bool, elem, tag string) {
+		s.Add(elem, tag)
+		added[tag] = true
+	}
+	inA := func(tag string) bool { return addedA[tag] }
+	inB := func(tag string) bool { return addedB[tag] }
+	add(a, addedA, "x", "a1")
+	add(b, addedB, "x", "a1")
+
+	// a removes x while b adds it again concurrently, the add wins
+	tags := a.Tags("x")
+	a.Remove("x", tags, inA)
+	add(b, addedB, "x", "b1")
+	add(a, addedA, "x", "b1")
+	b.Remove("x", tags, inB)
+	if !a.Has("x") || !b.Has("x") {
+		t.Error("concurrent add should win")
+	}
+	if a.Tombstones() != 0 || b.Tombstones() != 0 {
+		t.Errorf("tombstones %d and %d of received adds", a.Tombstones(), b.Tombstones())
+	}
+
+	// a remove received before the add it observed makes the add a no-op
+	a.Remove("x", []string{"b1", "c1"}, inA)
+	if a.Has("x") || a.Tombstones() != 1 {
+		t.Errorf("x is visible %v, %d tombstones, expect 1", a.Has("x"), a.Tombstones())
+	}
+	add(a, addedA, "x", "c1")
+	if a.Has("x") {
+		t.Error("removed tag should not be added")
+	}
+	if a.Tombstones() != 0 {
+		t.Errorf("%d tombstones after the add arrived", a.Tombstones())
+	}
+
+	// the tombstone of an add that will never arrive is dropped by the next remove
+	a.Remove("x", []string{"c2"}, inA)
+	addedA["c2"] = true
+	a.Remove("y", nil, inA)
+	if a.Tombstones() != 0 {
+		t.Errorf("%d tombstones of a lost add", a.Tombstones())
+	}
+
+	b.Add("y", "b2")
+	members := b.Members()
+	sort.Strings(members)
+	if len(members) != 2 || members[0] != "x" || members[1] != "y" {
+		t.Errorf("members %v, expect [x y]", members)
+	}
+}
+
+func TestVersionVector(t *testing.T) {
+	v := NewVersionVector()
+	ts := Timestamp{Wall: 10, Logical: 1, Node: "a"}
+	if v.Covers(ts) {
+		t.Error("empty vector covers a timestamp")
+	}
+	v.Observe(ts)
+	v.Observe(Timestamp{Wall: 5, Node: "a"})
+	if !v.Covers(ts) || !v.Covers(Timestamp{Wall: 10, Node: "a"}) {
+		t.Error("vector should cover the received timestamps of a")
+	}
+	if v.Covers(Timestamp{Wall: 10, Logical: 2, Node: "a"}) || v.Covers(Timestamp{Wall: 1, Node: "b"}) {
+		t.Error("vector covers a timestamp not received")
+	}
+}
+
+func TestOpCommand(t *testing.T) {
+	op := &Op{
+		Type: OpHSet,
+		Key:  "k",
+		TS:   Timestamp{Wall: 10, Logical: 2, Node: "a"},
+		Args: [][]byte{[]byte("f"), []byte("v")},
+	}
+	res, err := ParseOp(op.ToCommand())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Type != op.Type || res.Key != op.Key || res.TS != op.TS || len(res.Args) != 2 || string(res.Args[1]) != "v" {
+		t.Errorf("parsed op %v, expect %v", res, op)
+	}
+	if res.Tag() != "a@10.2" {
+		t.Errorf("tag %s, expect a@10.2", res.Tag())
+	}
+	if ts, ok := ParseTag("n@1@10.2"); !ok || ts != (Timestamp{Wall: 10, Logical: 2, Node: "n@1"}) {
+		t.Errorf("ParseTag %v, %v", ts, ok)
+	}
+	if _, ok := ParseTag("a10.2"); ok {
+		t.Error("ParseTag of an invalid tag")
+	}
+}
+
+// acceptPeer accepts a connection of a TCPPeer of node a and checks its hello, replying +OK to it
+func acceptPeer(t *testing.T, ln net.Listener) (net.Conn, *resp.Reader) {
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	reader := resp.NewReader(conn)
+	cmd, err := reader.ReadCommand()
+	if err != nil || len(cmd) != 2 || string(cmd[0]) != HelloCommand || string(cmd[1]) != "a" {
+		t.Fatalf("hello %q, %v", cmd, err)
+	}
+	_, _ = conn.Write([]byte("+OK\r\n"))
+	return conn, reader
+}
+
+// readMerge reads a crdt.merge command and returns the key of its op
+func readMerge(t *testing.T, reader *resp.Reader) string {
+	cmd, err := reader.ReadCommand()
+	if err != nil {
+		t.Fatal(err)
+	}
+	op, err := ParseOp(cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return op.Key
+}
+
+// an op is resent until the peer replies +OK to it
+func TestTCPPeerResend(t *testing.T) {
+	// the peer errors are logged
+	if err := logger.Setup(&config.Config{LogDir: os.TempDir()}); err == nil {
+		logger.Disable()
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	p := NewTCPPeer(ln.Addr().String(), "a")
+	defer p.Close()
+	p.Send(&Op{Type: OpSet, Key: "k1", TS: Timestamp{Wall: 1, Node: "a"}, Args: [][]byte{[]byte("v")}})
+
+	// the connection is closed before the reply, like an idle client closed by the peer
+	conn, reader := acceptPeer(t, ln)
+	if key := readMerge(t, reader); key != "k1" {
+		t.Errorf("merge of %s, expect k1", key)
+	}
+	_ = conn.Close()
+
+	// the op is rejected
+	conn, reader = acceptPeer(t, ln)
+	if key := readMerge(t, reader); key != "k1" {
+		t.Errorf("resent merge of %s, expect k1", key)
+	}
+	_, _ = conn.Write([]byte("-ERR rejected\r\n"))
+
+	conn, reader = acceptPeer(t, ln)
+	defer conn.Close()
+	if key := readMerge(t, reader); key != "k1" {
+		t.Errorf("resent merge of %s, expect k1", key)
+	}
+	_, _ = conn.Write([]byte("+OK\r\n"))
+	p.Send(&Op{Type: OpSet, Key: "k2", TS: Timestamp{Wall: 2, Node: "a"}, Args: [][]byte{[]byte("v")}})
+	if key := readMerge(t, reader); key != "k2" {
+		t.Errorf("merge of %s after an ack, expect k2", key)
+	}
+	_, _ = conn.Write([]byte("+OK\r\n"))
+	for i := 0; ; i++ {
+		p.mu.Lock()
+		n := len(p.pending)
+		p.mu.Unlock()
+		if n == 0 {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("%d ops still pending", n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
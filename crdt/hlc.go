@@ -0,0 +1,125 @@
+package crdt
+
+import (
+	"sync"
+	"time"
+)
+
+// Timestamp is a hybrid logical clock reading.
+// Wall is the physical time in milliseconds, Logical orders events inside the same millisecond
+// and Node breaks ties between replicas, so that all timestamps are totally ordered.
+type Timestamp struct {
+	Wall    int64
+	Logical int64
+	Node    string
+}
+
+// Compare returns -1 if t < o, 0 if t == o and 1 if t > o
+func (t Timestamp) Compare(o Timestamp) int {
+	switch {
+	case t.Wall < o.Wall:
+		return -1
+	case t.Wall > o.Wall:
+		return 1
+	case t.Logical < o.Logical:
+		return -1
+	case t.Logical > o.Logical:
+		return 1
+	case t.Node < o.Node:
+		return -1
+	case t.Node > o.Node:
+		return 1
+	}
+	return 0
+}
+
+func (t Timestamp) IsZero() bool {
+	return t.Wall == 0 && t.Logical == 0 && t.Node == ""
+}
+
+// Clock is a hybrid logical clock owned by one replica.
+type Clock struct {
+	mu       sync.Mutex
+	last     Timestamp
+	physical func() int64
+}
+
+func NewClock(node string) *Clock {
+	return &Clock{
+		last: Timestamp{Node: node},
+		physical: func() int64 {
+			return time.Now().UnixMilli()
+		},
+	}
+}
+
+// Now returns a timestamp for a local event, it is always greater than any timestamp seen before.
+func (c *Clock) Now() Timestamp {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	pt := c.physical()
+	if pt > c.last.Wall {
+		c.last.Wall = pt
+		c.last.Logical = 0
+	} else {
+		c.last.Logical++
+	}
+	return c.last
+}
+
+// Update moves the clock forward after receiving a remote timestamp.
+func (c *Clock) Update(remote Timestamp) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	pt := c.physical()
+	wall := c.last.Wall
+	if remote.Wall > wall {
+		wall = remote.Wall
+	}
+	if pt > wall {
+		wall = pt
+	}
+	switch {
+	case wall == c.last.Wall && wall == remote.Wall:
+		if remote.Logical > c.last.Logical {
+			c.last.Logical = remote.Logical
+		}
+		c.last.Logical++
+	case wall == c.last.Wall:
+		c.last.Logical++
+	case wall == remote.Wall:
+		c.last.Logical = remote.Logical + 1
+	default:
+		c.last.Logical = 0
+	}
+	c.last.Wall = wall
+}
+
+// VersionVector keeps the greatest timestamp received from each node.
+// The ops of a node are shipped in timestamp order, so an op not greater than the one
+// recorded for its node was already received.
+type VersionVector struct {
+	mu     sync.Mutex
+	latest map[string]Timestamp
+}
+
+func NewVersionVector() *VersionVector {
+	return &VersionVector{latest: make(map[string]Timestamp)}
+}
+
+// Covers returns true if the event at ts was already received
+func (v *VersionVector) Covers(ts Timestamp) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	latest, ok := v.latest[ts.Node]
+	return ok && ts.Compare(latest) <= 0
+}
+
+// Observe records the event at ts as received
+func (v *VersionVector) Observe(ts Timestamp) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if latest, ok := v.latest[ts.Node]; !ok || ts.Compare(latest) > 0 {
+		v.latest[ts.Node] = ts
+	}
+}
@@ -0,0 +1,137 @@
+package crdt
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// MergeCommand is the command name used to ship operations between replicas.
+const MergeCommand = "crdt.merge"
+
+// HelloCommand is the first command of a peer connection: crdt.hello node.
+// It marks the connection as the one of peer node, only such a connection may send MergeCommand.
+const HelloCommand = "crdt.hello"
+
+// Operation types replicated between peers.
+const (
+	OpSet     = "set"     // args: value, observed counter entries...
+	OpDel     = "del"     // args: observed counter entries...
+	OpCounter = "counter" // args: field, node, p, n
+	OpExpire  = "expire"  // args: unix deadline in seconds, 0 means persist
+	OpSAdd    = "sadd"    // args: member
+	OpSRem    = "srem"    // args: member, observed tags
+	OpHSet    = "hset"    // args: field, value, observed counter entries...
+	OpHDel    = "hdel"    // args: field, observed tags, observed counter entries...
+	OpZAdd    = "zadd"    // args: member, score
+	OpZRem    = "zrem"    // args: member, observed tags
+)
+
+// Op is an effect of a local write, it is applied on the local replica and on every peer.
+// Applying an Op is idempotent and commutative with any concurrent Op.
+type Op struct {
+	Type string
+	Key  string
+	TS   Timestamp
+	Args [][]byte
+}
+
+// Tag returns the unique tag of an op that adds an element to an ORSet.
+// The timestamp of an op is unique, so it is used as the tag.
+func (op *Op) Tag() string {
+	return op.TS.Node + "@" + strconv.FormatInt(op.TS.Wall, 10) + "." + strconv.FormatInt(op.TS.Logical, 10)
+}
+
+// ParseTag returns the timestamp of the op that made tag, see Op.Tag
+func ParseTag(tag string) (Timestamp, bool) {
+	at := strings.LastIndexByte(tag, '@')
+	dot := strings.LastIndexByte(tag, '.')
+	if at < 0 || dot < at {
+		return Timestamp{}, false
+	}
+	wall, err := strconv.ParseInt(tag[at+1:dot], 10, 64)
+	if err != nil {
+		return Timestamp{}, false
+	}
+	logical, err := strconv.ParseInt(tag[dot+1:], 10, 64)
+	if err != nil {
+		return Timestamp{}, false
+	}
+	return Timestamp{Wall: wall, Logical: logical, Node: tag[:at]}, true
+}
+
+// ToCommand encodes the op as a command: crdt.merge type key node wall logical args...
+func (op *Op) ToCommand() [][]byte {
+	cmd := make([][]byte, 0, 6+len(op.Args))
+	cmd = append(cmd,
+		[]byte(MergeCommand),
+		[]byte(op.Type),
+		[]byte(op.Key),
+		[]byte(op.TS.Node),
+		[]byte(strconv.FormatInt(op.TS.Wall, 10)),
+		[]byte(strconv.FormatInt(op.TS.Logical, 10)),
+	)
+	return append(cmd, op.Args...)
+}
+
+// ParseOp decodes an op from a crdt.merge command
+func ParseOp(cmd [][]byte) (*Op, error) {
+	if len(cmd) < 6 || strings.ToLower(string(cmd[0])) != MergeCommand {
+		return nil, errors.New("invalid crdt.merge command")
+	}
+	wall, err := strconv.ParseInt(string(cmd[4]), 10, 64)
+	if err != nil {
+		return nil, errors.New("invalid crdt.merge timestamp")
+	}
+	logical, err := strconv.ParseInt(string(cmd[5]), 10, 64)
+	if err != nil {
+		return nil, errors.New("invalid crdt.merge timestamp")
+	}
+	return &Op{
+		Type: string(cmd[1]),
+		Key:  string(cmd[2]),
+		TS: Timestamp{
+			Wall:    wall,
+			Logical: logical,
+			Node:    string(cmd[3]),
+		},
+		Args: cmd[6:],
+	}, nil
+}
+
+// EncodeTags joins tags into a single argument
+func EncodeTags(tags []string) []byte {
+	return []byte(strings.Join(tags, ","))
+}
+
+func DecodeTags(arg []byte) []string {
+	if len(arg) == 0 {
+		return nil
+	}
+	return strings.Split(string(arg), ",")
+}
+
+// EncodeCounter encodes counter entries as node, p, n triples
+func EncodeCounter(entries []CounterEntry) [][]byte {
+	res := make([][]byte, 0, 3*len(entries))
+	for _, e := range entries {
+		res = append(res, []byte(e.Node), []byte(strconv.FormatInt(e.P, 10)), []byte(strconv.FormatInt(e.N, 10)))
+	}
+	return res
+}
+
+func DecodeCounter(args [][]byte) ([]CounterEntry, error) {
+	if len(args)%3 != 0 {
+		return nil, errors.New("invalid counter entries")
+	}
+	res := make([]CounterEntry, 0, len(args)/3)
+	for i := 0; i < len(args); i += 3 {
+		p, err1 := strconv.ParseInt(string(args[i+1]), 10, 64)
+		n, err2 := strconv.ParseInt(string(args[i+2]), 10, 64)
+		if err1 != nil || err2 != nil {
+			return nil, errors.New("invalid counter entries")
+		}
+		res = append(res, CounterEntry{Node: string(args[i]), P: p, N: n})
+	}
+	return res, nil
+}
@@ -0,0 +1,93 @@
+package crdt
+
+// ORSet is an observed-remove set with add-wins semantics.
+// Each add attaches a unique tag to the element, a remove only deletes the tags it has observed,
+// so an add concurrent with a remove keeps the element.
+// A remove can arrive before the add of a tag it observed, when the remove comes from a third replica.
+// Such a tag is kept as a tombstone, which makes the late add a no-op, until the add arrives.
+// The tags whose add was already received are never kept, so tombstones only cover adds in flight.
+type ORSet struct {
+	entries map[string]map[string]struct{}
+	removed map[string]struct{}
+}
+
+func NewORSet() *ORSet {
+	return &ORSet{
+		entries: make(map[string]map[string]struct{}),
+		removed: make(map[string]struct{}),
+	}
+}
+
+// Add adds elem with tag, returns true if elem was not visible before.
+// The add of a tag is received once, a duplicate must be filtered by the caller.
+func (s *ORSet) Add(elem, tag string) bool {
+	if _, ok := s.removed[tag]; ok {
+		delete(s.removed, tag)
+		return false
+	}
+	tags, ok := s.entries[elem]
+	if !ok {
+		tags = make(map[string]struct{})
+		s.entries[elem] = tags
+	}
+	tags[tag] = struct{}{}
+	return !ok
+}
+
+// Remove deletes the observed tags of elem, returns true if elem is not visible anymore.
+// added returns true if the add of a tag was already received, the other tags are kept as tombstones.
+// The tombstones whose add will never arrive anymore, because it was lost, are dropped too.
+func (s *ORSet) Remove(elem string, tags []string, added func(tag string) bool) bool {
+	for tag := range s.removed {
+		if added(tag) {
+			delete(s.removed, tag)
+		}
+	}
+	live := s.entries[elem]
+	for _, tag := range tags {
+		if _, ok := live[tag]; ok {
+			delete(live, tag)
+		} else if !added(tag) {
+			s.removed[tag] = struct{}{}
+		}
+	}
+	if live == nil {
+		return false
+	}
+	if len(live) == 0 {
+		delete(s.entries, elem)
+		return true
+	}
+	return false
+}
+
+// Tags returns the live tags of elem
+func (s *ORSet) Tags(elem string) []string {
+	res := make([]string, 0, len(s.entries[elem]))
+	for tag := range s.entries[elem] {
+		res = append(res, tag)
+	}
+	return res
+}
+
+func (s *ORSet) Has(elem string) bool {
+	_, ok := s.entries[elem]
+	return ok
+}
+
+func (s *ORSet) Members() []string {
+	res := make([]string, 0, len(s.entries))
+	for elem := range s.entries {
+		res = append(res, elem)
+	}
+	return res
+}
+
+func (s *ORSet) Len() int {
+	return len(s.entries)
+}
+
+// Tombstones returns the number of removed tags whose add is not received yet
+func (s *ORSet) Tombstones() int {
+	return len(s.removed)
+}
@@ -0,0 +1,200 @@
+package crdt
+
+import (
+	"bufio"
+	"easyRedis/logger"
+	"easyRedis/resp"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Peer receives the ops of the local replica.
+type Peer interface {
+	Send(op *Op)
+	Close()
+}
+
+const (
+	peerRetryInterval = time.Second
+	// peerReplyTimeout bounds the time to write a batch of ops and read their replies
+	peerReplyTimeout = 10 * time.Second
+	// peerBatchSize is the max number of ops written before reading their replies
+	peerBatchSize = 512
+	// peerMaxPending is the max number of ops kept for a peer that is down or slow,
+	// the ops sent beyond it are dropped and counted by Dropped
+	peerMaxPending = 1 << 20
+)
+
+// TCPPeer ships ops to a remote easyRedis node as crdt.merge commands,
+// after a crdt.hello with the local node id on each new connection.
+// An op is kept until the peer replies +OK to it, so a peer that is down or closes the
+// connection receives everything once it is reachable again. Ops are idempotent, resending is safe.
+// An op the peer rejects is logged and resent until it is accepted.
+type TCPPeer struct {
+	addr    string
+	node    string
+	mu      sync.Mutex
+	cond    *sync.Cond
+	pending []*Op
+	closed  bool
+	// dropped is the number of ops dropped because pending was full,
+	// overflow is true from a drop until an op is acked, so a full queue is logged once
+	dropped  uint64
+	overflow bool
+}
+
+// NewTCPPeer returns a peer shipping the ops of the local node to addr
+func NewTCPPeer(addr, node string) *TCPPeer {
+	p := &TCPPeer{addr: addr, node: node}
+	p.cond = sync.NewCond(&p.mu)
+	go p.run()
+	return p
+}
+
+func (p *TCPPeer) Send(op *Op) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return
+	}
+	if len(p.pending) >= peerMaxPending {
+		p.dropped++
+		if !p.overflow {
+			p.overflow = true
+			logger.Error("crdt peer ", p.addr, " has too many pending ops, new ops are dropped until it catches up")
+		}
+		return
+	}
+	p.pending = append(p.pending, op)
+	p.cond.Signal()
+}
+
+func (p *TCPPeer) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	p.cond.Signal()
+}
+
+// Dropped returns the number of ops that were never shipped because too many were pending
+func (p *TCPPeer) Dropped() uint64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.dropped
+}
+
+// next waits for pending ops and returns at most peerBatchSize of them, it returns nil when the peer is closed
+func (p *TCPPeer) next() []*Op {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for len(p.pending) == 0 && !p.closed {
+		p.cond.Wait()
+	}
+	if p.closed {
+		return nil
+	}
+	n := len(p.pending)
+	if n > peerBatchSize {
+		n = peerBatchSize
+	}
+	return p.pending[:n:n]
+}
+
+// ack removes the first n pending ops, the ones accepted by the peer
+func (p *TCPPeer) ack(n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if n > 0 {
+		p.pending = p.pending[n:]
+		p.overflow = false
+	}
+}
+
+func (p *TCPPeer) run() {
+	var conn net.Conn
+	var reader *bufio.Reader
+	// fresh is true until the hello is accepted on conn
+	fresh := false
+	defer func() {
+		if conn != nil {
+			_ = conn.Close()
+		}
+	}()
+	for {
+		ops := p.next()
+		if ops == nil {
+			return
+		}
+		if conn == nil {
+			var err error
+			conn, err = net.DialTimeout("tcp", p.addr, peerRetryInterval)
+			if err != nil {
+				logger.Warning("crdt peer ", p.addr, " unreachable: ", err.Error())
+				time.Sleep(peerRetryInterval)
+				continue
+			}
+			reader = bufio.NewReader(conn)
+			fresh = true
+		}
+		n, err := p.deliver(conn, reader, fresh, ops)
+		p.ack(n)
+		if err != nil {
+			logger.Warning("crdt peer ", p.addr, " error: ", err.Error())
+			_ = conn.Close()
+			conn = nil
+			time.Sleep(peerRetryInterval)
+			continue
+		}
+		fresh = false
+	}
+}
+
+// deliver writes ops to conn, preceded by the hello if fresh is true, and reads a reply per command.
+// It returns the number of ops accepted by the peer before the first error.
+func (p *TCPPeer) deliver(conn net.Conn, reader *bufio.Reader, fresh bool, ops []*Op) (int, error) {
+	buf := make([]byte, 0)
+	if fresh {
+		buf = append(buf, toArray([][]byte{[]byte(HelloCommand), []byte(p.node)}).ToBytes()...)
+	}
+	for _, op := range ops {
+		buf = append(buf, toArray(op.ToCommand()).ToBytes()...)
+	}
+	_ = conn.SetDeadline(time.Now().Add(peerReplyTimeout))
+	if _, err := conn.Write(buf); err != nil {
+		return 0, err
+	}
+	if fresh {
+		if err := readOK(reader); err != nil {
+			return 0, errors.New(HelloCommand + ": " + err.Error())
+		}
+	}
+	for i, op := range ops {
+		if err := readOK(reader); err != nil {
+			return i, errors.New(MergeCommand + " " + op.Type + " " + op.Key + ": " + err.Error())
+		}
+	}
+	return len(ops), nil
+}
+
+// readOK reads a reply, it returns an error unless the reply is +OK
+func readOK(reader *bufio.Reader) error {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if line != "+OK\r\n" {
+		return errors.New(strings.TrimSpace(line))
+	}
+	return nil
+}
+
+func toArray(cmd [][]byte) *resp.ArrayData {
+	data := make([]resp.RedisData, 0, len(cmd))
+	for _, arg := range cmd {
+		data = append(data, resp.NewBulkData(arg))
+	}
+	return resp.NewArrayData(data)
+}
@@ -0,0 +1,36 @@
+package crdt
+
+// Register is a last-writer-wins register ordered by hybrid logical clock timestamps.
+// A deleted register keeps its timestamp so that older writes can not bring the value back.
+type Register struct {
+	Value   []byte
+	TS      Timestamp
+	Deleted bool
+}
+
+// Set writes val if ts is newer than the last write, and reports whether the write won.
+func (r *Register) Set(val []byte, ts Timestamp) bool {
+	if ts.Compare(r.TS) <= 0 {
+		return false
+	}
+	r.Value = val
+	r.TS = ts
+	r.Deleted = false
+	return true
+}
+
+// Delete clears the register if ts is newer than the last write.
+func (r *Register) Delete(ts Timestamp) bool {
+	if ts.Compare(r.TS) <= 0 {
+		return false
+	}
+	r.Value = nil
+	r.TS = ts
+	r.Deleted = true
+	return true
+}
+
+// Exist returns true if the register holds a value
+func (r *Register) Exist() bool {
+	return !r.TS.IsZero() && !r.Deleted
+}
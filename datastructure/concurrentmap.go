@@ -2,6 +2,7 @@ package datastructure
 
 import (
 	"easyRedis/util"
+	"math/rand"
 	"sync"
 )
 
@@ -118,6 +119,22 @@ func (m *ConcurrentMap) Clear() {
 	*m = *NewConcurrentMap(m.size)
 }
 
+// RandomKey returns a key of a random shard, false if the map is empty
+func (m *ConcurrentMap) RandomKey() (string, bool) {
+	start := rand.Intn(m.size)
+	for i := 0; i < m.size; i++ {
+		shard := m.table[(start+i)%m.size]
+		shard.rwMu.RLock()
+		// the iteration order of a go map is random
+		for key := range shard.mp {
+			shard.rwMu.RUnlock()
+			return key, true
+		}
+		shard.rwMu.RUnlock()
+	}
+	return "", false
+}
+
 func (m *ConcurrentMap) Keys() []string {
 	keys := make([]string, m.count)
 	i := 0
@@ -0,0 +1,290 @@
+package datastructure
+
+import (
+	"math"
+)
+
+// Geohash helpers for the GEO commands, compatible with redis.
+//
+// A position is stored in a sorted set with a 52 bits score: the longitude and latitude are
+// quantized to 26 bits each and interleaved, latitude bits in the even positions.
+// Cells of a coarser step are prefixes of the score, so all the members inside a cell
+// are a continuous score range of the sorted set.
+
+const (
+	GeoLongMin = -180.0
+	GeoLongMax = 180.0
+	GeoLatMin  = -85.05112878
+	GeoLatMax  = 85.05112878
+
+	// GeoStepMax is the precision of the stored scores, 26 bits for each coordinate
+	GeoStepMax = 26
+
+	geoEarthRadius = 6372797.560856
+	geoMercatorMax = 20037726.37
+
+	geoBase32 = "0123456789bcdefghjkmnpqrstuvwxyz"
+)
+
+// GeoHashBits is a geohash of a cell at the given step
+type GeoHashBits struct {
+	Bits uint64
+	Step uint
+}
+
+// GeoArea is the area covered by a geohash cell
+type GeoArea struct {
+	LongMin, LongMax float64
+	LatMin, LatMax   float64
+}
+
+// GeoShape is the search area of GEOSEARCH, either a radius or a box centered on Long, Lat.
+// All lengths are in meters.
+type GeoShape struct {
+	Long, Lat     float64
+	Radius        float64
+	Width, Height float64
+	IsBox         bool
+}
+
+// GeoValid reports whether the coordinates can be indexed
+func GeoValid(long, lat float64) bool {
+	return long >= GeoLongMin && long <= GeoLongMax && lat >= GeoLatMin && lat <= GeoLatMax
+}
+
+// spread the lower 32 bits of v to the even bits of the result
+func geoSpread(v uint64) uint64 {
+	v &= 0xffffffff
+	v = (v | v<<16) & 0x0000ffff0000ffff
+	v = (v | v<<8) & 0x00ff00ff00ff00ff
+	v = (v | v<<4) & 0x0f0f0f0f0f0f0f0f
+	v = (v | v<<2) & 0x3333333333333333
+	v = (v | v<<1) & 0x5555555555555555
+	return v
+}
+
+// squash is the inverse of spread, it collects the even bits of v
+func geoSquash(v uint64) uint64 {
+	v &= 0x5555555555555555
+	v = (v | v>>1) & 0x3333333333333333
+	v = (v | v>>2) & 0x0f0f0f0f0f0f0f0f
+	v = (v | v>>4) & 0x00ff00ff00ff00ff
+	v = (v | v>>8) & 0x0000ffff0000ffff
+	v = (v | v>>16) & 0x00000000ffffffff
+	return v
+}
+
+func geoInterleave(latIdx, longIdx uint64) uint64 {
+	return geoSpread(latIdx) | geoSpread(longIdx)<<1
+}
+
+func geoDeinterleave(bits uint64) (latIdx, longIdx uint64) {
+	return geoSquash(bits), geoSquash(bits >> 1)
+}
+
+func geoEncode(long, lat, latMin, latMax float64, step uint) GeoHashBits {
+	latOffset := (lat - latMin) / (latMax - latMin)
+	longOffset := (long - GeoLongMin) / (GeoLongMax - GeoLongMin)
+	latOffset *= float64(uint64(1) << step)
+	longOffset *= float64(uint64(1) << step)
+	return GeoHashBits{Bits: geoInterleave(uint64(latOffset), uint64(longOffset)), Step: step}
+}
+
+// GeoEncode returns the geohash of the coordinates at the given step
+func GeoEncode(long, lat float64, step uint) GeoHashBits {
+	return geoEncode(long, lat, GeoLatMin, GeoLatMax, step)
+}
+
+// GeoDecode returns the area covered by hash
+func GeoDecode(hash GeoHashBits) GeoArea {
+	latIdx, longIdx := geoDeinterleave(hash.Bits)
+	cells := float64(uint64(1) << hash.Step)
+	latScale := GeoLatMax - GeoLatMin
+	longScale := GeoLongMax - GeoLongMin
+	return GeoArea{
+		LatMin:  GeoLatMin + float64(latIdx)/cells*latScale,
+		LatMax:  GeoLatMin + float64(latIdx+1)/cells*latScale,
+		LongMin: GeoLongMin + float64(longIdx)/cells*longScale,
+		LongMax: GeoLongMin + float64(longIdx+1)/cells*longScale,
+	}
+}
+
+// GeoScore returns the sorted set score of the coordinates
+func GeoScore(long, lat float64) float64 {
+	return float64(GeoEncode(long, lat, GeoStepMax).Bits)
+}
+
+// GeoPosition returns the coordinates stored in score, the center of its cell
+func GeoPosition(score float64) (long, lat float64) {
+	area := GeoDecode(GeoHashBits{Bits: uint64(score), Step: GeoStepMax})
+	long = math.Max(GeoLongMin, math.Min(GeoLongMax, (area.LongMin+area.LongMax)/2))
+	lat = math.Max(GeoLatMin, math.Min(GeoLatMax, (area.LatMin+area.LatMax)/2))
+	return
+}
+
+// GeoHashString returns the standard 11 characters geohash of score.
+// Scores are encoded with the mercator latitude range, so the position is re-encoded with [-90, 90].
+func GeoHashString(score float64) string {
+	long, lat := GeoPosition(score)
+	bits := geoEncode(long, lat, -90, 90, GeoStepMax).Bits
+	buf := make([]byte, 11)
+	for i := range buf {
+		idx := 0
+		// 52 bits give 10 full characters, the last one is always '0' like redis
+		if i < 10 {
+			idx = int(bits>>(52-(i+1)*5)) & 0x1f
+		}
+		buf[i] = geoBase32[idx]
+	}
+	return string(buf)
+}
+
+func geoDegRad(deg float64) float64 {
+	return deg * math.Pi / 180
+}
+
+func geoRadDeg(rad float64) float64 {
+	return rad * 180 / math.Pi
+}
+
+// GeoDistance returns the haversine distance in meters between two positions
+func GeoDistance(long1, lat1, long2, lat2 float64) float64 {
+	lat1r, lat2r := geoDegRad(lat1), geoDegRad(lat2)
+	u := math.Sin((lat2r - lat1r) / 2)
+	v := math.Sin(geoDegRad(long2-long1) / 2)
+	return 2 * geoEarthRadius * math.Asin(math.Sqrt(u*u+math.Cos(lat1r)*math.Cos(lat2r)*v*v))
+}
+
+// Match reports whether the position is inside the shape and returns its distance to the center
+func (s *GeoShape) Match(long, lat float64) (float64, bool) {
+	if !s.IsBox {
+		dist := GeoDistance(s.Long, s.Lat, long, lat)
+		return dist, dist <= s.Radius
+	}
+	// the latitude distance is cheaper, check it first
+	if geoEarthRadius*math.Abs(geoDegRad(lat)-geoDegRad(s.Lat)) > s.Height/2 {
+		return 0, false
+	}
+	if GeoDistance(s.Long, lat, long, lat) > s.Width/2 {
+		return 0, false
+	}
+	return GeoDistance(s.Long, s.Lat, long, lat), true
+}
+
+// boundingBox returns longMin, latMin, longMax, latMax of the shape
+func (s *GeoShape) boundingBox() (float64, float64, float64, float64) {
+	width, height := s.Width, s.Height
+	if !s.IsBox {
+		width, height = 2*s.Radius, 2*s.Radius
+	}
+	latDelta := geoRadDeg(height / 2 / geoEarthRadius)
+	longDeltaTop := geoRadDeg(width / 2 / geoEarthRadius / math.Cos(geoDegRad(s.Lat+latDelta)))
+	longDeltaBottom := geoRadDeg(width / 2 / geoEarthRadius / math.Cos(geoDegRad(s.Lat-latDelta)))
+	// the widest edge is the one nearest to the equator
+	longDelta := longDeltaTop
+	if s.Lat < 0 {
+		longDelta = longDeltaBottom
+	}
+	return s.Long - longDelta, s.Lat - latDelta, s.Long + longDelta, s.Lat + latDelta
+}
+
+// geoEstimateStep returns the step whose cells are about as large as rangeMeters
+func geoEstimateStep(rangeMeters, lat float64) uint {
+	if rangeMeters == 0 {
+		return GeoStepMax
+	}
+	step := 1
+	for rangeMeters < geoMercatorMax {
+		rangeMeters *= 2
+		step++
+	}
+	// make sure the range is included in most of the base cases
+	step -= 2
+	// cells are narrower near the poles
+	if lat > 66 || lat < -66 {
+		step--
+		if lat > 80 || lat < -80 {
+			step--
+		}
+	}
+	if step < 1 {
+		step = 1
+	}
+	if step > GeoStepMax {
+		step = GeoStepMax
+	}
+	return uint(step)
+}
+
+// geoNeighbors returns the cell of hash and its 8 neighbours, in the order
+// center, north, south, east, west, north east, north west, south east, south west.
+// Longitudes wrap around, as do latitudes like in redis.
+func geoNeighbors(hash GeoHashBits) [9]GeoHashBits {
+	latIdx, longIdx := geoDeinterleave(hash.Bits)
+	mask := uint64(1)<<hash.Step - 1
+	move := func(dLat, dLong int) GeoHashBits {
+		la := (latIdx + uint64(int64(dLat))) & mask
+		lo := (longIdx + uint64(int64(dLong))) & mask
+		return GeoHashBits{Bits: geoInterleave(la, lo), Step: hash.Step}
+	}
+	return [9]GeoHashBits{
+		hash,
+		move(1, 0), move(-1, 0), move(0, 1), move(0, -1),
+		move(1, 1), move(1, -1), move(-1, 1), move(-1, -1),
+	}
+}
+
+// SearchRanges returns the score ranges [min, max) of the cells that may contain
+// positions inside the shape. The members found in these ranges still have to be
+// checked with Match.
+func (s *GeoShape) SearchRanges() [][2]float64 {
+	longMin, latMin, longMax, latMax := s.boundingBox()
+	radius := s.Radius
+	if s.IsBox {
+		radius = math.Sqrt(s.Width*s.Width+s.Height*s.Height) / 2
+	}
+	step := geoEstimateStep(radius, s.Lat)
+	cells := geoNeighbors(GeoEncode(s.Long, s.Lat, step))
+
+	// the estimated step may be too fine when the center is near an edge of its cell
+	north, south := GeoDecode(cells[1]), GeoDecode(cells[2])
+	east, west := GeoDecode(cells[3]), GeoDecode(cells[4])
+	if step > 1 && (north.LatMax < latMax || south.LatMin > latMin || east.LongMax < longMax || west.LongMin > longMin) {
+		step--
+		cells = geoNeighbors(GeoEncode(s.Long, s.Lat, step))
+	}
+
+	// skip the neighbours outside the bounding box
+	skip := [9]bool{}
+	if step >= 2 {
+		area := GeoDecode(cells[0])
+		if area.LatMin < latMin {
+			skip[2], skip[7], skip[8] = true, true, true
+		}
+		if area.LatMax > latMax {
+			skip[1], skip[5], skip[6] = true, true, true
+		}
+		if area.LongMin < longMin {
+			skip[4], skip[6], skip[8] = true, true, true
+		}
+		if area.LongMax > longMax {
+			skip[3], skip[5], skip[7] = true, true, true
+		}
+	}
+
+	shift := 2 * (GeoStepMax - step)
+	seen := make(map[uint64]struct{}, len(cells))
+	ranges := make([][2]float64, 0, len(cells))
+	for i, cell := range cells {
+		if skip[i] {
+			continue
+		}
+		// with a coarse step the neighbours may wrap around to the same cell
+		if _, ok := seen[cell.Bits]; ok {
+			continue
+		}
+		seen[cell.Bits] = struct{}{}
+		ranges = append(ranges, [2]float64{float64(cell.Bits << shift), float64((cell.Bits + 1) << shift)})
+	}
+	return ranges
+}
@@ -0,0 +1,67 @@
+package datastructure
+
+import (
+	"math"
+	"testing"
+)
+
+func TestGeoEncodeDecode(t *testing.T) {
+	score := GeoScore(13.361389, 38.115556)
+	if score != 3479099956230698 {
+		t.Fatalf("GeoScore = %v", score)
+	}
+	long, lat := GeoPosition(score)
+	if math.Abs(long-13.361389) > 1e-5 || math.Abs(lat-38.115556) > 1e-5 {
+		t.Fatalf("GeoPosition = %v, %v", long, lat)
+	}
+	if hash := GeoHashString(score); hash != "sqc8b49rny0" {
+		t.Fatalf("GeoHashString = %s", hash)
+	}
+	if !GeoValid(180, GeoLatMax) || GeoValid(0, 86) || GeoValid(181, 0) {
+		t.Fatal("GeoValid limits")
+	}
+}
+
+func TestGeoDistance(t *testing.T) {
+	d := GeoDistance(13.361389, 38.115556, 15.087269, 37.502669)
+	if math.Abs(d-166274.15) > 1 {
+		t.Fatalf("GeoDistance = %v", d)
+	}
+}
+
+func TestGeoSearchRanges(t *testing.T) {
+	// every point inside the shape must fall in one of the scanned ranges
+	shapes := []GeoShape{
+		{Long: 15, Lat: 37, Radius: 200000},
+		{Long: 0.0001, Lat: 0.0001, Radius: 50},
+		{Long: -179.9, Lat: 70, Radius: 30000},
+		{Long: 15, Lat: 37, Width: 400000, Height: 100000, IsBox: true},
+	}
+	for _, shape := range shapes {
+		ranges := shape.SearchRanges()
+		for dx := -1.0; dx <= 1; dx += 0.05 {
+			for dy := -1.0; dy <= 1; dy += 0.05 {
+				long := shape.Long + dx*4
+				lat := shape.Lat + dy*4
+				if !GeoValid(long, lat) {
+					continue
+				}
+				score := GeoScore(long, lat)
+				pl, pa := GeoPosition(score)
+				if _, ok := shape.Match(pl, pa); !ok {
+					continue
+				}
+				found := false
+				for _, r := range ranges {
+					if score >= r[0] && score < r[1] {
+						found = true
+						break
+					}
+				}
+				if !found {
+					t.Fatalf("shape %+v: point %v,%v is not covered", shape, long, lat)
+				}
+			}
+		}
+	}
+}
@@ -1,86 +1,309 @@
 package datastructure
 
 import (
+	"math/rand"
 	"strconv"
 )
 
+// Hash is a hash whose fields may have an expire time.
+// Small hashes are stored in lp as field, value pairs, they are converted to table once they exceed
+// Limits.HashMaxListpackEntries fields or have a field or value longer than Limits.HashMaxListpackValue.
+// expires holds the deadlines of the fields with a ttl in unix milliseconds, it is nil until a field has one.
+// next is the earliest deadline of expires, 0 if expires is empty.
 type Hash struct {
-	table map[string][]byte
+	table   map[string][]byte
+	lp      *listpack
+	expires map[string]int64
+	next    int64
 }
 
 func NewHash() *Hash {
-	return &Hash{make(map[string][]byte)}
+	return &Hash{lp: &listpack{}}
 }
 
+// Copy returns a deep copy of the hash
+func (h *Hash) Copy() *Hash {
+	res := &Hash{next: h.next}
+	if h.lp != nil {
+		res.lp = h.lp.copy()
+	} else {
+		res.table = make(map[string][]byte, len(h.table))
+		for key, val := range h.table {
+			res.table[key] = append([]byte(nil), val...)
+		}
+	}
+	if len(h.expires) > 0 {
+		res.expires = make(map[string]int64, len(h.expires))
+		for key, deadline := range h.expires {
+			res.expires[key] = deadline
+		}
+	}
+	return res
+}
+
+// Encoding returns the name of the encoding of the hash
+func (h *Hash) Encoding() string {
+	if h.lp != nil {
+		return EncodingListpack
+	}
+	return EncodingHashtable
+}
+
+// convert moves the fields from lp to table
+func (h *Hash) convert() {
+	h.table = make(map[string][]byte, h.lp.n/2+1)
+	h.ForEach(func(field string, val []byte) bool {
+		h.table[field] = val
+		return true
+	})
+	h.lp = nil
+}
+
+// Set sets the value of a field and removes its expire time
 func (h *Hash) Set(key string, val []byte) {
+	h.set(key, val)
+	h.Persist(key)
+}
+
+// set sets the value of a field and keeps its expire time
+func (h *Hash) set(key string, val []byte) {
+	if h.lp != nil {
+		if len(key) <= Limits.HashMaxListpackValue && len(val) <= Limits.HashMaxListpackValue {
+			if _, off, ok := h.lp.find(key, 2); ok {
+				_, end := h.lp.entry(off)
+				h.lp.splice(off, end, 1, val)
+				return
+			}
+			if h.lp.n/2 < Limits.HashMaxListpackEntries {
+				h.lp.append([]byte(key), val)
+				return
+			}
+		}
+		h.convert()
+	}
 	h.table[key] = val
 }
 
+// SetExpire sets the deadline of a field in unix milliseconds, it returns false if the field doesn't exist
+func (h *Hash) SetExpire(key string, deadline int64) bool {
+	if !h.Exist(key) {
+		return false
+	}
+	if h.expires == nil {
+		h.expires = make(map[string]int64)
+	}
+	old, ok := h.expires[key]
+	h.expires[key] = deadline
+	if h.next == 0 || deadline < h.next {
+		h.next = deadline
+	} else if ok && old == h.next {
+		h.updateNext()
+	}
+	return true
+}
+
+// Expire returns the deadline of a field, false if the field has no expire time
+func (h *Hash) Expire(key string) (int64, bool) {
+	deadline, ok := h.expires[key]
+	return deadline, ok
+}
+
+// Persist removes the expire time of a field, it returns false if the field has none
+func (h *Hash) Persist(key string) bool {
+	deadline, ok := h.expires[key]
+	if !ok {
+		return false
+	}
+	delete(h.expires, key)
+	if deadline == h.next {
+		h.updateNext()
+	}
+	return true
+}
+
+// NextExpire returns the earliest deadline of the fields, false if no field has an expire time
+func (h *Hash) NextExpire() (int64, bool) {
+	return h.next, h.next != 0
+}
+
+// DelExpired deletes the fields whose deadline is not after now and returns their number
+func (h *Hash) DelExpired(now int64) int {
+	if h.next == 0 || h.next > now {
+		return 0
+	}
+	deleted := 0
+	for key, deadline := range h.expires {
+		if deadline <= now {
+			h.del(key)
+			delete(h.expires, key)
+			deleted++
+		}
+	}
+	h.updateNext()
+	return deleted
+}
+
+func (h *Hash) updateNext() {
+	h.next = 0
+	for _, deadline := range h.expires {
+		if h.next == 0 || deadline < h.next {
+			h.next = deadline
+		}
+	}
+	if len(h.expires) == 0 {
+		h.expires = nil
+	}
+}
+
 func (h *Hash) Get(key string) []byte {
+	if h.lp != nil {
+		if _, off, ok := h.lp.find(key, 2); ok {
+			val, _ := h.lp.entry(off)
+			return val
+		}
+		return nil
+	}
 	return h.table[key]
 }
 
 func (h *Hash) Del(key string) int {
-	if h.Exist(key) {
-		delete(h.table, key)
+	if h.del(key) {
+		h.Persist(key)
 		return 1
 	}
 	return 0
 }
 
-func (h *Hash) Keys() []string {
-	keys := make([]string, 0, len(h.table))
-	for key, _ := range h.table {
-		keys = append(keys, key)
+// del deletes a field without its expire time
+func (h *Hash) del(key string) bool {
+	if h.lp != nil {
+		off, next, ok := h.lp.find(key, 2)
+		if ok {
+			_, end := h.lp.entry(next)
+			h.lp.splice(off, end, 2)
+		}
+		return ok
 	}
+	if _, ok := h.table[key]; !ok {
+		return false
+	}
+	delete(h.table, key)
+	return true
+}
+
+// ForEach calls fn for each field and value of the hash until fn returns false
+func (h *Hash) ForEach(fn func(field string, val []byte) bool) {
+	if h.lp != nil {
+		for off := 0; off < len(h.lp.buf); {
+			field, next := h.lp.entry(off)
+			val, end := h.lp.entry(next)
+			if !fn(string(field), val) {
+				return
+			}
+			off = end
+		}
+		return
+	}
+	for field, val := range h.table {
+		if !fn(field, val) {
+			return
+		}
+	}
+}
+
+func (h *Hash) Keys() []string {
+	keys := make([]string, 0, h.Len())
+	h.ForEach(func(field string, _ []byte) bool {
+		keys = append(keys, field)
+		return true
+	})
 	return keys
 }
 
 func (h *Hash) Values() [][]byte {
-	values := make([][]byte, 0, len(h.table))
-	for _, v := range h.table {
-		values = append(values, v)
-	}
+	values := make([][]byte, 0, h.Len())
+	h.ForEach(func(_ string, val []byte) bool {
+		values = append(values, val)
+		return true
+	})
 	return values
 }
 
 func (h *Hash) All() [][]byte {
-	res := make([][]byte, 0, 2*len(h.table))
-	for k, v := range h.table {
-		res = append(res, []byte(k), v)
-	}
+	res := make([][]byte, 0, 2*h.Len())
+	h.ForEach(func(field string, val []byte) bool {
+		res = append(res, []byte(field), val)
+		return true
+	})
 	return res
 }
 
 func (h *Hash) Clear() {
-	h.table = make(map[string][]byte)
+	h.table = nil
+	h.lp = &listpack{}
+	h.expires = nil
+	h.next = 0
 }
 
 func (h *Hash) Len() int {
+	if h.lp != nil {
+		return h.lp.n / 2
+	}
 	return len(h.table)
 }
 
 func (h *Hash) IsEmpty() bool {
-	return len(h.table) == 0
+	return h.Len() == 0
 }
 
 func (h *Hash) Exist(key string) bool {
+	if h.lp != nil {
+		_, _, ok := h.lp.find(key, 2)
+		return ok
+	}
 	_, ok := h.table[key]
 	return ok
 }
 func (h *Hash) StrLen(key string) int {
-	return len(h.table[key])
+	return len(h.Get(key))
+}
+
+// randomIndexes returns the indexes of count random fields, see Random
+func (h *Hash) randomIndexes(count int) []int {
+	if count > 0 {
+		idx := rand.Perm(h.Len())
+		if count < len(idx) {
+			idx = idx[:count]
+		}
+		return idx
+	}
+	idx := make([]int, -count)
+	for i := range idx {
+		idx[i] = rand.Intn(h.Len())
+	}
+	return idx
 }
 
+// Random returns count random fields, distinct if count > 0 and at most all of them,
+// with repetitions if count < 0.
 func (h *Hash) Random(count int) []string {
 	var res []string
 	if count == 0 || h.Len() == 0 {
 		return res
-	} else if count > 0 {
+	}
+	if h.lp != nil {
+		keys := h.Keys()
+		res = make([]string, 0, len(keys))
+		for _, i := range h.randomIndexes(count) {
+			res = append(res, keys[i])
+		}
+		return res
+	}
+	if count > 0 {
 		if count > h.Len() {
 			count = h.Len()
 		}
-		res = make([]string, count)
+		res = make([]string, 0, count)
 		for key := range h.table {
 			res = append(res, key)
 			if len(res) == count {
@@ -88,7 +311,7 @@ func (h *Hash) Random(count int) []string {
 			}
 		}
 	} else {
-		res = make([]string, -count)
+		res = make([]string, 0, -count)
 		for { // 当count<0,返回-count个元素（-count可能会大于h.Len()）
 			for key := range h.table {
 				res = append(res, key)
@@ -101,16 +324,25 @@ func (h *Hash) Random(count int) []string {
 	return res
 }
 
+// RandomWithValue is Random with the values, it returns field, value pairs
 func (h *Hash) RandomWithValue(count int) [][]byte {
 	var res [][]byte
 	if count == 0 || h.Len() == 0 {
 		return res
-	} else if count > 0 {
+	}
+	if h.lp != nil {
+		all := h.All()
+		for _, i := range h.randomIndexes(count) {
+			res = append(res, all[2*i], all[2*i+1])
+		}
+		return res
+	}
+	if count > 0 {
 		if count >= h.Len() {
 			count = h.Len()
 		}
 		count *= 2
-		res = make([][]byte, count)
+		res = make([][]byte, 0, count)
 		for k, v := range h.table {
 			res = append(res, []byte(k), v)
 			if len(res) == count {
@@ -119,7 +351,7 @@ func (h *Hash) RandomWithValue(count int) [][]byte {
 		}
 	} else {
 		count *= 2
-		res = make([][]byte, -count)
+		res = make([][]byte, 0, -count)
 		for {
 			for key, val := range h.table {
 				res = append(res, []byte(key), val)
@@ -132,10 +364,6 @@ func (h *Hash) RandomWithValue(count int) [][]byte {
 	return res
 }
 
-func (h *Hash) Table() map[string][]byte {
-	return h.table
-}
-
 func (h *Hash) IncrBy(key string, incr int) (int, bool) {
 	temp := h.Get(key)
 	if len(temp) == 0 {
@@ -147,7 +375,8 @@ func (h *Hash) IncrBy(key string, incr int) (int, bool) {
 		return 0, false
 	}
 	val += incr
-	h.Set(key, []byte(strconv.Itoa(val)))
+	// an increment keeps the expire time of the field
+	h.set(key, []byte(strconv.Itoa(val)))
 	return val, true
 }
 
@@ -162,6 +391,6 @@ func (h *Hash) IncrByFloat(key string, incr float64) (float64, bool) {
 		return 0, false
 	}
 	val += incr
-	h.Set(key, []byte(strconv.FormatFloat(val, 'f', -1, 64)))
+	h.set(key, []byte(strconv.FormatFloat(val, 'f', -1, 64)))
 	return val, true
 }
@@ -0,0 +1,462 @@
+package datastructure
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// HyperLogLog stored in a string value, with the same layout as redis so that values are interchangeable.
+//
+// The value starts with a 16 bytes header:
+//   "HYLL" | encoding (1 byte) | unused (3 bytes) | cached cardinality (8 bytes, little endian)
+// The most significant bit of the last cardinality byte set means the cached cardinality is invalid.
+//
+// Dense encoding: 16384 registers of 6 bits, the least significant bits of a register come first.
+// Sparse encoding: run length encoded registers with three opcodes
+//   ZERO  00xxxxxx           xxxxxx+1 registers set to 0 (1-64)
+//   XZERO 01xxxxxx yyyyyyyy  xxxxxxyyyyyyyy+1 registers set to 0 (1-16384)
+//   VAL   1vvvvvxx           xx+1 registers set to vvvvv+1 (1-4 registers, values 1-32)
+// A sparse value is converted to dense when a register exceeds 32 or it grows over HLLSparseMaxBytes.
+
+const (
+	hllP           = 14
+	hllQ           = 64 - hllP
+	HLLRegisters   = 1 << hllP
+	hllPMask       = HLLRegisters - 1
+	hllBits        = 6
+	hllRegisterMax = 1<<hllBits - 1
+	hllHdrSize     = 16
+	hllDenseSize   = hllHdrSize + (HLLRegisters*hllBits+7)/8
+
+	HLLDense  = 0
+	HLLSparse = 1
+
+	hllSparseValMax    = 32
+	hllSparseZeroMax   = 64
+	hllSparseXZeroMax  = 16384
+	hllSparseValMaxLen = 4
+	// HLLSparseMaxBytes is the size limit of a sparse value, like hll-sparse-max-bytes of redis
+	HLLSparseMaxBytes = 3000
+
+	hllAlphaInf = 0.721347520444481703680 // 1 / (2 * ln(2))
+	hllSeed     = 0xadc83b19
+)
+
+var (
+	hllMagic = []byte("HYLL")
+
+	ErrHLLInvalid   = errors.New("WRONGTYPE Key is not a valid HyperLogLog string value.")
+	ErrHLLCorrupted = errors.New("INVALIDOBJ Corrupted HLL object detected")
+)
+
+// HLLRegisterSet holds the registers of a HyperLogLog, one byte per register
+type HLLRegisterSet [HLLRegisters]uint8
+
+// NewHLL returns an empty sparse HyperLogLog
+func NewHLL() []byte {
+	b := make([]byte, hllHdrSize, hllHdrSize+2)
+	copy(b, hllMagic)
+	b[4] = HLLSparse
+	// a single XZERO opcode covering all registers
+	v := hllSparseXZeroMax - 1
+	return append(b, 0x40|byte(v>>8), byte(v))
+}
+
+// IsHLL checks the header of a HyperLogLog value
+func IsHLL(b []byte) bool {
+	if len(b) < hllHdrSize || string(b[:4]) != string(hllMagic) {
+		return false
+	}
+	switch b[4] {
+	case HLLDense:
+		return len(b) == hllDenseSize
+	case HLLSparse:
+		return true
+	}
+	return false
+}
+
+// HLLEncoding returns HLLDense or HLLSparse
+func HLLEncoding(b []byte) byte {
+	return b[4]
+}
+
+func hllInvalidateCache(b []byte) {
+	b[15] |= 1 << 7
+}
+
+func hllCacheValid(b []byte) bool {
+	return b[15]&(1<<7) == 0
+}
+
+// MurmurHash64A is the hash function used by redis for HyperLogLog
+func MurmurHash64A(key []byte, seed uint64) uint64 {
+	const m = 0xc6a4a7935bd1e995
+	const r = 47
+	h := seed ^ (uint64(len(key)) * m)
+	n := len(key) / 8 * 8
+	for i := 0; i < n; i += 8 {
+		k := binary.LittleEndian.Uint64(key[i:])
+		k *= m
+		k ^= k >> r
+		k *= m
+		h ^= k
+		h *= m
+	}
+	tail := key[n:]
+	switch len(tail) {
+	case 7:
+		h ^= uint64(tail[6]) << 48
+		fallthrough
+	case 6:
+		h ^= uint64(tail[5]) << 40
+		fallthrough
+	case 5:
+		h ^= uint64(tail[4]) << 32
+		fallthrough
+	case 4:
+		h ^= uint64(tail[3]) << 24
+		fallthrough
+	case 3:
+		h ^= uint64(tail[2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint64(tail[1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint64(tail[0])
+		h *= m
+	}
+	h ^= h >> r
+	h *= m
+	h ^= h >> r
+	return h
+}
+
+// hllPatLen returns the register of elem and the length of the 000..1 pattern of its hash
+func hllPatLen(elem []byte) (int, uint8) {
+	hash := MurmurHash64A(elem, hllSeed)
+	index := int(hash & hllPMask)
+	hash >>= hllP
+	hash |= 1 << hllQ
+	count := uint8(1)
+	for bit := uint64(1); hash&bit == 0; bit <<= 1 {
+		count++
+	}
+	return index, count
+}
+
+func denseGet(regs []byte, i int) uint8 {
+	pos := i * hllBits / 8
+	fb := uint(i*hllBits) & 7
+	b0 := regs[pos]
+	var b1 byte
+	if pos+1 < len(regs) {
+		b1 = regs[pos+1]
+	}
+	return uint8((uint16(b0)>>fb | uint16(b1)<<(8-fb)) & hllRegisterMax)
+}
+
+func denseSet(regs []byte, i int, val uint8) {
+	pos := i * hllBits / 8
+	fb := uint(i*hllBits) & 7
+	regs[pos] &^= hllRegisterMax << fb
+	regs[pos] |= val << fb
+	if pos+1 < len(regs) {
+		regs[pos+1] &^= hllRegisterMax >> (8 - fb)
+		regs[pos+1] |= val >> (8 - fb)
+	}
+}
+
+// sparseDecode decodes the registers of a sparse HyperLogLog
+func sparseDecode(b []byte, regs *HLLRegisterSet) error {
+	idx := 0
+	p := b[hllHdrSize:]
+	for i := 0; i < len(p); i++ {
+		op := p[i]
+		switch {
+		case op&0xc0 == 0x00: // ZERO
+			idx += int(op&0x3f) + 1
+		case op&0xc0 == 0x40: // XZERO
+			if i+1 >= len(p) {
+				return ErrHLLCorrupted
+			}
+			idx += (int(op&0x3f)<<8 | int(p[i+1])) + 1
+			i++
+		default: // VAL
+			val := (op>>2)&0x1f + 1
+			runLen := int(op&0x3) + 1
+			if idx+runLen > HLLRegisters {
+				return ErrHLLCorrupted
+			}
+			for j := 0; j < runLen; j++ {
+				regs[idx+j] = val
+			}
+			idx += runLen
+		}
+		if idx > HLLRegisters {
+			return ErrHLLCorrupted
+		}
+	}
+	if idx != HLLRegisters {
+		return ErrHLLCorrupted
+	}
+	return nil
+}
+
+// sparseEncode encodes registers with the sparse encoding,
+// ok is false if a register can't be represented or the value is too large.
+func sparseEncode(regs *HLLRegisterSet, hdr []byte) (res []byte, ok bool) {
+	res = make([]byte, hllHdrSize, hllHdrSize+16)
+	copy(res, hdr[:hllHdrSize])
+	res[4] = HLLSparse
+	for i := 0; i < HLLRegisters; {
+		val := regs[i]
+		j := i + 1
+		for j < HLLRegisters && regs[j] == val {
+			j++
+		}
+		runLen := j - i
+		if val == 0 {
+			for runLen > 0 {
+				if runLen > hllSparseZeroMax {
+					n := runLen
+					if n > hllSparseXZeroMax {
+						n = hllSparseXZeroMax
+					}
+					res = append(res, 0x40|byte((n-1)>>8), byte(n-1))
+					runLen -= n
+				} else {
+					res = append(res, byte(runLen-1))
+					runLen = 0
+				}
+			}
+		} else {
+			if val > hllSparseValMax {
+				return nil, false
+			}
+			for runLen > 0 {
+				n := runLen
+				if n > hllSparseValMaxLen {
+					n = hllSparseValMaxLen
+				}
+				res = append(res, 0x80|(val-1)<<2|byte(n-1))
+				runLen -= n
+			}
+		}
+		if len(res) > HLLSparseMaxBytes {
+			return nil, false
+		}
+		i = j
+	}
+	return res, true
+}
+
+// denseEncode encodes registers with the dense encoding
+func denseEncode(regs *HLLRegisterSet, hdr []byte) []byte {
+	res := make([]byte, hllDenseSize)
+	copy(res, hdr[:hllHdrSize])
+	res[4] = HLLDense
+	for i, val := range regs {
+		if val != 0 {
+			denseSet(res[hllHdrSize:], i, val)
+		}
+	}
+	return res
+}
+
+// HLLGetRegisters decodes the registers of a HyperLogLog value
+func HLLGetRegisters(b []byte, regs *HLLRegisterSet) error {
+	if !IsHLL(b) {
+		return ErrHLLInvalid
+	}
+	if HLLEncoding(b) == HLLSparse {
+		*regs = HLLRegisterSet{}
+		return sparseDecode(b, regs)
+	}
+	for i := range regs {
+		regs[i] = denseGet(b[hllHdrSize:], i)
+	}
+	return nil
+}
+
+// HLLMergeRegisters merges the registers of b into regs, keeping the max of each register
+func HLLMergeRegisters(b []byte, regs *HLLRegisterSet) error {
+	var other HLLRegisterSet
+	if err := HLLGetRegisters(b, &other); err != nil {
+		return err
+	}
+	for i, val := range other {
+		if val > regs[i] {
+			regs[i] = val
+		}
+	}
+	return nil
+}
+
+// HLLFromRegisters creates a HyperLogLog value from registers, the sparse encoding is used if possible unless dense is true
+func HLLFromRegisters(regs *HLLRegisterSet, dense bool) []byte {
+	hdr := NewHLL()[:hllHdrSize]
+	hllInvalidateCache(hdr)
+	if !dense {
+		if res, ok := sparseEncode(regs, hdr); ok {
+			return res
+		}
+	}
+	return denseEncode(regs, hdr)
+}
+
+// HLLAdd adds elements to a HyperLogLog value.
+// It returns the new value, which may be b itself, and whether a register has changed.
+func HLLAdd(b []byte, elems [][]byte) ([]byte, bool, error) {
+	if !IsHLL(b) {
+		return nil, false, ErrHLLInvalid
+	}
+	changed := false
+	if HLLEncoding(b) == HLLDense {
+		regs := b[hllHdrSize:]
+		for _, elem := range elems {
+			index, count := hllPatLen(elem)
+			if count > denseGet(regs, index) {
+				denseSet(regs, index, count)
+				changed = true
+			}
+		}
+	} else {
+		var regs HLLRegisterSet
+		if err := sparseDecode(b, &regs); err != nil {
+			return nil, false, err
+		}
+		for _, elem := range elems {
+			index, count := hllPatLen(elem)
+			if count > regs[index] {
+				regs[index] = count
+				changed = true
+			}
+		}
+		if changed {
+			if res, ok := sparseEncode(&regs, b); ok {
+				b = res
+			} else {
+				b = denseEncode(&regs, b)
+			}
+		}
+	}
+	if changed {
+		hllInvalidateCache(b)
+	}
+	return b, changed, nil
+}
+
+// HLLToDense converts a sparse HyperLogLog value to the dense encoding
+func HLLToDense(b []byte) ([]byte, bool, error) {
+	if !IsHLL(b) {
+		return nil, false, ErrHLLInvalid
+	}
+	if HLLEncoding(b) == HLLDense {
+		return b, false, nil
+	}
+	var regs HLLRegisterSet
+	if err := sparseDecode(b, &regs); err != nil {
+		return nil, false, err
+	}
+	return denseEncode(&regs, b), true, nil
+}
+
+// HLLCount returns the estimated cardinality of a HyperLogLog value, the cached cardinality is used and updated.
+func HLLCount(b []byte) (uint64, error) {
+	if !IsHLL(b) {
+		return 0, ErrHLLInvalid
+	}
+	if hllCacheValid(b) {
+		return binary.LittleEndian.Uint64(b[8:16]), nil
+	}
+	var regs HLLRegisterSet
+	if err := HLLGetRegisters(b, &regs); err != nil {
+		return 0, err
+	}
+	card := HLLCountRegisters(&regs)
+	binary.LittleEndian.PutUint64(b[8:16], card)
+	return card, nil
+}
+
+// HLLCountRegisters estimates the cardinality with the estimator of Otmar Ertl, like redis
+func HLLCountRegisters(regs *HLLRegisterSet) uint64 {
+	var histo [hllQ + 2]int
+	for _, val := range regs {
+		histo[val]++
+	}
+	m := float64(HLLRegisters)
+	z := m * hllTau((m-float64(histo[hllQ+1]))/m)
+	for j := hllQ; j >= 1; j-- {
+		z += float64(histo[j])
+		z *= 0.5
+	}
+	z += m * hllSigma(float64(histo[0])/m)
+	return uint64(math.Round(hllAlphaInf * m * m / z))
+}
+
+func hllSigma(x float64) float64 {
+	if x == 1 {
+		return math.Inf(1)
+	}
+	y := 1.0
+	z := x
+	for {
+		x *= x
+		zPrime := z
+		z += x * y
+		y += y
+		if zPrime == z {
+			return z
+		}
+	}
+}
+
+func hllTau(x float64) float64 {
+	if x == 0 || x == 1 {
+		return 0
+	}
+	y := 1.0
+	z := 1 - x
+	for {
+		x = math.Sqrt(x)
+		zPrime := z
+		y *= 0.5
+		z -= math.Pow(1-x, 2) * y
+		if zPrime == z {
+			return z / 3
+		}
+	}
+}
+
+// HLLDecodeSparse returns the opcodes of a sparse HyperLogLog in a human readable form
+func HLLDecodeSparse(b []byte) (string, error) {
+	if !IsHLL(b) {
+		return "", ErrHLLInvalid
+	}
+	if HLLEncoding(b) != HLLSparse {
+		return "", errors.New("ERR HLL encoding is not sparse")
+	}
+	var sb strings.Builder
+	p := b[hllHdrSize:]
+	for i := 0; i < len(p); i++ {
+		op := p[i]
+		switch {
+		case op&0xc0 == 0x00:
+			fmt.Fprintf(&sb, "z:%d ", int(op&0x3f)+1)
+		case op&0xc0 == 0x40:
+			if i+1 >= len(p) {
+				return "", ErrHLLCorrupted
+			}
+			fmt.Fprintf(&sb, "Z:%d ", (int(op&0x3f)<<8|int(p[i+1]))+1)
+			i++
+		default:
+			fmt.Fprintf(&sb, "v:%d,%d ", (op>>2)&0x1f+1, int(op&0x3)+1)
+		}
+	}
+	return strings.TrimSuffix(sb.String(), " "), nil
+}
@@ -0,0 +1,108 @@
+package datastructure
+
+import (
+	"math"
+	"strconv"
+	"testing"
+)
+
+func TestHLLSparseAndDense(t *testing.T) {
+	hll := NewHLL()
+	if !IsHLL(hll) || HLLEncoding(hll) != HLLSparse {
+		t.Fatal("new hll should be a valid sparse hll")
+	}
+	if card, err := HLLCount(hll); err != nil || card != 0 {
+		t.Errorf("empty hll count %d, %v", card, err)
+	}
+
+	elems := make([][]byte, 0, 100)
+	for i := 0; i < 100; i++ {
+		elems = append(elems, []byte("elem"+strconv.Itoa(i)))
+	}
+	hll, changed, err := HLLAdd(hll, elems)
+	if err != nil || !changed {
+		t.Fatalf("add error %v, changed %v", err, changed)
+	}
+	if HLLEncoding(hll) != HLLSparse {
+		t.Error("hll with 100 elements should be sparse")
+	}
+	if _, changed, _ = HLLAdd(hll, elems[:10]); changed {
+		t.Error("adding existing elements should not change registers")
+	}
+
+	// the sparse and dense encodings have the same registers
+	dense, converted, err := HLLToDense(hll)
+	if err != nil || !converted || len(dense) != hllDenseSize {
+		t.Fatalf("to dense error %v, converted %v, len %d", err, converted, len(dense))
+	}
+	var sparseRegs, denseRegs HLLRegisterSet
+	if err = HLLGetRegisters(hll, &sparseRegs); err != nil {
+		t.Fatal(err)
+	}
+	if err = HLLGetRegisters(dense, &denseRegs); err != nil {
+		t.Fatal(err)
+	}
+	if sparseRegs != denseRegs {
+		t.Error("registers differ between sparse and dense encodings")
+	}
+	c1, _ := HLLCount(hll)
+	c2, _ := HLLCount(dense)
+	if c1 != c2 || c1 < 95 || c1 > 105 {
+		t.Errorf("count sparse %d, dense %d, expect about 100", c1, c2)
+	}
+	if again := HLLFromRegisters(&denseRegs, false); HLLEncoding(again) != HLLSparse {
+		t.Error("registers should be encoded as sparse")
+	}
+}
+
+func TestHLLPromoteToDense(t *testing.T) {
+	hll := NewHLL()
+	elems := make([][]byte, 0, 5000)
+	for i := 0; i < 5000; i++ {
+		elems = append(elems, []byte(strconv.Itoa(i)))
+	}
+	hll, _, err := HLLAdd(hll, elems)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if HLLEncoding(hll) != HLLDense {
+		t.Error("hll over the sparse size limit should be dense")
+	}
+}
+
+func TestHLLError(t *testing.T) {
+	hll := NewHLL()
+	const n = 100000
+	batch := make([][]byte, 0, 1000)
+	for i := 0; i < n; i++ {
+		batch = append(batch, []byte("user:"+strconv.Itoa(i)))
+		if len(batch) == cap(batch) {
+			hll, _, _ = HLLAdd(hll, batch)
+			batch = batch[:0]
+		}
+	}
+	card, err := HLLCount(hll)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the standard error is 0.81%, allow 3 times of it
+	if rel := math.Abs(float64(card)-n) / n; rel > 0.0243 {
+		t.Errorf("count %d, relative error %f", card, rel)
+	}
+	// cached cardinality
+	if cached, _ := HLLCount(hll); cached != card || !hllCacheValid(hll) {
+		t.Error("cardinality should be cached")
+	}
+}
+
+func TestHLLCorrupted(t *testing.T) {
+	hll := NewHLL()
+	hll[len(hll)-1] = 0 // XZERO covering less than all registers
+	var regs HLLRegisterSet
+	if err := HLLGetRegisters(hll, &regs); err != ErrHLLCorrupted {
+		t.Errorf("error %v, expect %v", err, ErrHLLCorrupted)
+	}
+	if IsHLL([]byte("not a hll")) {
+		t.Error("string should not be a valid hll")
+	}
+}
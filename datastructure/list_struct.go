@@ -2,31 +2,276 @@ package datastructure
 
 import (
 	"bytes"
+	"compress/flate"
+	"sync"
 )
 
-// List implements a double linked list for redis list
+// List implements a quicklist for redis list: a double linked list of nodes,
+// each node holds a chunk of continuous elements in a listpack.
+// The chunks are limited by Limits.ListMaxListpackSize, a positive value is the max number of
+// elements of a chunk, -1 to -5 limit the chunk size to 4, 8, 16, 32 or 64 KB.
+// The nodes deeper than Limits.ListCompressDepth from both ends are compressed, 0 disables the compression.
+// Index, Set and Range skip whole nodes from whichever end is closer.
 type List struct {
-	Head *ListNode
-	Tail *ListNode
+	head *listNode
+	tail *listNode
 	Len  int
+	// number of nodes
+	nodes int
 }
+
+// ListNode is an element returned by Index, LPop and RPop
 type ListNode struct {
-	Prev *ListNode
-	Next *ListNode
-	Val  []byte
+	Val []byte
+}
+
+// listNode is a node of the quicklist, its elements are stored in the listpack lp.
+// A compressed node keeps lp.buf deflated in compressed, lp.buf is nil and lp.n is still the number of elements.
+// size is the length of lp.buf, compressed or not.
+type listNode struct {
+	prev       *listNode
+	next       *listNode
+	lp         listpack
+	size       int
+	compressed []byte
+}
+
+// listSizeLimits are the max chunk sizes of the negative values of Limits.ListMaxListpackSize
+var listSizeLimits = [...]int{4096, 8192, 16384, 32768, 65536}
+
+// listSafetyLimit is the max chunk size when the chunks are limited by their number of elements
+const listSafetyLimit = 8192
+
+// listMinCompress is the min size of a node worth compressing
+const listMinCompress = 48
+
+var flateWriters = sync.Pool{
+	New: func() any {
+		w, _ := flate.NewWriter(nil, flate.BestSpeed)
+		return w
+	},
 }
 
 func NewList() *List {
-	head := &ListNode{}
-	tail := &ListNode{}
-	head.Next = tail
-	tail.Prev = head
+	return &List{}
+}
+
+// Encoding returns the name of the encoding of the list, a list in a single node is a listpack
+func (l *List) Encoding() string {
+	if l.nodes > 1 {
+		return EncodingQuicklist
+	}
+	return EncodingListpack
+}
+
+// entrySize is the size of an element in a listpack
+func entrySize(val []byte) int {
+	return uvarintLen(uint64(len(val))) + len(val)
+}
+
+// fits reports whether val can be added to node without exceeding the chunk limit
+func (n *listNode) fits(val []byte) bool {
+	if n == nil {
+		return false
+	}
+	size := n.size + entrySize(val)
+	fill := Limits.ListMaxListpackSize
+	if fill >= 0 {
+		return n.lp.n < fill && size <= listSafetyLimit
+	}
+	idx := -fill - 1
+	if idx >= len(listSizeLimits) {
+		idx = len(listSizeLimits) - 1
+	}
+	return size <= listSizeLimits[idx]
+}
+
+// view returns the listpack of the node, a compressed node is inflated without being changed
+// so that readers holding a read lock don't modify it
+func (n *listNode) view() *listpack {
+	if n.compressed == nil {
+		return &n.lp
+	}
+	return &listpack{buf: inflate(n.compressed, n.size), n: n.lp.n}
+}
+
+// items returns the elements of the node
+func (n *listNode) items() [][]byte {
+	lp := n.view()
+	items := make([][]byte, 0, lp.n)
+	for off := 0; off < len(lp.buf); {
+		var val []byte
+		val, off = lp.entry(off)
+		items = append(items, val)
+	}
+	return items
+}
+
+// at returns the i-th element of the node
+func (n *listNode) at(i int) []byte {
+	lp := n.view()
+	val, _ := lp.entry(lp.skip(0, i))
+	return val
+}
+
+func inflate(compressed []byte, size int) []byte {
+	buf := bytes.NewBuffer(make([]byte, 0, size))
+	if _, err := buf.ReadFrom(flate.NewReader(bytes.NewReader(compressed))); err != nil {
+		panic("list: corrupted compressed node: " + err.Error())
+	}
+	return buf.Bytes()
+}
+
+// decompress makes the elements of the node writable
+func (n *listNode) decompress() {
+	if n.compressed != nil {
+		n.lp.buf = inflate(n.compressed, n.size)
+		n.compressed = nil
+	}
+}
+
+// compress deflates the listpack of the node if it makes it smaller
+func (n *listNode) compress() {
+	if n.compressed != nil || n.size < listMinCompress {
+		return
+	}
+	var buf bytes.Buffer
+	w := flateWriters.Get().(*flate.Writer)
+	w.Reset(&buf)
+	_, _ = w.Write(n.lp.buf)
+	_ = w.Close()
+	flateWriters.Put(w)
+	if buf.Len() < n.size {
+		n.compressed = buf.Bytes()
+		n.lp.buf = nil
+	}
+}
+
+func (n *listNode) pushFront(val []byte) {
+	n.lp.splice(0, 0, 0, val)
+	n.size = len(n.lp.buf)
+}
+
+func (n *listNode) pushBack(val []byte) {
+	n.lp.push(val)
+	n.size = len(n.lp.buf)
+}
+
+// insertAt inserts val before the i-th element of the node
+func (n *listNode) insertAt(i int, val []byte) {
+	off := n.lp.skip(0, i)
+	n.lp.splice(off, off, 0, val)
+	n.size = len(n.lp.buf)
+}
+
+// removeAt removes and returns the i-th element of the node
+func (n *listNode) removeAt(i int) []byte {
+	off := n.lp.skip(0, i)
+	val := n.lp.cut(off)
+	n.size = len(n.lp.buf)
+	return val
+}
+
+// set replaces the i-th element of the node
+func (n *listNode) set(i int, val []byte) {
+	off := n.lp.skip(0, i)
+	_, next := n.lp.entry(off)
+	n.lp.splice(off, next, 1, val)
+	n.size = len(n.lp.buf)
+}
+
+// split moves the elements from the i-th one to a new node and returns it
+func (n *listNode) split(i int) *listNode {
+	off := n.lp.skip(0, i)
+	right := &listNode{lp: listpack{buf: n.lp.buf[off:], n: n.lp.n - i}}
+	right.size = len(right.lp.buf)
+	// the cap is limited, so that a push to n doesn't overwrite the elements of right
+	n.lp.buf = n.lp.buf[:off:off]
+	n.lp.n = i
+	n.size = off
+	return right
+}
+
+// linkAfter links node after prev, at the head if prev is nil
+func (l *List) linkAfter(prev, node *listNode) {
+	node.prev = prev
+	if prev == nil {
+		node.next = l.head
+		l.head = node
+	} else {
+		node.next = prev.next
+		prev.next = node
+	}
+	if node.next == nil {
+		l.tail = node
+	} else {
+		node.next.prev = node
+	}
+	l.nodes++
+}
 
-	return &List{
-		Head: head,
-		Tail: tail,
-		Len:  0,
+func (l *List) unlink(node *listNode) {
+	if node.prev == nil {
+		l.head = node.next
+	} else {
+		node.prev.next = node.next
+	}
+	if node.next == nil {
+		l.tail = node.prev
+	} else {
+		node.next.prev = node.prev
 	}
+	node.prev, node.next = nil, nil
+	l.nodes--
+}
+
+// compress keeps the nodes within Limits.ListCompressDepth of both ends uncompressed,
+// and compresses node and the first interior nodes from both ends, which a push may have moved inside.
+func (l *List) compress(node *listNode) {
+	depth := Limits.ListCompressDepth
+	if depth <= 0 || l.head == nil {
+		return
+	}
+	fwd, rev := l.head, l.tail
+	for i := 0; i < depth; i++ {
+		fwd.decompress()
+		rev.decompress()
+		if fwd == node || rev == node {
+			node = nil
+		}
+		// all the nodes are near an end
+		if fwd == rev || fwd.next == rev {
+			return
+		}
+		fwd, rev = fwd.next, rev.prev
+	}
+	fwd.compress()
+	rev.compress()
+	if node != nil {
+		node.compress()
+	}
+}
+
+// locate returns the node of the element at index and the index in the node,
+// walking from the closer end of the list
+func (l *List) locate(index int) (*listNode, int) {
+	if index < l.Len/2 {
+		for node := l.head; node != nil; node = node.next {
+			if index < node.lp.n {
+				return node, index
+			}
+			index -= node.lp.n
+		}
+		return nil, 0
+	}
+	index = l.Len - 1 - index
+	for node := l.tail; node != nil; node = node.prev {
+		if index < node.lp.n {
+			return node, node.lp.n - 1 - index
+		}
+		index -= node.lp.n
+	}
+	return nil, 0
 }
 
 func (l *List) Index(index int) *ListNode {
@@ -36,35 +281,70 @@ func (l *List) Index(index int) *ListNode {
 	if index < 0 || index >= l.Len {
 		return nil
 	}
-	node := l.Head.Next
-	for i := 0; i < index; i++ {
-		node = node.Next
+	node, i := l.locate(index)
+	return &ListNode{Val: node.at(i)}
+}
+
+// ForEach calls fn for each element from the head until fn returns false
+func (l *List) ForEach(fn func(index int, val []byte) bool) {
+	index := 0
+	for node := l.head; node != nil; node = node.next {
+		for _, val := range node.items() {
+			if !fn(index, val) {
+				return
+			}
+			index++
+		}
 	}
-	return node
 }
 
-func (l *List) Pos(val []byte) int {
-	pos := 0
-	for cur := l.Head.Next; cur != l.Tail; cur = cur.Next {
-		if bytes.Equal(cur.Val, val) {
-			return pos
+// ReverseForEach calls fn for each element from the tail until fn returns false
+func (l *List) ReverseForEach(fn func(index int, val []byte) bool) {
+	index := l.Len - 1
+	for node := l.tail; node != nil; node = node.prev {
+		items := node.items()
+		for i := len(items) - 1; i >= 0; i-- {
+			if !fn(index, items[i]) {
+				return
+			}
+			index--
 		}
-		pos++
 	}
-	return -1
+}
+
+func (l *List) Pos(val []byte) int {
+	pos := -1
+	l.ForEach(func(index int, cur []byte) bool {
+		if bytes.Equal(cur, val) {
+			pos = index
+			return false
+		}
+		return true
+	})
+	return pos
 }
 
 func (l *List) LPush(val []byte) {
-	node := &ListNode{Prev: l.Head, Next: l.Head.Next, Val: val}
-	l.Head.Next = node
-	node.Next.Prev = node
+	if l.head.fits(val) {
+		l.head.pushFront(val)
+	} else {
+		node := &listNode{}
+		node.pushBack(val)
+		l.linkAfter(nil, node)
+		l.compress(nil)
+	}
 	l.Len++
 }
 
 func (l *List) RPush(val []byte) {
-	node := &ListNode{Prev: l.Tail.Prev, Next: l.Tail, Val: val}
-	l.Tail.Prev = node
-	node.Prev.Next = node
+	if l.tail.fits(val) {
+		l.tail.pushBack(val)
+	} else {
+		node := &listNode{}
+		node.pushBack(val)
+		l.linkAfter(l.tail, node)
+		l.compress(nil)
+	}
 	l.Len++
 }
 
@@ -72,49 +352,41 @@ func (l *List) LPop() *ListNode {
 	if l.Len == 0 {
 		return nil
 	}
-	node := l.Head.Next
-	l.Head.Next = node.Next
-	node.Next.Prev = l.Head
-	node.Prev = nil
-	node.Next = nil
+	node := l.head
+	val := node.removeAt(0)
 	l.Len--
-	return node
+	if node.lp.n == 0 {
+		l.unlink(node)
+		l.compress(nil)
+	}
+	return &ListNode{Val: val}
 }
+
 func (l *List) RPop() *ListNode {
 	if l.Len == 0 {
 		return nil
 	}
-	node := l.Tail.Prev
-	l.Tail.Prev = node.Prev
-	node.Prev.Next = l.Tail
-	node.Prev = nil
-	node.Next = nil
+	node := l.tail
+	val := node.removeAt(node.lp.n - 1)
 	l.Len--
-	return node
+	if node.lp.n == 0 {
+		l.unlink(node)
+		l.compress(nil)
+	}
+	return &ListNode{Val: val}
 }
 
 func (l *List) Set(index int, val []byte) bool {
 	if index < 0 {
-		if -index > l.Len {
-			return false
-		}
-		node := l.Tail
-		for node != l.Head && index < 0 {
-			node = node.Prev
-			index++
-		}
-		node.Val = val
-	} else {
-		if index >= l.Len {
-			return false
-		}
-		node := l.Head
-		for node != l.Tail && index >= 0 {
-			node = node.Next
-			index--
-		}
-		node.Val = val
+		index += l.Len
+	}
+	if index < 0 || index >= l.Len {
+		return false
 	}
+	node, i := l.locate(index)
+	node.decompress()
+	node.set(i, val)
+	l.compress(node)
 	return true
 }
 
@@ -125,61 +397,96 @@ func (l *List) Range(start, end int) [][]byte {
 	if end < 0 {
 		end += l.Len
 	}
+	if start < 0 {
+		start = 0
+	}
 	if end >= l.Len {
 		end = l.Len - 1
 	}
+	if start > end {
+		return [][]byte{}
+	}
 	res := make([][]byte, 0, end-start+1)
-	node := l.Head
-	for i := 0; i <= end; i++ {
-		node = node.Next
-		if i >= start {
-			res = append(res, node.Val)
+	node, i := l.locate(start)
+	for ; node != nil && len(res) < cap(res); node = node.next {
+		items := node.items()[i:]
+		if rest := cap(res) - len(res); len(items) > rest {
+			items = items[:rest]
 		}
+		res = append(res, items...)
+		i = 0
 	}
 	return res
 }
 
-func (l *List) InsertBefore(val []byte, tar []byte) int {
+// find returns the node and the index in the node of the first element equal to val, and its index in the list
+func (l *List) find(val []byte) (*listNode, int, int) {
 	pos := 0
-	ok := false
-	for cur := l.Head.Next; cur != l.Tail; cur = cur.Next {
-		if bytes.Equal(cur.Val, tar) {
-			ok = true
-			node := &ListNode{Prev: cur.Prev, Next: cur, Val: val}
-			cur.Prev = node
-			node.Prev.Next = node
-			l.Len++
-			break
+	for node := l.head; node != nil; node = node.next {
+		for i, cur := range node.items() {
+			if bytes.Equal(cur, val) {
+				return node, i, pos
+			}
+			pos++
 		}
-		pos++
 	}
-	if ok {
-		return pos
-	}
-	return -1
+	return nil, 0, -1
 }
 
-func (l *List) InsertAfter(val, tar []byte) int {
-	pos := 0
-	ok := false
-	for cur := l.Head.Next; cur != l.Tail; cur = cur.Next {
-		if bytes.Equal(cur.Val, tar) {
-			ok = true
-			node := &ListNode{
-				Prev: cur,
-				Next: cur.Next,
-				Val:  val,
+// insert inserts val before the i-th element of node, splitting node if it is full
+func (l *List) insert(node *listNode, i int, val []byte) {
+	l.Len++
+	orig := node
+	node.decompress()
+	switch {
+	case node.fits(val):
+		node.insertAt(i, val)
+	case i == 0 && node.prev.fits(val):
+		node = node.prev
+		node.decompress()
+		node.pushBack(val)
+	case i == node.lp.n && node.next.fits(val):
+		node = node.next
+		node.decompress()
+		node.pushFront(val)
+	default:
+		newNode := &listNode{}
+		newNode.pushBack(val)
+		if i == 0 {
+			l.linkAfter(node.prev, newNode)
+		} else {
+			if i < node.lp.n {
+				// move the elements after val to a new node
+				right := node.split(i)
+				l.linkAfter(node, right)
+				l.compress(right)
 			}
-			cur.Next = node
-			node.Next.Prev = node
-			pos++
-			l.Len++
+			l.linkAfter(node, newNode)
 		}
+		node = newNode
+	}
+	l.compress(orig)
+	l.compress(node)
+}
+
+// InsertBefore inserts val before the first element equal to tar and returns the index of val, -1 if tar is not found
+func (l *List) InsertBefore(val []byte, tar []byte) int {
+	node, i, pos := l.find(tar)
+	if node == nil {
+		return -1
 	}
-	if ok {
-		return pos + 1
+	l.insert(node, i, val)
+	return pos
+}
+
+// InsertAfter inserts val after the first element equal to tar and returns the index of val, -1 if tar is not found
+func (l *List) InsertAfter(val, tar []byte) int {
+	node, i, pos := l.find(tar)
+	if node == nil {
+		return -1
 	}
-	return -1
+	l.insert(node, i+1, val)
+	return pos + 1
 }
 
 // RemoveElement remove count number elements with Val=val from list.
@@ -187,43 +494,73 @@ func (l *List) InsertAfter(val, tar []byte) int {
 // if count>0, remove from head to tail, otherwise remove from tail to head.
 // return the number of elements removed.
 func (l *List) RemoveElement(val []byte, count int) int {
-	if l.Len == 0 {
-		return 0
+	limit := count
+	if limit < 0 {
+		limit = -limit
 	}
-	if count == 0 {
-		count = l.Len - 1
+	if limit == 0 {
+		limit = l.Len
 	}
 	removed := 0
 	if count >= 0 {
-		for cur := l.Head.Next; cur != l.Tail && removed < count; {
-			if bytes.Equal(cur.Val, val) {
-				temp := cur.Next
-				cur.Prev.Next = cur.Next
-				cur.Next.Prev = cur.Prev
-				cur.Next = nil
-				cur.Prev = nil
-				removed++
-				cur = temp
-				l.Len--
-			} else {
-				cur = cur.Next
-			}
+		for node := l.head; node != nil && removed < limit; {
+			next := node.next
+			removed += l.removeFromNode(node, val, limit-removed, false)
+			node = next
 		}
 	} else {
-		for cur := l.Tail.Prev; cur != l.Head && removed < -count; {
-			if bytes.Equal(cur.Val, val) {
-				temp := cur.Prev
-				cur.Prev.Next = cur.Next
-				cur.Next.Prev = cur.Prev
-				cur.Prev = nil
-				cur.Next = nil
-				removed++
-				cur = temp
-				l.Len--
-			} else {
-				cur = cur.Prev
+		for node := l.tail; node != nil && removed < limit; {
+			prev := node.prev
+			removed += l.removeFromNode(node, val, limit-removed, true)
+			node = prev
+		}
+	}
+	l.Len -= removed
+	return removed
+}
+
+// removeFromNode removes at most limit elements equal to val from node, from its tail if reverse is true
+func (l *List) removeFromNode(node *listNode, val []byte, limit int, reverse bool) int {
+	items := node.items()
+	equal := 0
+	for _, cur := range items {
+		if bytes.Equal(cur, val) {
+			equal++
+		}
+	}
+	if equal == 0 {
+		return 0
+	}
+	matched := equal
+	if matched > limit {
+		matched = limit
+	}
+	node.decompress()
+	// with reverse, the last matched of the equal elements are removed
+	skipped := 0
+	if reverse {
+		skipped = equal - matched
+	}
+	kept := make([][]byte, 0, len(items))
+	for _, cur := range items {
+		if bytes.Equal(cur, val) {
+			if skipped == 0 && matched > 0 {
+				matched--
+				continue
 			}
+			skipped--
 		}
+		kept = append(kept, cur)
+	}
+	removed := node.lp.n - len(kept)
+	node.lp = listpack{}
+	node.lp.append(kept...)
+	node.size = len(node.lp.buf)
+	if node.lp.n == 0 {
+		l.unlink(node)
+		l.compress(nil)
+	} else {
+		l.compress(node)
 	}
 	return removed
 }
@@ -248,47 +585,59 @@ func (l *List) Trim(start, end int) {
 	if end >= l.Len {
 		end = l.Len - 1
 	}
-	var pos int
-	var startNode, endNode *ListNode
-	for cur := l.Head.Next; cur != l.Tail; cur = cur.Next {
-		if pos == start {
-			startNode = cur
-		}
-		if pos == end {
-			endNode = cur
-			break
+	l.removeFront(start)
+	l.removeBack(l.Len - (end - start + 1))
+}
+
+// removeFront removes the first n elements
+func (l *List) removeFront(n int) {
+	for n > 0 {
+		node := l.head
+		if node.lp.n <= n {
+			n -= node.lp.n
+			l.Len -= node.lp.n
+			l.unlink(node)
+			continue
 		}
-		pos++
-	}
-	// remove discarded nodes for gc
-	l.Head.Next.Prev = nil
-	l.Tail.Prev.Next = nil
-	if startNode.Prev != nil {
-		startNode.Prev.Next = nil
-	}
-	if endNode.Next != nil {
-		endNode.Next.Prev = nil
+		node.decompress()
+		right := node.split(n)
+		node.lp, node.size = right.lp, right.size
+		l.Len -= n
+		n = 0
 	}
+	l.compress(nil)
+}
 
-	//	link trimmed nodes to head and tail
-	l.Head.Next = startNode
-	startNode.Prev = l.Head
-	l.Tail.Prev = endNode
-	endNode.Next = l.Tail
-	l.Len = end - start + 1
+// removeBack removes the last n elements
+func (l *List) removeBack(n int) {
+	for n > 0 {
+		node := l.tail
+		if node.lp.n <= n {
+			n -= node.lp.n
+			l.Len -= node.lp.n
+			l.unlink(node)
+			continue
+		}
+		node.decompress()
+		node.split(node.lp.n - n)
+		l.Len -= n
+		n = 0
+	}
+	l.compress(nil)
 }
 
 func (l *List) Clear() {
-	if l.Len == 0 {
-		return
-	}
-	first := l.Head.Next
-	last := l.Tail.Prev
-	l.Head.Next = l.Tail
-	l.Tail.Prev = l.Head
-	l.Len = 0
+	l.head, l.tail = nil, nil
+	l.Len, l.nodes = 0, 0
+}
 
-	// gc will remove the list
-	first.Prev = nil
-	last.Next = nil
+// Copy returns a copy of the list, the nodes share the listpacks that are never changed in place
+func (l *List) Copy() *List {
+	res := NewList()
+	for node := l.head; node != nil; node = node.next {
+		newNode := &listNode{lp: *node.lp.copy(), size: node.size, compressed: node.compressed}
+		res.linkAfter(res.tail, newNode)
+	}
+	res.Len = l.Len
+	return res
 }
@@ -0,0 +1,246 @@
+package datastructure
+
+import (
+	"bytes"
+	"math/rand"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// withListLimits runs fn with small list nodes and compression so that the operations cross the nodes
+func withListLimits(size, depth int, fn func()) {
+	old := Limits
+	defer func() { Limits = old }()
+	Limits.ListMaxListpackSize = size
+	Limits.ListCompressDepth = depth
+	fn()
+}
+
+func listString(l *List) string {
+	var elems []string
+	for _, val := range l.Range(0, -1) {
+		elems = append(elems, string(val))
+	}
+	return strings.Join(elems, ",")
+}
+
+func TestListPushPop(t *testing.T) {
+	withListLimits(4, 1, func() {
+		l := NewList()
+		for i := 0; i < 20; i++ {
+			l.RPush([]byte("r" + strconv.Itoa(i)))
+			l.LPush([]byte("l" + strconv.Itoa(i)))
+		}
+		if l.Len != 40 || l.nodes != 11 {
+			t.Fatalf("list has %d elements in %d nodes", l.Len, l.nodes)
+		}
+		for i := 19; i >= 0; i-- {
+			if val := l.LPop().Val; string(val) != "l"+strconv.Itoa(i) {
+				t.Fatalf("lpop returns %s", val)
+			}
+			if val := l.RPop().Val; string(val) != "r"+strconv.Itoa(i) {
+				t.Fatalf("rpop returns %s", val)
+			}
+		}
+		if l.Len != 0 || l.head != nil || l.tail != nil || l.LPop() != nil || l.RPop() != nil {
+			t.Errorf("empty list has %d elements", l.Len)
+		}
+	})
+}
+
+func TestListIndex(t *testing.T) {
+	withListLimits(3, 1, func() {
+		l := NewList()
+		for i := 0; i < 30; i++ {
+			l.RPush([]byte(strconv.Itoa(i)))
+		}
+		for i := 0; i < 30; i++ {
+			if val := l.Index(i).Val; string(val) != strconv.Itoa(i) {
+				t.Errorf("index %d is %s", i, val)
+			}
+			if val := l.Index(i - 30).Val; string(val) != strconv.Itoa(i) {
+				t.Errorf("index %d is %s", i-30, val)
+			}
+		}
+		if l.Index(30) != nil || l.Index(-31) != nil {
+			t.Error("index out of range returns an element")
+		}
+		if !l.Set(14, []byte("x")) || !l.Set(-1, []byte("y")) || l.Set(30, []byte("z")) {
+			t.Error("set returns a wrong result")
+		}
+		if got := listString(l); !strings.Contains(got, ",13,x,15,") || !strings.HasSuffix(got, ",28,y") {
+			t.Errorf("list after set is %s", got)
+		}
+		if got := l.Range(-5, 100); len(got) != 5 || string(got[0]) != "25" {
+			t.Errorf("range -5 100 returns %q", got)
+		}
+		if got := l.Range(20, 10); len(got) != 0 {
+			t.Errorf("range 20 10 returns %q", got)
+		}
+	})
+}
+
+func TestListInsert(t *testing.T) {
+	withListLimits(4, 1, func() {
+		l := NewList()
+		for i := 0; i < 8; i++ {
+			l.RPush([]byte(strconv.Itoa(i)))
+		}
+		// the nodes are full, the insertions split them
+		if l.InsertBefore([]byte("a"), []byte("2")) != 2 || l.InsertAfter([]byte("b"), []byte("5")) != 7 {
+			t.Error("insert returns a wrong index")
+		}
+		if l.InsertBefore([]byte("c"), []byte("0")) != 0 || l.InsertAfter([]byte("d"), []byte("7")) != 11 {
+			t.Error("insert at the ends returns a wrong index")
+		}
+		if l.InsertAfter([]byte("e"), []byte("none")) != -1 {
+			t.Error("insert after a missing element")
+		}
+		if got := listString(l); got != "c,0,1,a,2,3,4,5,b,6,7,d" || l.Len != 12 {
+			t.Errorf("list after insert is %s", got)
+		}
+		for node := l.head; node != nil; node = node.next {
+			if node.lp.n == 0 || node.lp.n > 4 {
+				t.Errorf("node has %d elements", node.lp.n)
+			}
+		}
+	})
+}
+
+func TestListRemove(t *testing.T) {
+	withListLimits(3, 1, func() {
+		l := NewList()
+		for _, val := range strings.Split("a,b,a,c,a,a,d,a", ",") {
+			l.RPush([]byte(val))
+		}
+		if l.RemoveElement([]byte("a"), 2) != 2 || listString(l) != "b,c,a,a,d,a" {
+			t.Errorf("remove 2 from head, list is %s", listString(l))
+		}
+		if l.RemoveElement([]byte("a"), -1) != 1 || listString(l) != "b,c,a,a,d" {
+			t.Errorf("remove 1 from tail, list is %s", listString(l))
+		}
+		if l.RemoveElement([]byte("a"), 0) != 2 || listString(l) != "b,c,d" || l.Len != 3 {
+			t.Errorf("remove all, list is %s", listString(l))
+		}
+
+		l = NewList()
+		for i := 0; i < 20; i++ {
+			l.RPush([]byte(strconv.Itoa(i)))
+		}
+		l.Trim(4, -5)
+		if l.Len != 12 || string(l.Index(0).Val) != "4" || string(l.Index(-1).Val) != "15" {
+			t.Errorf("list after trim is %s", listString(l))
+		}
+		l.Trim(5, 2)
+		if l.Len != 0 || l.head != nil {
+			t.Errorf("list after an empty trim is %s", listString(l))
+		}
+	})
+}
+
+// TestListCompress checks that the nodes inside the depth are compressed and that the reads don't change them
+func TestListCompress(t *testing.T) {
+	withListLimits(8, 1, func() {
+		l := NewList()
+		for i := 0; i < 64; i++ {
+			l.RPush([]byte(strings.Repeat(strconv.Itoa(i%10), 20)))
+		}
+		compressed := 0
+		for node := l.head; node != nil; node = node.next {
+			if node.compressed != nil {
+				compressed++
+			}
+		}
+		if l.head.compressed != nil || l.tail.compressed != nil || compressed != l.nodes-2 {
+			t.Fatalf("%d of %d nodes are compressed", compressed, l.nodes)
+		}
+		if val := l.Index(20).Val; string(val) != strings.Repeat("0", 20) {
+			t.Errorf("index 20 of a compressed list is %s", val)
+		}
+		if l.head.next.compressed == nil {
+			t.Error("read decompressed a node")
+		}
+		l.Set(20, []byte("x"))
+		cp := l.Copy()
+		l.Set(21, []byte("y"))
+		if l.InsertAfter([]byte("z"), []byte("x")) != 21 || string(cp.Index(20).Val) != "x" || string(cp.Index(21).Val) == "y" {
+			t.Error("write to a compressed node failed")
+		}
+		if got := l.Range(19, 22); string(bytes.Join(got, []byte(","))) != strings.Repeat("9", 20)+",x,z,y" {
+			t.Errorf("range after the writes is %q", got)
+		}
+	})
+}
+
+// TestListRandom compares the list with a slice
+func TestListRandom(t *testing.T) {
+	withListLimits(-1, 2, func() {
+		l := NewList()
+		var expect [][]byte
+		for i := 0; i < 5000; i++ {
+			val := []byte(strings.Repeat("v", rand.Intn(300)) + strconv.Itoa(i))
+			switch rand.Intn(6) {
+			case 0:
+				l.LPush(val)
+				expect = append([][]byte{val}, expect...)
+			case 1, 2:
+				l.RPush(val)
+				expect = append(expect, val)
+			case 3:
+				if len(expect) > 0 {
+					l.LPop()
+					expect = expect[1:]
+				}
+			case 4:
+				if len(expect) > 0 {
+					j := rand.Intn(len(expect))
+					l.InsertBefore(val, expect[j])
+					expect = append(expect[:j], append([][]byte{val}, expect[j:]...)...)
+				}
+			case 5:
+				if len(expect) > 0 {
+					j := rand.Intn(len(expect))
+					l.Set(j, val)
+					expect[j] = val
+				}
+			}
+		}
+		if l.Len != len(expect) {
+			t.Fatalf("list has %d elements, expect %d", l.Len, len(expect))
+		}
+		for i, val := range l.Range(0, -1) {
+			if !bytes.Equal(val, expect[i]) {
+				t.Fatalf("element %d is %.20s, expect %.20s", i, val, expect[i])
+			}
+		}
+	})
+}
+
+// TestListValuesStable checks that the nodes never overwrite the elements returned before, nor the ones of a copy
+func TestListValuesStable(t *testing.T) {
+	withListLimits(8, 0, func() {
+		l := NewList()
+		for _, val := range []string{"a", "b", "c", "d"} {
+			l.RPush([]byte(val))
+		}
+		last := l.RPop().Val
+		first := l.LPop().Val
+		cp := l.Copy()
+		l.RPush([]byte("x"))
+		cp.RPush([]byte("y"))
+		l.LPush([]byte("z"))
+		if string(last) != "d" || string(first) != "a" {
+			t.Errorf("popped elements changed to %s and %s", last, first)
+		}
+		if got := listString(l); got != "z,b,c,x" {
+			t.Errorf("list is %s", got)
+		}
+		if got := listString(cp); got != "b,c,y" {
+			t.Errorf("copy is %s", got)
+		}
+		if l.head.size != len(l.head.lp.buf) || l.head.size != 8 {
+			t.Errorf("node size %d, listpack of %d bytes", l.head.size, len(l.head.lp.buf))
+		}
+	})
+}
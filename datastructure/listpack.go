@@ -0,0 +1,154 @@
+package datastructure
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// EncodingLimits are the sizes up to which small collections use a compact encoding, a collection growing
+// past them is converted to the regular encoding and never converted back.
+// They also hold the size of the list nodes and the number of nodes left uncompressed at both ends of a list.
+type EncodingLimits struct {
+	HashMaxListpackEntries int
+	HashMaxListpackValue   int
+	SetMaxIntsetEntries    int
+	SetMaxListpackEntries  int
+	SetMaxListpackValue    int
+	ZSetMaxListpackEntries int
+	ZSetMaxListpackValue   int
+	ListMaxListpackSize    int
+	ListCompressDepth      int
+}
+
+// Limits are the limits in use, the defaults are the ones of redis. They should only be changed at startup.
+var Limits = EncodingLimits{
+	HashMaxListpackEntries: 128,
+	HashMaxListpackValue:   64,
+	SetMaxIntsetEntries:    512,
+	SetMaxListpackEntries:  128,
+	SetMaxListpackValue:    64,
+	ZSetMaxListpackEntries: 128,
+	ZSetMaxListpackValue:   64,
+	ListMaxListpackSize:    -2,
+	ListCompressDepth:      0,
+}
+
+// the names of the encodings, as replied by OBJECT ENCODING
+const (
+	EncodingListpack  = "listpack"
+	EncodingIntset    = "intset"
+	EncodingHashtable = "hashtable"
+	EncodingSkiplist  = "skiplist"
+	EncodingQuicklist = "quicklist"
+)
+
+// listpack is the compact encoding of small collections and of the nodes of lists: all the entries are stored
+// one after another in a []byte, each one as its uvarint length followed by its content.
+// Lookups scan from the start, so it only suits few entries.
+// A change never overwrites the bytes of buf, it allocates a new buf or only writes past the end of buf,
+// so the entries read before can still be used after the lock is released, and copies can share buf.
+type listpack struct {
+	buf []byte
+	// the number of entries
+	n int
+}
+
+// entry returns the entry at off and the offset of the next entry
+func (lp *listpack) entry(off int) ([]byte, int) {
+	l, n := binary.Uvarint(lp.buf[off:])
+	start := off + n
+	end := start + int(l)
+	// the cap is limited, so that an append of the caller doesn't overwrite the next entries
+	return lp.buf[start:end:end], end
+}
+
+// find compares every step-th entry from the start with val, it returns the offset of the equal entry and of the entry after it
+func (lp *listpack) find(val string, step int) (int, int, bool) {
+	for off := 0; off < len(lp.buf); {
+		e, next := lp.entry(off)
+		if string(e) == val {
+			return off, next, true
+		}
+		off = next
+		for i := 1; i < step; i++ {
+			_, off = lp.entry(off)
+		}
+	}
+	return 0, 0, false
+}
+
+// skip returns the offset of the count-th entry after off
+func (lp *listpack) skip(off, count int) int {
+	for i := 0; i < count; i++ {
+		_, off = lp.entry(off)
+	}
+	return off
+}
+
+// splice replaces the removed entries in [start, end) by entries
+func (lp *listpack) splice(start, end, removed int, entries ...[]byte) {
+	size := len(lp.buf) - (end - start)
+	for _, e := range entries {
+		size += uvarintLen(uint64(len(e))) + len(e)
+	}
+	buf := make([]byte, 0, size)
+	buf = append(buf, lp.buf[:start]...)
+	for _, e := range entries {
+		buf = binary.AppendUvarint(buf, uint64(len(e)))
+		buf = append(buf, e...)
+	}
+	lp.buf = append(buf, lp.buf[end:]...)
+	lp.n += len(entries) - removed
+}
+
+// append adds entries at the end
+func (lp *listpack) append(entries ...[]byte) {
+	lp.splice(len(lp.buf), len(lp.buf), 0, entries...)
+}
+
+// push adds val at the end, in the room after buf when there is some
+func (lp *listpack) push(val []byte) {
+	lp.buf = binary.AppendUvarint(lp.buf, uint64(len(val)))
+	lp.buf = append(lp.buf, val...)
+	lp.n++
+}
+
+// cut removes the entry at off and returns it. The first and the last entries are cut off without a copy,
+// the cap of buf is limited after cutting the last one, so that a push doesn't overwrite it.
+func (lp *listpack) cut(off int) []byte {
+	val, next := lp.entry(off)
+	switch {
+	case off == 0:
+		lp.buf = lp.buf[next:]
+		lp.n--
+	case next == len(lp.buf):
+		lp.buf = lp.buf[:off:off]
+		lp.n--
+	default:
+		lp.splice(off, next, 1)
+	}
+	return val
+}
+
+// copy returns a copy sharing buf, the cap of buf is limited so that a push to a copy doesn't write into the others
+func (lp *listpack) copy() *listpack {
+	return &listpack{buf: lp.buf[:len(lp.buf):len(lp.buf)], n: lp.n}
+}
+
+func uvarintLen(x uint64) int {
+	n := 1
+	for x >= 0x80 {
+		x >>= 7
+		n++
+	}
+	return n
+}
+
+// encodeScore encodes a score in 8 bytes
+func encodeScore(score float64) []byte {
+	return binary.BigEndian.AppendUint64(make([]byte, 0, 8), math.Float64bits(score))
+}
+
+func decodeScore(b []byte) float64 {
+	return math.Float64frombits(binary.BigEndian.Uint64(b))
+}
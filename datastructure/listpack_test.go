@@ -0,0 +1,178 @@
+package datastructure
+
+import (
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestListpack(t *testing.T) {
+	lp := &listpack{}
+	lp.append([]byte("a"), []byte(""), []byte(strings.Repeat("x", 200)))
+	if lp.n != 3 {
+		t.Fatalf("listpack has %d entries, expect 3", lp.n)
+	}
+	off, next, ok := lp.find(strings.Repeat("x", 200), 1)
+	if !ok || next != len(lp.buf) {
+		t.Fatalf("find the long entry: %d %d %v", off, next, ok)
+	}
+	old := lp.copy()
+	first, _ := lp.entry(0)
+	lp.splice(0, lp.skip(0, 1), 1, []byte("b"), []byte("c"))
+	if e, _ := lp.entry(0); string(e) != "b" || lp.n != 4 {
+		t.Errorf("first entry after splice %q, %d entries", e, lp.n)
+	}
+	// the entries read before a change and the copies are not changed
+	if string(first) != "a" {
+		t.Errorf("entry read before splice is %q", first)
+	}
+	if e, _ := old.entry(0); string(e) != "a" || old.n != 3 {
+		t.Errorf("copy changed by splice: %q, %d entries", e, old.n)
+	}
+}
+
+func TestHashEncoding(t *testing.T) {
+	h := NewHash()
+	for i := 0; i < Limits.HashMaxListpackEntries; i++ {
+		h.Set("f"+strconv.Itoa(i), []byte(strconv.Itoa(i)))
+	}
+	h.Set("f0", []byte("new"))
+	if h.Encoding() != EncodingListpack || h.Len() != Limits.HashMaxListpackEntries {
+		t.Fatalf("hash is %s with %d fields", h.Encoding(), h.Len())
+	}
+	if string(h.Get("f0")) != "new" || h.Del("f1") != 1 || h.Exist("f1") {
+		t.Error("listpack hash get or del failed")
+	}
+	h.SetExpire("f2", 100)
+	if n, _ := h.IncrBy("f2", 1); n != 3 {
+		t.Errorf("incrby f2 returns %d", n)
+	}
+	if _, ok := h.Expire("f2"); !ok {
+		t.Error("incrby removed the expire time of f2")
+	}
+	h.Set("f1", []byte("1"))
+	h.Set("f128", []byte("128"))
+	if h.Encoding() != EncodingHashtable || h.Len() != Limits.HashMaxListpackEntries+1 || string(h.Get("f0")) != "new" {
+		t.Errorf("hash is %s with %d fields after the conversion", h.Encoding(), h.Len())
+	}
+
+	h = NewHash()
+	h.Set("f", []byte(strings.Repeat("v", Limits.HashMaxListpackValue+1)))
+	if h.Encoding() != EncodingHashtable {
+		t.Errorf("hash with a long value is %s", h.Encoding())
+	}
+}
+
+func TestSetEncoding(t *testing.T) {
+	s := NewSet()
+	for _, m := range []string{"3", "-1", "2", "3"} {
+		s.Add(m)
+	}
+	if s.Encoding() != EncodingIntset || strings.Join(s.Member(), ",") != "-1,2,3" {
+		t.Fatalf("set is %s with %v", s.Encoding(), s.Member())
+	}
+	// not canonical integers are strings
+	if s.Has("02") || s.Has("+2") {
+		t.Error("intset has a not canonical integer")
+	}
+	s.Add("02")
+	if s.Encoding() != EncodingListpack || s.Len() != 4 || !s.Has("02") || !s.Has("-1") {
+		t.Fatalf("set is %s with %v", s.Encoding(), s.Member())
+	}
+	s.Remove("-1")
+	for i := s.Len(); i < Limits.SetMaxListpackEntries; i++ {
+		s.Add("m" + strconv.Itoa(i))
+	}
+	if s.Encoding() != EncodingListpack {
+		t.Fatalf("full set is %s", s.Encoding())
+	}
+	s.Add("last")
+	if s.Encoding() != EncodingHashtable || s.Len() != Limits.SetMaxListpackEntries+1 || !s.Has("02") {
+		t.Errorf("set is %s with %d members after the conversion", s.Encoding(), s.Len())
+	}
+
+	s = NewSet()
+	for i := 0; i <= Limits.SetMaxIntsetEntries; i++ {
+		s.Add(strconv.Itoa(i))
+	}
+	if s.Encoding() != EncodingHashtable || s.Len() != Limits.SetMaxIntsetEntries+1 {
+		t.Errorf("large intset is %s with %d members", s.Encoding(), s.Len())
+	}
+
+	a, b := NewSet(), NewSet()
+	a.Add("1")
+	a.Add("x")
+	b.Add("1")
+	b.Add("2")
+	union := a.Union(b).Member()
+	sort.Strings(union)
+	if strings.Join(union, ",") != "1,2,x" || strings.Join(a.Intersect(b).Member(), ",") != "1" ||
+		strings.Join(a.Difference(b).Member(), ",") != "x" {
+		t.Error("set operations across encodings failed")
+	}
+}
+
+// TestSortSetEncoding checks that a listpack sorted set and a skip list one give the same results
+func TestSortSetEncoding(t *testing.T) {
+	lp, sl := NewDefaultSortSet(), NewDefaultSortSet()
+	sl.convert()
+	for i := 0; i < 60; i++ {
+		item := &StItem{F: float64(rand.Intn(20)), K: strconv.Itoa(rand.Intn(80))}
+		lp.Add(item)
+		sl.Add(&StItem{F: item.F, K: item.K})
+	}
+	lp.Remove("1", "2")
+	sl.Remove("1", "2")
+	if lp.Encoding() != EncodingListpack || sl.Encoding() != EncodingSkiplist {
+		t.Fatalf("encodings are %s and %s", lp.Encoding(), sl.Encoding())
+	}
+	same := func(name string, a, b []ISortSet) {
+		if lexKeys(a) != lexKeys(b) {
+			t.Errorf("%s: listpack %s, skip list %s", name, lexKeys(a), lexKeys(b))
+		}
+	}
+	same("range", lp.Range(0, -1), sl.Range(0, -1))
+	same("revrange", lp.RevRange(2, -3), sl.RevRange(2, -3))
+	findRange := &SkipListFindRange{Min: 3, Max: 12, MinBra: true}
+	same("rangebyscore", lp.RangeByScore(findRange), sl.RangeByScore(findRange))
+	same("rangebyscore limit", lp.RevRangeByScoreWithLimit(findRange, 2, 5), sl.RevRangeByScoreWithLimit(findRange, 2, 5))
+	for _, key := range lp.GetAllKeys() {
+		if lp.Rank(key) != sl.Rank(key) || lp.RevRank(key) != sl.RevRank(key) || lp.Score(key) != sl.Score(key) {
+			t.Errorf("rank of %s: listpack %d, skip list %d", key, lp.Rank(key), sl.Rank(key))
+		}
+	}
+	// lex ranges are only defined when all the scores are the same
+	lexLp, lexSl := NewDefaultSortSet(), NewDefaultSortSet()
+	lexSl.convert()
+	lp.ForEach(func(key string, _ float64) bool {
+		lexLp.Add(&StItem{K: key})
+		lexSl.Add(&StItem{K: key})
+		return true
+	})
+	lexRange := &SkipListLexRange{Min: "3", MinBra: true, Max: "6"}
+	same("rangebylex", lexLp.RangeByLex(lexRange, 1, -1), lexSl.RangeByLex(lexRange, 1, -1))
+	same("revrangebylex", lexLp.RevRangeByLex(lexRange, 0, 3), lexSl.RevRangeByLex(lexRange, 0, 3))
+	if lexLp.RemoveRangeByLex(lexRange) != lexSl.RemoveRangeByLex(lexRange) {
+		t.Error("removerangebylex removed different members")
+	}
+	same("range after removerangebylex", lexLp.Range(0, -1), lexSl.Range(0, -1))
+
+	if lp.RemoveRangeByScore(0, 5, false, false, false, false) != sl.RemoveRangeByScore(0, 5, false, false, false, false) {
+		t.Error("removerangebyscore removed different members")
+	}
+	same("range after remove", lp.Range(0, -1), sl.Range(0, -1))
+
+	for i := 0; lp.Count() <= int64(Limits.ZSetMaxListpackEntries); i++ {
+		lp.Add(&StItem{F: float64(i), K: "m" + strconv.Itoa(i)})
+	}
+	if lp.Encoding() != EncodingSkiplist {
+		t.Errorf("large sorted set is %s", lp.Encoding())
+	}
+	long := NewDefaultSortSet()
+	long.Add(&StItem{F: 1, K: strings.Repeat("k", Limits.ZSetMaxListpackValue+1)})
+	if long.Encoding() != EncodingSkiplist || long.Count() != 1 {
+		t.Errorf("sorted set with a long member is %s", long.Encoding())
+	}
+}
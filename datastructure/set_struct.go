@@ -1,102 +1,275 @@
 package datastructure
 
+import (
+	"math/rand"
+	"sort"
+	"strconv"
+)
+
 type void struct{}
 
+// Set is stored in one of three encodings:
+// intset, a sorted slice, while all the members are integers and there are at most Limits.SetMaxIntsetEntries of them;
+// lp, while there are at most Limits.SetMaxListpackEntries members no longer than Limits.SetMaxListpackValue;
+// table otherwise. An empty set is an intset.
 type Set struct {
-	table map[string]void
+	table  map[string]void
+	lp     *listpack
+	intset []int64
 }
 
 func NewSet() *Set {
-	return &Set{make(map[string]void)}
+	return &Set{}
+}
+
+// Copy returns a copy of the set
+func (s *Set) Copy() *Set {
+	switch {
+	case s.table != nil:
+		res := &Set{table: make(map[string]void, len(s.table))}
+		for key := range s.table {
+			res.table[key] = void{}
+		}
+		return res
+	case s.lp != nil:
+		return &Set{lp: s.lp.copy()}
+	}
+	return &Set{intset: append([]int64(nil), s.intset...)}
+}
+
+// Encoding returns the name of the encoding of the set
+func (s *Set) Encoding() string {
+	switch {
+	case s.table != nil:
+		return EncodingHashtable
+	case s.lp != nil:
+		return EncodingListpack
+	}
+	return EncodingIntset
+}
+
+// setInt returns the integer of key if key is the canonical form of an int64
+func setInt(key string) (int64, bool) {
+	v, err := strconv.ParseInt(key, 10, 64)
+	if err != nil || strconv.FormatInt(v, 10) != key {
+		return 0, false
+	}
+	return v, true
+}
+
+// intsetSearch returns the index of v in the intset, or where v should be inserted
+func (s *Set) intsetSearch(v int64) (int, bool) {
+	i := sort.Search(len(s.intset), func(i int) bool { return s.intset[i] >= v })
+	return i, i < len(s.intset) && s.intset[i] == v
+}
+
+// convert moves the members to a listpack if toListpack is true, else to table
+func (s *Set) convert(toListpack bool) {
+	members := s.Member()
+	if toListpack {
+		s.lp = &listpack{}
+		for _, member := range members {
+			s.lp.append([]byte(member))
+		}
+	} else {
+		s.table = make(map[string]void, len(members)+1)
+		for _, member := range members {
+			s.table[member] = void{}
+		}
+		s.lp = nil
+	}
+	s.intset = nil
 }
 
 func (s *Set) Add(key string) int {
 	if s.Has(key) {
 		return 0
 	}
+	if s.table == nil && s.lp == nil {
+		if v, ok := setInt(key); ok && len(s.intset) < Limits.SetMaxIntsetEntries {
+			i, _ := s.intsetSearch(v)
+			s.intset = append(s.intset, 0)
+			copy(s.intset[i+1:], s.intset[i:])
+			s.intset[i] = v
+			return 1
+		}
+		_, isInt := setInt(key)
+		s.convert(!isInt && s.listpackFits(key))
+	}
+	if s.lp != nil {
+		if s.listpackFits(key) {
+			s.lp.append([]byte(key))
+			return 1
+		}
+		s.convert(false)
+	}
 	s.table[key] = void{}
 	return 1
 }
 
+// listpackFits reports whether key can be added to the set in a listpack
+func (s *Set) listpackFits(key string) bool {
+	return s.Len() < Limits.SetMaxListpackEntries && len(key) <= Limits.SetMaxListpackValue
+}
+
 func (s *Set) Len() int {
-	return len(s.table)
+	switch {
+	case s.table != nil:
+		return len(s.table)
+	case s.lp != nil:
+		return s.lp.n
+	}
+	return len(s.intset)
 }
 
 func (s *Set) Remove(key string) int {
-	if s.Has(key) {
-		delete(s.table, key)
-		return 1
+	switch {
+	case s.table != nil:
+		if _, ok := s.table[key]; ok {
+			delete(s.table, key)
+			return 1
+		}
+	case s.lp != nil:
+		if off, end, ok := s.lp.find(key, 1); ok {
+			s.lp.splice(off, end, 1)
+			return 1
+		}
+	default:
+		v, ok := setInt(key)
+		if !ok {
+			return 0
+		}
+		if i, ok := s.intsetSearch(v); ok {
+			s.intset = append(s.intset[:i], s.intset[i+1:]...)
+			return 1
+		}
 	}
 	return 0
 }
 
+// Pop removes and returns a random member, "" if the set is empty
 func (s *Set) Pop() string {
-	for key := range s.table {
-		s.Remove(key)
-		return key
+	if s.table != nil {
+		for key := range s.table {
+			s.Remove(key)
+			return key
+		}
+		return ""
+	}
+	if s.Len() == 0 {
+		return ""
 	}
-	return ""
+	key := s.Member()[rand.Intn(s.Len())]
+	s.Remove(key)
+	return key
 }
+
 func (s *Set) Clear() {
-	s.table = make(map[string]void)
+	s.table = nil
+	s.lp = nil
+	s.intset = nil
+}
+
+// ForEach calls fn for each member of the set until fn returns false
+func (s *Set) ForEach(fn func(key string) bool) {
+	switch {
+	case s.table != nil:
+		for key := range s.table {
+			if !fn(key) {
+				return
+			}
+		}
+	case s.lp != nil:
+		for off := 0; off < len(s.lp.buf); {
+			key, next := s.lp.entry(off)
+			if !fn(string(key)) {
+				return
+			}
+			off = next
+		}
+	default:
+		for _, v := range s.intset {
+			if !fn(strconv.FormatInt(v, 10)) {
+				return
+			}
+		}
+	}
 }
 
 func (s *Set) Member() []string {
-	res := make([]string, 0, len(s.table))
-	for key := range s.table {
+	res := make([]string, 0, s.Len())
+	s.ForEach(func(key string) bool {
 		res = append(res, key)
-	}
+		return true
+	})
 	return res
 }
 
 func (s *Set) Union(sets ...*Set) *Set {
-	res := NewSet()
-	for key := range s.table {
-		res.Add(key)
-	}
+	res := s.Copy()
 	for _, set := range sets {
-		for key := range set.table {
+		set.ForEach(func(key string) bool {
 			res.Add(key)
-		}
+			return true
+		})
 	}
 	return res
 }
 
+// Intersect returns the members of s that are in all the sets.
+// It iterates over s, so s should be the smallest set.
 func (s *Set) Intersect(sets ...*Set) *Set {
 	res := NewSet()
-	for key := range s.table {
-		res.Add(key)
-	}
+	s.ForEach(func(key string) bool {
+		if hasAll(key, sets) {
+			res.Add(key)
+		}
+		return true
+	})
+	return res
+}
+
+// IntersectCard returns the cardinality of the intersection of s and the sets,
+// it stops counting once limit is reached if limit > 0.
+// It iterates over s, so s should be the smallest set.
+func (s *Set) IntersectCard(limit int, sets ...*Set) int {
+	card := 0
+	s.ForEach(func(key string) bool {
+		if hasAll(key, sets) {
+			card++
+		}
+		return limit <= 0 || card < limit
+	})
+	return card
+}
+
+func hasAll(key string, sets []*Set) bool {
 	for _, set := range sets {
-		for key := range res.table {
-			if !set.Has(key) {
-				res.Remove(key)
-			}
+		if !set.Has(key) {
+			return false
 		}
 	}
-	return res
+	return true
 }
 
 func (s *Set) Difference(sets ...*Set) *Set {
-	res := NewSet()
-	for key := range s.table {
-		res.Add(key)
-	}
+	res := s.Copy()
 	for _, set := range sets {
-		for key := range set.table {
+		set.ForEach(func(key string) bool {
 			res.Remove(key)
-		}
+			return true
+		})
 	}
 	return res
 }
 
 func (s *Set) IsSubset(set *Set) bool {
-	for key := range s.table {
-		if !set.Has(key) {
-			return false
-		}
-	}
-	return true
+	res := true
+	s.ForEach(func(key string) bool {
+		res = set.Has(key)
+		return res
+	})
+	return res
 }
 
 // Random returns a random member of the set.
@@ -106,11 +279,30 @@ func (s *Set) Random(count int) []string {
 	var res []string
 	if count == 0 || s.Len() == 0 {
 		return res
-	} else if count > 0 {
+	}
+	if s.table == nil {
+		// the compact encodings are ordered, pick the members by index
+		members := s.Member()
+		if count > 0 {
+			rand.Shuffle(len(members), func(i, j int) {
+				members[i], members[j] = members[j], members[i]
+			})
+			if count < len(members) {
+				members = members[:count]
+			}
+			return members
+		}
+		res = make([]string, -count)
+		for i := range res {
+			res[i] = members[rand.Intn(len(members))]
+		}
+		return res
+	}
+	if count > 0 {
 		if count > s.Len() {
 			count = s.Len()
 		}
-		res = make([]string, count)
+		res = make([]string, 0, count)
 		for key := range s.table {
 			res = append(res, key)
 			if len(res) == count {
@@ -118,7 +310,7 @@ func (s *Set) Random(count int) []string {
 			}
 		}
 	} else {
-		res = make([]string, -count)
+		res = make([]string, 0, -count)
 		for {
 			for key := range s.table {
 				res = append(res, key)
@@ -132,6 +324,18 @@ func (s *Set) Random(count int) []string {
 }
 
 func (s *Set) Has(key string) bool {
-	_, ok := s.table[key]
+	switch {
+	case s.table != nil:
+		_, ok := s.table[key]
+		return ok
+	case s.lp != nil:
+		_, _, ok := s.lp.find(key, 1)
+		return ok
+	}
+	v, ok := setInt(key)
+	if !ok {
+		return false
+	}
+	_, ok = s.intsetSearch(v)
 	return ok
 }
@@ -68,7 +68,7 @@ func (list *SkipList) InsertByScore(score float64, value ISkipListNode) *SkipLis
 		if i < list.level-1 {
 			rank[i] = rank[i+1]
 		}
-		for t.Next(i) != nil && (t.Next(i).score < score || (t.Next(i).score == value.Score() && t.Next(i).value.Compare(value) < 0)) {
+		for t.Next(i) != nil && (t.Next(i).score < score || (t.Next(i).score == score && t.Next(i).value.Compare(value) < 0)) {
 			rank[i] += t.level[i].span
 			t = t.Next(i)
 		}
@@ -111,22 +111,23 @@ func (list *SkipList) InsertByScore(score float64, value ISkipListNode) *SkipLis
 	return newNode
 }
 
-func (list *SkipList) UpdateScore(node *SkipListNode, score float64) {
+// UpdateScore 更新node的分数,返回更新后的节点(重新插入时为新节点)
+func (list *SkipList) UpdateScore(node *SkipListNode, score float64) *SkipListNode {
 	if node.score == score {
-		return
+		return node
 	}
 	//更新后,分数还是 < next node的位置不用变
 	if score > node.score {
 		if node.Next(0) != nil && node.Next(0).score > score {
 			node.score = score
-			return
+			return node
 		}
 	}
 	//更新后,分数还是 > per node的位置不用变
 	if score < node.score {
 		if node.Pre() != nil && score > node.Pre().score {
 			node.score = score
-			return
+			return node
 		}
 	}
 
@@ -134,7 +135,7 @@ func (list *SkipList) UpdateScore(node *SkipListNode, score float64) {
 	updateList := list.GetUpdateList(node)
 	list.Delete(node, updateList)
 	//重新插入
-	list.InsertByScore(score, node.value)
+	return list.InsertByScore(score, node.value)
 }
 
 func (list *SkipList) GetUpdateList(node *SkipListNode) (updateList []*SkipListNode) {
@@ -192,6 +193,11 @@ func (list *SkipList) GetNodeByScore(findRange *SkipListFindRange) (result []*Sk
 				t = t.Next(i)
 			}
 		}
+		//t是最后一个score < Min的节点(可能是head),从它的下一个节点开始
+		t = t.Next(0)
+	}
+	if t == nil {
+		return
 	}
 
 	for {
@@ -242,7 +248,8 @@ func (list *SkipList) GetNodeRank(node *SkipListNode) int64 {
 	rank := int64(0)
 	t := list.head
 	for i := list.level - 1; i >= 0; i-- {
-		for t.Next(i) != nil && t.Next(i).score <= node.score {
+		//分数相同时按value比较, 不能越过value更大的节点
+		for t.Next(i) != nil && (t.Next(i).score < node.score || (t.Next(i).score == node.score && t.Next(i).value.Compare(node.value) <= 0)) {
 			rank += t.level[i].span
 			if t.Next(i).score == node.score && t.Next(i).value.Compare(node.value) == 0 {
 				return rank
@@ -262,3 +269,166 @@ func (list *SkipList) ScoreRange(findRange *SkipListFindRange) bool {
 	}
 	return true
 }
+
+// SkipListLexRange 根据成员的字典序查找元素的条件
+// 只有在所有元素的分数都相同时, 字典序的范围才有意义
+type SkipListLexRange struct {
+	Min, Max       string
+	MinBra, MaxBra bool //是否为开区间
+	MinInf, MaxInf bool //是否为 '-' 和 '+'
+}
+
+// gteMin 判断key是否满足范围的下界
+func (r *SkipListLexRange) gteMin(key string) bool {
+	if r.MinInf {
+		return true
+	}
+	if r.MinBra {
+		return key > r.Min
+	}
+	return key >= r.Min
+}
+
+// lteMax 判断key是否满足范围的上界
+func (r *SkipListLexRange) lteMax(key string) bool {
+	if r.MaxInf {
+		return true
+	}
+	if r.MaxBra {
+		return key < r.Max
+	}
+	return key <= r.Max
+}
+
+// nodeKey 返回节点存储的成员, 字典序查找要求节点的值实现了ISortSet
+func nodeKey(node *SkipListNode) string {
+	return node.value.(ISortSet).Key()
+}
+
+// FirstInLexRange 返回第一个在字典序范围内的节点, 不存在返回nil
+func (list *SkipList) FirstInLexRange(lexRange *SkipListLexRange) *SkipListNode {
+	if lexRange == nil || list.size == 0 {
+		return nil
+	}
+	t := list.head
+	for i := list.level - 1; i >= 0; i-- {
+		for t.Next(i) != nil && !lexRange.gteMin(nodeKey(t.Next(i))) {
+			t = t.Next(i)
+		}
+	}
+	//t是最后一个不满足下界的节点(可能是head)
+	t = t.Next(0)
+	if t == nil || !lexRange.lteMax(nodeKey(t)) {
+		return nil
+	}
+	return t
+}
+
+// LastInLexRange 返回最后一个在字典序范围内的节点, 不存在返回nil
+func (list *SkipList) LastInLexRange(lexRange *SkipListLexRange) *SkipListNode {
+	if lexRange == nil || list.size == 0 {
+		return nil
+	}
+	t := list.head
+	for i := list.level - 1; i >= 0; i-- {
+		for t.Next(i) != nil && lexRange.lteMax(nodeKey(t.Next(i))) {
+			t = t.Next(i)
+		}
+	}
+	//t是最后一个满足上界的节点
+	if t == list.head || !lexRange.gteMin(nodeKey(t)) {
+		return nil
+	}
+	return t
+}
+
+// LexRankRange 返回字典序范围内第一个和最后一个节点的rank(从1开始), 范围内没有节点时返回ok=false
+func (list *SkipList) LexRankRange(lexRange *SkipListLexRange) (first, last int64, ok bool) {
+	firstNode := list.FirstInLexRange(lexRange)
+	if firstNode == nil {
+		return 0, 0, false
+	}
+	lastNode := list.LastInLexRange(lexRange)
+	if lastNode == nil {
+		return 0, 0, false
+	}
+	first, last = list.GetNodeRank(firstNode), list.GetNodeRank(lastNode)
+	if first > last {
+		return 0, 0, false
+	}
+	return first, last, true
+}
+
+// gteMin 判断score是否满足范围的下界
+func (r *SkipListFindRange) gteMin(score float64) bool {
+	if r.MinInf {
+		return true
+	}
+	if r.MinBra {
+		return score > r.Min
+	}
+	return score >= r.Min
+}
+
+// lteMax 判断score是否满足范围的上界
+func (r *SkipListFindRange) lteMax(score float64) bool {
+	if r.MaxInf {
+		return true
+	}
+	if r.MaxBra {
+		return score < r.Max
+	}
+	return score <= r.Max
+}
+
+// FirstInScoreRange 返回第一个在分数范围内的节点, 不存在返回nil
+func (list *SkipList) FirstInScoreRange(findRange *SkipListFindRange) *SkipListNode {
+	if findRange == nil || list.size == 0 {
+		return nil
+	}
+	t := list.head
+	for i := list.level - 1; i >= 0; i-- {
+		for t.Next(i) != nil && !findRange.gteMin(t.Next(i).score) {
+			t = t.Next(i)
+		}
+	}
+	t = t.Next(0)
+	if t == nil || !findRange.lteMax(t.score) {
+		return nil
+	}
+	return t
+}
+
+// LastInScoreRange 返回最后一个在分数范围内的节点, 不存在返回nil
+func (list *SkipList) LastInScoreRange(findRange *SkipListFindRange) *SkipListNode {
+	if findRange == nil || list.size == 0 {
+		return nil
+	}
+	t := list.head
+	for i := list.level - 1; i >= 0; i-- {
+		for t.Next(i) != nil && findRange.lteMax(t.Next(i).score) {
+			t = t.Next(i)
+		}
+	}
+	if t == list.head || !findRange.gteMin(t.score) {
+		return nil
+	}
+	return t
+}
+
+// ScoreRankRange 返回分数范围内第一个和最后一个节点的rank(从1开始), 范围内没有节点时返回ok=false
+func (list *SkipList) ScoreRankRange(findRange *SkipListFindRange) (first, last int64, ok bool) {
+	firstNode := list.FirstInScoreRange(findRange)
+	if firstNode == nil {
+		return 0, 0, false
+	}
+	lastNode := list.LastInScoreRange(findRange)
+	if lastNode == nil {
+		return 0, 0, false
+	}
+	first, last = list.GetNodeRank(firstNode), list.GetNodeRank(lastNode)
+	if first > last {
+		return 0, 0, false
+	}
+	return first, last, true
+}
@@ -1,5 +1,7 @@
 package datastructure
 
+import "math/rand"
+
 // ISortSet 有序集必须实现的接口
 type ISortSet interface {
 	Key() string
@@ -37,12 +39,17 @@ type SortSet struct {
 	// map的key为StItem.k+StItem.F
 	member map[string]*SkipListNode
 	sl     *SkipList
+	//成员较少时使用的紧凑编码, 按分数和成员从小到大依次存储 member, score
+	//成员超过 Limits.ZSetMaxListpackEntries 或者成员长度超过 Limits.ZSetMaxListpackValue 时转换为跳表, 之后lp为nil
+	lp *listpack
+	//转换为跳表时使用的最大层数
+	maxLevel int
 }
 
 func NewDefaultSortSet() *SortSet {
 	return &SortSet{
-		member: map[string]*SkipListNode{},
-		sl:     NewDefaultSkipList(),
+		lp:       &listpack{},
+		maxLevel: SkipListMaxLevel,
 	}
 }
 
@@ -50,31 +57,209 @@ func NewDefaultSortSet() *SortSet {
 // 没有特殊情况,不建议自定义层数
 func NewSortSet(level int) *SortSet {
 	return &SortSet{
-		member: map[string]*SkipListNode{},
-		sl:     NewSkipList(level),
+		lp:       &listpack{},
+		maxLevel: level,
+	}
+}
+
+// Copy 返回SortSet的深拷贝, 底层跳表使用相同的最大层数
+func (set *SortSet) Copy() *SortSet {
+	res := NewSortSet(set.maxLevel)
+	if set.lp != nil {
+		res.lp = set.lp.copy()
+		return res
+	}
+	for key, node := range set.member {
+		res.Add(&StItem{F: node.score, K: key})
+	}
+	return res
+}
+
+// Encoding 返回当前使用的编码的名字
+func (set *SortSet) Encoding() string {
+	if set.lp != nil {
+		return EncodingListpack
+	}
+	return EncodingSkiplist
+}
+
+// convert 把lp中的成员转移到跳表中
+func (set *SortSet) convert() {
+	set.member = make(map[string]*SkipListNode, set.lp.n/2+1)
+	set.sl = NewSkipList(set.maxLevel)
+	set.lpEach(func(_ int64, key string, score float64) bool {
+		set.addMember(key, set.sl.InsertByScore(score, &StItem{F: score, K: key}))
+		return true
+	})
+	set.lp = nil
+}
+
+// lpEach 按顺序遍历lp中的成员, rank从1开始, fn返回false时停止遍历
+func (set *SortSet) lpEach(fn func(rank int64, key string, score float64) bool) {
+	rank := int64(1)
+	for off := 0; off < len(set.lp.buf); rank++ {
+		key, next := set.lp.entry(off)
+		score, end := set.lp.entry(next)
+		if !fn(rank, string(key), decodeScore(score)) {
+			return
+		}
+		off = end
+	}
+}
+
+// lpFind 返回成员在lp中的偏移区间和rank(从1开始)
+func (set *SortSet) lpFind(key string) (start, end int, rank int64, score float64, ok bool) {
+	rank = 1
+	for off := 0; off < len(set.lp.buf); rank++ {
+		k, next := set.lp.entry(off)
+		s, e := set.lp.entry(next)
+		if string(k) == key {
+			return off, e, rank, decodeScore(s), true
+		}
+		off = e
+	}
+	return 0, 0, 0, 0, false
+}
+
+// lpAdd 向lp中添加或者更新成员, 成员不能放入lp时返回false
+func (set *SortSet) lpAdd(key string, score float64) bool {
+	if start, end, _, _, ok := set.lpFind(key); ok {
+		set.lp.splice(start, end, 2)
+	} else if len(key) > Limits.ZSetMaxListpackValue || set.lp.n/2 >= Limits.ZSetMaxListpackEntries {
+		return false
+	}
+	//插入到第一个比它大的成员之前
+	off := 0
+	for off < len(set.lp.buf) {
+		k, next := set.lp.entry(off)
+		s, end := set.lp.entry(next)
+		if sc := decodeScore(s); sc > score || (sc == score && string(k) > key) {
+			break
+		}
+		off = end
 	}
+	set.lp.splice(off, off, 0, []byte(key), encodeScore(score))
+	return true
+}
+
+// lpRankRange 返回第一个满足gteMin的成员和之后连续满足lteMax的最后一个成员的rank, 与跳表的查找方式相同
+func (set *SortSet) lpRankRange(gteMin, lteMax func(key string, score float64) bool) (first, last int64, ok bool) {
+	set.lpEach(func(rank int64, key string, score float64) bool {
+		if first == 0 && gteMin(key, score) {
+			first = rank
+		}
+		if !lteMax(key, score) {
+			return false
+		}
+		last = rank
+		return true
+	})
+	if first == 0 || first > last {
+		return 0, 0, false
+	}
+	return first, last, true
+}
+
+// scoreRankRange 返回分数范围内第一个和最后一个成员的rank(从1开始)
+func (set *SortSet) scoreRankRange(findRange *SkipListFindRange) (int64, int64, bool) {
+	if set.lp == nil {
+		return set.sl.ScoreRankRange(findRange)
+	}
+	if findRange == nil {
+		return 0, 0, false
+	}
+	return set.lpRankRange(func(_ string, score float64) bool {
+		return findRange.gteMin(score)
+	}, func(_ string, score float64) bool {
+		return findRange.lteMax(score)
+	})
+}
+
+// lexRankRange 返回字典序范围内第一个和最后一个成员的rank(从1开始)
+func (set *SortSet) lexRankRange(lexRange *SkipListLexRange) (int64, int64, bool) {
+	if set.lp == nil {
+		return set.sl.LexRankRange(lexRange)
+	}
+	if lexRange == nil {
+		return 0, 0, false
+	}
+	return set.lpRankRange(func(key string, _ float64) bool {
+		return lexRange.gteMin(key)
+	}, func(key string, _ float64) bool {
+		return lexRange.lteMax(key)
+	})
+}
+
+// byRank 返回rank区间[left, right](从1开始)内的成员, 与跳表的GetNodeByRank相同, right超过成员数量时截断
+func (set *SortSet) byRank(left, right int64) (result []ISortSet) {
+	if set.lp == nil {
+		nodes := set.sl.GetNodeByRank(left, right)
+		if len(nodes) == 0 {
+			return
+		}
+		result = make([]ISortSet, len(nodes))
+		for i, node := range nodes {
+			result[i] = node.value.(ISortSet)
+		}
+		return
+	}
+	if left == 0 || right < left {
+		return
+	}
+	set.lpEach(func(rank int64, key string, score float64) bool {
+		if rank >= left {
+			result = append(result, &StItem{F: score, K: key})
+		}
+		return rank < right
+	})
+	return
 }
 
 func (set *SortSet) GetAllKeysAndScores() map[string]float64 {
 	result := make(map[string]float64)
-	for k, v := range set.member {
-		result[k] = v.value.Score()
-	}
+	set.ForEach(func(key string, score float64) bool {
+		result[key] = score
+		return true
+	})
 	return result
 
 }
 
+// ForEach 遍历sortSet中所有的成员和分数, 顺序不确定, fn返回false时停止遍历
+func (set *SortSet) ForEach(fn func(key string, score float64) bool) {
+	if set.lp != nil {
+		set.lpEach(func(_ int64, key string, score float64) bool {
+			return fn(key, score)
+		})
+		return
+	}
+	for k, v := range set.member {
+		if !fn(k, v.score) {
+			return
+		}
+	}
+}
+
 // GetAllKeys 返回sortSet中所有的keys
 func (set *SortSet) GetAllKeys() []string {
 	var result []string
-	for k, _ := range set.member {
-		result = append(result, k)
-	}
+	set.ForEach(func(key string, _ float64) bool {
+		result = append(result, key)
+		return true
+	})
 	return result
 }
 
-func (set *SortSet) GetMember(key string) *SkipListNode {
-	return set.member[key]
+// GetScore 返回成员的分数, 成员不存在时ok为false
+func (set *SortSet) GetScore(key string) (float64, bool) {
+	if set.lp != nil {
+		_, _, _, score, ok := set.lpFind(key)
+		return score, ok
+	}
+	if member := set.member[key]; member != nil {
+		return member.score, true
+	}
+	return 0, false
 }
 
 func (set *SortSet) addMember(key string, member *SkipListNode) {
@@ -100,11 +285,18 @@ func (set *SortSet) Add(items ...ISortSet) int {
 			l--
 			continue
 		}
-		if member := set.GetMember(items[l].Key()); member == nil {
-			node := set.sl.InsertByScore(items[l].Score(), items[l])
-			set.addMember(items[l].Key(), node)
-		} else {
-			set.sl.UpdateScore(member, items[l].Score())
+		if set.lp != nil && !set.lpAdd(items[l].Key(), items[l].Score()) {
+			set.convert()
+		}
+		if set.lp == nil {
+			if member := set.member[items[l].Key()]; member == nil {
+				node := set.sl.InsertByScore(items[l].Score(), items[l])
+				set.addMember(items[l].Key(), node)
+			} else {
+				//删掉旧节点后插入新的元素, 保证节点的value和score一致
+				set.sl.Delete(member, set.sl.GetUpdateList(member))
+				set.addMember(items[l].Key(), set.sl.InsertByScore(items[l].Score(), items[l]))
+			}
 		}
 		op[items[l].Key()] = struct{}{}
 		l--
@@ -113,41 +305,54 @@ func (set *SortSet) Add(items ...ISortSet) int {
 }
 
 func (set *SortSet) Count() int64 {
+	if set.lp != nil {
+		return int64(set.lp.n / 2)
+	}
 	return set.sl.Size()
 }
 
-// Rank 返回有序集合中指定的成员索引（从0开始）不存在返回-1
-func (set *SortSet) Rank(key string) int64 {
-	member := set.GetMember(key)
+// rank 返回成员的rank(从1开始), 不存在返回0
+func (set *SortSet) rank(key string) int64 {
+	if set.lp != nil {
+		_, _, rank, _, _ := set.lpFind(key)
+		return rank
+	}
+	member := set.member[key]
 	if member == nil {
-		return -1
+		return 0
 	}
-	return set.sl.GetNodeRank(member) - 1
+	return set.sl.GetNodeRank(member)
+}
+
+// Rank 返回有序集合中指定的成员索引（从0开始）不存在返回-1
+func (set *SortSet) Rank(key string) int64 {
+	return set.rank(key) - 1
 }
 
 // RevRank 倒序返回有序集合中指定成员的索引(从0开始)不存在返回 -1
 func (set *SortSet) RevRank(key string) int64 {
-	member := set.GetMember(key)
-	if member == nil {
+	rank := set.rank(key)
+	if rank == 0 {
 		return -1
 	}
-	rank := set.sl.GetNodeRank(member)
-	return set.sl.Size() - rank
+	return set.Count() - rank
 }
 
 func (set *SortSet) Score(key string) float64 {
-	member := set.GetMember(key)
-	if member == nil {
-		return 0
-	}
-	return member.score
+	score, _ := set.GetScore(key)
+	return score
 }
 
 // Remove 移除sortSet中的一个或多个元素
 func (set *SortSet) Remove(keys ...string) int {
 	removed := 0
 	for _, key := range keys {
-		if member := set.GetMember(key); member != nil {
+		if set.lp != nil {
+			if start, end, _, _, ok := set.lpFind(key); ok {
+				set.lp.splice(start, end, 2)
+				removed++
+			}
+		} else if member := set.member[key]; member != nil {
 			set.delMember(key)
 			set.sl.Delete(member, set.sl.GetUpdateList(member))
 			removed++
@@ -156,19 +361,23 @@ func (set *SortSet) Remove(keys ...string) int {
 	return removed
 }
 
-// RemoveRangeByRank 移除集合中给定排名区间的所有成员
-func (set *SortSet) RemoveRangeByRank(min, max int64) int {
-	// 现根据rank范围查找node
-	result := set.Range(min, max)
-	if len(result) == 0 {
+// removeItems 移除按顺序排列的一段连续的成员
+func (set *SortSet) removeItems(items []ISortSet) int {
+	if len(items) == 0 {
 		return 0
 	}
+	if set.lp != nil {
+		for _, item := range items {
+			set.Remove(item.Key())
+		}
+		return len(items)
+	}
 	var updateList []*SkipListNode
 	//删除数据需要的各层结点信息(路径)
 	//想一下,为啥只需要获取一次路径就行呢?????
 	//因为是按顺序返回的，第一个就是删除的起点后续删除的updateList都是一样的
-	for _, key := range result {
-		if member := set.GetMember(key.Key()); member != nil {
+	for _, key := range items {
+		if member := set.member[key.Key()]; member != nil {
 			if updateList == nil {
 				updateList = set.sl.GetUpdateList(member)
 			}
@@ -176,64 +385,53 @@ func (set *SortSet) RemoveRangeByRank(min, max int64) int {
 			set.sl.Delete(member, updateList)
 		}
 	}
-	return len(result)
+	return len(items)
+}
+
+// RemoveRangeByRank 移除集合中给定排名区间的所有成员
+func (set *SortSet) RemoveRangeByRank(min, max int64) int {
+	// 现根据rank范围查找node
+	return set.removeItems(set.Range(min, max))
 }
 
 // RemoveRangeByScore 移除有序集合中给定的分数区间的所有成员
 func (set *SortSet) RemoveRangeByScore(min, max float64, minBra, maxBra, minInf, maxInf bool) int {
-	result := set.RangeByScore(&SkipListFindRange{
+	return set.removeItems(set.RangeByScore(&SkipListFindRange{
 		Min:    min,
 		Max:    max,
 		MinBra: minBra,
 		MaxBra: maxBra,
 		MinInf: minInf,
 		MaxInf: maxInf,
-	})
-	if len(result) == 0 {
-		return 0
-	}
-	var updateList []*SkipListNode
-	for _, key := range result {
-		if member := set.GetMember(key.Key()); member != nil {
-			if updateList == nil {
-				updateList = set.sl.GetUpdateList(member)
-			}
-			set.delMember(key.Key())
-			set.sl.Delete(member, updateList)
-		}
-	}
-	return len(result)
+	}))
 }
 
 // Range 通过索引区间返回有序集合指定区间内的成员,分数从低到高
 func (set *SortSet) Range(min, max int64) (result []ISortSet) {
-	if set.sl.size == 0 {
+	size := set.Count()
+	if size == 0 {
 		return
 	}
 	if min < 0 {
-		min = set.sl.Size() + min
+		min = size + min
 	}
 	if max < 0 {
-		max = set.sl.size + max
+		max = size + max
+	}
+	if min < 0 {
+		min = 0
 	}
 	if min > max {
 		return
 	}
 	//索引是从0开始的, 跳表中的rank是从1开始,所以这里要 +1
-	nodes := set.sl.GetNodeByRank(min+1, max+1)
-	if len(nodes) == 0 {
-		return
-	}
-	result = make([]ISortSet, len(nodes))
-	for i, node := range nodes {
-		result[i] = node.value.(ISortSet)
-	}
-	return
+	return set.byRank(min+1, max+1)
 }
 
 // RevRange 返回有序集中指定区间内的成员，通过索引，分数从高到低排序
 func (set *SortSet) RevRange(min, max int64) (result []ISortSet) {
-	if set.sl.Size() == 0 {
+	size := set.Count()
+	if size == 0 {
 		return
 	}
 	//反向查找也是按照正向查找来做的
@@ -242,17 +440,17 @@ func (set *SortSet) RevRange(min, max int64) (result []ISortSet) {
 	if min < 0 {
 		min = -min
 	} else {
-		if set.sl.Size() >= min {
-			min = set.sl.Size() - min
+		if size >= min {
+			min = size - min
 		} else {
-			min = set.sl.Size()
+			min = size
 		}
 	}
 	if max < 0 {
 		max = -max
 	} else {
-		if set.sl.Size() > max {
-			max = set.sl.Size() - max
+		if size > max {
+			max = size - max
 		} else {
 			max = 1
 		}
@@ -260,24 +458,30 @@ func (set *SortSet) RevRange(min, max int64) (result []ISortSet) {
 	if max > min {
 		return
 	}
-	nodes := set.sl.GetNodeByRank(max, min)
-	if len(nodes) == 0 {
-		return
-	}
-	l := len(nodes)
-	result = make([]ISortSet, l)
-	l--
-	for i, node := range nodes {
-		result[l-i] = node.value.(ISortSet)
-	}
+	result = set.byRank(max, min)
+	reverseItems(result)
 	return
 }
 
+// reverseItems 原地翻转成员的顺序
+func reverseItems(items []ISortSet) {
+	for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+		items[i], items[j] = items[j], items[i]
+	}
+}
+
 // RangeByScore 返回有序集中指定分数区间内的成员，分数从低到高排序
 func (set *SortSet) RangeByScore(findRange *SkipListFindRange) (result []ISortSet) {
-	if findRange == nil || set.sl.Size() == 0 {
+	if findRange == nil || set.Count() == 0 {
 		return
 	}
+	if set.lp != nil {
+		first, last, ok := set.scoreRankRange(findRange)
+		if !ok {
+			return
+		}
+		return set.byRank(first, last)
+	}
 	nodes := set.sl.GetNodeByScore(findRange)
 	if len(nodes) == 0 {
 		return
@@ -291,7 +495,7 @@ func (set *SortSet) RangeByScore(findRange *SkipListFindRange) (result []ISortSe
 
 // RevRangeByScore 返回有序集中指定分数区间内的成员，分数从高到低排序
 func (set *SortSet) RevRangeByScore(findRange *SkipListFindRange) (result []ISortSet) {
-	if findRange == nil || set.sl.Size() == 0 {
+	if findRange == nil || set.Count() == 0 {
 		return
 	}
 
@@ -300,15 +504,136 @@ func (set *SortSet) RevRangeByScore(findRange *SkipListFindRange) (result []ISor
 	//最后再把查找的结果翻转一下
 	findRange.Max, findRange.Min = findRange.Min, findRange.Max
 	findRange.MaxInf, findRange.MinInf = findRange.MinInf, findRange.MaxInf
-	nodes := set.sl.GetNodeByScore(findRange)
-	l := len(nodes)
-	if l == 0 {
+	result = set.RangeByScore(findRange)
+	reverseItems(result)
+	return
+}
+
+// rangeRanks 返回rank区间[first, last](从1开始)内的成员, 跳过前offset个成员, 最多返回count个, count < 0 表示返回全部
+// rev为true时从last向first返回. offset通过跳表的span定位, 不需要逐个遍历节点
+func (set *SortSet) rangeRanks(first, last, offset, count int64, rev bool) (result []ISortSet) {
+	if offset < 0 || count == 0 {
 		return
 	}
-	result = make([]ISortSet, l)
-	l--
-	for i, node := range nodes {
-		result[l-i] = node.value.(ISortSet)
+	//倒序时先把rank转换成倒序的rank, 计算完再转换回来
+	size := set.Count()
+	if rev {
+		first, last = size-last+1, size-first+1
+	}
+	first += offset
+	if count > 0 && first+count-1 < last {
+		last = first + count - 1
+	}
+	if first > last {
+		return
+	}
+	if rev {
+		first, last = size-last+1, size-first+1
+	}
+	result = set.byRank(first, last)
+	if rev {
+		reverseItems(result)
+	}
+	return
+}
+
+// RangeByLex 返回有序集中指定字典序区间内的成员, 跳过前offset个成员, 最多返回count个, count < 0 表示返回全部
+func (set *SortSet) RangeByLex(lexRange *SkipListLexRange, offset, count int64) []ISortSet {
+	first, last, ok := set.lexRankRange(lexRange)
+	if !ok {
+		return nil
+	}
+	return set.rangeRanks(first, last, offset, count, false)
+}
+
+// RevRangeByLex 返回有序集中指定字典序区间内的成员, 字典序从大到小, offset和count的含义与RangeByLex相同
+func (set *SortSet) RevRangeByLex(lexRange *SkipListLexRange, offset, count int64) []ISortSet {
+	first, last, ok := set.lexRankRange(lexRange)
+	if !ok {
+		return nil
+	}
+	return set.rangeRanks(first, last, offset, count, true)
+}
+
+// RangeByScoreWithLimit 返回有序集中指定分数区间内的成员, 分数从低到高, offset和count的含义与RangeByLex相同
+func (set *SortSet) RangeByScoreWithLimit(findRange *SkipListFindRange, offset, count int64) []ISortSet {
+	first, last, ok := set.scoreRankRange(findRange)
+	if !ok {
+		return nil
+	}
+	return set.rangeRanks(first, last, offset, count, false)
+}
+
+// RevRangeByScoreWithLimit 返回有序集中指定分数区间内的成员, 分数从高到低, offset和count的含义与RangeByLex相同
+// 与RevRangeByScore不同, findRange的Min和Max不需要调换
+func (set *SortSet) RevRangeByScoreWithLimit(findRange *SkipListFindRange, offset, count int64) []ISortSet {
+	first, last, ok := set.scoreRankRange(findRange)
+	if !ok {
+		return nil
+	}
+	return set.rangeRanks(first, last, offset, count, true)
+}
+
+// LexCount 返回有序集中指定字典序区间内的成员数量
+func (set *SortSet) LexCount(lexRange *SkipListLexRange) int64 {
+	first, last, ok := set.lexRankRange(lexRange)
+	if !ok {
+		return 0
+	}
+	return last - first + 1
+}
+
+// RemoveRangeByLex 移除有序集中指定字典序区间内的所有成员
+func (set *SortSet) RemoveRangeByLex(lexRange *SkipListLexRange) int {
+	return set.removeItems(set.RangeByLex(lexRange, 0, -1))
+}
+
+// MaxRandomCount 是Random在count < 0时最多返回的成员数, 结果全部在内存中构建
+const MaxRandomCount = 1 << 24
+
+// Random 随机返回count个成员, count > 0 时成员不重复, 最多返回全部成员; count < 0 时成员可以重复, 返回-count个成员,
+// 最多MaxRandomCount个
+func (set *SortSet) Random(count int) (result []ISortSet) {
+	size := set.Count()
+	if count == 0 || size == 0 {
+		return
+	}
+	if count < -MaxRandomCount {
+		count = -MaxRandomCount
+	} else if int64(count) > size {
+		count = int(size)
+	}
+	//通过随机的rank查找成员, 跳表中每次查找都是O(logN)
+	randomItem := func(rank int64) ISortSet {
+		return set.byRank(rank+1, rank+1)[0]
+	}
+	if count < 0 {
+		result = make([]ISortSet, -count)
+		for i := range result {
+			result[i] = randomItem(rand.Int63n(size))
+		}
+		return
+	}
+	//需要的成员较多时, 打乱全部成员后截取
+	if int64(count)*2 > size {
+		result = set.Range(0, -1)
+		rand.Shuffle(len(result), func(i, j int) {
+			result[i], result[j] = result[j], result[i]
+		})
+		if int64(count) < size {
+			result = result[:count]
+		}
+		return
+	}
+	picked := make(map[int64]struct{}, count)
+	result = make([]ISortSet, 0, count)
+	for len(result) < count {
+		rank := rand.Int63n(size)
+		if _, ok := picked[rank]; ok {
+			continue
+		}
+		picked[rank] = struct{}{}
+		result = append(result, randomItem(rank))
 	}
 	return
 }
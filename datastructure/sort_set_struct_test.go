@@ -2,6 +2,7 @@ package datastructure
 
 import (
 	"fmt"
+	"math"
 	"math/rand"
 	"sort"
 	"testing"
@@ -417,3 +418,108 @@ func insert4(set *SortSet, size int) SortS3 {
 	sort.Sort(arrS)
 	return arrS
 }
+
+func TestSortSet_RangeByScoreAroundZero(t *testing.T) {
+	set := NewDefaultSortSet()
+	set.Add(&StItem{F: 1, K: "a"}, &StItem{F: 3, K: "b"}, &StItem{F: 8, K: "c"})
+	// the head of the skip list has score 0 and must never be returned
+	res := set.RangeByScore(&SkipListFindRange{Min: -5, Max: 5})
+	if len(res) != 2 || res[0].Key() != "a" || res[1].Key() != "b" {
+		t.Fatalf("RangeByScore [-5, 5] = %v", res)
+	}
+	res = set.RangeByScore(&SkipListFindRange{Min: 0, Max: 3, MaxBra: true})
+	if len(res) != 1 || res[0].Key() != "a" {
+		t.Fatalf("RangeByScore [0, 3) = %v", res)
+	}
+}
+
+func lexKeys(items []ISortSet) string {
+	keys := ""
+	for _, item := range items {
+		keys += item.Key()
+	}
+	return keys
+}
+
+func TestSortSet_RangeByLex(t *testing.T) {
+	set := NewDefaultSortSet()
+	for _, k := range []string{"e", "a", "d", "b", "g", "c", "f"} {
+		set.Add(&StItem{F: 0, K: k})
+	}
+	cases := []struct {
+		lexRange      *SkipListLexRange
+		offset, count int64
+		want, revWant string
+	}{
+		{&SkipListLexRange{MinInf: true, MaxInf: true}, 0, -1, "abcdefg", "gfedcba"},
+		{&SkipListLexRange{Min: "b", Max: "e"}, 0, -1, "bcde", "edcb"},
+		{&SkipListLexRange{Min: "b", Max: "e", MinBra: true, MaxBra: true}, 0, -1, "cd", "dc"},
+		{&SkipListLexRange{Min: "bb", MaxInf: true}, 1, 2, "de", "fe"},
+		{&SkipListLexRange{MinInf: true, Max: "c"}, 2, 5, "c", "a"},
+		{&SkipListLexRange{Min: "e", Max: "b"}, 0, -1, "", ""},
+		{&SkipListLexRange{Min: "x", MaxInf: true}, 0, -1, "", ""},
+		{&SkipListLexRange{MinInf: true, MaxInf: true}, 7, -1, "", ""},
+	}
+	for _, c := range cases {
+		if got := lexKeys(set.RangeByLex(c.lexRange, c.offset, c.count)); got != c.want {
+			t.Errorf("RangeByLex %+v %d %d = %q, want %q", c.lexRange, c.offset, c.count, got, c.want)
+		}
+		if got := lexKeys(set.RevRangeByLex(c.lexRange, c.offset, c.count)); got != c.revWant {
+			t.Errorf("RevRangeByLex %+v %d %d = %q, want %q", c.lexRange, c.offset, c.count, got, c.revWant)
+		}
+		if c.offset == 0 && c.count < 0 && set.LexCount(c.lexRange) != int64(len(c.want)) {
+			t.Errorf("LexCount %+v = %d, want %d", c.lexRange, set.LexCount(c.lexRange), len(c.want))
+		}
+	}
+
+	if n := set.RemoveRangeByLex(&SkipListLexRange{Min: "b", Max: "d", MaxBra: true}); n != 2 {
+		t.Fatalf("RemoveRangeByLex removed %d", n)
+	}
+	if got := lexKeys(set.Range(0, -1)); got != "adefg" || set.Count() != 5 {
+		t.Fatalf("members after RemoveRangeByLex %q", got)
+	}
+}
+
+func TestSortSet_RangeByScoreWithLimit(t *testing.T) {
+	set := NewDefaultSortSet()
+	for i, k := range []string{"a", "b", "c", "d", "e", "f"} {
+		set.Add(&StItem{F: float64(i / 2), K: k})
+	}
+	findRange := &SkipListFindRange{Min: 0, Max: 2, MinBra: true}
+	if got := lexKeys(set.RangeByScoreWithLimit(findRange, 0, -1)); got != "cdef" {
+		t.Errorf("RangeByScoreWithLimit (0, 2] = %q", got)
+	}
+	if got := lexKeys(set.RangeByScoreWithLimit(findRange, 1, 2)); got != "de" {
+		t.Errorf("RangeByScoreWithLimit (0, 2] limit 1 2 = %q", got)
+	}
+	if got := lexKeys(set.RevRangeByScoreWithLimit(findRange, 1, 2)); got != "ed" {
+		t.Errorf("RevRangeByScoreWithLimit (0, 2] limit 1 2 = %q", got)
+	}
+	if got := lexKeys(set.RangeByScoreWithLimit(&SkipListFindRange{Min: 3, Max: 1}, 0, -1)); got != "" {
+		t.Errorf("RangeByScoreWithLimit [3, 1] = %q", got)
+	}
+}
+
+func TestSortSet_Random(t *testing.T) {
+	set := NewDefaultSortSet()
+	for i := 0; i < 10; i++ {
+		set.Add(&StItem{F: float64(i), K: fmt.Sprint(i)})
+	}
+	for _, count := range []int{3, 8, 20, math.MaxInt} {
+		res := set.Random(count)
+		want := count
+		if want > 10 {
+			want = 10
+		}
+		seen := make(map[string]bool)
+		for _, item := range res {
+			seen[item.Key()] = true
+		}
+		if len(res) != want || len(seen) != want {
+			t.Errorf("Random(%d) returned %d members, %d distinct", count, len(res), len(seen))
+		}
+	}
+	if res := set.Random(-25); len(res) != 25 {
+		t.Errorf("Random(-25) returned %d members", len(res))
+	}
+}
@@ -1,3 +1,5 @@
 module easyRedis
 
 go 1.19
+
+require github.com/yuin/gopher-lua v1.1.1
@@ -13,11 +13,22 @@ import (
 func init() {
 	// Register commands
 	memdb.RegisterKeyCommands()
+	memdb.RegisterObjectCommands()
+	memdb.RegisterClientCommands()
+	memdb.RegisterSlowlogCommands()
+	memdb.RegisterDumpCommands()
+	memdb.RegisterSortCommands()
 	memdb.RegisterStringCommands()
 	memdb.RegisterListCommands()
 	memdb.RegisterSetCommands()
 	memdb.RegisterHashCommands()
 	memdb.RegisterSortSetCommands()
+	memdb.RegisterGeoCommands()
+	memdb.RegisterBitmapCommands()
+	memdb.RegisterHyperLogLogCommands()
+	memdb.RegisterCrdtCommands()
+	memdb.RegisterScriptCommands()
+	memdb.RegisterFunctionCommands()
 }
 
 func main() {
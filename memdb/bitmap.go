@@ -0,0 +1,587 @@
+package memdb
+
+import (
+	"easyRedis/logger"
+	"easyRedis/resp"
+	"fmt"
+	"math"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// bitmap.go implements bit operations on string values.
+// Bits are addressed from the most significant bit of the first byte, like redis.
+
+// maxBitOffset limits the size of a bitmap to 512MB
+const maxBitOffset = 1<<32 - 1
+
+func getBit(val []byte, offset uint64) byte {
+	if offset>>3 >= uint64(len(val)) {
+		return 0
+	}
+	return (val[offset>>3] >> (7 - offset&7)) & 1
+}
+
+func setBit(val []byte, offset uint64, bit byte) {
+	if bit == 1 {
+		val[offset>>3] |= 1 << (7 - offset&7)
+	} else {
+		val[offset>>3] &^= 1 << (7 - offset&7)
+	}
+}
+
+// growBytes returns val extended with zero bytes to at least size bytes
+func growBytes(val []byte, size uint64) []byte {
+	if uint64(len(val)) >= size {
+		return val
+	}
+	res := make([]byte, size)
+	copy(res, val)
+	return res
+}
+
+func parseBitOffset(arg []byte) (uint64, bool) {
+	offset, err := strconv.ParseInt(string(arg), 10, 64)
+	if err != nil || offset < 0 || offset > maxBitOffset {
+		return 0, false
+	}
+	return uint64(offset), true
+}
+
+// getStringValue returns the string value of key, the caller must hold the lock of key
+func (m *MemDb) getStringValue(key string) ([]byte, resp.RedisData) {
+	temp, ok := m.db.Get(key)
+	if !ok {
+		return nil, nil
+	}
+	val, ok := temp.([]byte)
+	if !ok {
+		return nil, resp.NewErrorData("WRONGTYPE Operation against a key holding the wrong kind of value")
+	}
+	return val, nil
+}
+
+func setBitString(m *MemDb, cmd [][]byte) resp.RedisData {
+	if strings.ToLower(string(cmd[0])) != "setbit" {
+		logger.Error("setBitString func: cmdName is not setbit")
+		return resp.NewErrorData("Server error")
+	}
+	if len(cmd) != 4 {
+		return resp.NewErrorData("ERR wrong number of arguments for 'setbit' command")
+	}
+	offset, ok := parseBitOffset(cmd[2])
+	if !ok {
+		return resp.NewErrorData("ERR bit offset is not an integer or out of range")
+	}
+	bitStr := string(cmd[3])
+	if bitStr != "0" && bitStr != "1" {
+		return resp.NewErrorData("ERR bit is not an integer or out of range")
+	}
+
+	key := string(cmd[1])
+	m.CheckTTL(key)
+	m.locks.Lock(key)
+	defer m.locks.Unlock(key)
+
+	val, errData := m.getStringValue(key)
+	if errData != nil {
+		return errData
+	}
+	old := getBit(val, offset)
+	val = growBytes(val, offset>>3+1)
+	setBit(val, offset, bitStr[0]-'0')
+	m.db.Set(key, val)
+	return resp.NewIntData(int64(old))
+}
+
+func getBitString(m *MemDb, cmd [][]byte) resp.RedisData {
+	if strings.ToLower(string(cmd[0])) != "getbit" {
+		logger.Error("getBitString func: cmdName is not getbit")
+		return resp.NewErrorData("Server error")
+	}
+	if len(cmd) != 3 {
+		return resp.NewErrorData("ERR wrong number of arguments for 'getbit' command")
+	}
+	offset, ok := parseBitOffset(cmd[2])
+	if !ok {
+		return resp.NewErrorData("ERR bit offset is not an integer or out of range")
+	}
+
+	key := string(cmd[1])
+	if !m.CheckTTL(key) {
+		return resp.NewIntData(0)
+	}
+	m.locks.RLock(key)
+	defer m.locks.RUnlock(key)
+
+	val, errData := m.getStringValue(key)
+	if errData != nil {
+		return errData
+	}
+	return resp.NewIntData(int64(getBit(val, offset)))
+}
+
+// parseBitRange parses start end [BYTE|BIT] and returns the range of bits [start, end] in a value of size bytes.
+// empty is true if the range contains no bit.
+func parseBitRange(args [][]byte, size int) (start, end int64, empty bool, errData resp.RedisData) {
+	s, err1 := strconv.ParseInt(string(args[0]), 10, 64)
+	e, err2 := strconv.ParseInt(string(args[1]), 10, 64)
+	if err1 != nil || err2 != nil {
+		return 0, 0, false, resp.NewErrorData("ERR value is not an integer or out of range")
+	}
+	unitBit := false
+	if len(args) == 3 {
+		switch strings.ToLower(string(args[2])) {
+		case "byte":
+		case "bit":
+			unitBit = true
+		default:
+			return 0, 0, false, resp.NewErrorData("ERR syntax error")
+		}
+	}
+	total := int64(size)
+	if unitBit {
+		total *= 8
+	}
+	if s < 0 {
+		s += total
+	}
+	if e < 0 {
+		e += total
+	}
+	if s < 0 {
+		s = 0
+	}
+	if e >= total {
+		e = total - 1
+	}
+	if s > e || total == 0 {
+		return 0, 0, true, nil
+	}
+	if !unitBit {
+		s, e = s*8, e*8+7
+	}
+	return s, e, false, nil
+}
+
+// countBits counts the bits set in the bit range [start, end]
+func countBits(val []byte, start, end int64) int64 {
+	var res int64
+	for start <= end && start&7 != 0 {
+		res += int64(getBit(val, uint64(start)))
+		start++
+	}
+	for start+7 <= end {
+		res += int64(bits.OnesCount8(val[start>>3]))
+		start += 8
+	}
+	for start <= end {
+		res += int64(getBit(val, uint64(start)))
+		start++
+	}
+	return res
+}
+
+func bitCountString(m *MemDb, cmd [][]byte) resp.RedisData {
+	if strings.ToLower(string(cmd[0])) != "bitcount" {
+		logger.Error("bitCountString func: cmdName is not bitcount")
+		return resp.NewErrorData("Server error")
+	}
+	if len(cmd) != 2 && len(cmd) != 4 && len(cmd) != 5 {
+		return resp.NewErrorData("ERR syntax error")
+	}
+
+	key := string(cmd[1])
+	if !m.CheckTTL(key) {
+		return resp.NewIntData(0)
+	}
+	m.locks.RLock(key)
+	defer m.locks.RUnlock(key)
+
+	val, errData := m.getStringValue(key)
+	if errData != nil {
+		return errData
+	}
+	start, end := int64(0), int64(len(val))*8-1
+	if len(cmd) > 2 {
+		var empty bool
+		start, end, empty, errData = parseBitRange(cmd[2:], len(val))
+		if errData != nil {
+			return errData
+		}
+		if empty {
+			return resp.NewIntData(0)
+		}
+	}
+	return resp.NewIntData(countBits(val, start, end))
+}
+
+func bitPosString(m *MemDb, cmd [][]byte) resp.RedisData {
+	if strings.ToLower(string(cmd[0])) != "bitpos" {
+		logger.Error("bitPosString func: cmdName is not bitpos")
+		return resp.NewErrorData("Server error")
+	}
+	if len(cmd) < 3 || len(cmd) > 6 {
+		return resp.NewErrorData("ERR wrong number of arguments for 'bitpos' command")
+	}
+	bitStr := string(cmd[2])
+	if bitStr != "0" && bitStr != "1" {
+		return resp.NewErrorData("ERR The bit argument must be 1 or 0.")
+	}
+	bit := bitStr[0] - '0'
+
+	key := string(cmd[1])
+	m.CheckTTL(key)
+	m.locks.RLock(key)
+	defer m.locks.RUnlock(key)
+
+	val, errData := m.getStringValue(key)
+	if errData != nil {
+		return errData
+	}
+	if val == nil {
+		if bit == 0 {
+			return resp.NewIntData(0)
+		}
+		return resp.NewIntData(-1)
+	}
+
+	// the range arguments are start [end [BYTE|BIT]], end defaults to the last byte
+	endGiven := len(cmd) > 4
+	rangeArgs := [][]byte{[]byte("0"), []byte("-1")}
+	if len(cmd) > 3 {
+		rangeArgs[0] = cmd[3]
+	}
+	if endGiven {
+		rangeArgs = append(rangeArgs[:1], cmd[4:]...)
+	}
+	start, end, empty, errData := parseBitRange(rangeArgs, len(val))
+	if errData != nil {
+		return errData
+	}
+	if empty {
+		return resp.NewIntData(-1)
+	}
+	for i := start; i <= end; i++ {
+		if getBit(val, uint64(i)) == bit {
+			return resp.NewIntData(i)
+		}
+	}
+	// looking for a clear bit in a range with all bits set:
+	// without an explicit end the value is considered padded with zeros on the right
+	if bit == 0 && !endGiven {
+		return resp.NewIntData(end + 1)
+	}
+	return resp.NewIntData(-1)
+}
+
+func bitOpString(m *MemDb, cmd [][]byte) resp.RedisData {
+	if strings.ToLower(string(cmd[0])) != "bitop" {
+		logger.Error("bitOpString func: cmdName is not bitop")
+		return resp.NewErrorData("Server error")
+	}
+	if len(cmd) < 4 {
+		return resp.NewErrorData("ERR wrong number of arguments for 'bitop' command")
+	}
+	op := strings.ToLower(string(cmd[1]))
+	if op != "and" && op != "or" && op != "xor" && op != "not" {
+		return resp.NewErrorData("ERR syntax error")
+	}
+	if op == "not" && len(cmd) != 4 {
+		return resp.NewErrorData("ERR BITOP NOT must be called with a single source key.")
+	}
+
+	dest := string(cmd[2])
+	keys := make([]string, 0, len(cmd)-2)
+	keys = append(keys, dest)
+	for _, k := range cmd[3:] {
+		m.CheckTTL(string(k))
+		keys = append(keys, string(k))
+	}
+	m.locks.LockMulti(keys)
+	defer m.locks.UnlockMulti(keys)
+
+	srcs := make([][]byte, 0, len(keys)-1)
+	maxLen := 0
+	for _, key := range keys[1:] {
+		val, errData := m.getStringValue(key)
+		if errData != nil {
+			return errData
+		}
+		srcs = append(srcs, val)
+		if len(val) > maxLen {
+			maxLen = len(val)
+		}
+	}
+
+	res := make([]byte, maxLen)
+	for i := 0; i < maxLen; i++ {
+		b := byteAt(srcs[0], i)
+		if op == "not" {
+			b = ^b
+		}
+		for _, src := range srcs[1:] {
+			switch op {
+			case "and":
+				b &= byteAt(src, i)
+			case "or":
+				b |= byteAt(src, i)
+			case "xor":
+				b ^= byteAt(src, i)
+			}
+		}
+		res[i] = b
+	}
+
+	m.DelTTL(dest)
+	if maxLen == 0 {
+		m.db.Delete(dest)
+	} else {
+		m.db.Set(dest, res)
+	}
+	return resp.NewIntData(int64(maxLen))
+}
+
+func byteAt(val []byte, i int) byte {
+	if i < len(val) {
+		return val[i]
+	}
+	return 0
+}
+
+// bitfield overflow modes
+const (
+	overflowWrap = iota
+	overflowSat
+	overflowFail
+)
+
+// bitfieldType is a BITFIELD encoding like i8 or u16
+type bitfieldType struct {
+	signed bool
+	bits   uint
+}
+
+func parseBitfieldType(arg []byte) (bitfieldType, bool) {
+	s := strings.ToLower(string(arg))
+	if len(s) < 2 || (s[0] != 'i' && s[0] != 'u') {
+		return bitfieldType{}, false
+	}
+	n, err := strconv.Atoi(s[1:])
+	t := bitfieldType{signed: s[0] == 'i', bits: uint(n)}
+	if err != nil || n < 1 || (t.signed && n > 64) || (!t.signed && n > 63) {
+		return bitfieldType{}, false
+	}
+	return t, true
+}
+
+// parseBitfieldOffset parses an offset, #N means N times the width of the type
+func parseBitfieldOffset(arg []byte, t bitfieldType) (uint64, bool) {
+	s := string(arg)
+	mul := uint64(1)
+	if strings.HasPrefix(s, "#") {
+		s = s[1:]
+		mul = uint64(t.bits)
+	}
+	offset, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || offset < 0 || uint64(offset)*mul+uint64(t.bits)-1 > maxBitOffset {
+		return 0, false
+	}
+	return uint64(offset) * mul, true
+}
+
+func (t bitfieldType) minMax() (int64, int64) {
+	if t.signed {
+		return -1 << (t.bits - 1), 1<<(t.bits-1) - 1
+	}
+	return 0, 1<<t.bits - 1
+}
+
+// truncate wraps v to the width of the type
+func (t bitfieldType) truncate(v uint64) int64 {
+	if t.bits == 64 {
+		return int64(v)
+	}
+	v &= 1<<t.bits - 1
+	if t.signed && v&(1<<(t.bits-1)) != 0 {
+		v |= math.MaxUint64 << t.bits
+	}
+	return int64(v)
+}
+
+func (t bitfieldType) get(val []byte, offset uint64) int64 {
+	var v uint64
+	for i := uint64(0); i < uint64(t.bits); i++ {
+		v = v<<1 | uint64(getBit(val, offset+i))
+	}
+	return t.truncate(v)
+}
+
+func (t bitfieldType) set(val []byte, offset uint64, v int64) {
+	for i := uint64(0); i < uint64(t.bits); i++ {
+		setBit(val, offset+i, byte(uint64(v)>>(uint64(t.bits)-1-i))&1)
+	}
+}
+
+// add returns old+incr handling the overflow with mode, ok is false if mode is FAIL and an overflow happens
+func (t bitfieldType) add(old, incr int64, mode int) (int64, bool) {
+	minVal, maxVal := t.minMax()
+	var over, under bool
+	if t.signed {
+		over = incr > 0 && old > maxVal-incr
+		under = incr < 0 && old < minVal-incr
+	} else {
+		// old is in [0, maxVal], compare in uint64 to avoid overflows
+		if incr > 0 {
+			over = uint64(incr) > uint64(maxVal-old)
+		} else if incr < 0 {
+			under = uint64(-(incr+1))+1 > uint64(old)
+		}
+	}
+	if !over && !under {
+		return old + incr, true
+	}
+	switch mode {
+	case overflowSat:
+		if over {
+			return maxVal, true
+		}
+		return minVal, true
+	case overflowFail:
+		return 0, false
+	}
+	return t.truncate(uint64(old) + uint64(incr)), true
+}
+
+// bitfieldOp is a GET, SET or INCRBY sub command of BITFIELD
+type bitfieldOp struct {
+	op       string
+	typ      bitfieldType
+	offset   uint64
+	value    int64
+	overflow int
+}
+
+func bitFieldString(m *MemDb, cmd [][]byte) resp.RedisData {
+	cmdName := strings.ToLower(string(cmd[0]))
+	if cmdName != "bitfield" && cmdName != "bitfield_ro" {
+		logger.Error("bitFieldString func: cmdName is not bitfield or bitfield_ro")
+		return resp.NewErrorData("Server error")
+	}
+	if len(cmd) < 2 {
+		return resp.NewErrorData(fmt.Sprintf("ERR wrong number of arguments for '%s' command", cmdName))
+	}
+
+	ops := make([]bitfieldOp, 0)
+	overflow := overflowWrap
+	write := false
+	for i := 2; i < len(cmd); {
+		sub := strings.ToLower(string(cmd[i]))
+		if sub == "overflow" {
+			if cmdName == "bitfield_ro" || i+1 >= len(cmd) {
+				return resp.NewErrorData("ERR syntax error")
+			}
+			switch strings.ToLower(string(cmd[i+1])) {
+			case "wrap":
+				overflow = overflowWrap
+			case "sat":
+				overflow = overflowSat
+			case "fail":
+				overflow = overflowFail
+			default:
+				return resp.NewErrorData("ERR Invalid OVERFLOW type specified")
+			}
+			i += 2
+			continue
+		}
+		argc := 3
+		if sub == "get" {
+			argc = 2
+		} else if sub != "set" && sub != "incrby" {
+			return resp.NewErrorData("ERR syntax error")
+		} else if cmdName == "bitfield_ro" {
+			return resp.NewErrorData("ERR BITFIELD_RO only supports the GET subcommand")
+		}
+		if i+argc >= len(cmd) {
+			return resp.NewErrorData("ERR syntax error")
+		}
+		typ, ok := parseBitfieldType(cmd[i+1])
+		if !ok {
+			return resp.NewErrorData("ERR Invalid bitfield type. Use something like i16 u8. Note that u64 is not supported but i64 is.")
+		}
+		offset, ok := parseBitfieldOffset(cmd[i+2], typ)
+		if !ok {
+			return resp.NewErrorData("ERR bit offset is not an integer or out of range")
+		}
+		op := bitfieldOp{op: sub, typ: typ, offset: offset, overflow: overflow}
+		if argc == 3 {
+			var err error
+			op.value, err = strconv.ParseInt(string(cmd[i+3]), 10, 64)
+			if err != nil {
+				return resp.NewErrorData("ERR value is not an integer or out of range")
+			}
+			write = true
+		}
+		ops = append(ops, op)
+		i += argc + 1
+	}
+
+	key := string(cmd[1])
+	m.CheckTTL(key)
+	if write {
+		m.locks.Lock(key)
+		defer m.locks.Unlock(key)
+	} else {
+		m.locks.RLock(key)
+		defer m.locks.RUnlock(key)
+	}
+	val, errData := m.getStringValue(key)
+	if errData != nil {
+		return errData
+	}
+
+	res := make([]resp.RedisData, 0, len(ops))
+	changed := false
+	for _, op := range ops {
+		old := op.typ.get(val, op.offset)
+		if op.op == "get" {
+			res = append(res, resp.NewIntData(old))
+			continue
+		}
+		var newVal int64
+		var ok bool
+		if op.op == "set" {
+			// setting a value is adding the difference to zero, so the overflow rules are the same
+			newVal, ok = op.typ.add(0, op.value, op.overflow)
+		} else {
+			newVal, ok = op.typ.add(old, op.value, op.overflow)
+		}
+		if !ok {
+			res = append(res, resp.NewBulkData(nil))
+			continue
+		}
+		val = growBytes(val, (op.offset+uint64(op.typ.bits)+7)>>3)
+		op.typ.set(val, op.offset, newVal)
+		changed = true
+		if op.op == "set" {
+			res = append(res, resp.NewIntData(old))
+		} else {
+			res = append(res, resp.NewIntData(newVal))
+		}
+	}
+	if changed {
+		m.db.Set(key, val)
+	}
+	return resp.NewArrayData(res)
+}
+
+func RegisterBitmapCommands() {
+	RegisterCommand("setbit", setBitString)
+	RegisterCommand("getbit", getBitString, flagReadOnly)
+	RegisterCommand("bitcount", bitCountString, flagReadOnly)
+	RegisterCommand("bitpos", bitPosString, flagReadOnly)
+	RegisterCommand("bitop", bitOpString)
+	RegisterCommand("bitfield", bitFieldString)
+	RegisterCommand("bitfield_ro", bitFieldString, flagReadOnly)
+}
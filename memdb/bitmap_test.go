@@ -0,0 +1,152 @@
+package memdb
+
+import (
+	"testing"
+)
+
+func TestSetBitGetBit(t *testing.T) {
+	mem := newTestDb()
+
+	if res := exec(mem, "setbit", "b", "7", "1"); res != ":0\r\n" {
+		t.Errorf("setbit reply %q", res)
+	}
+	if res := exec(mem, "setbit", "b", "7", "0"); res != ":1\r\n" {
+		t.Errorf("setbit reply %q", res)
+	}
+	// zero extension
+	exec(mem, "setbit", "b", "17", "1")
+	if res := exec(mem, "get", "b"); res != "$3\r\n\x00\x00\x40\r\n" {
+		t.Errorf("get bitmap %q", res)
+	}
+	if res := exec(mem, "getbit", "b", "17"); res != ":1\r\n" {
+		t.Errorf("getbit reply %q", res)
+	}
+	if res := exec(mem, "getbit", "b", "1000"); res != ":0\r\n" {
+		t.Errorf("getbit out of range reply %q", res)
+	}
+	if res := exec(mem, "setbit", "b", "-1", "1"); res != "-ERR bit offset is not an integer or out of range\r\n" {
+		t.Errorf("setbit negative offset reply %q", res)
+	}
+	if res := exec(mem, "setbit", "b", "1", "2"); res != "-ERR bit is not an integer or out of range\r\n" {
+		t.Errorf("setbit bad bit reply %q", res)
+	}
+}
+
+func TestBitCountBitPos(t *testing.T) {
+	mem := newTestDb()
+	exec(mem, "set", "s", "foobar")
+
+	cases := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"bitcount", "s"}, ":26\r\n"},
+		{[]string{"bitcount", "s", "0", "0"}, ":4\r\n"},
+		{[]string{"bitcount", "s", "1", "1"}, ":6\r\n"},
+		{[]string{"bitcount", "s", "1", "1", "byte"}, ":6\r\n"},
+		{[]string{"bitcount", "s", "5", "30", "bit"}, ":17\r\n"},
+		{[]string{"bitcount", "s", "-2", "-1"}, ":7\r\n"},
+		{[]string{"bitcount", "nokey"}, ":0\r\n"},
+	}
+	for _, c := range cases {
+		if res := exec(mem, c.args...); res != c.want {
+			t.Errorf("%v reply %q, expect %q", c.args, res, c.want)
+		}
+	}
+
+	exec(mem, "set", "p", "\xff\xf0\x00")
+	cases = []struct {
+		args []string
+		want string
+	}{
+		{[]string{"bitpos", "p", "0"}, ":12\r\n"},
+		{[]string{"bitpos", "p", "1", "2"}, ":-1\r\n"},
+		{[]string{"bitpos", "p", "1", "7", "15", "bit"}, ":7\r\n"},
+		{[]string{"bitpos", "nokey", "0"}, ":0\r\n"},
+		{[]string{"bitpos", "nokey", "1"}, ":-1\r\n"},
+	}
+	for _, c := range cases {
+		if res := exec(mem, c.args...); res != c.want {
+			t.Errorf("%v reply %q, expect %q", c.args, res, c.want)
+		}
+	}
+
+	// all bits set: without an end the value is padded with zeros
+	exec(mem, "set", "ones", "\xff\xff")
+	if res := exec(mem, "bitpos", "ones", "0"); res != ":16\r\n" {
+		t.Errorf("bitpos ones 0 reply %q", res)
+	}
+	if res := exec(mem, "bitpos", "ones", "0", "0", "-1"); res != ":-1\r\n" {
+		t.Errorf("bitpos ones 0 0 -1 reply %q", res)
+	}
+}
+
+func TestBitOp(t *testing.T) {
+	mem := newTestDb()
+	exec(mem, "set", "a", "\x0f\xf0")
+	exec(mem, "set", "b", "\xff")
+
+	if res := exec(mem, "bitop", "and", "d", "a", "b"); res != ":2\r\n" {
+		t.Errorf("bitop and reply %q", res)
+	}
+	if res := exec(mem, "get", "d"); res != "$2\r\n\x0f\x00\r\n" {
+		t.Errorf("bitop and result %q", res)
+	}
+	exec(mem, "bitop", "or", "d", "a", "b")
+	if res := exec(mem, "get", "d"); res != "$2\r\n\xff\xf0\r\n" {
+		t.Errorf("bitop or result %q", res)
+	}
+	exec(mem, "bitop", "xor", "d", "a", "b")
+	if res := exec(mem, "get", "d"); res != "$2\r\n\xf0\xf0\r\n" {
+		t.Errorf("bitop xor result %q", res)
+	}
+	exec(mem, "bitop", "not", "d", "a")
+	if res := exec(mem, "get", "d"); res != "$2\r\n\xf0\x0f\r\n" {
+		t.Errorf("bitop not result %q", res)
+	}
+	if res := exec(mem, "bitop", "not", "d", "a", "b"); res[0] != '-' {
+		t.Errorf("bitop not with two keys reply %q", res)
+	}
+}
+
+func TestBitField(t *testing.T) {
+	mem := newTestDb()
+
+	res := exec(mem, "bitfield", "f", "set", "i8", "0", "-100", "get", "i8", "0", "get", "u8", "0")
+	if res != "*3\r\n:0\r\n:-100\r\n:156\r\n" {
+		t.Errorf("bitfield set get reply %q", res)
+	}
+	res = exec(mem, "bitfield", "f", "incrby", "u2", "100", "1", "overflow", "sat", "incrby", "u2", "102", "1")
+	if res != "*2\r\n:1\r\n:1\r\n" {
+		t.Errorf("bitfield incrby reply %q", res)
+	}
+
+	// wrap, sat and fail
+	exec(mem, "bitfield", "o", "set", "u8", "#0", "250")
+	res = exec(mem, "bitfield", "o", "incrby", "u8", "#0", "10")
+	if res != "*1\r\n:4\r\n" {
+		t.Errorf("bitfield wrap reply %q", res)
+	}
+	res = exec(mem, "bitfield", "o", "overflow", "sat", "incrby", "u8", "#0", "300", "overflow", "sat", "incrby", "i8", "#1", "-300")
+	if res != "*2\r\n:255\r\n:-128\r\n" {
+		t.Errorf("bitfield sat reply %q", res)
+	}
+	res = exec(mem, "bitfield", "o", "overflow", "fail", "incrby", "u8", "#0", "1", "get", "u8", "#0")
+	if res != "*2\r\n$-1\r\n:255\r\n" {
+		t.Errorf("bitfield fail reply %q", res)
+	}
+	res = exec(mem, "bitfield", "o", "set", "i64", "0", "-1", "incrby", "i64", "0", "-9223372036854775808")
+	if res != "*2\r\n:-36028797018963968\r\n:9223372036854775807\r\n" {
+		t.Errorf("bitfield i64 wrap reply %q", res)
+	}
+
+	if res = exec(mem, "bitfield_ro", "o", "get", "u8", "0"); res != "*1\r\n:127\r\n" {
+		t.Errorf("bitfield_ro reply %q", res)
+	}
+	if res = exec(mem, "bitfield_ro", "o", "set", "u8", "0", "1"); res[0] != '-' {
+		t.Errorf("bitfield_ro set reply %q", res)
+	}
+	if res = exec(mem, "bitfield", "o", "get", "u64", "0"); res[0] != '-' {
+		t.Errorf("bitfield u64 reply %q", res)
+	}
+}
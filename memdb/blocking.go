@@ -0,0 +1,123 @@
+package memdb
+
+import (
+	"easyRedis/resp"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// blocking.go lets the blocking commands wait until one of their keys is written.
+//
+// A blocked client watches its keys with a channel, the commands that add elements to a key
+// signal the channels watching it and the client retries its command.
+// Scripts never block, their blocking commands behave like the non-blocking ones.
+// A blocked client stops waiting once its connection is closed, so that it doesn't pop
+// an element written later that nobody would read.
+
+type keyWatchers struct {
+	mu       sync.Mutex
+	watchers map[string]map[chan struct{}]struct{}
+}
+
+func newKeyWatchers() *keyWatchers {
+	return &keyWatchers{watchers: make(map[string]map[chan struct{}]struct{})}
+}
+
+// watch registers ch for keys, ch should be buffered
+func (w *keyWatchers) watch(keys []string, ch chan struct{}) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, key := range keys {
+		chs, ok := w.watchers[key]
+		if !ok {
+			chs = make(map[chan struct{}]struct{})
+			w.watchers[key] = chs
+		}
+		chs[ch] = struct{}{}
+	}
+}
+
+func (w *keyWatchers) unwatch(keys []string, ch chan struct{}) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, key := range keys {
+		chs := w.watchers[key]
+		delete(chs, ch)
+		if len(chs) == 0 {
+			delete(w.watchers, key)
+		}
+	}
+}
+
+// signal wakes the clients watching key without waiting for them
+func (w *keyWatchers) signal(key string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for ch := range w.watchers[key] {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// signalKey wakes the clients blocked on key, it is called once key is written
+func (m *MemDb) signalKey(key string) {
+	m.root().watchers.signal(key)
+}
+
+// parseBlockTimeout parses the timeout of the blocking commands, in seconds with decimals
+func parseBlockTimeout(arg []byte) (time.Duration, resp.RedisData) {
+	secs, err := strconv.ParseFloat(string(arg), 64)
+	if err != nil || math.IsNaN(secs) || math.IsInf(secs, 0) {
+		return 0, resp.NewErrorData("ERR timeout is not a float or out of range")
+	}
+	if secs < 0 {
+		return 0, resp.NewErrorData("ERR timeout is negative")
+	}
+	return time.Duration(secs * float64(time.Second)), nil
+}
+
+// blockPop calls pop until it returns a reply, waiting for a write on keys between the calls.
+// pop returns nil when all the keys are empty. It replies a nil array once timeout expires
+// or the client is closed, a zero timeout waits forever.
+func (m *MemDb) blockPop(keys []string, timeout time.Duration, pop func() resp.RedisData) resp.RedisData {
+	if m.origin != nil {
+		if res := pop(); res != nil {
+			return res
+		}
+		return resp.NewArrayData(nil)
+	}
+
+	// watch before the first try, so a write between the try and the wait is not missed
+	ch := make(chan struct{}, 1)
+	m.watchers.watch(keys, ch)
+	defer m.watchers.unwatch(keys, ch)
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	var closed <-chan struct{}
+	for {
+		if res := pop(); res != nil {
+			return res
+		}
+		// the connection is watched only once the client really waits
+		if closed == nil && m.client != nil && m.client.WatchClose != nil {
+			var stop func()
+			closed, stop = m.client.WatchClose()
+			defer stop()
+		}
+		select {
+		case <-ch:
+		case <-expired:
+			return resp.NewArrayData(nil)
+		case <-closed:
+			return resp.NewArrayData(nil)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package memdb
+
+import (
+	"easyRedis/logger"
+	"easyRedis/resp"
+	"fmt"
+	"strings"
+)
+
+// client.go implements CLIENT SETNAME and GETNAME.
+// A Client is owned by the connection running its commands, so it is not locked.
+
+// Client is a connection running commands, it is recorded with its commands such as in the slow log
+type Client struct {
+	// Addr is the ip:port of the client
+	Addr string
+	// Name is set by CLIENT SETNAME, empty if not set
+	Name string
+	// Peer is the node id sent by CRDT.HELLO, empty if the client is not an active-active peer.
+	// Only a peer may merge ops, and only the ops of its own node.
+	Peer string
+	// WatchClose is called when a command of the client blocks. It returns a channel closed once the
+	// connection is closed, and a function that stops watching when the command returns.
+	// It is nil if the connection can't be watched.
+	WatchClose func() (closed <-chan struct{}, stop func())
+}
+
+func NewClient(addr string) *Client {
+	return &Client{Addr: addr}
+}
+
+func clientCommand(m *MemDb, cmd [][]byte) resp.RedisData {
+	if strings.ToLower(string(cmd[0])) != "client" {
+		logger.Error("clientCommand Function: cmdName is not client")
+		return resp.NewErrorData("server error")
+	}
+	if len(cmd) < 2 {
+		return resp.NewErrorData("ERR wrong number of arguments for 'client' command")
+	}
+	if m.client == nil {
+		return resp.NewErrorData("ERR CLIENT can only be called by a client connection")
+	}
+	sub := strings.ToLower(string(cmd[1]))
+	switch sub {
+	case "setname":
+		if len(cmd) != 3 {
+			return resp.NewErrorData("ERR wrong number of arguments for 'client|setname' command")
+		}
+		// like redis, a name is a word of printable characters, an empty name removes it
+		for _, c := range cmd[2] {
+			if c < '!' || c > '~' {
+				return resp.NewErrorData("ERR Client names cannot contain spaces, newlines or special characters.")
+			}
+		}
+		m.client.Name = string(cmd[2])
+		return resp.NewStringData("OK")
+	case "getname":
+		if len(cmd) != 2 {
+			return resp.NewErrorData("ERR wrong number of arguments for 'client|getname' command")
+		}
+		if m.client.Name == "" {
+			return resp.NewBulkData(nil)
+		}
+		return resp.NewBulkData([]byte(m.client.Name))
+	}
+	return resp.NewErrorData(fmt.Sprintf("ERR unknown subcommand '%s'. Supported subcommands are SETNAME and GETNAME.", cmd[1]))
+}
+
+func RegisterClientCommands() {
+	RegisterCommand("client", clientCommand, flagReadOnly, flagNoScript, flagClient)
+}
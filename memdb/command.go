@@ -7,12 +7,59 @@ var CmdTable = make(map[string]*command)
 
 type cmdExecutor func(m *MemDb, cmd [][]byte) resp.RedisData
 
+type cmdFlag uint8
+
+const (
+	// flagReadOnly marks commands that never modify the database
+	flagReadOnly cmdFlag = 1 << iota
+	// flagNoScript marks commands that can not be called from scripts
+	flagNoScript
+	// flagNoTouch marks introspection commands that don't change the access stats of the keys
+	flagNoTouch
+	// flagBlocking marks commands that may wait for a key, they are not kept in the slow log
+	flagBlocking
+	// flagClient marks commands that get the client running them, such as CLIENT SETNAME,
+	// or the blocking commands that stop waiting when the client is closed
+	flagClient
+	// flagNoActiveActive marks write commands making values that can't be replicated in active-active mode
+	flagNoActiveActive
+)
+
 type command struct {
 	executor cmdExecutor
+	flags    cmdFlag
 }
 
-func RegisterCommand(cmdName string, executor cmdExecutor) {
-	CmdTable[cmdName] = &command{
+func RegisterCommand(cmdName string, executor cmdExecutor, flags ...cmdFlag) {
+	cmd := &command{
 		executor: executor,
 	}
+	for _, flag := range flags {
+		cmd.flags |= flag
+	}
+	CmdTable[cmdName] = cmd
+}
+
+func (c *command) readOnly() bool {
+	return c.flags&flagReadOnly != 0
+}
+
+func (c *command) noScript() bool {
+	return c.flags&flagNoScript != 0
+}
+
+func (c *command) noTouch() bool {
+	return c.flags&flagNoTouch != 0
+}
+
+func (c *command) blocking() bool {
+	return c.flags&flagBlocking != 0
+}
+
+func (c *command) needsClient() bool {
+	return c.flags&flagClient != 0
+}
+
+func (c *command) noActiveActive() bool {
+	return c.flags&flagNoActiveActive != 0
 }
@@ -0,0 +1,1084 @@
+package memdb
+
+import (
+	"bytes"
+	"easyRedis/config"
+	"easyRedis/crdt"
+	"easyRedis/datastructure"
+	"easyRedis/logger"
+	"easyRedis/resp"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// crdt.go implements the active-active mode.
+// Every write of a replica is turned into crdt.Op effects which are applied locally and shipped to all peers.
+// Conflicts are resolved per type:
+//   string:     last-writer-wins register ordered by hybrid logical clock, INCR family uses a PN-counter
+//   set:        observed-remove set
+//   hash:       add-wins fields, field values are LWW registers, HINCRBY uses a PN-counter per field
+//   sorted set: add-wins members, scores are LWW registers
+//   ttl:        LWW register holding the unix deadline
+// The CRDT metadata of a key lives in activeActive.meta, db only holds the materialized value,
+// so all read commands work unchanged.
+//
+// The write commands of crdtCmdTable emit their ops directly. The other write commands run unchanged
+// on a view of db recording the keys they use, then the new values of these keys are turned into ops
+// by replicateKey, they are last-writer-wins like SET. These commands run one at a time, so that no op
+// is applied to the keys between the command and its replication.
+// Lists and the expiration of hash fields are not kept in the CRDT state: the commands flagged
+// flagNoActiveActive and the blocking commands are rejected, and a list made by another command,
+// like SORT with STORE or RESTORE, is deleted with an error.
+
+// crdtCmdTable holds the write commands with a CRDT specific implementation.
+// An executor returns nil for the forms of the command it doesn't handle, they are replicated like
+// the other write commands.
+var crdtCmdTable = make(map[string]cmdExecutor)
+
+// mu is held for reading by the commands of crdtCmdTable, and for writing by the other write commands
+type activeActive struct {
+	node  string
+	clock *crdt.Clock
+	peers []crdt.Peer
+	meta  *datastructure.ConcurrentMap
+	mu    sync.RWMutex
+	// seen holds the latest op applied from each node, the local one included, it filters the resent ops.
+	// emitMu makes the local ops reach the peers in timestamp order, which seen relies on.
+	seen   *crdt.VersionVector
+	emitMu sync.Mutex
+}
+
+// received returns true if the op that made tag was already applied
+func (aa *activeActive) received(tag string) bool {
+	ts, ok := crdt.ParseTag(tag)
+	return !ok || aa.seen.Covers(ts)
+}
+
+type crdtKind int
+
+const (
+	crdtNone crdtKind = iota
+	crdtString
+	crdtSet
+	crdtHash
+	crdtSortSet
+)
+
+// crdtEntry is the CRDT state of a key
+type crdtEntry struct {
+	kind     crdtKind
+	created  crdt.Timestamp
+	reg      crdt.Register              // string value
+	cnt      *crdt.PNCounter            // string counter
+	members  *crdt.ORSet                // set members, hash fields or sorted set members
+	values   map[string]*crdt.Register  // hash values or sorted set scores
+	counters map[string]*crdt.PNCounter // hash field counters
+	ttl      crdt.Register              // unix deadline in seconds
+}
+
+func newCrdtEntry(kind crdtKind, created crdt.Timestamp) *crdtEntry {
+	return &crdtEntry{
+		kind:     kind,
+		created:  created,
+		cnt:      crdt.NewPNCounter(),
+		members:  crdt.NewORSet(),
+		values:   make(map[string]*crdt.Register),
+		counters: make(map[string]*crdt.PNCounter),
+	}
+}
+
+func (e *crdtEntry) visible() bool {
+	switch e.kind {
+	case crdtString:
+		return e.reg.Exist() || e.cnt.Live()
+	case crdtSet, crdtSortSet:
+		return e.members.Len() > 0
+	case crdtHash:
+		for _, field := range e.members.Members() {
+			if e.hasField(field) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// hasField returns true if field of a hash is present.
+// A field re-added concurrently with HDEL wins, but its value may have been deleted by the later HDEL.
+func (e *crdtEntry) hasField(field string) bool {
+	return e.members.Has(field) && (e.value(field).Exist() || e.counter(field).Live())
+}
+
+func (e *crdtEntry) value(field string) *crdt.Register {
+	r, ok := e.values[field]
+	if !ok {
+		r = &crdt.Register{}
+		e.values[field] = r
+	}
+	return r
+}
+
+func (e *crdtEntry) counter(field string) *crdt.PNCounter {
+	c, ok := e.counters[field]
+	if !ok {
+		c = crdt.NewPNCounter()
+		e.counters[field] = c
+	}
+	return c
+}
+
+// counterValue combines a register and a counter: the counter is added to the integer held by the register
+func counterValue(reg *crdt.Register, cnt *crdt.PNCounter) []byte {
+	if !cnt.Live() {
+		return reg.Value
+	}
+	var base int64
+	if reg.Exist() {
+		base, _ = strconv.ParseInt(string(reg.Value), 10, 64)
+	}
+	return []byte(strconv.FormatInt(base+cnt.Value(), 10))
+}
+
+// EnableActiveActive turns on the active-active mode, writes are replicated to peers.
+func (m *MemDb) EnableActiveActive(nodeID string, peers ...crdt.Peer) {
+	m.aa = &activeActive{
+		node:  nodeID,
+		clock: crdt.NewClock(nodeID),
+		peers: peers,
+		meta:  datastructure.NewConcurrentMap(config.Configures.ShardNum),
+		seen:  crdt.NewVersionVector(),
+	}
+}
+
+func (m *MemDb) execActiveActive(cmdName string, command *command, cmd [][]byte) resp.RedisData {
+	if command.noActiveActive() || command.blocking() {
+		return resp.NewErrorData(fmt.Sprintf("ERR command '%s' is not supported in active-active mode", cmdName))
+	}
+	// the commands called by a script are replicated with the script
+	if m.origin != nil {
+		return command.executor(m, cmd)
+	}
+	if executor, ok := crdtCmdTable[cmdName]; ok {
+		m.aa.mu.RLock()
+		res := executor(m, cmd)
+		m.aa.mu.RUnlock()
+		if res != nil {
+			return res
+		}
+	}
+
+	m.aa.mu.Lock()
+	defer m.aa.mu.Unlock()
+	ctx := *m
+	ctx.db = m.db.recording()
+	res := command.executor(&ctx, cmd)
+	for key, written := range ctx.db.used {
+		m.locks.Lock(key)
+		errData := m.replicateKey(key, written)
+		m.locks.Unlock(key)
+		if errData != nil {
+			res = errData
+		}
+	}
+	return res
+}
+
+// replicateKey emits the ops turning the CRDT state of key into the value of key in db.
+// A value that can't be replicated is deleted if written is true, and left local otherwise.
+// The caller must hold the write lock of key.
+func (m *MemDb) replicateKey(key string, written bool) resp.RedisData {
+	temp, ok := m.db.Peek(key)
+	if !ok {
+		m.emitDelete(key)
+		return nil
+	}
+	var kind crdtKind
+	switch val := temp.(type) {
+	case []byte:
+		kind = crdtString
+	case *datastructure.Set:
+		kind = crdtSet
+	case *datastructure.Hash:
+		if _, ok := val.NextExpire(); !ok {
+			kind = crdtHash
+		}
+	case *datastructure.SortSet:
+		kind = crdtSortSet
+	}
+	if kind == crdtNone {
+		if !written {
+			return nil
+		}
+		// materialize expects db to hold the kind of the CRDT state, so the value is deleted first
+		m.db.Delete(key)
+		m.DelTTL(key)
+		m.emitDelete(key)
+		return resp.NewErrorData(fmt.Sprintf("ERR the value of '%s' can't be replicated in active-active mode", key))
+	}
+	// the ops below sync the ttl of the CRDT state to db, so the ttl of db is read first
+	var deadline int64
+	if ttl, ok := m.ttlKeys.Get(key); ok {
+		deadline = ttl.(int64)
+	}
+	e := m.peekEntry(key)
+	if e != nil && e.kind != kind && e.visible() {
+		m.db.Delete(key)
+		m.emitDelete(key)
+	}
+	if e = m.peekEntry(key); e != nil && e.kind != kind {
+		e = nil
+	}
+
+	switch kind {
+	case crdtString:
+		val := temp.([]byte)
+		if e != nil && e.visible() && bytes.Equal(counterValue(&e.reg, e.cnt), val) {
+			break
+		}
+		args := [][]byte{val}
+		if e != nil {
+			args = append(args, crdt.EncodeCounter(e.cnt.Snapshot())...)
+		}
+		m.emit(crdt.OpSet, key, args...)
+	case crdtSet:
+		set := temp.(*datastructure.Set)
+		// add before removing, so that the key never looks empty
+		for _, member := range set.Member() {
+			if e == nil || !e.members.Has(member) {
+				m.emit(crdt.OpSAdd, key, []byte(member))
+			}
+		}
+		if e != nil {
+			for _, member := range e.members.Members() {
+				if !set.Has(member) {
+					m.emit(crdt.OpSRem, key, []byte(member), crdt.EncodeTags(e.members.Tags(member)))
+				}
+			}
+		}
+	case crdtHash:
+		hash := temp.(*datastructure.Hash)
+		// materialize writes the hash, so the changed fields are collected before emitting
+		var changed [][]byte
+		hash.ForEach(func(field string, val []byte) bool {
+			if e == nil || !e.hasField(field) || !bytes.Equal(counterValue(e.value(field), e.counter(field)), val) {
+				// the value may point into the listpack of the hash
+				changed = append(changed, []byte(field), append([]byte(nil), val...))
+			}
+			return true
+		})
+		for i := 0; i < len(changed); i += 2 {
+			args := [][]byte{changed[i], changed[i+1]}
+			if e != nil {
+				args = append(args, crdt.EncodeCounter(e.counter(string(changed[i])).Snapshot())...)
+			}
+			m.emit(crdt.OpHSet, key, args...)
+		}
+		if e != nil {
+			for _, field := range e.members.Members() {
+				if !hash.Exist(field) {
+					args := [][]byte{[]byte(field), crdt.EncodeTags(e.members.Tags(field))}
+					args = append(args, crdt.EncodeCounter(e.counter(field).Snapshot())...)
+					m.emit(crdt.OpHDel, key, args...)
+				}
+			}
+		}
+	case crdtSortSet:
+		sortSet := temp.(*datastructure.SortSet)
+		var changed [][]byte
+		sortSet.ForEach(func(member string, score float64) bool {
+			if e != nil && e.members.Has(member) {
+				if old, err := strconv.ParseFloat(string(e.value(member).Value), 64); err == nil && old == score {
+					return true
+				}
+			}
+			changed = append(changed, []byte(member), []byte(strconv.FormatFloat(score, 'g', -1, 64)))
+			return true
+		})
+		for i := 0; i < len(changed); i += 2 {
+			m.emit(crdt.OpZAdd, key, changed[i], changed[i+1])
+		}
+		if e != nil {
+			for _, member := range e.members.Members() {
+				if _, ok := sortSet.GetScore(member); !ok {
+					m.emit(crdt.OpZRem, key, []byte(member), crdt.EncodeTags(e.members.Tags(member)))
+				}
+			}
+		}
+	}
+
+	e = m.peekEntry(key)
+	if e == nil {
+		return nil
+	}
+	if deadline == 0 {
+		m.emitClearTTL(key)
+	} else if old, _ := strconv.ParseInt(string(e.ttl.Value), 10, 64); !e.ttl.Exist() || old != deadline {
+		m.emit(crdt.OpExpire, key, []byte(strconv.FormatInt(deadline, 10)))
+	}
+	return nil
+}
+
+// entry returns the CRDT state of key for an op of the given kind.
+// A key holding another kind is replaced if it is empty or if the op is older than the key,
+// so that all replicas keep the kind of the earliest write. Otherwise, nil is returned and the op is dropped.
+func (m *MemDb) entry(key string, kind crdtKind, ts crdt.Timestamp) *crdtEntry {
+	temp, ok := m.aa.meta.Get(key)
+	if !ok {
+		e := newCrdtEntry(kind, ts)
+		m.aa.meta.Set(key, e)
+		return e
+	}
+	e := temp.(*crdtEntry)
+	if e.kind == kind || kind == crdtNone {
+		return e
+	}
+	if e.kind == crdtNone {
+		e.kind = kind
+		e.created = ts
+		return e
+	}
+	if e.visible() && ts.Compare(e.created) > 0 {
+		return nil
+	}
+	ne := newCrdtEntry(kind, ts)
+	ne.ttl = e.ttl
+	m.aa.meta.Set(key, ne)
+	m.db.Delete(key)
+	return ne
+}
+
+// peekEntry returns the CRDT state of key without creating it
+func (m *MemDb) peekEntry(key string) *crdtEntry {
+	temp, ok := m.aa.meta.Get(key)
+	if !ok {
+		return nil
+	}
+	return temp.(*crdtEntry)
+}
+
+func opKind(op *crdt.Op) crdtKind {
+	switch op.Type {
+	case crdt.OpSet, crdt.OpDel:
+		return crdtString
+	case crdt.OpCounter:
+		if len(op.Args) > 0 && len(op.Args[0]) > 0 {
+			return crdtHash
+		}
+		return crdtString
+	case crdt.OpSAdd, crdt.OpSRem:
+		return crdtSet
+	case crdt.OpHSet, crdt.OpHDel:
+		return crdtHash
+	case crdt.OpZAdd, crdt.OpZRem:
+		return crdtSortSet
+	}
+	return crdtNone
+}
+
+var errInvalidOp = errors.New("ERR invalid crdt op")
+
+// applyOp merges op into the CRDT state of its key and materializes the result into db.
+// The caller must hold the write lock of op.Key.
+func (m *MemDb) applyOp(op *crdt.Op) error {
+	if op.Type == crdt.OpExpire && len(op.Args) != 1 {
+		return errInvalidOp
+	}
+	e := m.entry(op.Key, opKind(op), op.TS)
+	if e == nil {
+		return nil
+	}
+	field := ""
+	switch op.Type {
+	case crdt.OpSet:
+		if len(op.Args) < 1 {
+			return errInvalidOp
+		}
+		observed, err := crdt.DecodeCounter(op.Args[1:])
+		if err != nil {
+			return errInvalidOp
+		}
+		e.reg.Set(op.Args[0], op.TS)
+		e.cnt.Reset(observed)
+	case crdt.OpDel:
+		observed, err := crdt.DecodeCounter(op.Args)
+		if err != nil {
+			return errInvalidOp
+		}
+		e.reg.Delete(op.TS)
+		e.cnt.Reset(observed)
+	case crdt.OpCounter:
+		if len(op.Args) != 4 {
+			return errInvalidOp
+		}
+		entries, err := crdt.DecodeCounter(op.Args[1:])
+		if err != nil {
+			return errInvalidOp
+		}
+		field = string(op.Args[0])
+		if field == "" {
+			e.cnt.Merge(entries[0])
+		} else {
+			e.members.Add(field, op.Tag())
+			e.counter(field).Merge(entries[0])
+		}
+	case crdt.OpExpire:
+		deadline, err := strconv.ParseInt(string(op.Args[0]), 10, 64)
+		if err != nil {
+			return errInvalidOp
+		}
+		if deadline == 0 {
+			e.ttl.Delete(op.TS)
+		} else {
+			e.ttl.Set(op.Args[0], op.TS)
+		}
+	case crdt.OpSAdd, crdt.OpZAdd:
+		if (op.Type == crdt.OpSAdd && len(op.Args) != 1) || (op.Type == crdt.OpZAdd && len(op.Args) != 2) {
+			return errInvalidOp
+		}
+		field = string(op.Args[0])
+		e.members.Add(field, op.Tag())
+		if op.Type == crdt.OpZAdd {
+			if _, err := strconv.ParseFloat(string(op.Args[1]), 64); err != nil {
+				return errInvalidOp
+			}
+			e.value(field).Set(op.Args[1], op.TS)
+		}
+	case crdt.OpSRem, crdt.OpZRem:
+		if len(op.Args) != 2 {
+			return errInvalidOp
+		}
+		field = string(op.Args[0])
+		e.members.Remove(field, crdt.DecodeTags(op.Args[1]), m.aa.received)
+	case crdt.OpHSet:
+		if len(op.Args) < 2 {
+			return errInvalidOp
+		}
+		observed, err := crdt.DecodeCounter(op.Args[2:])
+		if err != nil {
+			return errInvalidOp
+		}
+		field = string(op.Args[0])
+		e.members.Add(field, op.Tag())
+		e.value(field).Set(op.Args[1], op.TS)
+		e.counter(field).Reset(observed)
+	case crdt.OpHDel:
+		if len(op.Args) < 2 {
+			return errInvalidOp
+		}
+		observed, err := crdt.DecodeCounter(op.Args[2:])
+		if err != nil {
+			return errInvalidOp
+		}
+		field = string(op.Args[0])
+		e.members.Remove(field, crdt.DecodeTags(op.Args[1]), m.aa.received)
+		e.value(field).Delete(op.TS)
+		e.counter(field).Reset(observed)
+	default:
+		return errInvalidOp
+	}
+	m.materialize(op.Key, e, field)
+	return nil
+}
+
+// materialize writes the CRDT state of key into db, field is the member changed by the last op.
+func (m *MemDb) materialize(key string, e *crdtEntry, field string) {
+	if !e.visible() {
+		m.db.Delete(key)
+		m.DelTTL(key)
+		return
+	}
+	switch e.kind {
+	case crdtString:
+		m.db.Set(key, counterValue(&e.reg, e.cnt))
+	case crdtSet:
+		temp, ok := m.db.Get(key)
+		if !ok {
+			temp = datastructure.NewSet()
+			m.db.Set(key, temp)
+		}
+		set := temp.(*datastructure.Set)
+		if e.members.Has(field) {
+			set.Add(field)
+		} else {
+			set.Remove(field)
+		}
+	case crdtHash:
+		temp, ok := m.db.Get(key)
+		if !ok {
+			temp = datastructure.NewHash()
+			m.db.Set(key, temp)
+		}
+		hash := temp.(*datastructure.Hash)
+		if e.hasField(field) {
+			hash.Set(field, counterValue(e.value(field), e.counter(field)))
+		} else {
+			hash.Del(field)
+		}
+	case crdtSortSet:
+		temp, ok := m.db.Get(key)
+		if !ok {
+			temp = datastructure.NewDefaultSortSet()
+			m.db.Set(key, temp)
+		}
+		sortSet := temp.(*datastructure.SortSet)
+		if e.members.Has(field) {
+			score, _ := strconv.ParseFloat(string(e.value(field).Value), 64)
+			sortSet.Add(&datastructure.StItem{F: score, K: field})
+			m.signalKey(key)
+		} else {
+			sortSet.Remove(field)
+		}
+	}
+
+	// sync ttl
+	if !e.ttl.Exist() {
+		if _, ok := m.ttlKeys.Get(key); ok {
+			m.DelTTL(key)
+		}
+		return
+	}
+	deadline, _ := strconv.ParseInt(string(e.ttl.Value), 10, 64)
+	if old, ok := m.ttlKeys.Get(key); !ok || old.(int64) != deadline {
+		m.ttlKeys.Set(key, deadline)
+		interval := time.Duration(deadline-time.Now().Unix()) * time.Second
+		if interval < 0 {
+			interval = 0
+		}
+		root := m.root()
+		m.delay.Add(interval, key, func() {
+			root.CheckTTL(key)
+		})
+	}
+}
+
+// emit stamps a write of the local replica, applies it and ships it to all peers.
+// The caller must hold the write lock of key.
+func (m *MemDb) emit(typ, key string, args ...[]byte) {
+	m.aa.emitMu.Lock()
+	defer m.aa.emitMu.Unlock()
+	op := &crdt.Op{
+		Type: typ,
+		Key:  key,
+		TS:   m.aa.clock.Now(),
+		Args: args,
+	}
+	if err := m.applyOp(op); err != nil {
+		logger.Error("crdt: apply local op error: ", err.Error())
+		return
+	}
+	m.aa.seen.Observe(op.TS)
+	for _, peer := range m.aa.peers {
+		peer.Send(op)
+	}
+}
+
+// emitClearTTL removes the ttl of key on all replicas if it has one
+func (m *MemDb) emitClearTTL(key string) {
+	if e := m.peekEntry(key); e != nil && e.ttl.Exist() {
+		m.emit(crdt.OpExpire, key, []byte("0"))
+	}
+}
+
+// emitDelete removes all the observed state of key
+func (m *MemDb) emitDelete(key string) int {
+	e := m.peekEntry(key)
+	if e == nil || !e.visible() {
+		return 0
+	}
+	m.emitClearTTL(key)
+	switch e.kind {
+	case crdtString:
+		m.emit(crdt.OpDel, key, crdt.EncodeCounter(e.cnt.Snapshot())...)
+	case crdtSet:
+		for _, member := range e.members.Members() {
+			m.emit(crdt.OpSRem, key, []byte(member), crdt.EncodeTags(e.members.Tags(member)))
+		}
+	case crdtHash:
+		for _, field := range e.members.Members() {
+			args := [][]byte{[]byte(field), crdt.EncodeTags(e.members.Tags(field))}
+			args = append(args, crdt.EncodeCounter(e.counter(field).Snapshot())...)
+			m.emit(crdt.OpHDel, key, args...)
+		}
+	case crdtSortSet:
+		for _, member := range e.members.Members() {
+			m.emit(crdt.OpZRem, key, []byte(member), crdt.EncodeTags(e.members.Tags(member)))
+		}
+	}
+	return 1
+}
+
+// checkKind returns false if key holds a value of another kind in db
+func (m *MemDb) checkKind(key string, kind crdtKind) bool {
+	temp, ok := m.db.Get(key)
+	if !ok {
+		return true
+	}
+	switch temp.(type) {
+	case []byte:
+		return kind == crdtString
+	case *datastructure.Set:
+		return kind == crdtSet
+	case *datastructure.Hash:
+		return kind == crdtHash
+	case *datastructure.SortSet:
+		return kind == crdtSortSet
+	}
+	return false
+}
+
+// crdtHello marks the connection running it as the one of a peer, see crdt.HelloCommand
+func crdtHello(m *MemDb, cmd [][]byte) resp.RedisData {
+	if m.aa == nil {
+		return resp.NewErrorData("ERR active-active mode is disabled")
+	}
+	if len(cmd) != 2 || len(cmd[1]) == 0 {
+		return resp.NewErrorData("ERR wrong number of arguments for 'crdt.hello' command")
+	}
+	if m.client == nil {
+		return resp.NewErrorData("ERR crdt.hello can only be called by a client connection")
+	}
+	if string(cmd[1]) == m.aa.node {
+		return resp.NewErrorData("ERR crdt.hello from the local node")
+	}
+	m.client.Peer = string(cmd[1])
+	return resp.NewStringData("OK")
+}
+
+func crdtMerge(m *MemDb, cmd [][]byte) resp.RedisData {
+	if m.aa == nil {
+		return resp.NewErrorData("ERR active-active mode is disabled")
+	}
+	if m.client == nil || m.client.Peer == "" {
+		return resp.NewErrorData("ERR crdt.merge is only accepted from a peer connection, send crdt.hello first")
+	}
+	// the ops after a failed one are rejected until the peer reconnects and resends them,
+	// so that seen never skips the failed op
+	op, err := crdt.ParseOp(cmd)
+	if err != nil {
+		m.client.Peer = ""
+		return resp.NewErrorData("ERR " + err.Error())
+	}
+	if op.TS.Node != m.client.Peer {
+		peer := m.client.Peer
+		m.client.Peer = ""
+		return resp.NewErrorData(fmt.Sprintf("ERR crdt.merge of an op of node %s from peer %s", op.TS.Node, peer))
+	}
+	if m.aa.seen.Covers(op.TS) {
+		// resent after a lost reply
+		return resp.NewStringData("OK")
+	}
+	m.aa.clock.Update(op.TS)
+
+	m.locks.Lock(op.Key)
+	defer m.locks.Unlock(op.Key)
+	if err = m.applyOp(op); err != nil {
+		logger.Error("crdt: apply remote op error: ", err.Error())
+		m.client.Peer = ""
+		return resp.NewErrorData(err.Error())
+	}
+	m.aa.seen.Observe(op.TS)
+	return resp.NewStringData("OK")
+}
+
+func crdtSetString(m *MemDb, cmd [][]byte) resp.RedisData {
+	cmdName := strings.ToLower(string(cmd[0]))
+	var key string
+	var val []byte
+	var ttl int64
+	var err error
+	switch cmdName {
+	case "set":
+		// the other options are replicated like the other write commands
+		if len(cmd) != 3 && (len(cmd) != 5 || strings.ToLower(string(cmd[3])) != "ex") {
+			return nil
+		}
+		key, val = string(cmd[1]), cmd[2]
+		if len(cmd) == 5 {
+			ttl, err = strconv.ParseInt(string(cmd[4]), 10, 64)
+		}
+	case "setex":
+		if len(cmd) != 4 {
+			return resp.NewErrorData("wrong number of arguments for 'setex' command")
+		}
+		key, val = string(cmd[1]), cmd[3]
+		ttl, err = strconv.ParseInt(string(cmd[2]), 10, 64)
+	default:
+		logger.Error("crdtSetString function: cmdName is not set or setex")
+		return resp.NewErrorData("server error")
+	}
+	if err != nil || (len(cmd) > 3 && ttl <= 0) {
+		return resp.NewErrorData("ERR invalid expire time")
+	}
+	m.CheckTTL(key)
+	m.locks.Lock(key)
+	defer m.locks.Unlock(key)
+	if !m.checkKind(key, crdtString) {
+		if m.emitDelete(key) == 0 {
+			return resp.NewErrorData("WRONGTYPE Operation against a key holding the wrong kind of value")
+		}
+	}
+	args := [][]byte{val}
+	if e := m.peekEntry(key); e != nil {
+		args = append(args, crdt.EncodeCounter(e.cnt.Snapshot())...)
+	}
+	m.emit(crdt.OpSet, key, args...)
+	if ttl > 0 {
+		m.emit(crdt.OpExpire, key, []byte(strconv.FormatInt(time.Now().Unix()+ttl, 10)))
+	} else {
+		m.emitClearTTL(key)
+	}
+	return resp.NewStringData("OK")
+}
+
+func crdtMSetString(m *MemDb, cmd [][]byte) resp.RedisData {
+	if len(cmd) < 3 || len(cmd)&1 != 1 {
+		return resp.NewErrorData("wrong number of arguments for 'mset' command")
+	}
+	keys := make([]string, 0, len(cmd)/2)
+	for i := 1; i < len(cmd); i += 2 {
+		keys = append(keys, string(cmd[i]))
+	}
+	m.locks.LockMulti(keys)
+	defer m.locks.UnlockMulti(keys)
+	for i, key := range keys {
+		if !m.checkKind(key, crdtString) {
+			m.emitDelete(key)
+		}
+		args := [][]byte{cmd[2*i+2]}
+		if e := m.peekEntry(key); e != nil {
+			args = append(args, crdt.EncodeCounter(e.cnt.Snapshot())...)
+		}
+		m.emit(crdt.OpSet, key, args...)
+		m.emitClearTTL(key)
+	}
+	return resp.NewStringData("OK")
+}
+
+func crdtIncrString(m *MemDb, cmd [][]byte) resp.RedisData {
+	cmdName := strings.ToLower(string(cmd[0]))
+	var delta int64
+	var err error
+	switch cmdName {
+	case "incr", "decr":
+		if len(cmd) != 2 {
+			return resp.NewErrorData(fmt.Sprintf("wrong number of arguments for '%s' command", cmdName))
+		}
+		delta = 1
+	case "incrby", "decrby":
+		if len(cmd) != 3 {
+			return resp.NewErrorData(fmt.Sprintf("wrong number of arguments for '%s' command", cmdName))
+		}
+		delta, err = strconv.ParseInt(string(cmd[2]), 10, 64)
+		if err != nil {
+			return resp.NewErrorData("ERR value is not an integer or out of range")
+		}
+	default:
+		logger.Error("crdtIncrString function: cmdName is not incr, incrby, decr or decrby")
+		return resp.NewErrorData("server error")
+	}
+	if cmdName == "decr" || cmdName == "decrby" {
+		delta = -delta
+	}
+
+	key := string(cmd[1])
+	m.CheckTTL(key)
+	m.locks.Lock(key)
+	defer m.locks.Unlock(key)
+	temp, ok := m.db.Get(key)
+	if ok {
+		val, isStr := temp.([]byte)
+		if !isStr {
+			return resp.NewErrorData("WRONGTYPE Operation against a key holding the wrong kind of value")
+		}
+		if _, err = strconv.ParseInt(string(val), 10, 64); err != nil {
+			return resp.NewErrorData("ERR value is not an integer or out of range")
+		}
+	}
+	cnt := crdt.NewPNCounter()
+	if e := m.peekEntry(key); e != nil && e.kind == crdtString {
+		cnt = e.cnt
+	}
+	entry := cnt.Incr(m.aa.node, delta)
+	args := append([][]byte{nil}, crdt.EncodeCounter([]crdt.CounterEntry{entry})...)
+	m.emit(crdt.OpCounter, key, args...)
+
+	temp, _ = m.db.Get(key)
+	res, _ := strconv.ParseInt(string(temp.([]byte)), 10, 64)
+	return resp.NewIntData(res)
+}
+
+func crdtDelKey(m *MemDb, cmd [][]byte) resp.RedisData {
+	if len(cmd) < 2 {
+		return resp.NewErrorData("error: ERR wrong number of arguments for 'del' command")
+	}
+	res := 0
+	for _, k := range cmd[1:] {
+		key := string(k)
+		if !m.CheckTTL(key) {
+			continue
+		}
+		m.locks.Lock(key)
+		res += m.emitDelete(key)
+		m.locks.Unlock(key)
+	}
+	return resp.NewIntData(int64(res))
+}
+
+func crdtExpireKey(m *MemDb, cmd [][]byte) resp.RedisData {
+	cmdName := strings.ToLower(string(cmd[0]))
+	if cmdName == "persist" {
+		if len(cmd) != 2 {
+			return resp.NewErrorData("wrong number of arguments for 'persist' command")
+		}
+	} else if len(cmd) < 3 || len(cmd) > 4 {
+		return resp.NewErrorData("wrong number of arguments for 'expire' command")
+	}
+	key := string(cmd[1])
+	if !m.CheckTTL(key) {
+		return resp.NewIntData(0)
+	}
+	m.locks.Lock(key)
+	defer m.locks.Unlock(key)
+	e := m.peekEntry(key)
+	if e == nil || !e.visible() {
+		return resp.NewIntData(0)
+	}
+	if cmdName == "persist" {
+		if !e.ttl.Exist() {
+			return resp.NewIntData(0)
+		}
+		m.emitClearTTL(key)
+		return resp.NewIntData(1)
+	}
+
+	ttl, err := strconv.ParseInt(string(cmd[2]), 10, 64)
+	if err != nil {
+		return resp.NewErrorData("ERR value is not an integer or out of range")
+	}
+	deadline := time.Now().Unix() + ttl
+	var old int64
+	if e.ttl.Exist() {
+		old, _ = strconv.ParseInt(string(e.ttl.Value), 10, 64)
+	}
+	if len(cmd) == 4 {
+		opt := strings.ToLower(string(cmd[3]))
+		switch opt {
+		case "nx":
+			if e.ttl.Exist() {
+				return resp.NewIntData(0)
+			}
+		case "xx":
+			if !e.ttl.Exist() {
+				return resp.NewIntData(0)
+			}
+		case "gt":
+			if !e.ttl.Exist() || deadline <= old {
+				return resp.NewIntData(0)
+			}
+		case "lt":
+			if e.ttl.Exist() && deadline >= old {
+				return resp.NewIntData(0)
+			}
+		default:
+			return resp.NewErrorData(fmt.Sprintf("error: unsupport %s, except nx, xx, gt, lt", opt))
+		}
+	}
+	if ttl <= 0 {
+		m.emitDelete(key)
+		return resp.NewIntData(1)
+	}
+	m.emit(crdt.OpExpire, key, []byte(strconv.FormatInt(deadline, 10)))
+	return resp.NewIntData(1)
+}
+
+func crdtSAddSet(m *MemDb, cmd [][]byte) resp.RedisData {
+	cmdName := strings.ToLower(string(cmd[0]))
+	if len(cmd) < 3 {
+		return resp.NewErrorData(fmt.Sprintf("wrong number of arguments for '%s' command", cmdName))
+	}
+	key := string(cmd[1])
+	m.CheckTTL(key)
+	m.locks.Lock(key)
+	defer m.locks.Unlock(key)
+	if !m.checkKind(key, crdtSet) {
+		return resp.NewErrorData("WRONGTYPE Operation against a key holding the wrong kind of value")
+	}
+	res := 0
+	for _, member := range cmd[2:] {
+		e := m.peekEntry(key)
+		visible := e != nil && e.kind == crdtSet && e.members.Has(string(member))
+		if cmdName == "sadd" {
+			if !visible {
+				res++
+			}
+			m.emit(crdt.OpSAdd, key, member)
+		} else if visible {
+			res++
+			m.emit(crdt.OpSRem, key, member, crdt.EncodeTags(e.members.Tags(string(member))))
+		}
+	}
+	return resp.NewIntData(int64(res))
+}
+
+func crdtHSetHash(m *MemDb, cmd [][]byte) resp.RedisData {
+	if len(cmd) < 4 || len(cmd)&1 == 1 {
+		return resp.NewErrorData("wrong number of arguments for 'hset' command")
+	}
+	key := string(cmd[1])
+	m.CheckTTL(key)
+	m.locks.Lock(key)
+	defer m.locks.Unlock(key)
+	if !m.checkKind(key, crdtHash) {
+		return resp.NewErrorData("WRONGTYPE Operation against a key holding the wrong kind of value")
+	}
+	for i := 2; i < len(cmd); i += 2 {
+		args := [][]byte{cmd[i], cmd[i+1]}
+		if e := m.peekEntry(key); e != nil && e.kind == crdtHash {
+			args = append(args, crdt.EncodeCounter(e.counter(string(cmd[i])).Snapshot())...)
+		}
+		m.emit(crdt.OpHSet, key, args...)
+	}
+	return resp.NewStringData("OK")
+}
+
+func crdtHDelHash(m *MemDb, cmd [][]byte) resp.RedisData {
+	if len(cmd) < 3 {
+		return resp.NewErrorData("wrong number of arguments for 'hdel' command")
+	}
+	key := string(cmd[1])
+	m.CheckTTL(key)
+	m.locks.Lock(key)
+	defer m.locks.Unlock(key)
+	if !m.checkKind(key, crdtHash) {
+		return resp.NewErrorData("WRONGTYPE Operation against a key holding the wrong kind of value")
+	}
+	res := 0
+	for _, field := range cmd[2:] {
+		e := m.peekEntry(key)
+		if e == nil || e.kind != crdtHash || !e.members.Has(string(field)) {
+			continue
+		}
+		if e.hasField(string(field)) {
+			res++
+		}
+		args := [][]byte{field, crdt.EncodeTags(e.members.Tags(string(field)))}
+		args = append(args, crdt.EncodeCounter(e.counter(string(field)).Snapshot())...)
+		m.emit(crdt.OpHDel, key, args...)
+	}
+	return resp.NewIntData(int64(res))
+}
+
+func crdtHIncrByHash(m *MemDb, cmd [][]byte) resp.RedisData {
+	if len(cmd) != 4 {
+		return resp.NewErrorData("wrong number of arguments for 'hincrby' command")
+	}
+	incr, err := strconv.ParseInt(string(cmd[3]), 10, 64)
+	if err != nil {
+		return resp.NewErrorData("incr value must be an integer")
+	}
+	key, field := string(cmd[1]), string(cmd[2])
+	m.CheckTTL(key)
+	m.locks.Lock(key)
+	defer m.locks.Unlock(key)
+	temp, ok := m.db.Get(key)
+	if ok {
+		hash, isHash := temp.(*datastructure.Hash)
+		if !isHash {
+			return resp.NewErrorData("WRONGTYPE Operation against a key holding the wrong kind of value")
+		}
+		if hash.Exist(field) {
+			if _, err = strconv.ParseInt(string(hash.Get(field)), 10, 64); err != nil {
+				return resp.NewErrorData("value is not an integer")
+			}
+		}
+	}
+	cnt := crdt.NewPNCounter()
+	if e := m.peekEntry(key); e != nil && e.kind == crdtHash {
+		cnt = e.counter(field)
+	}
+	entry := cnt.Incr(m.aa.node, incr)
+	args := append([][]byte{cmd[2]}, crdt.EncodeCounter([]crdt.CounterEntry{entry})...)
+	m.emit(crdt.OpCounter, key, args...)
+
+	temp, _ = m.db.Get(key)
+	res, _ := strconv.ParseInt(string(temp.(*datastructure.Hash).Get(field)), 10, 64)
+	return resp.NewIntData(res)
+}
+
+func crdtZAdd(m *MemDb, cmd [][]byte) resp.RedisData {
+	// ZADD with options is replicated like the other write commands
+	if len(cmd) < 4 || len(cmd)&1 == 1 {
+		return nil
+	}
+	for i := 2; i < len(cmd); i += 2 {
+		if _, err := strconv.ParseFloat(string(cmd[i]), 64); err != nil {
+			return nil
+		}
+	}
+	key := string(cmd[1])
+	m.CheckTTL(key)
+	m.locks.Lock(key)
+	defer m.locks.Unlock(key)
+	if !m.checkKind(key, crdtSortSet) {
+		return resp.NewErrorData("WRONGTYPE Operation against a key holding the wrong kind of value")
+	}
+	res := 0
+	for i := 2; i < len(cmd); i += 2 {
+		e := m.peekEntry(key)
+		if e == nil || e.kind != crdtSortSet || !e.members.Has(string(cmd[i+1])) {
+			res++
+		}
+		m.emit(crdt.OpZAdd, key, cmd[i+1], cmd[i])
+	}
+	return resp.NewIntData(int64(res))
+}
+
+func crdtZRem(m *MemDb, cmd [][]byte) resp.RedisData {
+	if len(cmd) < 3 {
+		return resp.NewErrorData("error: commands is invalid")
+	}
+	key := string(cmd[1])
+	m.CheckTTL(key)
+	m.locks.Lock(key)
+	defer m.locks.Unlock(key)
+	if !m.checkKind(key, crdtSortSet) {
+		return resp.NewErrorData("WRONGTYPE Operation against a key holding the wrong kind of value")
+	}
+	res := 0
+	for _, member := range cmd[2:] {
+		e := m.peekEntry(key)
+		if e == nil || e.kind != crdtSortSet || !e.members.Has(string(member)) {
+			continue
+		}
+		m.emit(crdt.OpZRem, key, member, crdt.EncodeTags(e.members.Tags(string(member))))
+		res++
+	}
+	return resp.NewIntData(int64(res))
+}
+
+func RegisterCrdtCommands() {
+	RegisterCommand(crdt.HelloCommand, crdtHello, flagReadOnly, flagNoScript, flagClient)
+	RegisterCommand(crdt.MergeCommand, crdtMerge, flagNoScript, flagClient)
+	crdtCmdTable[crdt.MergeCommand] = crdtMerge
+	crdtCmdTable["set"] = crdtSetString
+	crdtCmdTable["setex"] = crdtSetString
+	crdtCmdTable["mset"] = crdtMSetString
+	crdtCmdTable["incr"] = crdtIncrString
+	crdtCmdTable["incrby"] = crdtIncrString
+	crdtCmdTable["decr"] = crdtIncrString
+	crdtCmdTable["decrby"] = crdtIncrString
+	crdtCmdTable["del"] = crdtDelKey
+	crdtCmdTable["expire"] = crdtExpireKey
+	crdtCmdTable["persist"] = crdtExpireKey
+	crdtCmdTable["sadd"] = crdtSAddSet
+	crdtCmdTable["srem"] = crdtSAddSet
+	crdtCmdTable["hset"] = crdtHSetHash
+	crdtCmdTable["hdel"] = crdtHDelHash
+	crdtCmdTable["hincrby"] = crdtHIncrByHash
+	crdtCmdTable["zadd"] = crdtZAdd
+	crdtCmdTable["zrem"] = crdtZRem
+}
@@ -0,0 +1,379 @@
+package memdb
+
+import (
+	"easyRedis/crdt"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// bufferPeer keeps the ops sent to a replica until flush is called,
+// they are merged by client, the peer connection of the sender on target
+type bufferPeer struct {
+	target  *MemDb
+	client  *Client
+	pending []*crdt.Op
+}
+
+func (p *bufferPeer) Send(op *crdt.Op) {
+	p.pending = append(p.pending, op)
+}
+
+func (p *bufferPeer) Close() {}
+
+func (p *bufferPeer) flush(t *testing.T) {
+	for _, op := range p.pending {
+		res := p.target.ExecClientCommand(p.client, op.ToCommand())
+		if string(res.ToBytes()) != "+OK\r\n" {
+			t.Errorf("merge %v reply %s", op, res.ToBytes())
+		}
+	}
+	p.pending = nil
+}
+
+// newReplicas returns two linked replicas, ab ships the ops of a to b and ba the ops of b to a
+func newReplicas() (a, b *MemDb, ab, ba *bufferPeer) {
+	a, b = newTestDb(), newTestDb()
+	ab = &bufferPeer{target: b, client: &Client{Addr: "a", Peer: "a"}}
+	ba = &bufferPeer{target: a, client: &Client{Addr: "b", Peer: "b"}}
+	a.EnableActiveActive("a", ab)
+	b.EnableActiveActive("b", ba)
+	return
+}
+
+func sortedMembers(m *MemDb, key string) string {
+	res := strings.Split(strings.TrimSpace(exec(m, "smembers", key)), "\r\n")
+	sort.Strings(res)
+	return strings.Join(res, ",")
+}
+
+func TestCrdtString(t *testing.T) {
+	a, b, ab, ba := newReplicas()
+
+	exec(a, "set", "k", "1")
+	exec(b, "set", "k", "2")
+	ab.flush(t)
+	ba.flush(t)
+	ra, rb := exec(a, "get", "k"), exec(b, "get", "k")
+	if ra != rb || ra != "$1\r\n2\r\n" {
+		t.Errorf("last write should win, get %q and %q", ra, rb)
+	}
+
+	// concurrent increments are all kept
+	exec(a, "incrby", "k", "10")
+	exec(b, "incr", "k")
+	exec(b, "decrby", "k", "3")
+	ab.flush(t)
+	ba.flush(t)
+	ra, rb = exec(a, "get", "k"), exec(b, "get", "k")
+	if ra != rb || ra != "$2\r\n10\r\n" {
+		t.Errorf("counter diverged, get %q and %q", ra, rb)
+	}
+
+	// delete on a concurrent with increment on b keeps the increment only
+	exec(a, "del", "k")
+	exec(b, "incr", "k")
+	ab.flush(t)
+	ba.flush(t)
+	ra, rb = exec(a, "get", "k"), exec(b, "get", "k")
+	if ra != rb || ra != "$1\r\n1\r\n" {
+		t.Errorf("delete should only cancel observed increments, get %q and %q", ra, rb)
+	}
+
+	// ops are idempotent
+	exec(a, "set", "x", "v")
+	ops := ab.pending
+	ab.flush(t)
+	ab.pending = ops
+	ab.flush(t)
+	if res := exec(b, "get", "x"); res != "$1\r\nv\r\n" {
+		t.Errorf("get x %q", res)
+	}
+
+}
+
+func TestCrdtSet(t *testing.T) {
+	a, b, ab, ba := newReplicas()
+
+	exec(a, "sadd", "s", "x", "y")
+	ab.flush(t)
+
+	// remove on a concurrent with add on b, add wins
+	exec(a, "srem", "s", "x", "y")
+	exec(b, "sadd", "s", "x")
+	ab.flush(t)
+	ba.flush(t)
+	if ma, mb := sortedMembers(a, "s"), sortedMembers(b, "s"); ma != mb || ma != "$1,*1,x" {
+		t.Errorf("set diverged: %q and %q", ma, mb)
+	}
+	if res := exec(a, "sismember", "s", "y"); res != ":0\r\n" {
+		t.Errorf("y should be removed, sismember reply %q", res)
+	}
+}
+
+// tombstones are kept only for the adds that didn't arrive yet
+func TestCrdtTombstones(t *testing.T) {
+	a, b, ab, ba := newReplicas()
+
+	for i := 0; i < 10; i++ {
+		exec(a, "sadd", "s", "x", "y")
+		exec(a, "srem", "s", "x")
+		ab.flush(t)
+		exec(b, "srem", "s", "y")
+		ba.flush(t)
+	}
+	for _, m := range []*MemDb{a, b} {
+		if e := m.peekEntry("s"); e == nil || e.members.Tombstones() != 0 {
+			t.Errorf("tombstones left after removes")
+		}
+	}
+
+	// c removes the member added by a before the add reaches b
+	exec(a, "sadd", "s", "z")
+	add := ab.pending[0]
+	remove := &crdt.Op{
+		Type: crdt.OpSRem,
+		Key:  "s",
+		TS:   crdt.Timestamp{Wall: add.TS.Wall + 1, Node: "c"},
+		Args: [][]byte{[]byte("z"), crdt.EncodeTags([]string{add.Tag()})},
+	}
+	c := &Client{Addr: "c", Peer: "c"}
+	if res := b.ExecClientCommand(c, remove.ToCommand()); string(res.ToBytes()) != "+OK\r\n" {
+		t.Errorf("merge remove reply %q", res.ToBytes())
+	}
+	if b.peekEntry("s").members.Tombstones() != 1 {
+		t.Error("the remove of an add in flight should keep a tombstone")
+	}
+	ab.flush(t)
+	if res := exec(b, "sismember", "s", "z"); res != ":0\r\n" {
+		t.Errorf("late add of a removed member, sismember %q", res)
+	}
+	if b.peekEntry("s").members.Tombstones() != 0 {
+		t.Error("tombstone kept after the add arrived")
+	}
+}
+
+func TestCrdtHashAndSortSet(t *testing.T) {
+	a, b, ab, ba := newReplicas()
+
+	exec(a, "hset", "h", "f", "1", "g", "a")
+	exec(b, "hincrby", "h", "f", "5")
+	exec(b, "hset", "h", "g", "b")
+	ab.flush(t)
+	ba.flush(t)
+	for _, m := range []*MemDb{a, b} {
+		if res := exec(m, "hget", "h", "f"); res != "$1\r\n6\r\n" {
+			t.Errorf("hget h f %q, expect 6", res)
+		}
+		if res := exec(m, "hget", "h", "g"); res != "$1\r\nb\r\n" {
+			t.Errorf("hget h g %q, expect b", res)
+		}
+	}
+
+	exec(a, "hdel", "h", "f", "g")
+	ab.flush(t)
+	if res := exec(b, "exists", "h"); res != ":0\r\n" {
+		t.Errorf("hash should be deleted, exists reply %q", res)
+	}
+
+	exec(a, "zadd", "z", "1", "m")
+	exec(b, "zadd", "z", "2", "m", "3", "n")
+	ab.flush(t)
+	ba.flush(t)
+	exec(b, "zrem", "z", "n")
+	ba.flush(t)
+	for _, m := range []*MemDb{a, b} {
+		if res := exec(m, "zscore", "z", "m"); res != ":2\r\n" {
+			t.Errorf("zscore z m %q, expect 2", res)
+		}
+		if res := exec(m, "zcard", "z"); res != ":1\r\n" {
+			t.Errorf("zcard z %q, expect 1", res)
+		}
+	}
+}
+
+func TestCrdtTypeConflict(t *testing.T) {
+	a, b, ab, ba := newReplicas()
+
+	exec(a, "set", "k", "v")
+	exec(b, "sadd", "k", "m")
+	ab.flush(t)
+	ba.flush(t)
+	ra, rb := exec(a, "type", "k"), exec(b, "type", "k")
+	if ra != rb || ra != "+string\r\n" {
+		t.Errorf("earliest type should win, type %q and %q", ra, rb)
+	}
+}
+
+// only a connection marked by crdt.hello merges ops, and only the ops of its node
+func TestCrdtMergeFromPeer(t *testing.T) {
+	a, b, ab, _ := newReplicas()
+
+	exec(a, "set", "k", "v")
+	op := ab.pending[0].ToCommand()
+	if res := b.ExecCommand(op); !strings.HasPrefix(string(res.ToBytes()), "-ERR crdt.merge is only accepted from a peer") {
+		t.Errorf("merge without a client reply %q", res.ToBytes())
+	}
+	client := NewClient("127.0.0.1:1")
+	if res := b.ExecClientCommand(client, op); !strings.HasPrefix(string(res.ToBytes()), "-ERR crdt.merge is only accepted from a peer") {
+		t.Errorf("merge from a plain client reply %q", res.ToBytes())
+	}
+	if res := b.ExecClientCommand(client, [][]byte{[]byte("crdt.hello"), []byte("b")}); !strings.HasPrefix(string(res.ToBytes()), "-ERR") {
+		t.Errorf("hello with the local node id reply %q", res.ToBytes())
+	}
+	if res := b.ExecClientCommand(client, [][]byte{[]byte("crdt.hello"), []byte("c")}); string(res.ToBytes()) != "+OK\r\n" {
+		t.Errorf("hello reply %q", res.ToBytes())
+	}
+	if res := b.ExecClientCommand(client, op); !strings.HasPrefix(string(res.ToBytes()), "-ERR crdt.merge of an op of node a") {
+		t.Errorf("merge of the op of another node reply %q", res.ToBytes())
+	}
+	if res := exec(b, "exists", "k"); res != ":0\r\n" {
+		t.Errorf("rejected op applied, exists k %q", res)
+	}
+	if res := exec(b, "eval", "return redis.call('crdt.merge', 'set', 'k', 'a', '1', '0', 'v')", "0"); !strings.HasPrefix(res, "-") {
+		t.Errorf("merge from a script reply %q", res)
+	}
+	ab.flush(t)
+	if res := exec(b, "get", "k"); res != "$1\r\nv\r\n" {
+		t.Errorf("get k %q, expect v", res)
+	}
+}
+
+// SET and SETEX overwrite a key of any type like redis
+func TestCrdtSetOverwritesType(t *testing.T) {
+	a, b, ab, _ := newReplicas()
+
+	exec(a, "sadd", "s", "m")
+	exec(a, "hset", "h", "f", "v")
+	if res := exec(a, "setex", "s", "100", "v"); res != "+OK\r\n" {
+		t.Errorf("setex over a set reply %q", res)
+	}
+	if res := exec(a, "set", "h", "v"); res != "+OK\r\n" {
+		t.Errorf("set over a hash reply %q", res)
+	}
+	ab.flush(t)
+	for _, m := range []*MemDb{a, b} {
+		if res := exec(m, "type", "s"); res != "+string\r\n" {
+			t.Errorf("type s %q, expect string", res)
+		}
+		if res := exec(m, "ttl", "s"); res != ":100\r\n" && res != ":99\r\n" {
+			t.Errorf("ttl s %q, expect 100", res)
+		}
+		if res := exec(m, "get", "h"); res != "$1\r\nv\r\n" {
+			t.Errorf("get h %q, expect v", res)
+		}
+	}
+}
+
+func TestCrdtExpire(t *testing.T) {
+	a, b, ab, ba := newReplicas()
+
+	exec(a, "set", "k", "v")
+	ab.flush(t)
+	exec(b, "expire", "k", "100")
+	ba.flush(t)
+	if res := exec(a, "ttl", "k"); res != ":100\r\n" && res != ":99\r\n" {
+		t.Errorf("ttl k %q, expect 100", res)
+	}
+	exec(a, "persist", "k")
+	ab.flush(t)
+	if res := exec(b, "ttl", "k"); res != ":-1\r\n" {
+		t.Errorf("ttl k %q, expect -1", res)
+	}
+}
+
+func TestCrdtOtherWriteCommands(t *testing.T) {
+	a, b, ab, ba := newReplicas()
+
+	exec(a, "set", "s", "x")
+	exec(a, "append", "s", "y")
+	exec(a, "setnx", "n", "1")
+	exec(a, "hset", "h", "f", "1")
+	exec(a, "hsetnx", "h", "g", "2")
+	exec(a, "sadd", "s1", "a", "b")
+	exec(a, "sadd", "s2", "b", "c")
+	exec(a, "sinterstore", "s3", "s1", "s2")
+	exec(a, "zadd", "z", "nx", "1", "m")
+	exec(a, "set", "n2", "2", "get")
+	exec(a, "psetex", "e", "100000", "v")
+	exec(a, "eval", "redis.call('set', KEYS[1], 'lua') redis.call('hincrby', KEYS[2], 'f', 1)", "2", "lk", "h")
+	ab.flush(t)
+	for _, m := range []*MemDb{a, b} {
+		if res := exec(m, "get", "s"); res != "$2\r\nxy\r\n" {
+			t.Errorf("get s %q, expect xy", res)
+		}
+		if res := exec(m, "get", "n"); res != "$1\r\n1\r\n" {
+			t.Errorf("get n %q, expect 1", res)
+		}
+		if res := exec(m, "hget", "h", "g"); res != "$1\r\n2\r\n" {
+			t.Errorf("hget h g %q, expect 2", res)
+		}
+		if res := sortedMembers(m, "s3"); res != "$1,*1,b" {
+			t.Errorf("members of s3 %q, expect b", res)
+		}
+		if res := exec(m, "get", "lk"); res != "$3\r\nlua\r\n" {
+			t.Errorf("get lk %q, expect lua", res)
+		}
+		if res := exec(m, "hget", "h", "f"); res != "$1\r\n2\r\n" {
+			t.Errorf("hget h f %q, expect 2", res)
+		}
+		if res := exec(m, "get", "n2"); res != "$1\r\n2\r\n" {
+			t.Errorf("get n2 %q, expect 2", res)
+		}
+		if res := exec(m, "zscore", "z", "m"); res != ":1\r\n" {
+			t.Errorf("zscore z m %q, expect 1", res)
+		}
+		if res := exec(m, "ttl", "e"); res != ":100\r\n" && res != ":99\r\n" {
+			t.Errorf("ttl e %q, expect 100", res)
+		}
+	}
+
+	// rename replaces the set on b by a string
+	exec(b, "rename", "s", "s1")
+	exec(b, "srem", "s2", "c")
+	ba.flush(t)
+	for _, m := range []*MemDb{a, b} {
+		if res := exec(m, "get", "s1"); res != "$2\r\nxy\r\n" {
+			t.Errorf("get s1 %q, expect xy", res)
+		}
+		if res := exec(m, "exists", "s"); res != ":0\r\n" {
+			t.Errorf("exists s %q, expect 0", res)
+		}
+		if res := sortedMembers(m, "s2"); res != "$1,*1,b" {
+			t.Errorf("members of s2 %q, expect b", res)
+		}
+	}
+}
+
+// TestCrdtUnsupported shows the limits of the active-active mode: lists and the expiration of hash fields
+// are not replicated, so the commands making them are rejected, and blocking commands are rejected too
+func TestCrdtUnsupported(t *testing.T) {
+	a, b, ab, _ := newReplicas()
+
+	for _, cmd := range [][]string{
+		{"lpush", "l", "x"},
+		{"hexpire", "h", "10", "fields", "1", "f"},
+		{"bzpopmin", "z", "1"},
+	} {
+		want := "-ERR command '" + cmd[0] + "' is not supported in active-active mode\r\n"
+		if res := exec(a, cmd...); res != want {
+			t.Errorf("%s reply %q", cmd[0], res)
+		}
+	}
+	if res := exec(a, "exists", "l"); res != ":0\r\n" {
+		t.Errorf("rejected lpush must not write, exists reply %q", res)
+	}
+
+	// a list made by another command is deleted
+	exec(a, "sadd", "s", "1", "2")
+	exec(a, "sadd", "dst", "x")
+	if res := exec(a, "sort", "s", "store", "dst"); res != "-ERR the value of 'dst' can't be replicated in active-active mode\r\n" {
+		t.Errorf("sort store reply %q", res)
+	}
+	ab.flush(t)
+	for _, m := range []*MemDb{a, b} {
+		if res := exec(m, "exists", "dst"); res != ":0\r\n" {
+			t.Errorf("exists dst %q, expect 0", res)
+		}
+	}
+}
@@ -11,26 +11,74 @@ import (
 )
 
 // MemDb is the memory cache database
-// All key:value pairs are stored in db
+// All key:value pairs are stored in db, with the access stats of the keys
 // All ttl keys are stored in ttlKeys
+// The hashes having fields with an expire time are marked in fieldTTLKeys, so that CheckTTL skips the other keys,
+// a stale mark is removed by CheckTTL
 // locks is used to lock a key for db to ensure some atomic operations
+// aa is not nil if the active-active mode is enabled
+// scripts holds the loaded lua scripts and functions holds the function libraries
+// origin is the database a script context is made from, it is nil for the database itself
+// watchers wakes the clients blocked on keys
+// slowlog keeps the commands slower than slowlog-log-slower-than
+// client is the client running the command in the context of a command flagged flagClient, nil otherwise
 type MemDb struct {
-	db      *datastructure.ConcurrentMap
-	ttlKeys *datastructure.ConcurrentMap
-	locks   *datastructure.Locks
-	delay   *timewheel.Delay
+	db           *keyspace
+	ttlKeys      *datastructure.ConcurrentMap
+	fieldTTLKeys *datastructure.ConcurrentMap
+	locks        keyLocks
+	delay        *timewheel.Delay
+	aa           *activeActive
+	scripts      *scriptEngine
+	functions    *functionRegistry
+	origin       *MemDb
+	watchers     *keyWatchers
+	slowlog      *slowLog
+	client       *Client
+}
+
+// keyLocks locks the keys of a MemDb, it is implemented by datastructure.Locks and by the private locks of scripts
+type keyLocks interface {
+	Lock(key string)
+	Unlock(key string)
+	RLock(key string)
+	RUnlock(key string)
+	LockMulti(keys []string)
+	UnlockMulti(keys []string)
+	RLockMulti(keys []string)
+	RUnlockMulti(keys []string)
 }
 
 func NewMemDb() *MemDb {
 	return &MemDb{
-		db:      datastructure.NewConcurrentMap(config.Configures.ShardNum),
-		ttlKeys: datastructure.NewConcurrentMap(config.Configures.ShardNum),
-		locks:   datastructure.NewLocks(config.Configures.ShardNum * 2),
-		delay:   timewheel.NewDelay(),
+		db:           newKeyspace(),
+		ttlKeys:      datastructure.NewConcurrentMap(config.Configures.ShardNum),
+		fieldTTLKeys: datastructure.NewConcurrentMap(config.Configures.ShardNum),
+		locks:        datastructure.NewLocks(config.Configures.ShardNum * 2),
+		delay:        timewheel.NewDelay(),
+		scripts:      newScriptEngine(),
+		functions:    newFunctionRegistry(),
+		watchers:     newKeyWatchers(),
+		slowlog:      newSlowLog(config.Configures.SlowlogLogSlowerThan, config.Configures.SlowlogMaxLen),
+	}
+}
+
+// root returns the database that owns the locks of keys
+func (m *MemDb) root() *MemDb {
+	if m.origin != nil {
+		return m.origin
 	}
+	return m
 }
 
+// ExecCommand executes a command that doesn't come from a client connection
 func (m *MemDb) ExecCommand(cmd [][]byte) resp.RedisData {
+	return m.ExecClientCommand(nil, cmd)
+}
+
+// ExecClientCommand executes a command of client, and keeps it in the slow log if it is slow.
+// The commands called by scripts are not kept, the script command is.
+func (m *MemDb) ExecClientCommand(client *Client, cmd [][]byte) resp.RedisData {
 	if len(cmd) == 0 {
 		return nil
 	}
@@ -39,32 +87,66 @@ func (m *MemDb) ExecCommand(cmd [][]byte) resp.RedisData {
 	cmdName := strings.ToLower(string(cmd[0]))
 	command, ok := CmdTable[cmdName]
 	if !ok {
-		res = resp.NewErrorData("error: unsupported command")
+		return resp.NewErrorData("error: unsupported command")
+	}
+	start := time.Now()
+	ctx := m
+	if command.needsClient() {
+		ctx = m.clientContext(client)
+	}
+	if !command.noTouch() {
+		ctx = ctx.touchContext()
+	}
+	if m.aa != nil && !command.readOnly() {
+		res = ctx.execActiveActive(cmdName, command, cmd)
 	} else {
-		execFun := command.executor
-		res = execFun(m, cmd)
+		res = command.executor(ctx, cmd)
+	}
+	if !command.noTouch() {
+		ctx.db.touchAccessed()
+	}
+	if m.origin == nil && !command.blocking() {
+		m.slowlog.log(start, time.Since(start), cmd, client)
 	}
 	return res
 }
 
+// clientContext returns a copy of m running the commands of client
+func (m *MemDb) clientContext(client *Client) *MemDb {
+	ctx := *m
+	ctx.client = client
+	return &ctx
+}
+
+// touchContext returns a copy of m keeping the keys accessed by a command, so that they are touched once it returns
+func (m *MemDb) touchContext() *MemDb {
+	ctx := *m
+	ctx.db = m.db.tracking()
+	return &ctx
+}
+
 // CheckTTL check ttl keys and delete expired keys
 // return false if key is expired, else true.
 // Attention: Don't lock this function because it has called locks.Lock(key) for atomic deleting expired key.
 // Otherwise, it will cause a deadlock.
+// The expired fields of a hash are deleted too.
 func (m *MemDb) CheckTTL(key string) bool {
 	ttl, ok := m.ttlKeys.Get(key)
 	if !ok {
-		return true
+		return m.expireHashFields(key)
 	}
 	ttlTime := ttl.(int64)
 	now := time.Now().Unix()
 	if ttlTime > now {
-		return true
+		return m.expireHashFields(key)
 	}
 	m.locks.Lock(key)
 	defer m.locks.Unlock(key)
 	m.db.Delete(key)
 	m.ttlKeys.Delete(key)
+	if m.aa != nil {
+		m.aa.meta.Delete(key)
+	}
 	return false
 }
 
@@ -76,10 +158,28 @@ func (m *MemDb) SetTTL(key string, val int64) int {
 
 	m.ttlKeys.Set(key, val+time.Now().Unix())
 	interval := time.Duration(val) * time.Second
+	m.scheduleTTL(key, interval)
+	return 1
+}
+
+// SetTTLAt sets the expire time of key to deadline, a unix time in seconds
+func (m *MemDb) SetTTLAt(key string, deadline int64) int {
+	if _, ok := m.db.Get(key); !ok {
+		logger.Debug("SetTTLAt: key not exists")
+		return 0
+	}
+
+	m.ttlKeys.Set(key, deadline)
+	m.scheduleTTL(key, time.Duration(deadline-time.Now().Unix())*time.Second)
+	return 1
+}
+
+// scheduleTTL adds the timewheel task that deletes key when it expires
+func (m *MemDb) scheduleTTL(key string, interval time.Duration) {
+	root := m.root()
 	m.delay.Add(interval, key, func() {
-		m.CheckTTL(key)
+		root.CheckTTL(key)
 	})
-	return 1
 }
 
 func (m *MemDb) DelTTL(key string) int {
@@ -89,4 +189,9 @@ func (m *MemDb) DelTTL(key string) int {
 
 func (m *MemDb) Stop() {
 	m.delay.Stop()
+	if m.aa != nil {
+		for _, peer := range m.aa.peers {
+			peer.Close()
+		}
+	}
 }
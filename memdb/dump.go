@@ -0,0 +1,659 @@
+package memdb
+
+import (
+	"easyRedis/datastructure"
+	"easyRedis/logger"
+	"easyRedis/resp"
+	"encoding/binary"
+	"errors"
+	"hash/crc64"
+	"math"
+	"math/bits"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dump.go implements DUMP and RESTORE.
+//
+// The payload has the same layout as redis: the value serialized like in a rdb file
+// (a type byte and the encoded value), the rdb version as 2 bytes little endian and
+// the crc64 (jones) of everything before as 8 bytes little endian.
+// Values are written with the plain rdb encodings of rdb version 9, so redis 5 and later
+// can restore them. Payloads of newer versions are accepted as long as they only use these encodings.
+// A hash with expiring fields is written with the hash metadata type of rdb version 12 (redis 7.4),
+// which keeps the deadlines of the fields.
+
+const (
+	rdbTypeString = 0
+	rdbTypeList   = 1
+	rdbTypeSet    = 2
+	rdbTypeZSet   = 3
+	rdbTypeHash   = 4
+	rdbTypeZSet2  = 5
+	// a hash with the deadlines of its fields
+	rdbTypeHashMetadata = 24
+
+	rdbVersion = 9
+	// rdbVersionHashMetadata is the version of the payloads with rdbTypeHashMetadata
+	rdbVersionHashMetadata = 12
+	rdbVersionMax          = 12
+
+	// length encodings, the two most significant bits of the first byte
+	rdb6BitLen  = 0
+	rdb14BitLen = 1
+	rdb32BitLen = 0x80
+	rdb64BitLen = 0x81
+	rdbEncVal   = 3
+
+	// special string encodings, the lower 6 bits when the type is rdbEncVal
+	rdbEncInt8  = 0
+	rdbEncInt16 = 1
+	rdbEncInt32 = 2
+	rdbEncLZF   = 3
+)
+
+var (
+	// redis uses the jones polynomial with no initial or final xor
+	rdbCrc64Table = crc64.MakeTable(bits.Reverse64(0xad93d23594c935a9))
+
+	errDumpPayload = errors.New("ERR DUMP payload version or checksum are wrong")
+	errBadData     = errors.New("ERR Bad data format")
+)
+
+func rdbChecksum(data []byte) uint64 {
+	return ^crc64.Update(^uint64(0), rdbCrc64Table, data)
+}
+
+func rdbAppendLen(buf []byte, l uint64) []byte {
+	switch {
+	case l < 1<<6:
+		return append(buf, byte(l))
+	case l < 1<<14:
+		return append(buf, byte(rdb14BitLen<<6|l>>8), byte(l))
+	case l <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(buf, rdb32BitLen), uint32(l))
+	}
+	return binary.BigEndian.AppendUint64(append(buf, rdb64BitLen), l)
+}
+
+// rdbAppendString writes s as an integer when it is the canonical form of a 32 bits integer, like redis
+func rdbAppendString(buf []byte, s []byte) []byte {
+	if len(s) > 0 && len(s) <= 11 {
+		if v, err := strconv.ParseInt(string(s), 10, 32); err == nil && strconv.FormatInt(v, 10) == string(s) {
+			switch {
+			case v >= math.MinInt8 && v <= math.MaxInt8:
+				return append(buf, rdbEncVal<<6|rdbEncInt8, byte(v))
+			case v >= math.MinInt16 && v <= math.MaxInt16:
+				return binary.LittleEndian.AppendUint16(append(buf, rdbEncVal<<6|rdbEncInt16), uint16(v))
+			default:
+				return binary.LittleEndian.AppendUint32(append(buf, rdbEncVal<<6|rdbEncInt32), uint32(v))
+			}
+		}
+	}
+	buf = rdbAppendLen(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+// dumpValue serializes a db value, false if the type can't be dumped
+func dumpValue(val any) ([]byte, bool) {
+	var buf []byte
+	version := uint16(rdbVersion)
+	switch v := val.(type) {
+	case []byte:
+		buf = rdbAppendString(append(buf, rdbTypeString), v)
+	case *datastructure.List:
+		buf = rdbAppendLen(append(buf, rdbTypeList), uint64(v.Len))
+		v.ForEach(func(_ int, val []byte) bool {
+			buf = rdbAppendString(buf, val)
+			return true
+		})
+	case *datastructure.Set:
+		members := v.Member()
+		buf = rdbAppendLen(append(buf, rdbTypeSet), uint64(len(members)))
+		for _, member := range members {
+			buf = rdbAppendString(buf, []byte(member))
+		}
+	case *datastructure.Hash:
+		minExpire, withExpires := v.NextExpire()
+		if !withExpires {
+			buf = rdbAppendLen(append(buf, rdbTypeHash), uint64(v.Len()))
+			v.ForEach(func(field string, value []byte) bool {
+				buf = rdbAppendString(buf, []byte(field))
+				buf = rdbAppendString(buf, value)
+				return true
+			})
+			break
+		}
+		// the earliest deadline, then each field is preceded by its deadline relative to it plus one, 0 if none
+		version = rdbVersionHashMetadata
+		buf = binary.LittleEndian.AppendUint64(append(buf, rdbTypeHashMetadata), uint64(minExpire))
+		buf = rdbAppendLen(buf, uint64(v.Len()))
+		v.ForEach(func(field string, value []byte) bool {
+			var ttl uint64
+			if deadline, ok := v.Expire(field); ok {
+				ttl = uint64(deadline-minExpire) + 1
+			}
+			buf = rdbAppendLen(buf, ttl)
+			buf = rdbAppendString(buf, []byte(field))
+			buf = rdbAppendString(buf, value)
+			return true
+		})
+	case *datastructure.SortSet:
+		scores := v.GetAllKeysAndScores()
+		buf = rdbAppendLen(append(buf, rdbTypeZSet2), uint64(len(scores)))
+		for member, score := range scores {
+			buf = rdbAppendString(buf, []byte(member))
+			buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(score))
+		}
+	default:
+		return nil, false
+	}
+	buf = binary.LittleEndian.AppendUint16(buf, version)
+	return binary.LittleEndian.AppendUint64(buf, rdbChecksum(buf)), true
+}
+
+// rdbReader decodes a serialized value
+type rdbReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *rdbReader) readByte() (byte, error) {
+	if r.pos >= len(r.data) {
+		return 0, errBadData
+	}
+	r.pos++
+	return r.data[r.pos-1], nil
+}
+
+func (r *rdbReader) readBytes(n uint64) ([]byte, error) {
+	if n > uint64(len(r.data)-r.pos) {
+		return nil, errBadData
+	}
+	res := r.data[r.pos : r.pos+int(n)]
+	r.pos += int(n)
+	return res, nil
+}
+
+// readLen returns a length, or the special encoding of a string when encoded is true
+func (r *rdbReader) readLen() (l uint64, encoded bool, err error) {
+	b, err := r.readByte()
+	if err != nil {
+		return 0, false, err
+	}
+	switch {
+	case b>>6 == rdb6BitLen:
+		return uint64(b & 0x3f), false, nil
+	case b>>6 == rdb14BitLen:
+		next, err := r.readByte()
+		return uint64(b&0x3f)<<8 | uint64(next), false, err
+	case b>>6 == rdbEncVal:
+		return uint64(b & 0x3f), true, nil
+	case b == rdb32BitLen:
+		buf, err := r.readBytes(4)
+		if err != nil {
+			return 0, false, err
+		}
+		return uint64(binary.BigEndian.Uint32(buf)), false, nil
+	case b == rdb64BitLen:
+		buf, err := r.readBytes(8)
+		if err != nil {
+			return 0, false, err
+		}
+		return binary.BigEndian.Uint64(buf), false, nil
+	}
+	return 0, false, errBadData
+}
+
+// readCount reads the number of elements of an aggregate value
+func (r *rdbReader) readCount() (int, error) {
+	l, encoded, err := r.readLen()
+	if err != nil {
+		return 0, err
+	}
+	// every element takes one byte at least
+	if encoded || l > uint64(len(r.data)-r.pos) {
+		return 0, errBadData
+	}
+	return int(l), nil
+}
+
+func (r *rdbReader) readString() ([]byte, error) {
+	l, encoded, err := r.readLen()
+	if err != nil {
+		return nil, err
+	}
+	if !encoded {
+		buf, err := r.readBytes(l)
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte(nil), buf...), nil
+	}
+	switch l {
+	case rdbEncInt8:
+		b, err := r.readByte()
+		return []byte(strconv.FormatInt(int64(int8(b)), 10)), err
+	case rdbEncInt16:
+		buf, err := r.readBytes(2)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(strconv.FormatInt(int64(int16(binary.LittleEndian.Uint16(buf))), 10)), nil
+	case rdbEncInt32:
+		buf, err := r.readBytes(4)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(strconv.FormatInt(int64(int32(binary.LittleEndian.Uint32(buf))), 10)), nil
+	case rdbEncLZF:
+		cLen, encoded1, err := r.readLen()
+		if err != nil {
+			return nil, err
+		}
+		uLen, encoded2, err := r.readLen()
+		if err != nil || encoded1 || encoded2 {
+			return nil, errBadData
+		}
+		compressed, err := r.readBytes(cLen)
+		if err != nil {
+			return nil, err
+		}
+		return lzfDecompress(compressed, uLen)
+	}
+	return nil, errBadData
+}
+
+// readDouble reads a score of the old zset encoding, a length byte and the score as a string
+func (r *rdbReader) readDouble() (float64, error) {
+	l, err := r.readByte()
+	if err != nil {
+		return 0, err
+	}
+	switch l {
+	case 253:
+		return math.NaN(), nil
+	case 254:
+		return math.Inf(1), nil
+	case 255:
+		return math.Inf(-1), nil
+	}
+	buf, err := r.readBytes(uint64(l))
+	if err != nil {
+		return 0, err
+	}
+	f, err := strconv.ParseFloat(string(buf), 64)
+	if err != nil {
+		return 0, errBadData
+	}
+	return f, nil
+}
+
+func (r *rdbReader) readBinaryDouble() (float64, error) {
+	buf, err := r.readBytes(8)
+	if err != nil {
+		return 0, err
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(buf)), nil
+}
+
+// lzfDecompress decompresses the lzf data of a compressed rdb string
+func lzfDecompress(in []byte, uLen uint64) ([]byte, error) {
+	// a back reference of 3 bytes expands to 264 bytes at most
+	if uLen > uint64(len(in))*88 {
+		return nil, errBadData
+	}
+	out := make([]byte, 0, uLen)
+	for ip := 0; ip < len(in); {
+		ctrl := int(in[ip])
+		ip++
+		if ctrl < 1<<5 {
+			// literal run of ctrl+1 bytes
+			if ip+ctrl+1 > len(in) {
+				return nil, errBadData
+			}
+			out = append(out, in[ip:ip+ctrl+1]...)
+			ip += ctrl + 1
+			continue
+		}
+		// back reference
+		length := ctrl >> 5
+		ref := len(out) - (ctrl&0x1f)<<8 - 1
+		if length == 7 {
+			if ip >= len(in) {
+				return nil, errBadData
+			}
+			length += int(in[ip])
+			ip++
+		}
+		if ip >= len(in) {
+			return nil, errBadData
+		}
+		ref -= int(in[ip])
+		ip++
+		length += 2
+		if ref < 0 {
+			return nil, errBadData
+		}
+		for ; length > 0; length-- {
+			out = append(out, out[ref])
+			ref++
+		}
+	}
+	if uint64(len(out)) != uLen {
+		return nil, errBadData
+	}
+	return out, nil
+}
+
+// verifyDumpPayload checks the version and the checksum, it returns the serialized value
+func verifyDumpPayload(payload []byte) ([]byte, error) {
+	if len(payload) < 10 {
+		return nil, errDumpPayload
+	}
+	footer := payload[len(payload)-10:]
+	if binary.LittleEndian.Uint16(footer) > rdbVersionMax {
+		return nil, errDumpPayload
+	}
+	if rdbChecksum(payload[:len(payload)-8]) != binary.LittleEndian.Uint64(footer[2:]) {
+		return nil, errDumpPayload
+	}
+	return payload[:len(payload)-10], nil
+}
+
+// restoreValue decodes a value serialized by dumpValue
+func restoreValue(data []byte) (any, error) {
+	r := &rdbReader{data: data}
+	valType, err := r.readByte()
+	if err != nil {
+		return nil, err
+	}
+	var val any
+	switch valType {
+	case rdbTypeString:
+		val, err = r.readString()
+	case rdbTypeList:
+		val, err = r.readList()
+	case rdbTypeSet:
+		val, err = r.readSet()
+	case rdbTypeHash:
+		val, err = r.readHash()
+	case rdbTypeHashMetadata:
+		val, err = r.readHashMetadata()
+	case rdbTypeZSet, rdbTypeZSet2:
+		val, err = r.readZSet(valType == rdbTypeZSet2)
+	default:
+		return nil, errBadData
+	}
+	if err != nil {
+		return nil, err
+	}
+	if r.pos != len(data) {
+		return nil, errBadData
+	}
+	return val, nil
+}
+
+func (r *rdbReader) readList() (*datastructure.List, error) {
+	n, err := r.readCount()
+	if err != nil {
+		return nil, err
+	}
+	list := datastructure.NewList()
+	for i := 0; i < n; i++ {
+		elem, err := r.readString()
+		if err != nil {
+			return nil, err
+		}
+		list.RPush(elem)
+	}
+	return list, nil
+}
+
+func (r *rdbReader) readSet() (*datastructure.Set, error) {
+	n, err := r.readCount()
+	if err != nil {
+		return nil, err
+	}
+	set := datastructure.NewSet()
+	for i := 0; i < n; i++ {
+		member, err := r.readString()
+		if err != nil {
+			return nil, err
+		}
+		set.Add(string(member))
+	}
+	return set, nil
+}
+
+func (r *rdbReader) readHash() (*datastructure.Hash, error) {
+	n, err := r.readCount()
+	if err != nil {
+		return nil, err
+	}
+	hash := datastructure.NewHash()
+	for i := 0; i < n; i++ {
+		field, err := r.readString()
+		if err != nil {
+			return nil, err
+		}
+		value, err := r.readString()
+		if err != nil {
+			return nil, err
+		}
+		hash.Set(string(field), value)
+	}
+	return hash, nil
+}
+
+// readHashMetadata reads a hash with the deadlines of its fields, the expired fields are kept
+// for the caller to delete
+func (r *rdbReader) readHashMetadata() (*datastructure.Hash, error) {
+	buf, err := r.readBytes(8)
+	if err != nil {
+		return nil, err
+	}
+	minExpire := int64(binary.LittleEndian.Uint64(buf))
+	n, err := r.readCount()
+	if err != nil {
+		return nil, err
+	}
+	hash := datastructure.NewHash()
+	for i := 0; i < n; i++ {
+		ttl, encoded, err := r.readLen()
+		if err != nil || encoded {
+			return nil, errBadData
+		}
+		field, err := r.readString()
+		if err != nil {
+			return nil, err
+		}
+		value, err := r.readString()
+		if err != nil {
+			return nil, err
+		}
+		hash.Set(string(field), value)
+		if ttl > 0 {
+			deadline := minExpire + int64(ttl) - 1
+			if deadline < minExpire || deadline > maxFieldDeadline {
+				return nil, errBadData
+			}
+			hash.SetExpire(string(field), deadline)
+		}
+	}
+	return hash, nil
+}
+
+func (r *rdbReader) readZSet(binaryScore bool) (*datastructure.SortSet, error) {
+	n, err := r.readCount()
+	if err != nil {
+		return nil, err
+	}
+	sortSet := datastructure.NewDefaultSortSet()
+	for i := 0; i < n; i++ {
+		member, err := r.readString()
+		if err != nil {
+			return nil, err
+		}
+		var score float64
+		if binaryScore {
+			score, err = r.readBinaryDouble()
+		} else {
+			score, err = r.readDouble()
+		}
+		if err != nil {
+			return nil, err
+		}
+		if math.IsNaN(score) {
+			return nil, errBadData
+		}
+		sortSet.Add(&datastructure.StItem{F: score, K: string(member)})
+	}
+	return sortSet, nil
+}
+
+func dumpKey(m *MemDb, cmd [][]byte) resp.RedisData {
+	cmdName := strings.ToLower(string(cmd[0]))
+	if cmdName != "dump" || len(cmd) != 2 {
+		logger.Error("dumpKey Function: cmdName is not dump or command args number is invalid")
+		return resp.NewErrorData("error: cmdName is not dump or command args number is invalid")
+	}
+	key := string(cmd[1])
+	if !m.CheckTTL(key) {
+		return resp.NewBulkData(nil)
+	}
+	m.locks.RLock(key)
+	defer m.locks.RUnlock(key)
+
+	val, ok := m.db.Get(key)
+	if !ok {
+		return resp.NewBulkData(nil)
+	}
+	payload, ok := dumpValue(val)
+	if !ok {
+		logger.Error("dumpKey Function: unknown value type of key %s", key)
+		return resp.NewErrorData("server error")
+	}
+	return resp.NewBulkData(payload)
+}
+
+func restoreKey(m *MemDb, cmd [][]byte) resp.RedisData {
+	cmdName := strings.ToLower(string(cmd[0]))
+	if cmdName != "restore" || len(cmd) < 4 {
+		logger.Error("restoreKey Function: cmdName is not restore or command args number is invalid")
+		return resp.NewErrorData("error: cmdName is not restore or command args number is invalid")
+	}
+	ttl, err := strconv.ParseInt(string(cmd[2]), 10, 64)
+	if err != nil {
+		return resp.NewErrorData("ERR value is not an integer or out of range")
+	}
+	if ttl < 0 {
+		return resp.NewErrorData("ERR Invalid TTL value, must be >= 0")
+	}
+	var replace, absTTL bool
+	// the access stats of the restored key, freq is -1 if not given
+	var idle time.Duration
+	freq := -1
+	for i := 4; i < len(cmd); i++ {
+		switch strings.ToLower(string(cmd[i])) {
+		case "replace":
+			replace = true
+		case "absttl":
+			absTTL = true
+		// IDLETIME and FREQ set the stats reported by OBJECT IDLETIME and OBJECT FREQ
+		case "idletime":
+			if i+1 >= len(cmd) {
+				return resp.NewErrorData("ERR syntax error")
+			}
+			secs, err := strconv.ParseInt(string(cmd[i+1]), 10, 64)
+			if err != nil {
+				return resp.NewErrorData("ERR value is not an integer or out of range")
+			}
+			if secs < 0 {
+				return resp.NewErrorData("ERR Invalid IDLETIME value, must be >= 0")
+			}
+			// longer idle times can't be represented, they are older than any unix time anyway
+			if secs > math.MaxInt64/int64(time.Second) {
+				secs = math.MaxInt64 / int64(time.Second)
+			}
+			idle = time.Duration(secs) * time.Second
+			i++
+		case "freq":
+			if i+1 >= len(cmd) {
+				return resp.NewErrorData("ERR syntax error")
+			}
+			n, err := strconv.ParseInt(string(cmd[i+1]), 10, 64)
+			if err != nil {
+				return resp.NewErrorData("ERR value is not an integer or out of range")
+			}
+			if n < 0 || n > lfuMaxVal {
+				return resp.NewErrorData("ERR Invalid FREQ value, must be >= 0 and <= 255")
+			}
+			freq = int(n)
+			i++
+		default:
+			return resp.NewErrorData("ERR syntax error")
+		}
+	}
+
+	data, err := verifyDumpPayload(cmd[3])
+	if err != nil {
+		return resp.NewErrorData(err.Error())
+	}
+	val, err := restoreValue(data)
+	if err != nil {
+		return resp.NewErrorData(err.Error())
+	}
+
+	key := string(cmd[1])
+	m.CheckTTL(key)
+	m.locks.Lock(key)
+	defer m.locks.Unlock(key)
+	// peeked, touching the replaced key would touch the restored one once the command returns
+	if _, ok := m.db.Peek(key); ok && !replace {
+		return resp.NewErrorData("BUSYKEY Target key name already exists.")
+	}
+
+	// the ttl is in milliseconds, ttls are kept in seconds so it is rounded up
+	var now int64
+	if !absTTL {
+		now = time.Now().UnixMilli()
+	}
+	// a deadline past the int64 milliseconds would wrap around and delete the key
+	if ttl > math.MaxInt64-999-now {
+		return resp.NewErrorData("ERR Invalid TTL value")
+	}
+	deadline := ttl + now
+	m.db.Delete(key)
+	m.DelTTL(key)
+	if ttl > 0 && deadline <= time.Now().UnixMilli() {
+		// already expired, the key is not created
+		return resp.NewStringData("OK")
+	}
+	if hash, ok := val.(*datastructure.Hash); ok {
+		// the fields expired since the dump are not restored
+		if hash.DelExpired(time.Now().UnixMilli()); hash.IsEmpty() {
+			return resp.NewStringData("OK")
+		}
+	}
+	m.db.Set(key, val)
+	if stats := m.db.stats(key); stats != nil && (idle > 0 || freq >= 0) {
+		stats.restore(idle, freq)
+	}
+	if ttl > 0 {
+		m.SetTTLAt(key, (deadline+999)/1000)
+	}
+	if hash, ok := val.(*datastructure.Hash); ok {
+		m.scheduleHashFields(key, hash)
+	}
+	if _, ok := val.(*datastructure.SortSet); ok {
+		m.signalKey(key)
+	}
+	return resp.NewStringData("OK")
+}
+
+func RegisterDumpCommands() {
+	RegisterCommand("dump", dumpKey, flagReadOnly)
+	RegisterCommand("restore", restoreKey)
+}
@@ -0,0 +1,162 @@
+package memdb
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+	"time"
+)
+
+// dumpOf returns the payload of key
+func dumpOf(t *testing.T, m *MemDb, key string) string {
+	res := exec(m, "dump", key)
+	if !strings.HasPrefix(res, "$") {
+		t.Fatalf("dump %s reply %q", key, res)
+	}
+	payload := res[strings.Index(res, "\r\n")+2 : len(res)-2]
+	return payload
+}
+
+func TestDumpRedisCompatible(t *testing.T) {
+	mem := newTestDb()
+	exec(mem, "set", "mykey", "10")
+	// the payload redis returns for the same value
+	want := "\x00\xc0\n\t\x00\xbem\x06\x89Z(\x00\n"
+	if payload := dumpOf(t, mem, "mykey"); payload != want {
+		t.Errorf("dump payload %q", payload)
+	}
+	if res := exec(mem, "dump", "nokey"); res != "$-1\r\n" {
+		t.Errorf("dump of a missing key reply %q", res)
+	}
+}
+
+func TestDumpRestore(t *testing.T) {
+	mem := newTestDb()
+	exec(mem, "set", "s", strings.Repeat("x", 100))
+	exec(mem, "set", "n", "-70000")
+	exec(mem, "hset", "h", "f1", "v1", "f2", "2")
+	exec(mem, "sadd", "set", "a", "b", "c")
+	exec(mem, "zadd", "z", "1.5", "a", "-2", "b")
+
+	for _, key := range []string{"s", "n", "h", "set", "z"} {
+		payload := dumpOf(t, mem, key)
+		if res := exec(mem, "restore", key+"2", "0", payload); res != "+OK\r\n" {
+			t.Fatalf("restore %s reply %q", key, res)
+		}
+	}
+	if res := exec(mem, "get", "s2"); res != "$100\r\n"+strings.Repeat("x", 100)+"\r\n" {
+		t.Errorf("restored string %q", res)
+	}
+	if res := exec(mem, "get", "n2"); res != "$6\r\n-70000\r\n" {
+		t.Errorf("restored integer string %q", res)
+	}
+	if res := exec(mem, "hget", "h2", "f2"); res != "$1\r\n2\r\n" {
+		t.Errorf("restored hash %q", res)
+	}
+	if res := exec(mem, "scard", "set2"); res != ":3\r\n" {
+		t.Errorf("restored set %q", res)
+	}
+	if res := exec(mem, "zscore", "z2", "b"); res != ":-2\r\n" {
+		t.Errorf("restored sorted set %q", res)
+	}
+
+	payload := dumpOf(t, mem, "s")
+	if res := exec(mem, "restore", "s2", "0", payload); res != "-BUSYKEY Target key name already exists.\r\n" {
+		t.Errorf("restore on an existing key reply %q", res)
+	}
+	if res := exec(mem, "restore", "s2", "5000", payload, "replace", "idletime", "10"); res != "+OK\r\n" {
+		t.Errorf("restore replace reply %q", res)
+	}
+	if ttl, ok := mem.ttlKeys.Get("s2"); !ok || ttl.(int64)-time.Now().Unix() < 4 || ttl.(int64)-time.Now().Unix() > 6 {
+		t.Error("restore ttl error")
+	}
+	// an absolute ttl in the past doesn't create the key
+	if res := exec(mem, "restore", "s3", "1000", payload, "absttl"); res != "+OK\r\n" {
+		t.Errorf("restore absttl reply %q", res)
+	}
+	if res := exec(mem, "exists", "s3"); res != ":0\r\n" {
+		t.Errorf("expired restored key exists %q", res)
+	}
+	// a relative ttl that overflows the deadline is rejected and keeps the existing key
+	if res := exec(mem, "restore", "s2", "9223372036854775000", payload, "replace"); res != "-ERR Invalid TTL value\r\n" {
+		t.Errorf("restore with an overflowing ttl reply %q", res)
+	}
+	if res := exec(mem, "exists", "s2"); res != ":1\r\n" {
+		t.Errorf("key deleted by an overflowing ttl %q", res)
+	}
+	if res := exec(mem, "restore", "s3", "0", payload, "freq", "300"); !strings.HasPrefix(res, "-ERR Invalid FREQ value") {
+		t.Errorf("restore invalid freq reply %q", res)
+	}
+}
+
+func TestRestoreInvalidPayload(t *testing.T) {
+	mem := newTestDb()
+	exec(mem, "set", "s", "value")
+	payload := []byte(dumpOf(t, mem, "s"))
+
+	corrupted := append([]byte(nil), payload...)
+	corrupted[2] ^= 0xff
+	if res := exec(mem, "restore", "k", "0", string(corrupted)); res != "-ERR DUMP payload version or checksum are wrong\r\n" {
+		t.Errorf("restore with a wrong checksum reply %q", res)
+	}
+
+	// a newer version with a valid checksum is rejected too
+	body := append([]byte(nil), payload[:len(payload)-10]...)
+	body = binary.LittleEndian.AppendUint16(body, rdbVersionMax+1)
+	body = binary.LittleEndian.AppendUint64(body, rdbChecksum(body))
+	if res := exec(mem, "restore", "k", "0", string(body)); res != "-ERR DUMP payload version or checksum are wrong\r\n" {
+		t.Errorf("restore with a newer version reply %q", res)
+	}
+
+	// an unknown type
+	body = []byte{42, 0}
+	body = binary.LittleEndian.AppendUint16(body, rdbVersion)
+	body = binary.LittleEndian.AppendUint64(body, rdbChecksum(body))
+	if res := exec(mem, "restore", "k", "0", string(body)); res != "-ERR Bad data format\r\n" {
+		t.Errorf("restore of an unknown type reply %q", res)
+	}
+}
+
+func TestRestoreLZFString(t *testing.T) {
+	mem := newTestDb()
+	// "aaaaaaaaaa" compressed: a literal 'a' and a back reference of 9 bytes
+	body := []byte{rdbTypeString, 0xc3, 5, 10, 0x00, 'a', 0xe0, 0x00, 0x00}
+	body = binary.LittleEndian.AppendUint16(body, rdbVersion)
+	body = binary.LittleEndian.AppendUint64(body, rdbChecksum(body))
+	if res := exec(mem, "restore", "k", "0", string(body)); res != "+OK\r\n" {
+		t.Fatalf("restore lzf string reply %q", res)
+	}
+	if res := exec(mem, "get", "k"); res != "$10\r\naaaaaaaaaa\r\n" {
+		t.Errorf("restored lzf string %q", res)
+	}
+}
+
+func TestDumpRestoreHashFieldExpires(t *testing.T) {
+	mem := newTestDb()
+	exec(mem, "hset", "h", "f1", "v1", "f2", "v2", "f3", "v3")
+	exec(mem, "hexpire", "h", "100", "fields", "1", "f1")
+	exec(mem, "hpexpire", "h", "200", "fields", "1", "f2")
+	payload := dumpOf(t, mem, "h")
+	if payload[0] != rdbTypeHashMetadata {
+		t.Fatalf("hash with field expires dumped with type %d", payload[0])
+	}
+	if res := exec(mem, "restore", "h2", "0", payload); res != "+OK\r\n" {
+		t.Fatalf("restore reply %q", res)
+	}
+	if res := exec(mem, "httl", "h2", "fields", "3", "f1", "f2", "f3"); res != "*3\r\n:100\r\n:1\r\n:-1\r\n" {
+		t.Errorf("httl of the restored fields %q", res)
+	}
+	// the restored deadlines are deleted actively like the original ones
+	time.Sleep(300 * time.Millisecond)
+	if res := exec(mem, "hgetall", "h2"); res != "*4\r\n$2\r\nf1\r\n$2\r\nv1\r\n$2\r\nf3\r\n$2\r\nv3\r\n" {
+		t.Errorf("restored hash after the field expired %q", res)
+	}
+
+	// the fields expired since the dump are not restored
+	exec(mem, "hpexpire", "h", "50", "fields", "2", "f1", "f3")
+	payload = dumpOf(t, mem, "h")
+	time.Sleep(100 * time.Millisecond)
+	if res := exec(mem, "restore", "h3", "0", payload); res != "+OK\r\n" || exec(mem, "exists", "h3") != ":0\r\n" {
+		t.Errorf("restore of an expired hash reply %q", res)
+	}
+}
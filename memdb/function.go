@@ -0,0 +1,669 @@
+package memdb
+
+import (
+	"bytes"
+	"context"
+	"easyRedis/logger"
+	"easyRedis/resp"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc64"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// function.go implements redis functions: FUNCTION LOAD/DELETE/LIST/DUMP/RESTORE/FLUSH and FCALL/FCALL_RO.
+// A library is lua code starting with "#!lua name=<library>" that registers functions with redis.register_function.
+// The library code is compiled and run once on load. The lua state that ran it is kept with the callbacks
+// it registered, and FCALL borrows such a state to call the callback atomically like a script.
+// Concurrent calls of a library get more states, which run the library code once when they are created.
+// If a functions file is set, all libraries are saved to it after each change and loaded from it on startup.
+
+const (
+	functionLoadTimeout = 500 * time.Millisecond
+	functionDumpVersion = 1
+	// functionMaxIdleStates is the max number of lua states kept by a library between calls
+	functionMaxIdleStates = 16
+)
+
+var (
+	functionDumpMagic = []byte("EZFN")
+	crc64Table        = crc64.MakeTable(crc64.ECMA)
+)
+
+// function flags
+const (
+	funcFlagNoWrites = 1 << iota
+	funcFlagAllowOOM
+	funcFlagAllowStale
+	funcFlagNoCluster
+	funcFlagAllowCrossSlotKeys
+)
+
+var functionFlagNames = []string{"no-writes", "allow-oom", "allow-stale", "no-cluster", "allow-cross-slot-keys"}
+
+type functionDef struct {
+	name        string
+	description string
+	flags       int
+	lib         *functionLibrary
+}
+
+type functionLibrary struct {
+	name      string
+	code      string
+	proto     *lua.FunctionProto
+	functions map[string]*functionDef
+
+	// idle holds the lua states that ran the library code, not used by a call
+	mu   sync.Mutex
+	idle []*libraryState
+}
+
+// libraryState is a lua state that ran the code of a library, with the callbacks the code registered
+type libraryState struct {
+	L         *lua.LState
+	env       *scriptEnv
+	callbacks map[string]*lua.LFunction
+}
+
+// newLibraryState runs the code of lib in a new lua state. The registered functions are added to
+// functions, which is lib.functions on load and a throwaway map for the other states.
+func newLibraryState(lib *functionLibrary, ctx context.Context, env *scriptEnv, functions map[string]*functionDef) (*libraryState, error) {
+	st := &libraryState{env: env, callbacks: make(map[string]*lua.LFunction)}
+	st.L = newLuaState(ctx, st.env)
+	registerFunctionAPI(st.L, &functionLibrary{name: lib.name, functions: functions}, lib, st.callbacks)
+	loading := env.run.loading
+	env.run.loading = true
+	st.L.Push(st.L.NewFunctionFromProto(lib.proto))
+	err := st.L.PCall(0, 0, nil)
+	env.run.loading = loading
+	if err != nil {
+		st.L.Close()
+		return nil, err
+	}
+	st.L.RemoveContext()
+	return st, nil
+}
+
+// borrow returns an idle state of the library, or a new one, bound to the context and env of a call
+func (lib *functionLibrary) borrow(ctx context.Context, env *scriptEnv) (*libraryState, error) {
+	lib.mu.Lock()
+	var st *libraryState
+	if n := len(lib.idle); n > 0 {
+		st = lib.idle[n-1]
+		lib.idle = lib.idle[:n-1]
+	}
+	lib.mu.Unlock()
+	if st == nil {
+		var err error
+		if st, err = newLibraryState(lib, ctx, env, make(map[string]*functionDef)); err != nil {
+			return nil, err
+		}
+	}
+	*st.env = *env
+	st.L.SetContext(ctx)
+	return st, nil
+}
+
+// giveBack keeps a state after a call. A call that failed may have left the state inconsistent,
+// such as stopped in the middle of the code, so its state is dropped.
+func (lib *functionLibrary) giveBack(st *libraryState, failed bool) {
+	st.L.RemoveContext()
+	st.L.SetTop(0)
+	*st.env = scriptEnv{}
+	lib.mu.Lock()
+	if !failed && len(lib.idle) < functionMaxIdleStates {
+		lib.idle = append(lib.idle, st)
+		st = nil
+	}
+	lib.mu.Unlock()
+	if st != nil {
+		st.L.Close()
+	}
+}
+
+// functionRegistry holds all loaded libraries
+type functionRegistry struct {
+	mu        sync.RWMutex
+	libraries map[string]*functionLibrary
+	functions map[string]*functionDef
+	file      string
+	// saveMu serializes the writes of the functions file
+	saveMu sync.Mutex
+}
+
+func newFunctionRegistry() *functionRegistry {
+	return &functionRegistry{
+		libraries: make(map[string]*functionLibrary),
+		functions: make(map[string]*functionDef),
+	}
+}
+
+func validFunctionName(name string) bool {
+	if len(name) == 0 {
+		return false
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// parseLibraryMeta parses the shebang line of a library: #!lua name=<library>
+func parseLibraryMeta(code string) (string, error) {
+	if !strings.HasPrefix(code, "#!") {
+		return "", errors.New("ERR Missing library metadata")
+	}
+	line := code
+	if i := strings.IndexByte(code, '\n'); i >= 0 {
+		line = code[:i]
+	}
+	fields := strings.Fields(line[2:])
+	if len(fields) == 0 || fields[0] != "lua" {
+		engine := ""
+		if len(fields) > 0 {
+			engine = fields[0]
+		}
+		return "", fmt.Errorf("ERR Engine '%s' not found", engine)
+	}
+	name := ""
+	for _, field := range fields[1:] {
+		if !strings.HasPrefix(field, "name=") {
+			return "", fmt.Errorf("ERR Invalid metadata value given: %s", field)
+		}
+		name = field[len("name="):]
+	}
+	if name == "" {
+		return "", errors.New("ERR Library name was not given")
+	}
+	if !validFunctionName(name) {
+		return "", errors.New("ERR Library names can only contain letters, numbers, or underscores(_) and must be at least one character long")
+	}
+	return name, nil
+}
+
+// registerFunctionAPI installs redis.register_function in L.
+// The registered functions of owner are added to lib.functions, and their callbacks to callbacks.
+func registerFunctionAPI(L *lua.LState, lib *functionLibrary, owner *functionLibrary, callbacks map[string]*lua.LFunction) {
+	api := L.GetGlobal("redis").(*lua.LTable)
+	api.RawSetString("register_function", L.NewFunction(func(L *lua.LState) int {
+		def := &functionDef{lib: owner}
+		var callback *lua.LFunction
+		if L.GetTop() == 1 {
+			t := L.CheckTable(1)
+			name, ok := t.RawGetString("function_name").(lua.LString)
+			if !ok {
+				L.RaiseError("function_name argument given to redis.register_function must be a string")
+			}
+			def.name = string(name)
+			if callback, ok = t.RawGetString("callback").(*lua.LFunction); !ok {
+				L.RaiseError("callback argument given to redis.register_function must be a function")
+			}
+			if desc, ok := t.RawGetString("description").(lua.LString); ok {
+				def.description = string(desc)
+			}
+			if flags, ok := t.RawGetString("flags").(*lua.LTable); ok {
+				for i := 1; i <= flags.Len(); i++ {
+					flag := flags.RawGetInt(i).String()
+					j := 0
+					for j < len(functionFlagNames) && functionFlagNames[j] != flag {
+						j++
+					}
+					if j == len(functionFlagNames) {
+						L.RaiseError("unknown flag given")
+					}
+					def.flags |= 1 << j
+				}
+			}
+		} else {
+			def.name = L.CheckString(1)
+			callback = L.CheckFunction(2)
+		}
+		if !validFunctionName(def.name) {
+			L.RaiseError("Function names can only contain letters, numbers, or underscores(_) and must be at least one character long")
+		}
+		if _, ok := lib.functions[def.name]; ok {
+			L.RaiseError("Function already exists in the library")
+		}
+		lib.functions[def.name] = def
+		callbacks[def.name] = callback
+		return 0
+	}))
+}
+
+// compileLibrary compiles the code of a library and runs it to collect its functions
+func compileLibrary(code string) (*functionLibrary, error) {
+	name, err := parseLibraryMeta(code)
+	if err != nil {
+		return nil, err
+	}
+	// keep the shebang line as an empty line, so that the line numbers of errors are right
+	src := code
+	if i := strings.IndexByte(code, '\n'); i >= 0 {
+		src = code[i:]
+	} else {
+		src = ""
+	}
+	proto, err := compileScript("@user_function", []byte(src))
+	if err != nil {
+		return nil, errors.New("ERR Error compiling function: " + err.Error())
+	}
+	lib := &functionLibrary{
+		name:      name,
+		code:      code,
+		proto:     proto,
+		functions: make(map[string]*functionDef),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), functionLoadTimeout)
+	defer cancel()
+	run := &scriptRun{cancel: cancel, readOnly: true}
+	st, err := newLibraryState(lib, ctx, &scriptEnv{run: run}, lib.functions)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, errors.New("ERR FUNCTION LOAD timeout")
+		}
+		return nil, errors.New("ERR Error registering functions: " + err.Error())
+	}
+	if len(lib.functions) == 0 {
+		st.L.Close()
+		return nil, errors.New("ERR No functions registered")
+	}
+	// the state that ran the code on load is the first one used by the calls
+	lib.giveBack(st, false)
+	return lib, nil
+}
+
+// add adds a compiled library, an existing library with the same name is replaced if replace is true
+func (r *functionRegistry) add(lib *functionLibrary, replace bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	old, exist := r.libraries[lib.name]
+	if exist && !replace {
+		return fmt.Errorf("ERR Library '%s' already exists", lib.name)
+	}
+	for name := range lib.functions {
+		if def, ok := r.functions[name]; ok && def.lib != old {
+			return fmt.Errorf("ERR Function %s already exists", name)
+		}
+	}
+	if exist {
+		r.removeLocked(old)
+	}
+	r.libraries[lib.name] = lib
+	for name, def := range lib.functions {
+		r.functions[name] = def
+	}
+	return nil
+}
+
+func (r *functionRegistry) removeLocked(lib *functionLibrary) {
+	delete(r.libraries, lib.name)
+	for name := range lib.functions {
+		delete(r.functions, name)
+	}
+}
+
+func (r *functionRegistry) delete(name string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	lib, ok := r.libraries[name]
+	if !ok {
+		return false
+	}
+	r.removeLocked(lib)
+	return true
+}
+
+func (r *functionRegistry) flush() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.libraries = make(map[string]*functionLibrary)
+	r.functions = make(map[string]*functionDef)
+}
+
+func (r *functionRegistry) get(name string) (*functionDef, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	def, ok := r.functions[name]
+	return def, ok
+}
+
+// sortedLibraries returns all libraries ordered by name
+func (r *functionRegistry) sortedLibraries() []*functionLibrary {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	libs := make([]*functionLibrary, 0, len(r.libraries))
+	for _, lib := range r.libraries {
+		libs = append(libs, lib)
+	}
+	sort.Slice(libs, func(i, j int) bool {
+		return libs[i].name < libs[j].name
+	})
+	return libs
+}
+
+// dump serializes all libraries: magic, version, the code of each library prefixed by its length and a crc64 checksum.
+func (r *functionRegistry) dump() []byte {
+	buf := bytes.NewBuffer(nil)
+	buf.Write(functionDumpMagic)
+	buf.WriteByte(functionDumpVersion)
+	for _, lib := range r.sortedLibraries() {
+		buf.Write(binary.AppendUvarint(nil, uint64(len(lib.code))))
+		buf.WriteString(lib.code)
+	}
+	buf.Write(binary.LittleEndian.AppendUint64(nil, crc64.Checksum(buf.Bytes(), crc64Table)))
+	return buf.Bytes()
+}
+
+// parseDump compiles the libraries of a payload created by dump
+func parseDump(payload []byte) ([]*functionLibrary, error) {
+	errPayload := errors.New("ERR payload version or checksum are wrong")
+	if len(payload) < len(functionDumpMagic)+1+8 || !bytes.HasPrefix(payload, functionDumpMagic) {
+		return nil, errPayload
+	}
+	body := payload[:len(payload)-8]
+	if crc64.Checksum(body, crc64Table) != binary.LittleEndian.Uint64(payload[len(payload)-8:]) {
+		return nil, errPayload
+	}
+	if body[len(functionDumpMagic)] != functionDumpVersion {
+		return nil, errPayload
+	}
+	body = body[len(functionDumpMagic)+1:]
+	var libs []*functionLibrary
+	for len(body) > 0 {
+		size, n := binary.Uvarint(body)
+		if n <= 0 || uint64(len(body)-n) < size {
+			return nil, errPayload
+		}
+		lib, err := compileLibrary(string(body[n : n+int(size)]))
+		if err != nil {
+			return nil, err
+		}
+		libs = append(libs, lib)
+		body = body[n+int(size):]
+	}
+	return libs, nil
+}
+
+// restore loads the libraries of a dump payload with policy flush, append or replace
+func (r *functionRegistry) restore(payload []byte, policy string) error {
+	libs, err := parseDump(payload)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if policy == "flush" {
+		r.libraries = make(map[string]*functionLibrary)
+		r.functions = make(map[string]*functionDef)
+	}
+	// check conflicts first, so that nothing is restored on error
+	for _, lib := range libs {
+		old, exist := r.libraries[lib.name]
+		if exist && policy != "replace" {
+			return fmt.Errorf("ERR Library %s already exists", lib.name)
+		}
+		for name := range lib.functions {
+			if def, ok := r.functions[name]; ok && def.lib != old {
+				return fmt.Errorf("ERR Function %s already exists", name)
+			}
+		}
+	}
+	for _, lib := range libs {
+		if old, exist := r.libraries[lib.name]; exist {
+			r.removeLocked(old)
+		}
+		r.libraries[lib.name] = lib
+		for name, def := range lib.functions {
+			r.functions[name] = def
+		}
+	}
+	return nil
+}
+
+// save writes all libraries to the functions file through a temporary file renamed over it.
+// Saves are serialized and each one takes its snapshot once it holds saveMu,
+// so the file ends with the latest libraries whatever the order of concurrent changes.
+func (r *functionRegistry) save() {
+	if r.file == "" {
+		return
+	}
+	r.saveMu.Lock()
+	defer r.saveMu.Unlock()
+	tmp, err := os.CreateTemp(filepath.Dir(r.file), filepath.Base(r.file)+".tmp*")
+	if err != nil {
+		logger.Error("save functions error: ", err.Error())
+		return
+	}
+	_, err = tmp.Write(r.dump())
+	if err == nil {
+		err = tmp.Chmod(0644)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), r.file)
+	}
+	if err != nil {
+		logger.Error("save functions error: ", err.Error())
+		_ = os.Remove(tmp.Name())
+	}
+}
+
+// LoadFunctions sets the file used to keep function libraries across restarts and loads the libraries in it.
+func (m *MemDb) LoadFunctions(file string) error {
+	m.functions.file = file
+	payload, err := os.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if err = m.functions.restore(payload, "flush"); err != nil {
+		return fmt.Errorf("load functions from %s: %s", filepath.Clean(file), err.Error())
+	}
+	return nil
+}
+
+func functionCommand(m *MemDb, cmd [][]byte) resp.RedisData {
+	cmdName := strings.ToLower(string(cmd[0]))
+	if cmdName != "function" {
+		logger.Error("functionCommand function: cmdName is not function")
+		return resp.NewErrorData("server error")
+	}
+	if len(cmd) < 2 {
+		return resp.NewErrorData("ERR wrong number of arguments for 'function' command")
+	}
+	subCmd := strings.ToLower(string(cmd[1]))
+	switch subCmd {
+	case "load":
+		if len(cmd) < 3 || len(cmd) > 4 {
+			return resp.NewErrorData("ERR wrong number of arguments for 'function|load' command")
+		}
+		replace := false
+		if len(cmd) == 4 {
+			if strings.ToLower(string(cmd[2])) != "replace" {
+				return resp.NewErrorData(fmt.Sprintf("ERR Unknown option given: %s", cmd[2]))
+			}
+			replace = true
+		}
+		lib, err := compileLibrary(string(cmd[len(cmd)-1]))
+		if err != nil {
+			return resp.NewErrorData(err.Error())
+		}
+		if err = m.functions.add(lib, replace); err != nil {
+			return resp.NewErrorData(err.Error())
+		}
+		m.functions.save()
+		return resp.NewBulkData([]byte(lib.name))
+	case "delete":
+		if len(cmd) != 3 {
+			return resp.NewErrorData("ERR wrong number of arguments for 'function|delete' command")
+		}
+		if !m.functions.delete(string(cmd[2])) {
+			return resp.NewErrorData("ERR Library not found")
+		}
+		m.functions.save()
+		return resp.NewStringData("OK")
+	case "flush":
+		if len(cmd) > 3 {
+			return resp.NewErrorData("ERR wrong number of arguments for 'function|flush' command")
+		}
+		if len(cmd) == 3 {
+			mode := strings.ToLower(string(cmd[2]))
+			if mode != "sync" && mode != "async" {
+				return resp.NewErrorData("ERR FUNCTION FLUSH only supports SYNC|ASYNC option")
+			}
+		}
+		m.functions.flush()
+		m.functions.save()
+		return resp.NewStringData("OK")
+	case "list":
+		return functionList(m, cmd[2:])
+	case "dump":
+		if len(cmd) != 2 {
+			return resp.NewErrorData("ERR wrong number of arguments for 'function|dump' command")
+		}
+		return resp.NewBulkData(m.functions.dump())
+	case "restore":
+		if len(cmd) < 3 || len(cmd) > 4 {
+			return resp.NewErrorData("ERR wrong number of arguments for 'function|restore' command")
+		}
+		policy := "append"
+		if len(cmd) == 4 {
+			policy = strings.ToLower(string(cmd[3]))
+			if policy != "flush" && policy != "append" && policy != "replace" {
+				return resp.NewErrorData("ERR Wrong restore policy given, value should be either FLUSH, APPEND or REPLACE.")
+			}
+		}
+		if err := m.functions.restore(cmd[2], policy); err != nil {
+			return resp.NewErrorData(err.Error())
+		}
+		m.functions.save()
+		return resp.NewStringData("OK")
+	default:
+		return resp.NewErrorData(fmt.Sprintf("ERR unknown subcommand '%s'. Try FUNCTION HELP.", cmd[1]))
+	}
+}
+
+// functionList implements FUNCTION LIST [LIBRARYNAME pattern] [WITHCODE]
+func functionList(m *MemDb, opts [][]byte) resp.RedisData {
+	withCode := false
+	pattern := ""
+	for i := 0; i < len(opts); i++ {
+		switch strings.ToLower(string(opts[i])) {
+		case "withcode":
+			withCode = true
+		case "libraryname":
+			if i+1 >= len(opts) {
+				return resp.NewErrorData("ERR library name argument was not given")
+			}
+			i++
+			pattern = string(opts[i])
+		default:
+			return resp.NewErrorData(fmt.Sprintf("ERR Unknown argument %s", opts[i]))
+		}
+	}
+
+	res := make([]resp.RedisData, 0)
+	for _, lib := range m.functions.sortedLibraries() {
+		if pattern != "" {
+			if ok, err := filepath.Match(pattern, lib.name); err != nil || !ok {
+				continue
+			}
+		}
+		names := make([]string, 0, len(lib.functions))
+		for name := range lib.functions {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		functions := make([]resp.RedisData, 0, len(names))
+		for _, name := range names {
+			def := lib.functions[name]
+			var desc resp.RedisData = resp.NewBulkData(nil)
+			if def.description != "" {
+				desc = resp.NewBulkData([]byte(def.description))
+			}
+			flags := make([]resp.RedisData, 0)
+			for i, flag := range functionFlagNames {
+				if def.flags&(1<<i) != 0 {
+					flags = append(flags, resp.NewBulkData([]byte(flag)))
+				}
+			}
+			functions = append(functions, resp.NewArrayData([]resp.RedisData{
+				resp.NewBulkData([]byte("name")), resp.NewBulkData([]byte(name)),
+				resp.NewBulkData([]byte("description")), desc,
+				resp.NewBulkData([]byte("flags")), resp.NewArrayData(flags),
+			}))
+		}
+		item := []resp.RedisData{
+			resp.NewBulkData([]byte("library_name")), resp.NewBulkData([]byte(lib.name)),
+			resp.NewBulkData([]byte("engine")), resp.NewBulkData([]byte("LUA")),
+			resp.NewBulkData([]byte("functions")), resp.NewArrayData(functions),
+		}
+		if withCode {
+			item = append(item, resp.NewBulkData([]byte("library_code")), resp.NewBulkData([]byte(lib.code)))
+		}
+		res = append(res, resp.NewArrayData(item))
+	}
+	return resp.NewArrayData(res)
+}
+
+func fcallFunction(m *MemDb, cmd [][]byte) resp.RedisData {
+	cmdName := strings.ToLower(string(cmd[0]))
+	if cmdName != "fcall" && cmdName != "fcall_ro" {
+		logger.Error("fcallFunction function: cmdName is not fcall or fcall_ro")
+		return resp.NewErrorData("server error")
+	}
+	if len(cmd) < 3 {
+		return resp.NewErrorData(fmt.Sprintf("ERR wrong number of arguments for '%s' command", cmdName))
+	}
+	keys, args, errData := parseKeysArgs(cmd[2:])
+	if errData != nil {
+		return errData
+	}
+	def, ok := m.functions.get(string(cmd[1]))
+	if !ok {
+		return resp.NewErrorData("ERR Function not found")
+	}
+	readOnly := def.flags&funcFlagNoWrites != 0
+	if cmdName == "fcall_ro" && !readOnly {
+		return resp.NewErrorData("ERR Can not execute a script with write flag using *_ro command.")
+	}
+
+	return m.runScript(keys, readOnly, func(ctx context.Context, env *scriptEnv) (*lua.LState, int, func(bool), error) {
+		st, err := def.lib.borrow(ctx, env)
+		if err != nil {
+			return nil, 0, nil, err
+		}
+		callback, ok := st.callbacks[def.name]
+		if !ok {
+			def.lib.giveBack(st, true)
+			return nil, 0, nil, errors.New("function is not registered by its library")
+		}
+		L := st.L
+		L.Push(callback)
+		L.Push(luaArray(L, keys))
+		L.Push(luaArray(L, args))
+		return L, 2, func(failed bool) { def.lib.giveBack(st, failed) }, nil
+	})
+}
+
+func RegisterFunctionCommands() {
+	RegisterCommand("function", functionCommand, flagNoScript)
+	RegisterCommand("fcall", fcallFunction, flagNoScript)
+	RegisterCommand("fcall_ro", fcallFunction, flagNoScript, flagReadOnly)
+}
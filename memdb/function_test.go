@@ -0,0 +1,181 @@
+package memdb
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const testLibrary = `#!lua name=mylib
+local function incr(keys, args)
+  return redis.call('incrby', keys[1], args[1])
+end
+redis.register_function('myincr', incr)
+redis.register_function{
+  function_name = 'myget',
+  callback = function(keys) return redis.call('get', keys[1]) end,
+  flags = {'no-writes'},
+  description = 'get a key',
+}`
+
+func newFunctionDb() *MemDb {
+	return newScriptDb()
+}
+
+func TestFunctionLoadAndCall(t *testing.T) {
+	mem := newFunctionDb()
+
+	if res := exec(mem, "function", "load", testLibrary); res != "$5\r\nmylib\r\n" {
+		t.Fatalf("function load reply %q", res)
+	}
+	if res := exec(mem, "function", "load", testLibrary); !strings.HasPrefix(res, "-ERR Library 'mylib' already exists") {
+		t.Errorf("function load twice reply %q", res)
+	}
+	if res := exec(mem, "function", "load", "replace", testLibrary); res != "$5\r\nmylib\r\n" {
+		t.Errorf("function load replace reply %q", res)
+	}
+	other := "#!lua name=other\nredis.register_function('myget', function() return 1 end)"
+	if res := exec(mem, "function", "load", other); !strings.HasPrefix(res, "-ERR Function myget already exists") {
+		t.Errorf("function name conflict reply %q", res)
+	}
+	if res := exec(mem, "function", "load", "return 1"); !strings.HasPrefix(res, "-ERR Missing library metadata") {
+		t.Errorf("function load without metadata reply %q", res)
+	}
+	if res := exec(mem, "function", "load", "#!lua name=bad\nredis.call('set', 'a', 'b')"); !strings.HasPrefix(res, "-ERR Error registering functions") {
+		t.Errorf("function load with command reply %q", res)
+	}
+
+	if res := exec(mem, "fcall", "myincr", "1", "k", "5"); res != ":5\r\n" {
+		t.Errorf("fcall reply %q", res)
+	}
+	if res := exec(mem, "fcall_ro", "myget", "1", "k"); res != "$1\r\n5\r\n" {
+		t.Errorf("fcall_ro reply %q", res)
+	}
+	if res := exec(mem, "fcall_ro", "myincr", "1", "k", "1"); !strings.Contains(res, "write flag") {
+		t.Errorf("fcall_ro on write function reply %q", res)
+	}
+	if res := exec(mem, "fcall", "nope", "0"); res != "-ERR Function not found\r\n" {
+		t.Errorf("fcall unknown function reply %q", res)
+	}
+
+	res := exec(mem, "function", "list", "withcode")
+	if !strings.Contains(res, "mylib") || !strings.Contains(res, "no-writes") || !strings.Contains(res, "library_code") {
+		t.Errorf("function list reply %q", res)
+	}
+	if res = exec(mem, "function", "list", "libraryname", "x*"); res != "*0\r\n" {
+		t.Errorf("function list pattern reply %q", res)
+	}
+
+	if res = exec(mem, "function", "delete", "mylib"); res != "+OK\r\n" {
+		t.Errorf("function delete reply %q", res)
+	}
+	if res = exec(mem, "fcall", "myincr", "1", "k", "5"); res != "-ERR Function not found\r\n" {
+		t.Errorf("fcall after delete reply %q", res)
+	}
+}
+
+func TestFunctionCachedState(t *testing.T) {
+	mem := newFunctionDb()
+	lib := `#!lua name=counter
+local runs = 0
+runs = runs + 1
+local calls = 0
+redis.register_function('count', function()
+  calls = calls + 1
+  return {runs, calls}
+end)
+redis.register_function('fail', function() error('failed') end)`
+	if res := exec(mem, "function", "load", lib); res != "$7\r\ncounter\r\n" {
+		t.Fatalf("function load reply %q", res)
+	}
+	// the library code ran once on load, the calls share its state
+	for i := 1; i <= 3; i++ {
+		if res := exec(mem, "fcall", "count", "0"); res != "*2\r\n:1\r\n:"+strconv.Itoa(i)+"\r\n" {
+			t.Errorf("fcall %d reply %q", i, res)
+		}
+	}
+	// a failed call drops its state, the next call runs the library code in a new one
+	if res := exec(mem, "fcall", "fail", "0"); !strings.HasPrefix(res, "-") {
+		t.Errorf("failing fcall reply %q", res)
+	}
+	if res := exec(mem, "fcall", "count", "0"); res != "*2\r\n:1\r\n:1\r\n" {
+		t.Errorf("fcall after a failed call reply %q", res)
+	}
+}
+
+func TestFunctionDumpRestore(t *testing.T) {
+	mem := newFunctionDb()
+	exec(mem, "function", "load", testLibrary)
+	payload := mem.functions.dump()
+
+	exec(mem, "function", "flush")
+	if res := exec(mem, "function", "list"); res != "*0\r\n" {
+		t.Errorf("function list after flush reply %q", res)
+	}
+	if res := string(mem.ExecCommand([][]byte{[]byte("function"), []byte("restore"), payload}).ToBytes()); res != "+OK\r\n" {
+		t.Fatalf("function restore reply %q", res)
+	}
+	if res := string(mem.ExecCommand([][]byte{[]byte("function"), []byte("restore"), payload}).ToBytes()); !strings.Contains(res, "already exists") {
+		t.Errorf("function restore append reply %q", res)
+	}
+	if res := string(mem.ExecCommand([][]byte{[]byte("function"), []byte("restore"), payload, []byte("replace")}).ToBytes()); res != "+OK\r\n" {
+		t.Errorf("function restore replace reply %q", res)
+	}
+	payload[len(payload)-1] ^= 0xff
+	if res := string(mem.ExecCommand([][]byte{[]byte("function"), []byte("restore"), payload}).ToBytes()); !strings.Contains(res, "checksum") {
+		t.Errorf("function restore bad payload reply %q", res)
+	}
+}
+
+func TestFunctionPersistence(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "functions.dump")
+	mem := newFunctionDb()
+	if err := mem.LoadFunctions(file); err != nil {
+		t.Fatal(err)
+	}
+	exec(mem, "function", "load", testLibrary)
+
+	// a restarted server gets the libraries back
+	mem2 := newFunctionDb()
+	if err := mem2.LoadFunctions(file); err != nil {
+		t.Fatal(err)
+	}
+	if res := exec(mem2, "fcall", "myincr", "1", "k", "2"); res != ":2\r\n" {
+		t.Errorf("fcall after restart reply %q", res)
+	}
+}
+
+func TestFunctionConcurrentSave(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "functions.dump")
+	mem := newFunctionDb()
+	if err := mem.LoadFunctions(file); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			lib := fmt.Sprintf("#!lua name=lib%d\nredis.register_function('f%d', function() return %d end)", i, i, i)
+			exec(mem, "function", "load", lib)
+		}(i)
+	}
+	wg.Wait()
+
+	// the file holds every library, and no temporary file is left
+	mem2 := newFunctionDb()
+	if err := mem2.LoadFunctions(file); err != nil {
+		t.Fatal(err)
+	}
+	if libs := mem2.functions.sortedLibraries(); len(libs) != 20 {
+		t.Errorf("%d libraries saved, expect 20", len(libs))
+	}
+	if entries, err := os.ReadDir(dir); err != nil || len(entries) != 1 {
+		t.Errorf("%d files in the functions dir, %v", len(entries), err)
+	}
+}
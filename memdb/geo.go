@@ -0,0 +1,528 @@
+package memdb
+
+import (
+	"easyRedis/datastructure"
+	"easyRedis/logger"
+	"easyRedis/resp"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// geo.go implements the GEO commands on top of sorted sets, the score of a member is the geohash of its position.
+
+// geoUnit returns the number of meters in unit
+func geoUnit(unit []byte) (float64, bool) {
+	switch strings.ToLower(string(unit)) {
+	case "m":
+		return 1, true
+	case "km":
+		return 1000, true
+	case "ft":
+		return 0.3048, true
+	case "mi":
+		return 1609.34, true
+	}
+	return 0, false
+}
+
+func geoUnitError() resp.RedisData {
+	return resp.NewErrorData("ERR unsupported unit provided. please use M, KM, FT, MI")
+}
+
+func geoFormatCoord(v float64) *resp.BulkData {
+	return resp.NewBulkData([]byte(strconv.FormatFloat(v, 'f', 17, 64)))
+}
+
+func geoFormatDist(dist, unit float64) *resp.BulkData {
+	return resp.NewBulkData([]byte(fmt.Sprintf("%.4f", dist/unit)))
+}
+
+func geoParseLongLat(long, lat []byte) (float64, float64, resp.RedisData) {
+	x, err1 := strconv.ParseFloat(string(long), 64)
+	y, err2 := strconv.ParseFloat(string(lat), 64)
+	if err1 != nil || err2 != nil {
+		return 0, 0, resp.NewErrorData("ERR value is not a valid float")
+	}
+	if !datastructure.GeoValid(x, y) {
+		return 0, 0, resp.NewErrorData(fmt.Sprintf("ERR invalid longitude,latitude pair %f,%f", x, y))
+	}
+	return x, y, nil
+}
+
+// getSortSet returns the sorted set of key, nil if key doesn't exist.
+// The caller must hold the lock of key.
+func (m *MemDb) getSortSet(key string) (*datastructure.SortSet, resp.RedisData) {
+	temp, ok := m.db.Get(key)
+	if !ok {
+		return nil, nil
+	}
+	sortSet, ok := temp.(*datastructure.SortSet)
+	if !ok {
+		return nil, resp.NewErrorData("WRONGTYPE Operation against a key holding the wrong kind of value")
+	}
+	return sortSet, nil
+}
+
+func geoAdd(m *MemDb, cmd [][]byte) resp.RedisData {
+	if strings.ToLower(string(cmd[0])) != "geoadd" {
+		logger.Error("geoAdd Function: cmdName is not geoadd")
+		return resp.NewErrorData("server error")
+	}
+	if len(cmd) < 5 {
+		return resp.NewErrorData("ERR wrong number of arguments for 'geoadd' command")
+	}
+	var nx, xx, ch bool
+	i := 2
+	for ; i < len(cmd); i++ {
+		opt := strings.ToLower(string(cmd[i]))
+		if opt == "nx" {
+			nx = true
+		} else if opt == "xx" {
+			xx = true
+		} else if opt == "ch" {
+			ch = true
+		} else {
+			break
+		}
+	}
+	if nx && xx {
+		return resp.NewErrorData("ERR XX and NX options at the same time are not compatible")
+	}
+	if len(cmd) == i || (len(cmd)-i)%3 != 0 {
+		return resp.NewErrorData("ERR syntax error")
+	}
+	items := make([]*datastructure.StItem, 0, (len(cmd)-i)/3)
+	for ; i < len(cmd); i += 3 {
+		long, lat, errData := geoParseLongLat(cmd[i], cmd[i+1])
+		if errData != nil {
+			return errData
+		}
+		items = append(items, &datastructure.StItem{F: datastructure.GeoScore(long, lat), K: string(cmd[i+2])})
+	}
+
+	key := string(cmd[1])
+	m.CheckTTL(key)
+	defer m.signalKey(key)
+	m.locks.Lock(key)
+	defer m.locks.Unlock(key)
+	sortSet, errData := m.getSortSet(key)
+	if errData != nil {
+		return errData
+	}
+	if sortSet == nil {
+		if xx {
+			return resp.NewIntData(0)
+		}
+		sortSet = datastructure.NewDefaultSortSet()
+		m.db.Set(key, sortSet)
+	}
+
+	var added, changed int64
+	for _, item := range items {
+		score, exist := sortSet.GetScore(item.K)
+		if !exist {
+			if xx {
+				continue
+			}
+			added++
+		} else {
+			if nx || score == item.F {
+				continue
+			}
+			changed++
+		}
+		sortSet.Add(item)
+	}
+	if ch {
+		return resp.NewIntData(added + changed)
+	}
+	return resp.NewIntData(added)
+}
+
+func geoPos(m *MemDb, cmd [][]byte) resp.RedisData {
+	if strings.ToLower(string(cmd[0])) != "geopos" {
+		logger.Error("geoPos Function: cmdName is not geopos")
+		return resp.NewErrorData("server error")
+	}
+	if len(cmd) < 2 {
+		return resp.NewErrorData("ERR wrong number of arguments for 'geopos' command")
+	}
+	key := string(cmd[1])
+	m.CheckTTL(key)
+	m.locks.RLock(key)
+	defer m.locks.RUnlock(key)
+	sortSet, errData := m.getSortSet(key)
+	if errData != nil {
+		return errData
+	}
+	res := make([]resp.RedisData, 0, len(cmd)-2)
+	for _, member := range cmd[2:] {
+		if sortSet == nil {
+			res = append(res, resp.NewArrayData(nil))
+			continue
+		}
+		score, ok := sortSet.GetScore(string(member))
+		if !ok {
+			res = append(res, resp.NewArrayData(nil))
+			continue
+		}
+		long, lat := datastructure.GeoPosition(score)
+		res = append(res, resp.NewArrayData([]resp.RedisData{geoFormatCoord(long), geoFormatCoord(lat)}))
+	}
+	return resp.NewArrayData(res)
+}
+
+func geoDist(m *MemDb, cmd [][]byte) resp.RedisData {
+	if strings.ToLower(string(cmd[0])) != "geodist" {
+		logger.Error("geoDist Function: cmdName is not geodist")
+		return resp.NewErrorData("server error")
+	}
+	if len(cmd) != 4 && len(cmd) != 5 {
+		return resp.NewErrorData("ERR wrong number of arguments for 'geodist' command")
+	}
+	unit := 1.0
+	if len(cmd) == 5 {
+		var ok bool
+		if unit, ok = geoUnit(cmd[4]); !ok {
+			return geoUnitError()
+		}
+	}
+	key := string(cmd[1])
+	m.CheckTTL(key)
+	m.locks.RLock(key)
+	defer m.locks.RUnlock(key)
+	sortSet, errData := m.getSortSet(key)
+	if errData != nil {
+		return errData
+	}
+	if sortSet == nil {
+		return resp.NewBulkData(nil)
+	}
+	score1, ok1 := sortSet.GetScore(string(cmd[2]))
+	score2, ok2 := sortSet.GetScore(string(cmd[3]))
+	if !ok1 || !ok2 {
+		return resp.NewBulkData(nil)
+	}
+	long1, lat1 := datastructure.GeoPosition(score1)
+	long2, lat2 := datastructure.GeoPosition(score2)
+	return geoFormatDist(datastructure.GeoDistance(long1, lat1, long2, lat2), unit)
+}
+
+func geoHash(m *MemDb, cmd [][]byte) resp.RedisData {
+	if strings.ToLower(string(cmd[0])) != "geohash" {
+		logger.Error("geoHash Function: cmdName is not geohash")
+		return resp.NewErrorData("server error")
+	}
+	if len(cmd) < 2 {
+		return resp.NewErrorData("ERR wrong number of arguments for 'geohash' command")
+	}
+	key := string(cmd[1])
+	m.CheckTTL(key)
+	m.locks.RLock(key)
+	defer m.locks.RUnlock(key)
+	sortSet, errData := m.getSortSet(key)
+	if errData != nil {
+		return errData
+	}
+	res := make([]resp.RedisData, 0, len(cmd)-2)
+	for _, member := range cmd[2:] {
+		if sortSet == nil {
+			res = append(res, resp.NewBulkData(nil))
+			continue
+		}
+		score, ok := sortSet.GetScore(string(member))
+		if !ok {
+			res = append(res, resp.NewBulkData(nil))
+			continue
+		}
+		res = append(res, resp.NewBulkData([]byte(datastructure.GeoHashString(score))))
+	}
+	return resp.NewArrayData(res)
+}
+
+// geoSearchArgs are the parsed options of GEOSEARCH and GEOSEARCHSTORE
+type geoSearchArgs struct {
+	fromMember string
+	hasMember  bool
+	hasLongLat bool
+	shape      datastructure.GeoShape
+	hasShape   bool
+	unit       float64
+	desc, asc  bool
+	count      int
+	any        bool
+	withCoord  bool
+	withDist   bool
+	withHash   bool
+	storeDist  bool
+}
+
+// geoPoint is a member found by GEOSEARCH
+type geoPoint struct {
+	member    string
+	score     float64
+	dist      float64
+	long, lat float64
+}
+
+// parseGeoSearchArgs parses the options starting at cmd[0], store is true for GEOSEARCHSTORE
+func parseGeoSearchArgs(cmd [][]byte, store bool) (*geoSearchArgs, resp.RedisData) {
+	args := &geoSearchArgs{}
+	syntaxErr := resp.NewErrorData("ERR syntax error")
+	for i := 0; i < len(cmd); i++ {
+		switch strings.ToLower(string(cmd[i])) {
+		case "frommember":
+			if i+1 >= len(cmd) || args.hasMember || args.hasLongLat {
+				return nil, resp.NewErrorData("ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for GEOSEARCH")
+			}
+			args.fromMember = string(cmd[i+1])
+			args.hasMember = true
+			i++
+		case "fromlonlat":
+			if i+2 >= len(cmd) || args.hasMember || args.hasLongLat {
+				return nil, resp.NewErrorData("ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for GEOSEARCH")
+			}
+			long, lat, errData := geoParseLongLat(cmd[i+1], cmd[i+2])
+			if errData != nil {
+				return nil, errData
+			}
+			args.shape.Long, args.shape.Lat = long, lat
+			args.hasLongLat = true
+			i += 2
+		case "byradius":
+			if i+2 >= len(cmd) || args.hasShape {
+				return nil, resp.NewErrorData("ERR exactly one of BYRADIUS and BYBOX can be specified for GEOSEARCH")
+			}
+			radius, err := strconv.ParseFloat(string(cmd[i+1]), 64)
+			if err != nil || radius < 0 {
+				return nil, resp.NewErrorData("ERR need numeric radius")
+			}
+			unit, ok := geoUnit(cmd[i+2])
+			if !ok {
+				return nil, geoUnitError()
+			}
+			args.shape.Radius = radius * unit
+			args.unit = unit
+			args.hasShape = true
+			i += 2
+		case "bybox":
+			if i+3 >= len(cmd) || args.hasShape {
+				return nil, resp.NewErrorData("ERR exactly one of BYRADIUS and BYBOX can be specified for GEOSEARCH")
+			}
+			width, err1 := strconv.ParseFloat(string(cmd[i+1]), 64)
+			height, err2 := strconv.ParseFloat(string(cmd[i+2]), 64)
+			if err1 != nil || err2 != nil || width < 0 || height < 0 {
+				return nil, resp.NewErrorData("ERR need numeric width and height")
+			}
+			unit, ok := geoUnit(cmd[i+3])
+			if !ok {
+				return nil, geoUnitError()
+			}
+			args.shape.Width, args.shape.Height = width*unit, height*unit
+			args.shape.IsBox = true
+			args.unit = unit
+			args.hasShape = true
+			i += 3
+		case "asc":
+			args.asc = true
+		case "desc":
+			args.desc = true
+		case "count":
+			if i+1 >= len(cmd) {
+				return nil, syntaxErr
+			}
+			count, err := strconv.Atoi(string(cmd[i+1]))
+			if err != nil || count <= 0 {
+				return nil, resp.NewErrorData("ERR COUNT must be > 0")
+			}
+			args.count = count
+			i++
+			if i+1 < len(cmd) && strings.ToLower(string(cmd[i+1])) == "any" {
+				args.any = true
+				i++
+			}
+		case "withcoord":
+			args.withCoord = true
+		case "withdist":
+			args.withDist = true
+		case "withhash":
+			args.withHash = true
+		case "storedist":
+			if !store {
+				return nil, syntaxErr
+			}
+			args.storeDist = true
+		case "any":
+			return nil, resp.NewErrorData("ERR the ANY argument requires COUNT argument")
+		default:
+			return nil, syntaxErr
+		}
+	}
+	if !args.hasMember && !args.hasLongLat {
+		return nil, resp.NewErrorData("ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for GEOSEARCH")
+	}
+	if !args.hasShape {
+		return nil, resp.NewErrorData("ERR exactly one of BYRADIUS and BYBOX can be specified for GEOSEARCH")
+	}
+	if args.asc && args.desc {
+		return nil, syntaxErr
+	}
+	if store && (args.withCoord || args.withDist || args.withHash) {
+		return nil, syntaxErr
+	}
+	// like redis, COUNT without ANY returns the nearest members
+	if args.count > 0 && !args.any && !args.desc {
+		args.asc = true
+	}
+	return args, nil
+}
+
+// geoSearch returns the members of sortSet inside the search area.
+// The neighbouring geohash cells of the center are scanned with score ranges of the skip list.
+func geoSearch(sortSet *datastructure.SortSet, args *geoSearchArgs) ([]*geoPoint, resp.RedisData) {
+	if args.hasMember {
+		score, ok := sortSet.GetScore(args.fromMember)
+		if !ok {
+			return nil, resp.NewErrorData("ERR could not decode requested zset member")
+		}
+		args.shape.Long, args.shape.Lat = datastructure.GeoPosition(score)
+	}
+
+	points := make([]*geoPoint, 0)
+	for _, r := range args.shape.SearchRanges() {
+		items := sortSet.RangeByScore(&datastructure.SkipListFindRange{Min: r[0], Max: r[1], MaxBra: true})
+		for _, item := range items {
+			long, lat := datastructure.GeoPosition(item.Score())
+			dist, ok := args.shape.Match(long, lat)
+			if !ok {
+				continue
+			}
+			points = append(points, &geoPoint{member: item.Key(), score: item.Score(), dist: dist, long: long, lat: lat})
+			// ANY stops as soon as enough members are found
+			if args.any && len(points) == args.count {
+				break
+			}
+		}
+		if args.any && len(points) == args.count {
+			break
+		}
+	}
+
+	if args.asc {
+		sort.SliceStable(points, func(i, j int) bool { return points[i].dist < points[j].dist })
+	} else if args.desc {
+		sort.SliceStable(points, func(i, j int) bool { return points[i].dist > points[j].dist })
+	}
+	if args.count > 0 && len(points) > args.count {
+		points = points[:args.count]
+	}
+	return points, nil
+}
+
+func geoSearchCommand(m *MemDb, cmd [][]byte) resp.RedisData {
+	if strings.ToLower(string(cmd[0])) != "geosearch" {
+		logger.Error("geoSearchCommand Function: cmdName is not geosearch")
+		return resp.NewErrorData("server error")
+	}
+	if len(cmd) < 2 {
+		return resp.NewErrorData("ERR wrong number of arguments for 'geosearch' command")
+	}
+	args, errData := parseGeoSearchArgs(cmd[2:], false)
+	if errData != nil {
+		return errData
+	}
+	key := string(cmd[1])
+	m.CheckTTL(key)
+	m.locks.RLock(key)
+	defer m.locks.RUnlock(key)
+	sortSet, errData := m.getSortSet(key)
+	if errData != nil {
+		return errData
+	}
+	if sortSet == nil {
+		return resp.NewArrayData([]resp.RedisData{})
+	}
+	points, errData := geoSearch(sortSet, args)
+	if errData != nil {
+		return errData
+	}
+
+	res := make([]resp.RedisData, 0, len(points))
+	for _, p := range points {
+		name := resp.NewBulkData([]byte(p.member))
+		if !args.withDist && !args.withHash && !args.withCoord {
+			res = append(res, name)
+			continue
+		}
+		item := []resp.RedisData{name}
+		if args.withDist {
+			item = append(item, geoFormatDist(p.dist, args.unit))
+		}
+		if args.withHash {
+			item = append(item, resp.NewIntData(int64(p.score)))
+		}
+		if args.withCoord {
+			item = append(item, resp.NewArrayData([]resp.RedisData{geoFormatCoord(p.long), geoFormatCoord(p.lat)}))
+		}
+		res = append(res, resp.NewArrayData(item))
+	}
+	return resp.NewArrayData(res)
+}
+
+func geoSearchStore(m *MemDb, cmd [][]byte) resp.RedisData {
+	if strings.ToLower(string(cmd[0])) != "geosearchstore" {
+		logger.Error("geoSearchStore Function: cmdName is not geosearchstore")
+		return resp.NewErrorData("server error")
+	}
+	if len(cmd) < 3 {
+		return resp.NewErrorData("ERR wrong number of arguments for 'geosearchstore' command")
+	}
+	args, errData := parseGeoSearchArgs(cmd[3:], true)
+	if errData != nil {
+		return errData
+	}
+	desKey, srcKey := string(cmd[1]), string(cmd[2])
+	m.CheckTTL(desKey)
+	m.CheckTTL(srcKey)
+	keys := []string{desKey, srcKey}
+	m.locks.LockMulti(keys)
+	defer m.locks.UnlockMulti(keys)
+	sortSet, errData := m.getSortSet(srcKey)
+	if errData != nil {
+		return errData
+	}
+	var points []*geoPoint
+	if sortSet != nil {
+		if points, errData = geoSearch(sortSet, args); errData != nil {
+			return errData
+		}
+	}
+
+	m.db.Delete(desKey)
+	m.DelTTL(desKey)
+	if len(points) == 0 {
+		return resp.NewIntData(0)
+	}
+	desSortSet := datastructure.NewDefaultSortSet()
+	for _, p := range points {
+		score := p.score
+		if args.storeDist {
+			score = p.dist / args.unit
+		}
+		desSortSet.Add(&datastructure.StItem{F: score, K: p.member})
+	}
+	m.db.Set(desKey, desSortSet)
+	defer m.signalKey(desKey)
+	return resp.NewIntData(int64(len(points)))
+}
+
+func RegisterGeoCommands() {
+	RegisterCommand("geoadd", geoAdd)
+	RegisterCommand("geopos", geoPos, flagReadOnly)
+	RegisterCommand("geodist", geoDist, flagReadOnly)
+	RegisterCommand("geohash", geoHash, flagReadOnly)
+	RegisterCommand("geosearch", geoSearchCommand, flagReadOnly)
+	RegisterCommand("geosearchstore", geoSearchStore)
+}
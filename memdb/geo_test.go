@@ -0,0 +1,123 @@
+package memdb
+
+import (
+	"strings"
+	"testing"
+)
+
+func newSicily(t *testing.T) *MemDb {
+	mem := newTestDb()
+	res := exec(mem, "geoadd", "Sicily", "13.361389", "38.115556", "Palermo", "15.087269", "37.502669", "Catania")
+	if res != ":2\r\n" {
+		t.Fatalf("geoadd reply %q", res)
+	}
+	return mem
+}
+
+func TestGeoAdd(t *testing.T) {
+	mem := newSicily(t)
+	if res := exec(mem, "geoadd", "Sicily", "nx", "13", "38", "Palermo"); res != ":0\r\n" {
+		t.Errorf("geoadd nx reply %q", res)
+	}
+	if res := exec(mem, "geoadd", "Sicily", "xx", "ch", "13.361389", "38.115556", "Palermo", "13", "37", "Agrigento"); res != ":0\r\n" {
+		t.Errorf("geoadd xx ch unchanged reply %q", res)
+	}
+	if res := exec(mem, "zcard", "Sicily"); res != ":2\r\n" {
+		t.Errorf("zcard after xx reply %q", res)
+	}
+	if res := exec(mem, "geoadd", "Sicily", "ch", "13.5", "38", "Palermo", "13.583333", "37.316667", "Agrigento"); res != ":2\r\n" {
+		t.Errorf("geoadd ch reply %q", res)
+	}
+	if res := exec(mem, "geoadd", "Sicily", "200", "38", "Nowhere"); !strings.HasPrefix(res, "-ERR invalid longitude,latitude pair") {
+		t.Errorf("geoadd invalid pair reply %q", res)
+	}
+	if res := exec(mem, "geoadd", "Sicily", "nx", "xx", "13", "38", "a"); !strings.HasPrefix(res, "-ERR XX and NX") {
+		t.Errorf("geoadd nx xx reply %q", res)
+	}
+	if res := exec(mem, "geoadd", "Sicily", "13", "38", "a", "14"); res != "-ERR syntax error\r\n" {
+		t.Errorf("geoadd odd arguments reply %q", res)
+	}
+}
+
+func TestGeoPosDistHash(t *testing.T) {
+	mem := newSicily(t)
+	if res := exec(mem, "geopos", "Sicily", "Palermo", "Nowhere"); res != "*2\r\n*2\r\n$20\r\n13.36138933897018433\r\n$20\r\n38.11555639549629859\r\n*-1\r\n" {
+		t.Errorf("geopos reply %q", res)
+	}
+	if res := exec(mem, "geodist", "Sicily", "Palermo", "Catania"); res != "$11\r\n166274.1516\r\n" {
+		t.Errorf("geodist reply %q", res)
+	}
+	if res := exec(mem, "geodist", "Sicily", "Palermo", "Catania", "km"); res != "$8\r\n166.2742\r\n" {
+		t.Errorf("geodist km reply %q", res)
+	}
+	if res := exec(mem, "geodist", "Sicily", "Palermo", "Nowhere"); res != "$-1\r\n" {
+		t.Errorf("geodist missing member reply %q", res)
+	}
+	if res := exec(mem, "geodist", "Sicily", "Palermo", "Catania", "yd"); !strings.HasPrefix(res, "-ERR unsupported unit") {
+		t.Errorf("geodist bad unit reply %q", res)
+	}
+	if res := exec(mem, "geohash", "Sicily", "Palermo", "Catania", "Nowhere"); res != "*3\r\n$11\r\nsqc8b49rny0\r\n$11\r\nsqdtr74hyu0\r\n$-1\r\n" {
+		t.Errorf("geohash reply %q", res)
+	}
+}
+
+func TestGeoSearch(t *testing.T) {
+	mem := newSicily(t)
+	exec(mem, "geoadd", "Sicily", "12.758489", "38.788135", "edge1", "17.241510", "38.788135", "edge2")
+
+	if res := exec(mem, "geosearch", "Sicily", "fromlonlat", "15", "37", "byradius", "200", "km", "asc"); res != "*2\r\n$7\r\nCatania\r\n$7\r\nPalermo\r\n" {
+		t.Errorf("geosearch byradius reply %q", res)
+	}
+	res := exec(mem, "geosearch", "Sicily", "fromlonlat", "15", "37", "bybox", "400", "400", "km", "asc", "withdist")
+	want := "*4\r\n*2\r\n$7\r\nCatania\r\n$7\r\n56.4413\r\n*2\r\n$7\r\nPalermo\r\n$8\r\n190.4424\r\n" +
+		"*2\r\n$5\r\nedge2\r\n$8\r\n279.7403\r\n*2\r\n$5\r\nedge1\r\n$8\r\n279.7405\r\n"
+	if res != want {
+		t.Errorf("geosearch bybox reply %q", res)
+	}
+	if res := exec(mem, "geosearch", "Sicily", "frommember", "Palermo", "byradius", "200", "km", "desc", "count", "1"); res != "*1\r\n$7\r\nCatania\r\n" {
+		t.Errorf("geosearch frommember count reply %q", res)
+	}
+	if res := exec(mem, "geosearch", "Sicily", "frommember", "Palermo", "byradius", "1", "m", "withhash", "withcoord"); res != "*1\r\n*3\r\n$7\r\nPalermo\r\n:3479099956230698\r\n*2\r\n$20\r\n13.36138933897018433\r\n$20\r\n38.11555639549629859\r\n" {
+		t.Errorf("geosearch withhash withcoord reply %q", res)
+	}
+	if res := exec(mem, "geosearch", "Sicily", "fromlonlat", "15", "37", "byradius", "500", "km", "count", "2", "any"); !strings.HasPrefix(res, "*2\r\n") {
+		t.Errorf("geosearch count any reply %q", res)
+	}
+	if res := exec(mem, "geosearch", "Sicily", "frommember", "Nowhere", "byradius", "1", "km"); !strings.HasPrefix(res, "-ERR could not decode") {
+		t.Errorf("geosearch missing member reply %q", res)
+	}
+	if res := exec(mem, "geosearch", "Sicily", "byradius", "1", "km"); !strings.HasPrefix(res, "-ERR exactly one of FROMMEMBER") {
+		t.Errorf("geosearch without center reply %q", res)
+	}
+	if res := exec(mem, "geosearch", "Sicily", "fromlonlat", "15", "37", "byradius", "1", "km", "any"); !strings.HasPrefix(res, "-ERR the ANY argument") {
+		t.Errorf("geosearch any without count reply %q", res)
+	}
+	if res := exec(mem, "geosearch", "nokey", "fromlonlat", "15", "37", "byradius", "1", "km"); res != "*0\r\n" {
+		t.Errorf("geosearch missing key reply %q", res)
+	}
+}
+
+func TestGeoSearchStore(t *testing.T) {
+	mem := newSicily(t)
+	if res := exec(mem, "geosearchstore", "dst", "Sicily", "fromlonlat", "15", "37", "byradius", "200", "km"); res != ":2\r\n" {
+		t.Errorf("geosearchstore reply %q", res)
+	}
+	if res := exec(mem, "geohash", "dst", "Palermo"); res != "*1\r\n$11\r\nsqc8b49rny0\r\n" {
+		t.Errorf("geohash of stored member reply %q", res)
+	}
+	if res := exec(mem, "geosearchstore", "dst", "Sicily", "fromlonlat", "15", "37", "byradius", "100", "km", "storedist"); res != ":1\r\n" {
+		t.Errorf("geosearchstore storedist reply %q", res)
+	}
+	if res := exec(mem, "zscore", "dst", "Catania"); !strings.HasPrefix(res, ":56.441") {
+		t.Errorf("stored distance reply %q", res)
+	}
+	if res := exec(mem, "geosearchstore", "dst", "Sicily", "fromlonlat", "0", "0", "byradius", "1", "km"); res != ":0\r\n" {
+		t.Errorf("geosearchstore empty reply %q", res)
+	}
+	if res := exec(mem, "zcard", "dst"); res != ":0\r\n" {
+		t.Errorf("empty result must delete the destination, zcard %q", res)
+	}
+	if res := exec(mem, "geosearchstore", "dst", "Sicily", "fromlonlat", "15", "37", "byradius", "1", "km", "withdist"); res != "-ERR syntax error\r\n" {
+		t.Errorf("geosearchstore withdist reply %q", res)
+	}
+}
@@ -20,6 +20,7 @@ func hDelHash(m *MemDb, cmd [][]byte) resp.RedisData {
 	}
 	key := string(cmd[1])
 
+	m.CheckTTL(key)
 	m.locks.Lock(key)
 	defer m.locks.Unlock(key)
 	temp, ok := m.db.Get(key)
@@ -56,6 +57,7 @@ func hExistsHash(m *MemDb, cmd [][]byte) resp.RedisData {
 
 	key := string(cmd[1])
 
+	m.CheckTTL(key)
 	m.locks.RLock(key)
 	defer m.locks.RUnlock(key)
 
@@ -85,6 +87,7 @@ func hGetHash(m *MemDb, cmd [][]byte) resp.RedisData {
 
 	key := string(cmd[1])
 
+	m.CheckTTL(key)
 	m.locks.RLock(key)
 	defer m.locks.RUnlock(key)
 	temp, ok := m.db.Get(key)
@@ -112,6 +115,7 @@ func hGetAllHash(m *MemDb, cmd [][]byte) resp.RedisData {
 		return resp.NewErrorData("wrong number of arguments for 'hgetall' command")
 	}
 	key := string(cmd[1])
+	m.CheckTTL(key)
 	m.locks.RLock(key)
 	defer m.locks.RUnlock(key)
 
@@ -123,11 +127,11 @@ func hGetAllHash(m *MemDb, cmd [][]byte) resp.RedisData {
 	if !ok {
 		return resp.NewErrorData("WRONGTYPE Operation against a key holding the wrong kind of value")
 	}
-	table := hash.Table()
-	res := make([]resp.RedisData, 0, len(table)*2)
-	for k, v := range table {
-		res = append(res, resp.NewBulkData([]byte(k)), resp.NewBulkData(v))
-	}
+	res := make([]resp.RedisData, 0, hash.Len()*2)
+	hash.ForEach(func(field string, val []byte) bool {
+		res = append(res, resp.NewBulkData([]byte(field)), resp.NewBulkData(val))
+		return true
+	})
 	return resp.NewArrayData(res)
 
 }
@@ -151,6 +155,7 @@ func hIncrByHash(m *MemDb, cmd [][]byte) resp.RedisData {
 		return resp.NewErrorData("incr value must be an integer")
 	}
 
+	m.CheckTTL(key)
 	m.locks.Lock(key)
 	defer m.locks.Unlock(key)
 
@@ -188,6 +193,7 @@ func hIncrByFloatHash(m *MemDb, cmd [][]byte) resp.RedisData {
 		return resp.NewErrorData("incr value must be a float")
 	}
 
+	m.CheckTTL(key)
 	m.locks.Lock(key)
 	defer m.locks.Unlock(key)
 
@@ -218,6 +224,7 @@ func hKeysHash(m *MemDb, cmd [][]byte) resp.RedisData {
 		return resp.NewErrorData("wrong number of arguments for 'hkeys' command")
 	}
 	key := string(cmd[1])
+	m.CheckTTL(key)
 	m.locks.RLock(key)
 	defer m.locks.RUnlock(key)
 
@@ -248,6 +255,7 @@ func hLenHash(m *MemDb, cmd [][]byte) resp.RedisData {
 	}
 
 	key := string(cmd[1])
+	m.CheckTTL(key)
 	m.locks.RLock(key)
 	defer m.locks.RUnlock(key)
 
@@ -273,6 +281,7 @@ func hMGetHash(m *MemDb, cmd [][]byte) resp.RedisData {
 	}
 
 	key := string(cmd[1])
+	m.CheckTTL(key)
 	m.locks.RLock(key)
 	defer m.locks.RUnlock(key)
 
@@ -308,6 +317,7 @@ func hSetHash(m *MemDb, cmd [][]byte) resp.RedisData {
 	}
 
 	key := string(cmd[1])
+	m.CheckTTL(key)
 	m.locks.Lock(key)
 	defer m.locks.Unlock(key)
 
@@ -343,6 +353,7 @@ func hSetNxHash(m *MemDb, cmd [][]byte) resp.RedisData {
 	key := string(cmd[1])
 	field := string(cmd[2])
 	val := cmd[3]
+	m.CheckTTL(key)
 	m.locks.Lock(key)
 	defer m.locks.Unlock(key)
 
@@ -374,6 +385,7 @@ func hValsHash(m *MemDb, cmd [][]byte) resp.RedisData {
 		return resp.NewErrorData("wrong number of arguments for 'hvals' command")
 	}
 	key := string(cmd[1])
+	m.CheckTTL(key)
 	m.locks.RLock(key)
 	defer m.locks.RUnlock(key)
 
@@ -405,6 +417,7 @@ func hStrLenHash(m *MemDb, cmd [][]byte) resp.RedisData {
 	key := string(cmd[1])
 	field := string(cmd[2])
 
+	m.CheckTTL(key)
 	m.locks.RLock(key)
 	defer m.locks.RUnlock(key)
 
@@ -448,6 +461,7 @@ func hRandFieldHash(m *MemDb, cmd [][]byte) resp.RedisData {
 		}
 	}
 
+	m.CheckTTL(key)
 	m.locks.RLock(key)
 	defer m.locks.RUnlock(key)
 	temp, ok := m.db.Get(key)
@@ -476,18 +490,30 @@ func hRandFieldHash(m *MemDb, cmd [][]byte) resp.RedisData {
 
 func RegisterHashCommands() {
 	RegisterCommand("hdel", hDelHash)
-	RegisterCommand("hexists", hExistsHash)
-	RegisterCommand("hget", hGetHash)
-	RegisterCommand("hgetall", hGetAllHash)
+	RegisterCommand("hexists", hExistsHash, flagReadOnly)
+	RegisterCommand("hget", hGetHash, flagReadOnly)
+	RegisterCommand("hgetall", hGetAllHash, flagReadOnly)
 	RegisterCommand("hincrby", hIncrByHash)
 	RegisterCommand("hincrbyfloat", hIncrByFloatHash)
-	RegisterCommand("hkeys", hKeysHash)
-	RegisterCommand("hlen", hLenHash)
-	RegisterCommand("hmget", hMGetHash)
+	RegisterCommand("hkeys", hKeysHash, flagReadOnly)
+	RegisterCommand("hlen", hLenHash, flagReadOnly)
+	RegisterCommand("hmget", hMGetHash, flagReadOnly)
 	RegisterCommand("hset", hSetHash)
 	RegisterCommand("hsetnx", hSetNxHash)
-	RegisterCommand("hvals", hValsHash)
-	RegisterCommand("hstrlen", hStrLenHash)
-	RegisterCommand("hrandfield", hRandFieldHash)
+	RegisterCommand("hvals", hValsHash, flagReadOnly)
+	RegisterCommand("hstrlen", hStrLenHash, flagReadOnly)
+	RegisterCommand("hrandfield", hRandFieldHash, flagReadOnly)
+	RegisterCommand("hexpire", hExpireHash, flagNoActiveActive)
+	RegisterCommand("hpexpire", hExpireHash, flagNoActiveActive)
+	RegisterCommand("hexpireat", hExpireHash, flagNoActiveActive)
+	RegisterCommand("hpexpireat", hExpireHash, flagNoActiveActive)
+	RegisterCommand("httl", hTTLHash, flagReadOnly)
+	RegisterCommand("hpttl", hTTLHash, flagReadOnly)
+	RegisterCommand("hexpiretime", hTTLHash, flagReadOnly)
+	RegisterCommand("hpexpiretime", hTTLHash, flagReadOnly)
+	RegisterCommand("hpersist", hPersistHash, flagNoActiveActive)
+	RegisterCommand("hgetex", hGetExHash, flagNoActiveActive)
+	RegisterCommand("hsetex", hSetExHash, flagNoActiveActive)
+	RegisterCommand("hgetdel", hGetDelHash)
 
 }
@@ -0,0 +1,510 @@
+package memdb
+
+import (
+	"easyRedis/datastructure"
+	"easyRedis/logger"
+	"easyRedis/resp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// hash_expire.go implements the expiration of hash fields: HEXPIRE, HPEXPIRE, HEXPIREAT, HPEXPIREAT,
+// HTTL, HPTTL, HEXPIRETIME, HPEXPIRETIME, HPERSIST, HGETEX, HSETEX and HGETDEL.
+//
+// The deadlines of the fields are in unix milliseconds. Expired fields are deleted lazily by CheckTTL,
+// and actively by a timewheel task scheduled at the earliest deadline of each hash.
+// A hash is deleted once its last field is.
+
+// hashFieldTaskPrefix prefixes the key of the timewheel tasks of the fields, to keep them apart from the key ttl tasks
+const hashFieldTaskPrefix = "\x00hfe:"
+
+// maxFieldDeadline is the largest deadline of a field, 2^48 - 1 milliseconds like redis
+const maxFieldDeadline = 1<<48 - 1
+
+// expireHashFields deletes the expired fields of the hash of key, and key once the hash is empty.
+// It returns false if key was deleted. Like CheckTTL, it must be called without the lock of key.
+func (m *MemDb) expireHashFields(key string) bool {
+	if _, ok := m.fieldTTLKeys.Get(key); !ok {
+		return true
+	}
+	m.locks.RLock(key)
+	hash := m.getHashWithExpires(key)
+	now := time.Now().UnixMilli()
+	expired := false
+	if hash != nil {
+		next, _ := hash.NextExpire()
+		expired = next <= now
+	} else {
+		// the fields were persisted or deleted, or key was deleted or renamed since the mark
+		m.fieldTTLKeys.Delete(key)
+	}
+	m.locks.RUnlock(key)
+	if !expired {
+		return true
+	}
+
+	m.locks.Lock(key)
+	defer m.locks.Unlock(key)
+	// the hash may have been changed before the lock
+	if hash = m.getHashWithExpires(key); hash == nil {
+		return true
+	}
+	hash.DelExpired(now)
+	if hash.IsEmpty() {
+		m.db.Delete(key)
+		m.DelTTL(key)
+		m.fieldTTLKeys.Delete(key)
+		return false
+	}
+	m.scheduleHashFields(key, hash)
+	return true
+}
+
+// getHashWithExpires returns the hash of key if some of its fields have an expire time, else nil
+func (m *MemDb) getHashWithExpires(key string) *datastructure.Hash {
+	temp, ok := m.db.Peek(key)
+	if !ok {
+		return nil
+	}
+	hash, ok := temp.(*datastructure.Hash)
+	if !ok {
+		return nil
+	}
+	if _, ok = hash.NextExpire(); !ok {
+		return nil
+	}
+	return hash
+}
+
+// scheduleHashFields marks key in fieldTTLKeys and adds the timewheel task that deletes the fields of key
+// at their earliest deadline. The caller must hold the lock of key.
+func (m *MemDb) scheduleHashFields(key string, hash *datastructure.Hash) {
+	next, ok := hash.NextExpire()
+	if !ok {
+		return
+	}
+	m.fieldTTLKeys.Set(key, struct{}{})
+	interval := time.Until(time.UnixMilli(next))
+	if interval < 0 {
+		interval = 0
+	}
+	root := m.root()
+	m.delay.Add(interval, hashFieldTaskPrefix+key, func() {
+		root.CheckTTL(key)
+	})
+}
+
+// getHash returns the hash of key, nil if key doesn't exist
+// The caller must hold the lock of key.
+func (m *MemDb) getHash(key string) (*datastructure.Hash, resp.RedisData) {
+	temp, ok := m.db.Get(key)
+	if !ok {
+		return nil, nil
+	}
+	hash, ok := temp.(*datastructure.Hash)
+	if !ok {
+		return nil, resp.NewErrorData("WRONGTYPE Operation against a key holding the wrong kind of value")
+	}
+	return hash, nil
+}
+
+// parseHashFields parses FIELDS numfields field ... at the end of args, with step arguments per field
+func parseHashFields(args [][]byte, step int) ([][]byte, resp.RedisData) {
+	if len(args) < 2 || strings.ToLower(string(args[0])) != "fields" {
+		return nil, resp.NewErrorData("ERR Mandatory argument FIELDS is missing or not at the right position")
+	}
+	numFields, err := strconv.Atoi(string(args[1]))
+	if err != nil || numFields <= 0 {
+		return nil, resp.NewErrorData("ERR Parameter `numFields` should be greater than 0")
+	}
+	if numFields*step != len(args)-2 {
+		return nil, resp.NewErrorData("ERR The `numfields` parameter must match the number of arguments")
+	}
+	return args[2:], nil
+}
+
+// fieldDeadline converts the expire time of an option to a deadline in unix milliseconds
+func fieldDeadline(opt string, val int64) (int64, bool) {
+	if val < 0 || val > maxFieldDeadline {
+		return 0, false
+	}
+	now := time.Now().UnixMilli()
+	var deadline int64
+	switch opt {
+	case "ex":
+		deadline = now + val*1000
+	case "px":
+		deadline = now + val
+	case "exat":
+		deadline = val * 1000
+	default:
+		deadline = val
+	}
+	return deadline, deadline <= maxFieldDeadline
+}
+
+// delHashFields deletes fields of the hash of key, and key once the hash is empty.
+// The caller must hold the lock of key.
+func (m *MemDb) delHashFields(key string, hash *datastructure.Hash, fields ...string) {
+	for _, field := range fields {
+		hash.Del(field)
+	}
+	if hash.IsEmpty() {
+		m.db.Delete(key)
+		m.DelTTL(key)
+	}
+}
+
+// hExpireHash implements HEXPIRE, HPEXPIRE, HEXPIREAT and HPEXPIREAT.
+// The reply for each field is -2 if it doesn't exist, 0 if the condition is not met,
+// 1 if the expire time is set and 2 if the field is deleted by a deadline in the past.
+func hExpireHash(m *MemDb, cmd [][]byte) resp.RedisData {
+	cmdName := strings.ToLower(string(cmd[0]))
+	opt, ok := map[string]string{"hexpire": "ex", "hpexpire": "px", "hexpireat": "exat", "hpexpireat": "pxat"}[cmdName]
+	if !ok {
+		logger.Error("hExpireHash Function: cmdName is not hexpire, hpexpire, hexpireat or hpexpireat")
+		return resp.NewErrorData("server error")
+	}
+	if len(cmd) < 6 {
+		return resp.NewErrorData("ERR wrong number of arguments for '" + cmdName + "' command")
+	}
+	val, err := strconv.ParseInt(string(cmd[2]), 10, 64)
+	if err != nil {
+		return resp.NewErrorData("ERR value is not an integer or out of range")
+	}
+	deadline, ok := fieldDeadline(opt, val)
+	if !ok {
+		return resp.NewErrorData("ERR invalid expire time in '" + cmdName + "' command")
+	}
+	cond, rest := "", cmd[3:]
+	switch c := strings.ToLower(string(rest[0])); c {
+	case "nx", "xx", "gt", "lt":
+		cond, rest = c, rest[1:]
+	}
+	fields, errData := parseHashFields(rest, 1)
+	if errData != nil {
+		return errData
+	}
+
+	key := string(cmd[1])
+	m.CheckTTL(key)
+	m.locks.Lock(key)
+	defer m.locks.Unlock(key)
+
+	hash, errData := m.getHash(key)
+	if errData != nil {
+		return errData
+	}
+	res := make([]resp.RedisData, len(fields))
+	var deleted []string
+	for i, f := range fields {
+		field := string(f)
+		if hash == nil || !hash.Exist(field) {
+			res[i] = resp.NewIntData(-2)
+			continue
+		}
+		// a field without expire time has an infinite ttl for GT and LT
+		old, hasTTL := hash.Expire(field)
+		if (cond == "nx" && hasTTL) || (cond == "xx" && !hasTTL) ||
+			(cond == "gt" && (!hasTTL || deadline <= old)) || (cond == "lt" && hasTTL && deadline >= old) {
+			res[i] = resp.NewIntData(0)
+			continue
+		}
+		if deadline <= time.Now().UnixMilli() {
+			deleted = append(deleted, field)
+			res[i] = resp.NewIntData(2)
+			continue
+		}
+		hash.SetExpire(field, deadline)
+		res[i] = resp.NewIntData(1)
+	}
+	if hash != nil {
+		m.delHashFields(key, hash, deleted...)
+		m.scheduleHashFields(key, hash)
+	}
+	return resp.NewArrayData(res)
+}
+
+// hTTLHash implements HTTL, HPTTL, HEXPIRETIME and HPEXPIRETIME.
+// The reply for each field is -2 if it doesn't exist and -1 if it has no expire time.
+func hTTLHash(m *MemDb, cmd [][]byte) resp.RedisData {
+	cmdName := strings.ToLower(string(cmd[0]))
+	if cmdName != "httl" && cmdName != "hpttl" && cmdName != "hexpiretime" && cmdName != "hpexpiretime" {
+		logger.Error("hTTLHash Function: cmdName is not httl, hpttl, hexpiretime or hpexpiretime")
+		return resp.NewErrorData("server error")
+	}
+	if len(cmd) < 5 {
+		return resp.NewErrorData("ERR wrong number of arguments for '" + cmdName + "' command")
+	}
+	fields, errData := parseHashFields(cmd[2:], 1)
+	if errData != nil {
+		return errData
+	}
+
+	key := string(cmd[1])
+	m.CheckTTL(key)
+	m.locks.RLock(key)
+	defer m.locks.RUnlock(key)
+
+	hash, errData := m.getHash(key)
+	if errData != nil {
+		return errData
+	}
+	now := time.Now().UnixMilli()
+	res := make([]resp.RedisData, len(fields))
+	for i, f := range fields {
+		field := string(f)
+		if hash == nil || !hash.Exist(field) {
+			res[i] = resp.NewIntData(-2)
+			continue
+		}
+		deadline, ok := hash.Expire(field)
+		if !ok {
+			res[i] = resp.NewIntData(-1)
+			continue
+		}
+		switch cmdName {
+		case "httl":
+			res[i] = resp.NewIntData((deadline - now + 999) / 1000)
+		case "hpttl":
+			res[i] = resp.NewIntData(deadline - now)
+		case "hexpiretime":
+			res[i] = resp.NewIntData((deadline + 999) / 1000)
+		default:
+			res[i] = resp.NewIntData(deadline)
+		}
+	}
+	return resp.NewArrayData(res)
+}
+
+// hPersistHash replies -2 for the fields that don't exist, -1 if they have no expire time, else 1
+func hPersistHash(m *MemDb, cmd [][]byte) resp.RedisData {
+	if strings.ToLower(string(cmd[0])) != "hpersist" {
+		logger.Error("hPersistHash Function: cmdName is not hpersist")
+		return resp.NewErrorData("server error")
+	}
+	if len(cmd) < 5 {
+		return resp.NewErrorData("ERR wrong number of arguments for 'hpersist' command")
+	}
+	fields, errData := parseHashFields(cmd[2:], 1)
+	if errData != nil {
+		return errData
+	}
+
+	key := string(cmd[1])
+	m.CheckTTL(key)
+	m.locks.Lock(key)
+	defer m.locks.Unlock(key)
+
+	hash, errData := m.getHash(key)
+	if errData != nil {
+		return errData
+	}
+	res := make([]resp.RedisData, len(fields))
+	for i, f := range fields {
+		field := string(f)
+		switch {
+		case hash == nil || !hash.Exist(field):
+			res[i] = resp.NewIntData(-2)
+		case hash.Persist(field):
+			res[i] = resp.NewIntData(1)
+		default:
+			res[i] = resp.NewIntData(-1)
+		}
+	}
+	return resp.NewArrayData(res)
+}
+
+// hGetExHash returns the values of the fields and sets or removes their expire time
+func hGetExHash(m *MemDb, cmd [][]byte) resp.RedisData {
+	if strings.ToLower(string(cmd[0])) != "hgetex" {
+		logger.Error("hGetExHash Function: cmdName is not hgetex")
+		return resp.NewErrorData("server error")
+	}
+	if len(cmd) < 5 {
+		return resp.NewErrorData("ERR wrong number of arguments for 'hgetex' command")
+	}
+	opt, rest := "", cmd[2:]
+	var deadline int64
+	switch o := strings.ToLower(string(rest[0])); o {
+	case "ex", "px", "exat", "pxat":
+		if len(rest) < 2 {
+			return resp.NewErrorData("ERR syntax error")
+		}
+		val, err := strconv.ParseInt(string(rest[1]), 10, 64)
+		if err != nil {
+			return resp.NewErrorData("ERR value is not an integer or out of range")
+		}
+		var ok bool
+		if deadline, ok = fieldDeadline(o, val); !ok {
+			return resp.NewErrorData("ERR invalid expire time in 'hgetex' command")
+		}
+		opt, rest = o, rest[2:]
+	case "persist":
+		opt, rest = o, rest[1:]
+	}
+	fields, errData := parseHashFields(rest, 1)
+	if errData != nil {
+		return errData
+	}
+
+	key := string(cmd[1])
+	m.CheckTTL(key)
+	m.locks.Lock(key)
+	defer m.locks.Unlock(key)
+
+	hash, errData := m.getHash(key)
+	if errData != nil {
+		return errData
+	}
+	res := make([]resp.RedisData, len(fields))
+	var deleted []string
+	for i, f := range fields {
+		field := string(f)
+		if hash == nil || !hash.Exist(field) {
+			res[i] = resp.NewBulkData(nil)
+			continue
+		}
+		res[i] = resp.NewBulkData(hash.Get(field))
+		switch {
+		case opt == "persist":
+			hash.Persist(field)
+		case opt != "" && deadline <= time.Now().UnixMilli():
+			deleted = append(deleted, field)
+		case opt != "":
+			hash.SetExpire(field, deadline)
+		}
+	}
+	if hash != nil {
+		m.delHashFields(key, hash, deleted...)
+		m.scheduleHashFields(key, hash)
+	}
+	return resp.NewArrayData(res)
+}
+
+// hSetExHash sets fields with an expire time, it replies 0 if the FNX or FXX condition is not met, else 1
+func hSetExHash(m *MemDb, cmd [][]byte) resp.RedisData {
+	if strings.ToLower(string(cmd[0])) != "hsetex" {
+		logger.Error("hSetExHash Function: cmdName is not hsetex")
+		return resp.NewErrorData("server error")
+	}
+	if len(cmd) < 6 {
+		return resp.NewErrorData("ERR wrong number of arguments for 'hsetex' command")
+	}
+	var cond, opt string
+	var deadline int64
+	rest := cmd[2:]
+	for len(rest) > 0 && strings.ToLower(string(rest[0])) != "fields" {
+		switch o := strings.ToLower(string(rest[0])); o {
+		case "fnx", "fxx":
+			if cond != "" {
+				return resp.NewErrorData("ERR syntax error")
+			}
+			cond, rest = o, rest[1:]
+		case "ex", "px", "exat", "pxat":
+			if opt != "" || len(rest) < 2 {
+				return resp.NewErrorData("ERR syntax error")
+			}
+			val, err := strconv.ParseInt(string(rest[1]), 10, 64)
+			if err != nil {
+				return resp.NewErrorData("ERR value is not an integer or out of range")
+			}
+			var ok bool
+			if deadline, ok = fieldDeadline(o, val); !ok {
+				return resp.NewErrorData("ERR invalid expire time in 'hsetex' command")
+			}
+			opt, rest = o, rest[2:]
+		case "keepttl":
+			if opt != "" {
+				return resp.NewErrorData("ERR syntax error")
+			}
+			opt, rest = o, rest[1:]
+		default:
+			return resp.NewErrorData("ERR syntax error")
+		}
+	}
+	fields, errData := parseHashFields(rest, 2)
+	if errData != nil {
+		return errData
+	}
+
+	key := string(cmd[1])
+	m.CheckTTL(key)
+	m.locks.Lock(key)
+	defer m.locks.Unlock(key)
+
+	hash, errData := m.getHash(key)
+	if errData != nil {
+		return errData
+	}
+	for i := 0; i < len(fields); i += 2 {
+		exist := hash != nil && hash.Exist(string(fields[i]))
+		if (cond == "fnx" && exist) || (cond == "fxx" && !exist) {
+			return resp.NewIntData(0)
+		}
+	}
+	if hash == nil {
+		hash = datastructure.NewHash()
+		m.db.Set(key, hash)
+	}
+	var deleted []string
+	for i := 0; i < len(fields); i += 2 {
+		field := string(fields[i])
+		old, keep := hash.Expire(field)
+		hash.Set(field, fields[i+1])
+		switch {
+		case opt == "keepttl":
+			if keep {
+				hash.SetExpire(field, old)
+			}
+		case opt != "" && deadline <= time.Now().UnixMilli():
+			deleted = append(deleted, field)
+		case opt != "":
+			hash.SetExpire(field, deadline)
+		}
+	}
+	m.delHashFields(key, hash, deleted...)
+	m.scheduleHashFields(key, hash)
+	return resp.NewIntData(1)
+}
+
+// hGetDelHash returns the values of the fields and deletes them
+func hGetDelHash(m *MemDb, cmd [][]byte) resp.RedisData {
+	if strings.ToLower(string(cmd[0])) != "hgetdel" {
+		logger.Error("hGetDelHash Function: cmdName is not hgetdel")
+		return resp.NewErrorData("server error")
+	}
+	if len(cmd) < 5 {
+		return resp.NewErrorData("ERR wrong number of arguments for 'hgetdel' command")
+	}
+	fields, errData := parseHashFields(cmd[2:], 1)
+	if errData != nil {
+		return errData
+	}
+
+	key := string(cmd[1])
+	m.CheckTTL(key)
+	m.locks.Lock(key)
+	defer m.locks.Unlock(key)
+
+	hash, errData := m.getHash(key)
+	if errData != nil {
+		return errData
+	}
+	res := make([]resp.RedisData, len(fields))
+	deleted := make([]string, 0, len(fields))
+	for i, f := range fields {
+		field := string(f)
+		if hash == nil || !hash.Exist(field) {
+			res[i] = resp.NewBulkData(nil)
+			continue
+		}
+		res[i] = resp.NewBulkData(hash.Get(field))
+		deleted = append(deleted, field)
+	}
+	if hash != nil {
+		m.delHashFields(key, hash, deleted...)
+	}
+	return resp.NewArrayData(res)
+}
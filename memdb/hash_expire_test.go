@@ -0,0 +1,165 @@
+package memdb
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestHExpireHash(t *testing.T) {
+	mem := newTestDb()
+	exec(mem, "hset", "h", "a", "1", "b", "2", "c", "3")
+	if res := exec(mem, "hexpire", "h", "100", "fields", "3", "a", "b", "x"); res != "*3\r\n:1\r\n:1\r\n:-2\r\n" {
+		t.Errorf("hexpire reply %q", res)
+	}
+	if res := exec(mem, "hexpire", "h", "200", "nx", "fields", "2", "a", "c"); res != "*2\r\n:0\r\n:1\r\n" {
+		t.Errorf("hexpire nx reply %q", res)
+	}
+	if res := exec(mem, "hexpire", "h", "50", "gt", "fields", "1", "a"); res != "*1\r\n:0\r\n" {
+		t.Errorf("hexpire gt reply %q", res)
+	}
+	if res := exec(mem, "hexpire", "h", "50", "lt", "fields", "1", "a"); res != "*1\r\n:1\r\n" {
+		t.Errorf("hexpire lt reply %q", res)
+	}
+	if res := exec(mem, "httl", "h", "fields", "3", "a", "c", "x"); res != "*3\r\n:50\r\n:200\r\n:-2\r\n" {
+		t.Errorf("httl reply %q", res)
+	}
+	if res := exec(mem, "hpersist", "h", "fields", "3", "a", "a", "x"); res != "*3\r\n:1\r\n:-1\r\n:-2\r\n" {
+		t.Errorf("hpersist reply %q", res)
+	}
+	// a deadline in the past deletes the field, and the key with its last field
+	if res := exec(mem, "hpexpireat", "h", "1", "fields", "2", "a", "b"); res != "*2\r\n:2\r\n:2\r\n" {
+		t.Errorf("hpexpireat reply %q", res)
+	}
+	if res := exec(mem, "hexpireat", "h", "1", "fields", "1", "c"); res != "*1\r\n:2\r\n" {
+		t.Errorf("hexpireat reply %q", res)
+	}
+	if res := exec(mem, "exists", "h"); res != ":0\r\n" {
+		t.Errorf("exists of the emptied hash reply %q", res)
+	}
+
+	for _, args := range [][]string{
+		{"hexpire", "h", "10", "fields", "2", "a"},
+		{"hexpire", "h", "10", "fields", "0"},
+		{"hexpire", "h", "10", "a", "1", "a"},
+		{"hexpire", "h", "-1", "fields", "1", "a"},
+	} {
+		if res := exec(mem, args...); res[0] != '-' {
+			t.Errorf("%v reply %q", args, res)
+		}
+	}
+}
+
+func TestHashFieldsReclaim(t *testing.T) {
+	mem := newTestDb()
+	exec(mem, "hset", "h", "a", "1", "b", "2")
+	exec(mem, "hpexpire", "h", "50", "fields", "1", "a")
+	time.Sleep(100 * time.Millisecond)
+	if res := exec(mem, "hgetall", "h"); res != "*2\r\n$1\r\nb\r\n$1\r\n2\r\n" {
+		t.Errorf("hgetall after the expiration reply %q", res)
+	}
+
+	// the key is deleted by the timewheel without being accessed
+	exec(mem, "hpexpire", "h", "50", "fields", "1", "b")
+	time.Sleep(1500 * time.Millisecond)
+	if _, ok := mem.db.Get("h"); ok {
+		t.Error("the hash is not deleted once all its fields expired")
+	}
+}
+
+// only the hashes with expiring fields are marked, CheckTTL drops the stale marks
+func TestHashFieldsMark(t *testing.T) {
+	mem := newTestDb()
+	exec(mem, "hset", "h", "a", "1")
+	exec(mem, "set", "s", "v")
+	if _, ok := mem.fieldTTLKeys.Get("h"); ok {
+		t.Error("hash without expiring fields is marked")
+	}
+	exec(mem, "hexpire", "h", "100", "fields", "1", "a")
+	if _, ok := mem.fieldTTLKeys.Get("h"); !ok {
+		t.Error("hash with an expiring field is not marked")
+	}
+	exec(mem, "hpersist", "h", "fields", "1", "a")
+	exec(mem, "hget", "h", "a")
+	if _, ok := mem.fieldTTLKeys.Get("h"); ok {
+		t.Error("mark kept after the fields are persisted")
+	}
+	if _, ok := mem.fieldTTLKeys.Get("s"); ok {
+		t.Error("string key is marked")
+	}
+}
+
+func TestHTTLHash(t *testing.T) {
+	mem := newTestDb()
+	exec(mem, "hset", "h", "a", "1", "b", "2")
+	deadline := time.Now().Add(time.Hour).UnixMilli()
+	exec(mem, "hpexpireat", "h", strconv.FormatInt(deadline, 10), "fields", "1", "a")
+	if res := exec(mem, "hpexpiretime", "h", "fields", "2", "a", "b"); res != "*2\r\n:"+strconv.FormatInt(deadline, 10)+"\r\n:-1\r\n" {
+		t.Errorf("hpexpiretime reply %q", res)
+	}
+	if res := exec(mem, "hexpiretime", "h", "fields", "1", "a"); res != "*1\r\n:"+strconv.FormatInt((deadline+999)/1000, 10)+"\r\n" {
+		t.Errorf("hexpiretime reply %q", res)
+	}
+	// writing a field clears its expire time
+	exec(mem, "hset", "h", "a", "3")
+	if res := exec(mem, "hpttl", "h", "fields", "1", "a"); res != "*1\r\n:-1\r\n" {
+		t.Errorf("hpttl after hset reply %q", res)
+	}
+}
+
+func TestHGetExHash(t *testing.T) {
+	mem := newTestDb()
+	exec(mem, "hset", "h", "a", "1", "b", "2")
+	if res := exec(mem, "hgetex", "h", "ex", "100", "fields", "2", "a", "x"); res != "*2\r\n$1\r\n1\r\n$-1\r\n" {
+		t.Errorf("hgetex reply %q", res)
+	}
+	if res := exec(mem, "httl", "h", "fields", "1", "a"); res != "*1\r\n:100\r\n" {
+		t.Errorf("httl after hgetex reply %q", res)
+	}
+	exec(mem, "hgetex", "h", "persist", "fields", "1", "a")
+	if res := exec(mem, "httl", "h", "fields", "1", "a"); res != "*1\r\n:-1\r\n" {
+		t.Errorf("httl after hgetex persist reply %q", res)
+	}
+	if res := exec(mem, "hgetex", "h", "pxat", "1", "fields", "2", "a", "b"); res != "*2\r\n$1\r\n1\r\n$1\r\n2\r\n" {
+		t.Errorf("hgetex pxat reply %q", res)
+	}
+	if res := exec(mem, "exists", "h"); res != ":0\r\n" {
+		t.Errorf("exists after hgetex pxat reply %q", res)
+	}
+}
+
+func TestHSetExHash(t *testing.T) {
+	mem := newTestDb()
+	if res := exec(mem, "hsetex", "h", "fxx", "fields", "1", "a", "1"); res != ":0\r\n" {
+		t.Errorf("hsetex fxx reply %q", res)
+	}
+	if res := exec(mem, "hsetex", "h", "fnx", "ex", "100", "fields", "2", "a", "1", "b", "2"); res != ":1\r\n" {
+		t.Errorf("hsetex fnx reply %q", res)
+	}
+	if res := exec(mem, "hsetex", "h", "fnx", "fields", "2", "a", "3", "c", "3"); res != ":0\r\n" {
+		t.Errorf("hsetex fnx of an existing field reply %q", res)
+	}
+	exec(mem, "hsetex", "h", "keepttl", "fields", "1", "a", "4")
+	exec(mem, "hsetex", "h", "fields", "1", "b", "5")
+	if res := exec(mem, "httl", "h", "fields", "2", "a", "b"); res != "*2\r\n:100\r\n:-1\r\n" {
+		t.Errorf("httl after hsetex reply %q", res)
+	}
+	if res := exec(mem, "hmget", "h", "a", "b"); res != "*2\r\n$1\r\n4\r\n$1\r\n5\r\n" {
+		t.Errorf("hmget after hsetex reply %q", res)
+	}
+	if res := exec(mem, "hsetex", "h", "ex", "1", "px", "1", "fields", "1", "a", "1"); res[0] != '-' {
+		t.Errorf("hsetex with two expire options reply %q", res)
+	}
+}
+
+func TestHGetDelHash(t *testing.T) {
+	mem := newTestDb()
+	exec(mem, "hset", "h", "a", "1", "b", "2")
+	if res := exec(mem, "hgetdel", "h", "fields", "2", "a", "x"); res != "*2\r\n$1\r\n1\r\n$-1\r\n" {
+		t.Errorf("hgetdel reply %q", res)
+	}
+	exec(mem, "hgetdel", "h", "fields", "1", "b")
+	if res := exec(mem, "exists", "h"); res != ":0\r\n" {
+		t.Errorf("exists after hgetdel reply %q", res)
+	}
+}
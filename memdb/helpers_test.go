@@ -0,0 +1,64 @@
+package memdb
+
+import (
+	"easyRedis/config"
+	"testing"
+)
+
+// helpers_test.go holds the fixtures shared by the tests of the package.
+
+func init() {
+	config.Configures = &config.Config{ShardNum: 100}
+}
+
+// registerAllCommands registers every command, like main does
+func registerAllCommands() {
+	RegisterKeyCommands()
+	RegisterObjectCommands()
+	RegisterClientCommands()
+	RegisterSlowlogCommands()
+	RegisterDumpCommands()
+	RegisterSortCommands()
+	RegisterStringCommands()
+	RegisterListCommands()
+	RegisterSetCommands()
+	RegisterHashCommands()
+	RegisterSortSetCommands()
+	RegisterGeoCommands()
+	RegisterBitmapCommands()
+	RegisterHyperLogLogCommands()
+	RegisterCrdtCommands()
+	RegisterScriptCommands()
+	RegisterFunctionCommands()
+}
+
+// newTestDb returns an empty database, all the commands are registered
+func newTestDb() *MemDb {
+	registerAllCommands()
+	return NewMemDb()
+}
+
+// withConfig replaces config.Configures by cfg until the test ends
+func withConfig(t *testing.T, cfg *config.Config) {
+	old := config.Configures
+	config.Configures = cfg
+	t.Cleanup(func() { config.Configures = old })
+}
+
+// exec runs a command that doesn't come from a client and returns its reply
+func exec(m *MemDb, args ...string) string {
+	cmd := make([][]byte, len(args))
+	for i, arg := range args {
+		cmd[i] = []byte(arg)
+	}
+	return string(m.ExecCommand(cmd).ToBytes())
+}
+
+// execClient runs a command of client and returns its reply
+func execClient(m *MemDb, client *Client, args ...string) string {
+	cmd := make([][]byte, len(args))
+	for i, arg := range args {
+		cmd[i] = []byte(arg)
+	}
+	return string(m.ExecClientCommand(client, cmd).ToBytes())
+}
@@ -0,0 +1,223 @@
+package memdb
+
+import (
+	"easyRedis/datastructure"
+	"easyRedis/logger"
+	"easyRedis/resp"
+	"fmt"
+	"strings"
+)
+
+// hyperloglog.go implements PFADD, PFCOUNT, PFMERGE and PFDEBUG on HyperLogLog string values.
+
+// getHLLValue returns the HyperLogLog value of key, nil if key doesn't exist.
+// The caller must hold the lock of key.
+func (m *MemDb) getHLLValue(key string) ([]byte, resp.RedisData) {
+	val, errData := m.getStringValue(key)
+	if errData != nil || val == nil {
+		return nil, errData
+	}
+	if !datastructure.IsHLL(val) {
+		return nil, resp.NewErrorData(datastructure.ErrHLLInvalid.Error())
+	}
+	return val, nil
+}
+
+func pfAddHLL(m *MemDb, cmd [][]byte) resp.RedisData {
+	if strings.ToLower(string(cmd[0])) != "pfadd" {
+		logger.Error("pfAddHLL func: cmdName is not pfadd")
+		return resp.NewErrorData("Server error")
+	}
+	if len(cmd) < 2 {
+		return resp.NewErrorData("ERR wrong number of arguments for 'pfadd' command")
+	}
+	key := string(cmd[1])
+	m.CheckTTL(key)
+	m.locks.Lock(key)
+	defer m.locks.Unlock(key)
+
+	val, errData := m.getHLLValue(key)
+	if errData != nil {
+		return errData
+	}
+	created := false
+	if val == nil {
+		val = datastructure.NewHLL()
+		created = true
+	}
+	val, changed, err := datastructure.HLLAdd(val, cmd[2:])
+	if err != nil {
+		return resp.NewErrorData(err.Error())
+	}
+	if created || changed {
+		m.db.Set(key, val)
+		return resp.NewIntData(1)
+	}
+	return resp.NewIntData(0)
+}
+
+func pfCountHLL(m *MemDb, cmd [][]byte) resp.RedisData {
+	if strings.ToLower(string(cmd[0])) != "pfcount" {
+		logger.Error("pfCountHLL func: cmdName is not pfcount")
+		return resp.NewErrorData("Server error")
+	}
+	if len(cmd) < 2 {
+		return resp.NewErrorData("ERR wrong number of arguments for 'pfcount' command")
+	}
+
+	// a single key uses and updates the cached cardinality, so it needs the write lock
+	if len(cmd) == 2 {
+		key := string(cmd[1])
+		m.CheckTTL(key)
+		m.locks.Lock(key)
+		defer m.locks.Unlock(key)
+		val, errData := m.getHLLValue(key)
+		if errData != nil {
+			return errData
+		}
+		if val == nil {
+			return resp.NewIntData(0)
+		}
+		card, err := datastructure.HLLCount(val)
+		if err != nil {
+			return resp.NewErrorData(err.Error())
+		}
+		return resp.NewIntData(int64(card))
+	}
+
+	// multiple keys: the cardinality of the union
+	keys := make([]string, 0, len(cmd)-1)
+	for _, k := range cmd[1:] {
+		m.CheckTTL(string(k))
+		keys = append(keys, string(k))
+	}
+	m.locks.RLockMulti(keys)
+	defer m.locks.RUnlockMulti(keys)
+	var regs datastructure.HLLRegisterSet
+	for _, key := range keys {
+		val, errData := m.getHLLValue(key)
+		if errData != nil {
+			return errData
+		}
+		if val == nil {
+			continue
+		}
+		if err := datastructure.HLLMergeRegisters(val, &regs); err != nil {
+			return resp.NewErrorData(err.Error())
+		}
+	}
+	return resp.NewIntData(int64(datastructure.HLLCountRegisters(&regs)))
+}
+
+func pfMergeHLL(m *MemDb, cmd [][]byte) resp.RedisData {
+	if strings.ToLower(string(cmd[0])) != "pfmerge" {
+		logger.Error("pfMergeHLL func: cmdName is not pfmerge")
+		return resp.NewErrorData("Server error")
+	}
+	if len(cmd) < 2 {
+		return resp.NewErrorData("ERR wrong number of arguments for 'pfmerge' command")
+	}
+	keys := make([]string, 0, len(cmd)-1)
+	for _, k := range cmd[1:] {
+		m.CheckTTL(string(k))
+		keys = append(keys, string(k))
+	}
+	m.locks.LockMulti(keys)
+	defer m.locks.UnlockMulti(keys)
+
+	// the destination is merged too, and it is dense if one of the inputs is dense
+	var regs datastructure.HLLRegisterSet
+	dense := false
+	for _, key := range keys {
+		val, errData := m.getHLLValue(key)
+		if errData != nil {
+			return errData
+		}
+		if val == nil {
+			continue
+		}
+		if err := datastructure.HLLMergeRegisters(val, &regs); err != nil {
+			return resp.NewErrorData(err.Error())
+		}
+		if datastructure.HLLEncoding(val) == datastructure.HLLDense {
+			dense = true
+		}
+	}
+	m.db.Set(keys[0], datastructure.HLLFromRegisters(&regs, dense))
+	return resp.NewStringData("OK")
+}
+
+func pfDebugHLL(m *MemDb, cmd [][]byte) resp.RedisData {
+	if strings.ToLower(string(cmd[0])) != "pfdebug" {
+		logger.Error("pfDebugHLL func: cmdName is not pfdebug")
+		return resp.NewErrorData("Server error")
+	}
+	if len(cmd) != 3 {
+		return resp.NewErrorData("ERR wrong number of arguments for 'pfdebug' command")
+	}
+	subCmd := strings.ToLower(string(cmd[1]))
+	key := string(cmd[2])
+	m.CheckTTL(key)
+	m.locks.Lock(key)
+	defer m.locks.Unlock(key)
+
+	val, errData := m.getHLLValue(key)
+	if errData != nil {
+		return errData
+	}
+	if val == nil {
+		return resp.NewErrorData("ERR The specified key does not exist")
+	}
+
+	switch subCmd {
+	case "getreg":
+		// like redis, GETREG converts the value to dense
+		dense, converted, err := datastructure.HLLToDense(val)
+		if err != nil {
+			return resp.NewErrorData(err.Error())
+		}
+		if converted {
+			m.db.Set(key, dense)
+		}
+		var regs datastructure.HLLRegisterSet
+		if err = datastructure.HLLGetRegisters(dense, &regs); err != nil {
+			return resp.NewErrorData(err.Error())
+		}
+		res := make([]resp.RedisData, len(regs))
+		for i, reg := range regs {
+			res[i] = resp.NewIntData(int64(reg))
+		}
+		return resp.NewArrayData(res)
+	case "decode":
+		decoded, err := datastructure.HLLDecodeSparse(val)
+		if err != nil {
+			return resp.NewErrorData(err.Error())
+		}
+		return resp.NewStringData(decoded)
+	case "encoding":
+		if datastructure.HLLEncoding(val) == datastructure.HLLDense {
+			return resp.NewStringData("dense")
+		}
+		return resp.NewStringData("sparse")
+	case "todense":
+		dense, converted, err := datastructure.HLLToDense(val)
+		if err != nil {
+			return resp.NewErrorData(err.Error())
+		}
+		if !converted {
+			return resp.NewIntData(0)
+		}
+		m.db.Set(key, dense)
+		return resp.NewIntData(1)
+	default:
+		return resp.NewErrorData(fmt.Sprintf("ERR Unknown PFDEBUG subcommand '%s'", cmd[1]))
+	}
+}
+
+func RegisterHyperLogLogCommands() {
+	RegisterCommand("pfadd", pfAddHLL)
+	// PFCOUNT only updates the cached cardinality
+	RegisterCommand("pfcount", pfCountHLL, flagReadOnly)
+	RegisterCommand("pfmerge", pfMergeHLL)
+	RegisterCommand("pfdebug", pfDebugHLL)
+}
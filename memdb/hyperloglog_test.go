@@ -0,0 +1,64 @@
+package memdb
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestPFAddCountMerge(t *testing.T) {
+	mem := newTestDb()
+
+	if res := exec(mem, "pfadd", "h1", "a", "b", "c", "d", "e", "f", "g"); res != ":1\r\n" {
+		t.Errorf("pfadd reply %q", res)
+	}
+	if res := exec(mem, "pfadd", "h1", "a"); res != ":0\r\n" {
+		t.Errorf("pfadd existing element reply %q", res)
+	}
+	if res := exec(mem, "pfcount", "h1"); res != ":7\r\n" {
+		t.Errorf("pfcount reply %q", res)
+	}
+	if res := exec(mem, "pfadd", "empty"); res != ":1\r\n" {
+		t.Errorf("pfadd without elements reply %q", res)
+	}
+	exec(mem, "pfadd", "h2", "f", "g", "h", "i")
+	if res := exec(mem, "pfcount", "h1", "h2", "nokey"); res != ":9\r\n" {
+		t.Errorf("pfcount union reply %q", res)
+	}
+	if res := exec(mem, "pfmerge", "h3", "h1", "h2"); res != "+OK\r\n" {
+		t.Errorf("pfmerge reply %q", res)
+	}
+	if res := exec(mem, "pfcount", "h3"); res != ":9\r\n" {
+		t.Errorf("pfcount merged reply %q", res)
+	}
+
+	exec(mem, "set", "str", "value")
+	if res := exec(mem, "pfadd", "str", "a"); !strings.HasPrefix(res, "-WRONGTYPE Key is not a valid HyperLogLog") {
+		t.Errorf("pfadd on a string reply %q", res)
+	}
+}
+
+func TestPFDebug(t *testing.T) {
+	mem := newTestDb()
+	exec(mem, "pfadd", "h", "a")
+
+	if res := exec(mem, "pfdebug", "encoding", "h"); res != "+sparse\r\n" {
+		t.Errorf("pfdebug encoding reply %q", res)
+	}
+	if res := exec(mem, "pfdebug", "decode", "h"); !strings.HasPrefix(res, "+") || !strings.Contains(res, "v:") {
+		t.Errorf("pfdebug decode reply %q", res)
+	}
+	if res := exec(mem, "pfdebug", "todense", "h"); res != ":1\r\n" {
+		t.Errorf("pfdebug todense reply %q", res)
+	}
+	if res := exec(mem, "pfdebug", "todense", "h"); res != ":0\r\n" {
+		t.Errorf("pfdebug todense twice reply %q", res)
+	}
+	res := exec(mem, "pfdebug", "getreg", "h")
+	if !strings.HasPrefix(res, "*"+strconv.Itoa(16384)+"\r\n") || strings.Count(res, ":0\r\n") != 16383 {
+		t.Errorf("pfdebug getreg reply has %d zero registers", strings.Count(res, ":0\r\n"))
+	}
+	if res = exec(mem, "pfcount", "h"); res != ":1\r\n" {
+		t.Errorf("pfcount dense reply %q", res)
+	}
+}
@@ -110,11 +110,11 @@ func expireKey(m *MemDb, cmd [][]byte) resp.RedisData {
 			res = m.SetTTL(key, ttl)
 		}
 	case "gt":
-		if v, ok := m.ttlKeys.Get(key); ok && ttl > v.(int64) {
+		if v, ok := m.ttlKeys.Get(key); ok && time.Now().Unix()+ttl > v.(int64) {
 			res = m.SetTTL(key, ttl)
 		}
 	case "lt":
-		if v, ok := m.ttlKeys.Get(key); ok && ttl < v.(int64) {
+		if v, ok := m.ttlKeys.Get(key); ok && time.Now().Unix()+ttl < v.(int64) {
 			res = m.SetTTL(key, ttl)
 		}
 	default:
@@ -186,7 +186,7 @@ func typeKey(m *MemDb, cmd [][]byte) resp.RedisData {
 
 	v, ok := m.db.Get(key)
 	if !ok {
-		resp.NewStringData("none")
+		return resp.NewStringData("none")
 	}
 	switch v.(type) {
 	case []byte:
@@ -222,17 +222,198 @@ func renameKey(m *MemDb, cmd [][]byte) resp.RedisData {
 	if !ok {
 		return resp.NewErrorData(fmt.Sprintf("error: %s not exist", oldName))
 	}
+	m.moveKey(oldName, newName, oldVal)
+	return resp.NewStringData("OK")
+}
+
+// moveKey moves val and the ttl of oldName to newName, replacing newName.
+// The timewheel tasks of both names are cancelled and the ttl is rescheduled for newName.
+// The caller must hold the locks of both keys.
+func (m *MemDb) moveKey(oldName, newName string, val any) {
+	ttl, hasTTL := m.ttlKeys.Get(oldName)
 	m.db.Delete(oldName)
-	m.ttlKeys.Delete(oldName)
+	m.DelTTL(oldName)
 	m.db.Delete(newName)
-	m.ttlKeys.Delete(newName)
-	m.db.Set(newName, oldVal)
-	ttl, ok := m.ttlKeys.Get(oldName)
-	if ok {
-		m.ttlKeys.Set(newName, ttl.(int64))
+	m.DelTTL(newName)
+	m.db.Set(newName, val)
+	if hasTTL {
+		m.SetTTLAt(newName, ttl.(int64))
+	}
+	if hash, ok := val.(*datastructure.Hash); ok {
+		m.scheduleHashFields(newName, hash)
+	}
+	if _, ok := val.(*datastructure.SortSet); ok {
+		m.signalKey(newName)
 	}
+}
 
-	return resp.NewStringData("OK")
+func renameNxKey(m *MemDb, cmd [][]byte) resp.RedisData {
+	cmdName := strings.ToLower(string(cmd[0]))
+	if cmdName != "renamenx" || len(cmd) != 3 {
+		logger.Error("renameNxKey Function: cmdName is not renamenx or command args number is invalid")
+		return resp.NewErrorData("error: cmdName is not renamenx or command args number is invalid")
+	}
+	oldName, newName := string(cmd[1]), string(cmd[2])
+	if !m.CheckTTL(oldName) {
+		return resp.NewErrorData(fmt.Sprintf("error: %s not exist", oldName))
+	}
+	m.CheckTTL(newName)
+
+	m.locks.LockMulti([]string{oldName, newName})
+	defer m.locks.UnlockMulti([]string{oldName, newName})
+	oldVal, ok := m.db.Get(oldName)
+	if !ok {
+		return resp.NewErrorData(fmt.Sprintf("error: %s not exist", oldName))
+	}
+	if _, ok = m.db.Get(newName); ok {
+		return resp.NewIntData(0)
+	}
+	m.moveKey(oldName, newName, oldVal)
+	return resp.NewIntData(1)
+}
+
+// copyValue returns a deep copy of a db value, so the copy doesn't share any memory with val
+func copyValue(val any) (any, bool) {
+	switch v := val.(type) {
+	case []byte:
+		return append([]byte(nil), v...), true
+	case *datastructure.List:
+		return v.Copy(), true
+	case *datastructure.Set:
+		return v.Copy(), true
+	case *datastructure.Hash:
+		return v.Copy(), true
+	case *datastructure.SortSet:
+		return v.Copy(), true
+	}
+	return nil, false
+}
+
+func copyKey(m *MemDb, cmd [][]byte) resp.RedisData {
+	cmdName := strings.ToLower(string(cmd[0]))
+	if cmdName != "copy" || len(cmd) < 3 {
+		logger.Error("copyKey Function: cmdName is not copy or command args number is invalid")
+		return resp.NewErrorData("error: cmdName is not copy or command args number is invalid")
+	}
+	var replace bool
+	for i := 3; i < len(cmd); i++ {
+		switch strings.ToLower(string(cmd[i])) {
+		case "replace":
+			replace = true
+		case "db":
+			if i+1 >= len(cmd) {
+				return resp.NewErrorData("ERR syntax error")
+			}
+			db, err := strconv.Atoi(string(cmd[i+1]))
+			if err != nil {
+				return resp.NewErrorData("ERR value is not an integer or out of range")
+			}
+			// there is only the database 0
+			if db != 0 {
+				return resp.NewErrorData("ERR DB index is out of range")
+			}
+			i++
+		default:
+			return resp.NewErrorData("ERR syntax error")
+		}
+	}
+	src, dst := string(cmd[1]), string(cmd[2])
+	if src == dst {
+		return resp.NewErrorData("ERR source and destination objects are the same")
+	}
+	if !m.CheckTTL(src) {
+		return resp.NewIntData(0)
+	}
+	m.CheckTTL(dst)
+
+	m.locks.LockMulti([]string{src, dst})
+	defer m.locks.UnlockMulti([]string{src, dst})
+	val, ok := m.db.Get(src)
+	if !ok {
+		return resp.NewIntData(0)
+	}
+	if _, ok = m.db.Get(dst); ok && !replace {
+		return resp.NewIntData(0)
+	}
+	newVal, ok := copyValue(val)
+	if !ok {
+		logger.Error("copyKey Function: unknown value type of key %s", src)
+		return resp.NewErrorData("server error")
+	}
+	m.db.Delete(dst)
+	m.DelTTL(dst)
+	m.db.Set(dst, newVal)
+	if ttl, ok := m.ttlKeys.Get(src); ok {
+		m.SetTTLAt(dst, ttl.(int64))
+	}
+	if hash, ok := newVal.(*datastructure.Hash); ok {
+		m.scheduleHashFields(dst, hash)
+	}
+	if _, ok := newVal.(*datastructure.SortSet); ok {
+		m.signalKey(dst)
+	}
+	return resp.NewIntData(1)
+}
+
+func touchKey(m *MemDb, cmd [][]byte) resp.RedisData {
+	cmdName := strings.ToLower(string(cmd[0]))
+	if cmdName != "touch" || len(cmd) < 2 {
+		logger.Error("touchKey Function: cmdName is not touch or command args number is invalid")
+		return resp.NewErrorData("error: cmdName is not touch or command args number is invalid")
+	}
+	touched := 0
+	for _, keyByte := range cmd[1:] {
+		key := string(keyByte)
+		if m.CheckTTL(key) {
+			m.locks.RLock(key)
+			if _, ok := m.db.Get(key); ok {
+				touched++
+			}
+			m.locks.RUnlock(key)
+		}
+	}
+	return resp.NewIntData(int64(touched))
+}
+
+func randomKey(m *MemDb, cmd [][]byte) resp.RedisData {
+	cmdName := strings.ToLower(string(cmd[0]))
+	if cmdName != "randomkey" || len(cmd) != 1 {
+		logger.Error("randomKey Function: cmdName is not randomkey or command args number is invalid")
+		return resp.NewErrorData("error: cmdName is not randomkey or command args number is invalid")
+	}
+	// CheckTTL deletes the expired keys, so this ends when a live key is found or the db is empty
+	for {
+		key, ok := m.db.RandomKey()
+		if !ok {
+			return resp.NewBulkData(nil)
+		}
+		if m.CheckTTL(key) {
+			return resp.NewBulkData([]byte(key))
+		}
+	}
+}
+
+func expireTimeKey(m *MemDb, cmd [][]byte) resp.RedisData {
+	cmdName := strings.ToLower(string(cmd[0]))
+	if cmdName != "expiretime" || len(cmd) != 2 {
+		logger.Error("expireTimeKey error: cmdName is not expiretime or command args number is not 2")
+		return resp.NewErrorData("error: cmdName is not expiretime or command args number is not 2")
+	}
+	key := string(cmd[1])
+	if !m.CheckTTL(key) {
+		return resp.NewIntData(int64(-2))
+	}
+	m.locks.RLock(key)
+	defer m.locks.RUnlock(key)
+
+	if _, ok := m.db.Get(key); !ok {
+		return resp.NewIntData(int64(-2))
+	}
+	ttl, ok := m.ttlKeys.Get(key)
+	if !ok {
+		return resp.NewIntData(int64(-1))
+	}
+	return resp.NewIntData(ttl.(int64))
 }
 
 func pingKeys(m *MemDb, cmd [][]byte) resp.RedisData {
@@ -254,13 +435,18 @@ func pingKeys(m *MemDb, cmd [][]byte) resp.RedisData {
 }
 
 func RegisterKeyCommands() {
-	RegisterCommand("ping", pingKeys)
+	RegisterCommand("ping", pingKeys, flagReadOnly)
 	RegisterCommand("del", delKey)
-	RegisterCommand("exists", existsKey)
-	RegisterCommand("keys", keysKey)
+	RegisterCommand("exists", existsKey, flagReadOnly, flagNoTouch)
+	RegisterCommand("keys", keysKey, flagReadOnly)
 	RegisterCommand("expire", expireKey)
 	RegisterCommand("persist", persistKey)
-	RegisterCommand("ttl", ttlKey)
-	RegisterCommand("type", typeKey)
+	RegisterCommand("ttl", ttlKey, flagReadOnly, flagNoTouch)
+	RegisterCommand("type", typeKey, flagReadOnly, flagNoTouch)
 	RegisterCommand("rename", renameKey)
+	RegisterCommand("renamenx", renameNxKey)
+	RegisterCommand("copy", copyKey)
+	RegisterCommand("touch", touchKey, flagReadOnly)
+	RegisterCommand("randomkey", randomKey, flagReadOnly)
+	RegisterCommand("expiretime", expireTimeKey, flagReadOnly, flagNoTouch)
 }
@@ -2,16 +2,12 @@ package memdb
 
 import (
 	"bytes"
-	"easyRedis/config"
+	"easyRedis/datastructure"
 	"fmt"
 	"testing"
 	"time"
 )
 
-func init() {
-	config.Configures = &config.Config{ShardNum: 100}
-}
-
 func TestDelKey(t *testing.T) {
 	memdb := NewMemDb()
 	memdb.db.Set("a", "a")
@@ -75,3 +71,159 @@ func TestExpireKey(t *testing.T) {
 		t.Error("ttl set incorrect")
 	}
 }
+
+// GT and LT compare the new expire time with the deadline of the key, not the ttl
+func TestExpireGtLtKey(t *testing.T) {
+	memdb := newTestDb()
+	memdb.db.Set("a", []byte("a"))
+	exec(memdb, "expire", "a", "100")
+
+	if res := exec(memdb, "expire", "a", "50", "gt"); res != ":0\r\n" {
+		t.Errorf("expire gt with a shorter ttl reply %q", res)
+	}
+	if res := exec(memdb, "expire", "a", "50", "lt"); res != ":1\r\n" {
+		t.Errorf("expire lt with a shorter ttl reply %q", res)
+	}
+	if res := exec(memdb, "expire", "a", "80", "lt"); res != ":0\r\n" {
+		t.Errorf("expire lt with a longer ttl reply %q", res)
+	}
+	attl, _ := memdb.ttlKeys.Get("a")
+	if attl.(int64)-time.Now().Unix() > 50 || attl.(int64)-time.Now().Unix() < 49 {
+		t.Error("ttl set incorrect")
+	}
+}
+
+func TestTypeKey(t *testing.T) {
+	memdb := newTestDb()
+	memdb.db.Set("a", []byte("a"))
+	if res := exec(memdb, "type", "a"); res != "+string\r\n" {
+		t.Errorf("type of a string reply %q", res)
+	}
+	if res := exec(memdb, "type", "nokey"); res != "+none\r\n" {
+		t.Errorf("type of a missing key reply %q", res)
+	}
+}
+
+func TestRenameKey(t *testing.T) {
+	memdb := newTestDb()
+	exec(memdb, "set", "a", "1")
+	exec(memdb, "expire", "a", "100")
+	exec(memdb, "set", "b", "2")
+	exec(memdb, "expire", "b", "5")
+
+	if res := exec(memdb, "rename", "a", "b"); res != "+OK\r\n" {
+		t.Errorf("rename reply %q", res)
+	}
+	if _, ok := memdb.db.Get("a"); ok {
+		t.Error("rename must delete the old key")
+	}
+	if ttl, ok := memdb.ttlKeys.Get("b"); !ok || ttl.(int64)-time.Now().Unix() < 99 {
+		t.Error("rename must carry the ttl of the old key")
+	}
+	if _, ok := memdb.ttlKeys.Get("a"); ok {
+		t.Error("rename must remove the ttl of the old name")
+	}
+
+	exec(memdb, "set", "c", "3")
+	if res := exec(memdb, "renamenx", "b", "c"); res != ":0\r\n" {
+		t.Errorf("renamenx on an existing key reply %q", res)
+	}
+	if res := exec(memdb, "renamenx", "b", "d"); res != ":1\r\n" {
+		t.Errorf("renamenx reply %q", res)
+	}
+	if ttl, ok := memdb.ttlKeys.Get("d"); !ok || ttl.(int64)-time.Now().Unix() < 99 {
+		t.Error("renamenx must carry the ttl")
+	}
+	if res := exec(memdb, "renamenx", "nokey", "e"); res != "-error: nokey not exist\r\n" {
+		t.Errorf("renamenx on a missing key reply %q", res)
+	}
+}
+
+func TestCopyKey(t *testing.T) {
+	memdb := newTestDb()
+	exec(memdb, "set", "s", "abc")
+	exec(memdb, "expire", "s", "100")
+	list := datastructure.NewList()
+	list.RPush([]byte("a"))
+	list.RPush([]byte("b"))
+	memdb.db.Set("l", list)
+	exec(memdb, "hset", "h", "f", "v")
+	exec(memdb, "sadd", "set", "m")
+	exec(memdb, "zadd", "z", "1", "m")
+
+	for _, key := range []string{"s", "l", "h", "set", "z"} {
+		if res := exec(memdb, "copy", key, key+"2"); res != ":1\r\n" {
+			t.Errorf("copy %s reply %q", key, res)
+		}
+	}
+	if ttl, ok := memdb.ttlKeys.Get("s2"); !ok || ttl.(int64)-time.Now().Unix() < 99 {
+		t.Error("copy must copy the ttl")
+	}
+	// the copies are independent from the sources
+	exec(memdb, "append", "s", "d")
+	exec(memdb, "rpush", "l", "c")
+	exec(memdb, "hset", "h", "f", "w")
+	exec(memdb, "sadd", "set", "n")
+	exec(memdb, "zadd", "z", "2", "m")
+	if res := exec(memdb, "get", "s2"); res != "$3\r\nabc\r\n" {
+		t.Errorf("copied string %q", res)
+	}
+	if res := exec(memdb, "llen", "l2"); res != ":2\r\n" {
+		t.Errorf("copied list len %q", res)
+	}
+	if res := exec(memdb, "hget", "h2", "f"); res != "$1\r\nv\r\n" {
+		t.Errorf("copied hash %q", res)
+	}
+	if res := exec(memdb, "scard", "set2"); res != ":1\r\n" {
+		t.Errorf("copied set card %q", res)
+	}
+	if res := exec(memdb, "zscore", "z2", "m"); res != ":1\r\n" {
+		t.Errorf("copied sorted set score %q", res)
+	}
+
+	if res := exec(memdb, "copy", "s", "s2"); res != ":0\r\n" {
+		t.Errorf("copy to an existing key reply %q", res)
+	}
+	if res := exec(memdb, "copy", "s", "s2", "db", "0", "replace"); res != ":1\r\n" {
+		t.Errorf("copy replace reply %q", res)
+	}
+	if res := exec(memdb, "get", "s2"); res != "$4\r\nabcd\r\n" {
+		t.Errorf("replaced string %q", res)
+	}
+	if res := exec(memdb, "copy", "s", "s3", "db", "1"); res != "-ERR DB index is out of range\r\n" {
+		t.Errorf("copy to another db reply %q", res)
+	}
+	if res := exec(memdb, "copy", "nokey", "s3"); res != ":0\r\n" {
+		t.Errorf("copy of a missing key reply %q", res)
+	}
+}
+
+func TestTouchRandomExpireTimeKey(t *testing.T) {
+	memdb := newTestDb()
+	if res := exec(memdb, "randomkey"); res != "$-1\r\n" {
+		t.Errorf("randomkey on an empty db reply %q", res)
+	}
+	exec(memdb, "set", "a", "1")
+	exec(memdb, "set", "b", "2")
+	// an expired key is never returned
+	memdb.ttlKeys.Set("b", time.Now().Unix()-1)
+	for i := 0; i < 10; i++ {
+		if res := exec(memdb, "randomkey"); res != "$1\r\na\r\n" {
+			t.Fatalf("randomkey reply %q", res)
+		}
+	}
+	if res := exec(memdb, "touch", "a", "b", "c"); res != ":1\r\n" {
+		t.Errorf("touch reply %q", res)
+	}
+
+	if res := exec(memdb, "expiretime", "a"); res != ":-1\r\n" {
+		t.Errorf("expiretime without ttl reply %q", res)
+	}
+	if res := exec(memdb, "expiretime", "nokey"); res != ":-2\r\n" {
+		t.Errorf("expiretime of a missing key reply %q", res)
+	}
+	exec(memdb, "expire", "a", "100")
+	if res := exec(memdb, "expiretime", "a"); res != fmt.Sprintf(":%d\r\n", time.Now().Unix()+100) {
+		t.Errorf("expiretime reply %q", res)
+	}
+}
@@ -0,0 +1,224 @@
+package memdb
+
+import (
+	"easyRedis/config"
+	"easyRedis/datastructure"
+	"math/rand"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// keyspace holds the key:value pairs of a MemDb, each value is stored in a keyEntry with the access stats of its key.
+// Reads and writes don't change the access stats: the keys read or overwritten through a view made by
+// tracking are touched once by touchAccessed, after the command using the view returns.
+// A view made by recording keeps the keys used through it in used, the value is true if the key was set or deleted.
+type keyspace struct {
+	*datastructure.ConcurrentMap
+	used     map[string]bool
+	accessed *[]string
+}
+
+// keyEntry is a value and the access stats of its key
+type keyEntry struct {
+	val    any
+	access keyAccess
+}
+
+// the logarithmic access counter works like the LFU counter of redis with the default
+// lfu-log-factor and lfu-decay-time
+const (
+	// lfuInitVal is the counter of a new key, so that it is not evicted before it is accessed again
+	lfuInitVal = 5
+	lfuMaxVal  = 255
+	// the higher lfuLogFactor is, the more accesses are needed to increment the counter
+	lfuLogFactor = 10
+	// the counter is decremented once every lfuDecayMinutes minutes since the last access
+	lfuDecayMinutes = 1
+)
+
+// keyAccess is the last access time of a key in unix milliseconds shifted left by 8 bits,
+// with the access counter in the lowest 8 bits
+type keyAccess struct {
+	stamp atomic.Uint64
+}
+
+func newKeyspace() *keyspace {
+	return &keyspace{ConcurrentMap: datastructure.NewConcurrentMap(config.Configures.ShardNum)}
+}
+
+// tracking returns a view of the keyspace that keeps the existing keys read or overwritten through it
+func (ks *keyspace) tracking() *keyspace {
+	return &keyspace{ConcurrentMap: ks.ConcurrentMap, used: ks.used, accessed: new([]string)}
+}
+
+// recording returns a view of the keyspace that records the keys used through it,
+// the values got from it may be changed in place, so every key read is recorded too
+func (ks *keyspace) recording() *keyspace {
+	return &keyspace{ConcurrentMap: ks.ConcurrentMap, used: make(map[string]bool), accessed: ks.accessed}
+}
+
+// record marks key as used, written is true if key is set or deleted
+func (ks *keyspace) record(key string, written bool) {
+	if ks.used != nil {
+		ks.used[key] = ks.used[key] || written
+	}
+}
+
+// access marks key as accessed by the command using a tracking view
+func (ks *keyspace) access(key string) {
+	if ks.accessed != nil {
+		*ks.accessed = append(*ks.accessed, key)
+	}
+}
+
+// touchAccessed updates the access stats of the keys accessed through a tracking view, once per key
+func (ks *keyspace) touchAccessed() {
+	keys := *ks.accessed
+	sort.Strings(keys)
+	for i, key := range keys {
+		if i > 0 && key == keys[i-1] {
+			continue
+		}
+		if e := ks.entry(key); e != nil {
+			e.access.touch()
+		}
+	}
+	*ks.accessed = keys[:0]
+}
+
+func (ks *keyspace) Get(key string) (any, bool) {
+	ks.record(key, false)
+	e := ks.entry(key)
+	if e == nil {
+		return nil, false
+	}
+	ks.access(key)
+	return e.val, true
+}
+
+// Peek returns the value of key without marking it accessed, for the internal housekeeping
+func (ks *keyspace) Peek(key string) (any, bool) {
+	ks.record(key, false)
+	if e := ks.entry(key); e != nil {
+		return e.val, true
+	}
+	return nil, false
+}
+
+// Set sets the value of key, the access stats of an existing key are kept
+func (ks *keyspace) Set(key string, val any) int {
+	ks.record(key, true)
+	e := &keyEntry{val: val}
+	old := ks.entry(key)
+	if old != nil {
+		e.access.stamp.Store(old.access.stamp.Load())
+		ks.access(key)
+	} else {
+		e.access.init()
+	}
+	ks.ConcurrentMap.Set(key, e)
+	if old != nil {
+		return 0
+	}
+	return 1
+}
+
+func (ks *keyspace) SetIfNotExist(key string, val any) int {
+	ks.record(key, true)
+	e := &keyEntry{val: val}
+	e.access.init()
+	return ks.ConcurrentMap.SetIfNotExist(key, e)
+}
+
+func (ks *keyspace) Delete(key string) int {
+	ks.record(key, true)
+	return ks.ConcurrentMap.Delete(key)
+}
+
+// entry returns the entry of key, nil if key doesn't exist
+func (ks *keyspace) entry(key string) *keyEntry {
+	if e, ok := ks.ConcurrentMap.Get(key); ok {
+		return e.(*keyEntry)
+	}
+	return nil
+}
+
+// stats returns the access stats of key, nil if key doesn't exist
+func (ks *keyspace) stats(key string) *keyAccess {
+	if e := ks.entry(key); e != nil {
+		return &e.access
+	}
+	return nil
+}
+
+// init sets the access stats of a new key
+func (a *keyAccess) init() {
+	a.stamp.Store(uint64(time.Now().UnixMilli())<<8 | lfuInitVal)
+}
+
+// touch sets the last access time to now, and increments the decayed counter logarithmically
+func (a *keyAccess) touch() {
+	now := time.Now().UnixMilli()
+	for {
+		old := a.stamp.Load()
+		counter := lfuLogIncr(decayedCounter(old, now))
+		if a.stamp.CompareAndSwap(old, uint64(now)<<8|uint64(counter)) {
+			return
+		}
+	}
+}
+
+// restore sets the access stats of a restored key: the last access is idle ago,
+// and the counter is freq, unless it is negative
+func (a *keyAccess) restore(idle time.Duration, freq int) {
+	last := uint64(time.Now().Add(-idle).UnixMilli())
+	counter := a.stamp.Load() & 0xff
+	if freq >= 0 {
+		counter = uint64(freq)
+	}
+	a.stamp.Store(last<<8 | counter)
+}
+
+// idleTime returns the time since the last access
+func (a *keyAccess) idleTime() time.Duration {
+	last := int64(a.stamp.Load() >> 8)
+	idle := time.Duration(time.Now().UnixMilli()-last) * time.Millisecond
+	if idle < 0 {
+		return 0
+	}
+	return idle
+}
+
+// freq returns the access counter decayed to now
+func (a *keyAccess) freq() int {
+	return decayedCounter(a.stamp.Load(), time.Now().UnixMilli())
+}
+
+// decayedCounter returns the counter of stamp decremented by the decay periods elapsed until now
+func decayedCounter(stamp uint64, now int64) int {
+	counter := int(stamp & 0xff)
+	periods := (now - int64(stamp>>8)) / (lfuDecayMinutes * time.Minute.Milliseconds())
+	if periods <= 0 {
+		return counter
+	}
+	if periods >= int64(counter) {
+		return 0
+	}
+	return counter - int(periods)
+}
+
+// lfuLogIncr increments counter with a probability that decreases as counter grows
+func lfuLogIncr(counter int) int {
+	if counter == lfuMaxVal {
+		return counter
+	}
+	base := counter - lfuInitVal
+	if base < 0 {
+		base = 0
+	}
+	if rand.Float64() < 1/float64(base*lfuLogFactor+1) {
+		counter++
+	}
+	return counter
+}
@@ -76,12 +76,11 @@ func lPosList(m *MemDb, cmd [][]byte) resp.RedisData {
 		return resp.NewErrorData("wrong number of arguments for 'lpos' command")
 	}
 
-	var rank, count, maxLen, reverse bool
+	var rank, count, maxLen bool
 	var rankVal, countVal, maxLenVal int
 	var key string
 	var elem []byte
 	var err error
-	var pos int
 
 	key = string(cmd[1])
 	elem = cmd[2]
@@ -126,124 +125,51 @@ func lPosList(m *MemDb, cmd [][]byte) resp.RedisData {
 	if list.Len == 0 {
 		return resp.NewBulkData(nil)
 	}
-	if count && countVal == 0 {
+	if !count {
+		countVal = 1
+	} else if countVal == 0 {
 		countVal = list.Len
 	}
-	if maxLen && maxLenVal == 0 {
+	if !rank {
+		rankVal = 1
+	}
+	if !maxLen || maxLenVal == 0 {
 		maxLenVal = list.Len
 	}
 
-	// normal pos without options
-	if !rank && !count && !maxLen {
-		pos := list.Pos(elem)
-		if pos == -1 {
-			return resp.NewBulkData(nil)
-		} else {
-			return resp.NewIntData(int64(pos))
+	// skip the first rank-1 matches from the head, or the tail if rank is negative
+	res := make([]resp.RedisData, 0)
+	scanned := 0
+	match := func(index int, val []byte) bool {
+		if scanned == maxLenVal {
+			return false
 		}
-	}
-
-	//handle options
-	var cur *datastructure.ListNode
-	if rank {
-		if rankVal > 0 {
-			pos = -1
-			for cur = list.Head.Next; cur != list.Tail; cur = cur.Next {
-				pos++
-				if bytes.Equal(cur.Val, elem) {
-					rankVal--
-				}
-				if maxLen {
-					maxLenVal--
-					if maxLenVal == 0 {
-						break
-					}
-				}
-				if rankVal == 0 {
-					break
-				}
-			}
-		} else {
-			reverse = true
-			pos = list.Len
-			for cur = list.Tail.Prev; cur != list.Head; cur = cur.Prev {
-				pos--
-				if bytes.Equal(cur.Val, elem) {
-					rankVal++
-				}
-				if maxLen {
-					maxLenVal--
-					if maxLenVal == 0 {
-						break
-					}
-				}
-				if rankVal == 0 {
-					break
-				}
-			}
+		scanned++
+		if !bytes.Equal(val, elem) {
+			return true
 		}
-	} else {
-		cur = list.Head.Next
-		pos = 0
-		if maxLen {
-			maxLenVal--
+		if rankVal > 1 {
+			rankVal--
+			return true
+		} else if rankVal < -1 {
+			rankVal++
+			return true
 		}
+		res = append(res, resp.NewIntData(int64(index)))
+		return len(res) < countVal
 	}
-
-	// when rank is out of range, return nil
-	if (rank && rankVal != 0) || cur == list.Tail || cur == list.Head {
-		return resp.NewBulkData(nil)
-	}
-	res := make([]resp.RedisData, 0)
-	if !count {
-		// if count is not set, return first find pos inside maxLen range
-		for ; cur != list.Tail; cur = cur.Next {
-			if bytes.Equal(cur.Val, elem) {
-				return resp.NewIntData(int64(pos))
-			}
-			pos++
-			if maxLen {
-				if maxLenVal <= 0 {
-					break
-				}
-				maxLenVal--
-			}
-		}
-		return resp.NewBulkData(nil)
+	if rankVal > 0 {
+		list.ForEach(match)
 	} else {
-		if !reverse {
-			for ; cur != list.Tail && countVal != 0; cur = cur.Next {
-				if bytes.Equal(cur.Val, elem) {
-					res = append(res, resp.NewIntData(int64(pos)))
-					countVal--
-				}
-				pos++
-				if maxLen {
-					if maxLenVal <= 0 {
-						break
-					}
-					maxLenVal--
-				}
-			}
-		} else {
-			for ; cur != list.Head && countVal != 0; cur = cur.Prev {
-				if bytes.Equal(cur.Val, elem) {
-					res = append(res, resp.NewIntData(int64(pos)))
-					countVal--
-				}
-				pos--
-				if maxLen {
-					if maxLenVal <= 0 {
-						break
-					}
-					maxLenVal--
-				}
-			}
-		}
+		list.ReverseForEach(match)
 	}
+
 	if len(res) == 0 {
 		return resp.NewBulkData(nil)
 	}
+	if !count {
+		return res[0]
+	}
 	return resp.NewArrayData(res)
 }
 
@@ -252,56 +178,7 @@ func lPopList(m *MemDb, cmd [][]byte) resp.RedisData {
 		logger.Error("lPopList: command is not lpop")
 		return resp.NewErrorData("server error")
 	}
-	if len(cmd) != 2 && len(cmd) != 3 {
-		return resp.NewErrorData("wrong number of arguments for 'lpop' command")
-	}
-	var cnt int
-	var err error
-	if len(cmd) == 3 {
-		cnt, err = strconv.Atoi(string(cmd[2]))
-		if err != nil || cnt < 0 {
-			return resp.NewErrorData("count value must be a positive integer")
-		}
-	}
-	key := string(cmd[1])
-	m.locks.Lock(key)
-	defer m.locks.Unlock(key)
-
-	temp, ok := m.db.Get(key)
-	if !ok || (len(cmd) == 3 && cnt == 0) {
-		return resp.NewBulkData(nil)
-	}
-	list, ok := temp.(*datastructure.List)
-	if !ok {
-		return resp.NewErrorData("WRONGTYPE Operation against a key holding the wrong kind of value")
-	}
-
-	// 当list的长度为0时删除，这是有必要的
-	defer func() {
-		if list.Len == 0 {
-			m.db.Delete(key)
-			m.DelTTL(key)
-		}
-	}()
-
-	// if cnt is not set, return the first element
-	if cnt == 0 && len(cmd) == 2 {
-		e := list.LPop()
-		if e == nil {
-			return resp.NewBulkData(nil)
-		}
-		return resp.NewBulkData(e.Val)
-	}
-	// return cnt number elements as array
-	res := make([]resp.RedisData, 0)
-	for i := 0; i < cnt; i++ {
-		e := list.LPop()
-		if e == nil {
-			break
-		}
-		res = append(res, resp.NewBulkData(e.Val))
-	}
-	return resp.NewArrayData(res)
+	return popList(m, cmd, true)
 }
 
 func rPopList(m *MemDb, cmd [][]byte) resp.RedisData {
@@ -309,58 +186,77 @@ func rPopList(m *MemDb, cmd [][]byte) resp.RedisData {
 		logger.Error("rPopList: command is not rpop")
 		return resp.NewErrorData("server error")
 	}
+	return popList(m, cmd, false)
+}
+
+// popList implements LPOP and RPOP.
+// Without count it replies an element or nil, with count an array, or a nil array if the key doesn't exist.
+func popList(m *MemDb, cmd [][]byte, left bool) resp.RedisData {
 	if len(cmd) != 2 && len(cmd) != 3 {
-		return resp.NewErrorData("wrong number of arguments for 'rpop' command")
+		return resp.NewErrorData(fmt.Sprintf("wrong number of arguments for '%s' command", strings.ToLower(string(cmd[0]))))
 	}
-
-	var cnt int
-	var err error
-
-	if len(cmd) == 3 {
+	withCount := len(cmd) == 3
+	cnt := 1
+	if withCount {
+		var err error
 		cnt, err = strconv.Atoi(string(cmd[2]))
 		if err != nil || cnt < 0 {
-			return resp.NewErrorData("count value must be a positive integer")
+			return resp.NewErrorData("ERR value is out of range, must be positive")
 		}
 	}
-
 	key := string(cmd[1])
+	m.CheckTTL(key)
 	m.locks.Lock(key)
 	defer m.locks.Unlock(key)
 
 	temp, ok := m.db.Get(key)
-	if !ok || (len(cmd) == 3 && cnt == 0) {
+	if !ok {
+		if withCount {
+			return resp.NewArrayData(nil)
+		}
 		return resp.NewBulkData(nil)
 	}
 	list, ok := temp.(*datastructure.List)
-	// if cnt is not set, return last element
 	if !ok {
 		return resp.NewErrorData("WRONGTYPE Operation against a key holding the wrong kind of value")
 	}
 
-	defer func() {
-		if list.Len == 0 {
-			m.db.Delete(key)
-			m.DelTTL(key)
-		}
-	}()
-	if cnt == 0 {
-		e := list.RPop()
-		if e == nil {
+	vals := m.popElements(key, list, left, cnt)
+	if !withCount {
+		if len(vals) == 0 {
 			return resp.NewBulkData(nil)
 		}
-		return resp.NewBulkData(e.Val)
+		return resp.NewBulkData(vals[0])
+	}
+	res := make([]resp.RedisData, len(vals))
+	for i, val := range vals {
+		res[i] = resp.NewBulkData(val)
 	}
+	return resp.NewArrayData(res)
+}
 
-	// return cnt number elements as array
-	res := make([]resp.RedisData, 0)
-	for i := 0; i < cnt; i++ {
-		e := list.RPop()
+// popElements pops at most count elements of list, the key is deleted when the list becomes empty.
+// The caller must hold the lock of key.
+func (m *MemDb) popElements(key string, list *datastructure.List, left bool, count int) [][]byte {
+	vals := make([][]byte, 0)
+	for i := 0; i < count; i++ {
+		var e *datastructure.ListNode
+		if left {
+			e = list.LPop()
+		} else {
+			e = list.RPop()
+		}
 		if e == nil {
 			break
 		}
-		res = append(res, resp.NewBulkData(e.Val))
+		vals = append(vals, e.Val)
 	}
-	return resp.NewArrayData(res)
+	// 当list的长度为0时删除，这是有必要的
+	if list.Len == 0 {
+		m.db.Delete(key)
+		m.DelTTL(key)
+	}
+	return vals
 }
 
 func lPushList(m *MemDb, cmd [][]byte) resp.RedisData {
@@ -376,13 +272,12 @@ func lPushList(m *MemDb, cmd [][]byte) resp.RedisData {
 	m.locks.Lock(key)
 	defer m.locks.Unlock(key)
 
-	var list *datastructure.List
 	temp, ok := m.db.Get(key)
 	if !ok {
-		list = datastructure.NewList()
-		m.db.Set(key, list)
+		temp = datastructure.NewList()
+		m.db.Set(key, temp)
 	}
-	list, ok = temp.(*datastructure.List)
+	list, ok := temp.(*datastructure.List)
 	if !ok {
 		return resp.NewErrorData("WRONGTYPE Operation against a key holding the wrong kind of value")
 	}
@@ -434,13 +329,12 @@ func rPushList(m *MemDb, cmd [][]byte) resp.RedisData {
 	m.locks.Lock(key)
 	defer m.locks.Unlock(key)
 
-	var list *datastructure.List
 	tem, ok := m.db.Get(key)
 	if !ok {
-		list = datastructure.NewList()
-		m.db.Set(key, list)
+		tem = datastructure.NewList()
+		m.db.Set(key, tem)
 	}
-	list, ok = tem.(*datastructure.List)
+	list, ok := tem.(*datastructure.List)
 	if !ok {
 		return resp.NewErrorData("WRONGTYPE Operation against a key holding the wrong kind of value")
 	}
@@ -687,20 +581,136 @@ func lMoveList(m *MemDb, cmd [][]byte) resp.RedisData {
 	return resp.NewBulkData(popElem.Val)
 }
 
+func lInsertList(m *MemDb, cmd [][]byte) resp.RedisData {
+	if strings.ToLower(string(cmd[0])) != "linsert" {
+		logger.Error("lInsertList Function : cmdName is not linsert")
+		return resp.NewErrorData("server error")
+	}
+	if len(cmd) != 5 {
+		return resp.NewErrorData("wrong number of arguments for 'linsert' command")
+	}
+	where := strings.ToLower(string(cmd[2]))
+	if where != "before" && where != "after" {
+		return resp.NewErrorData("ERR syntax error")
+	}
+
+	key := string(cmd[1])
+	m.CheckTTL(key)
+	m.locks.Lock(key)
+	defer m.locks.Unlock(key)
+
+	temp, ok := m.db.Get(key)
+	if !ok {
+		return resp.NewIntData(0)
+	}
+	list, ok := temp.(*datastructure.List)
+	if !ok {
+		return resp.NewErrorData("WRONGTYPE Operation against a key holding the wrong kind of value")
+	}
+	var pos int
+	if where == "before" {
+		pos = list.InsertBefore(cmd[4], cmd[3])
+	} else {
+		pos = list.InsertAfter(cmd[4], cmd[3])
+	}
+	if pos == -1 {
+		return resp.NewIntData(-1)
+	}
+	return resp.NewIntData(int64(list.Len))
+}
+
+func lMPopList(m *MemDb, cmd [][]byte) resp.RedisData {
+	if strings.ToLower(string(cmd[0])) != "lmpop" {
+		logger.Error("lMPopList Function : cmdName is not lmpop")
+		return resp.NewErrorData("server error")
+	}
+	if len(cmd) < 4 {
+		return resp.NewErrorData("wrong number of arguments for 'lmpop' command")
+	}
+	numKeys, err := strconv.Atoi(string(cmd[1]))
+	if err != nil || numKeys <= 0 {
+		return resp.NewErrorData("ERR numkeys should be greater than 0")
+	}
+	if numKeys > len(cmd)-3 {
+		return resp.NewErrorData("ERR syntax error")
+	}
+	keys := make([]string, numKeys)
+	for i := 0; i < numKeys; i++ {
+		keys[i] = string(cmd[i+2])
+	}
+	where := strings.ToLower(string(cmd[numKeys+2]))
+	if where != "left" && where != "right" {
+		return resp.NewErrorData("ERR syntax error")
+	}
+	count := 1
+	opts := cmd[numKeys+3:]
+	if len(opts) != 0 {
+		if len(opts) != 2 || strings.ToLower(string(opts[0])) != "count" {
+			return resp.NewErrorData("ERR syntax error")
+		}
+		count, err = strconv.Atoi(string(opts[1]))
+		if err != nil || count <= 0 {
+			return resp.NewErrorData("ERR count should be greater than 0")
+		}
+	}
+
+	for _, key := range keys {
+		m.CheckTTL(key)
+	}
+	// lock all keys, the elements are popped from the first non-empty list
+	m.locks.LockMulti(keys)
+	defer m.locks.UnlockMulti(keys)
+	for _, key := range keys {
+		temp, ok := m.db.Get(key)
+		if !ok {
+			continue
+		}
+		list, ok := temp.(*datastructure.List)
+		if !ok {
+			return resp.NewErrorData("WRONGTYPE Operation against a key holding the wrong kind of value")
+		}
+		if list.Len == 0 {
+			continue
+		}
+		vals := m.popElements(key, list, where == "left", count)
+		elems := make([]resp.RedisData, len(vals))
+		for i, val := range vals {
+			elems[i] = resp.NewBulkData(val)
+		}
+		return resp.NewArrayData([]resp.RedisData{resp.NewBulkData([]byte(key)), resp.NewArrayData(elems)})
+	}
+	return resp.NewArrayData(nil)
+}
+
+// rPopLPushList is LMOVE source destination RIGHT LEFT
+func rPopLPushList(m *MemDb, cmd [][]byte) resp.RedisData {
+	if strings.ToLower(string(cmd[0])) != "rpoplpush" {
+		logger.Error("rPopLPushList Function : cmdName is not rpoplpush")
+		return resp.NewErrorData("server error")
+	}
+	if len(cmd) != 3 {
+		return resp.NewErrorData("wrong number of arguments for 'rpoplpush' command")
+	}
+	return lMoveList(m, [][]byte{[]byte("lmove"), cmd[1], cmd[2], []byte("right"), []byte("left")})
+}
+
 func RegisterListCommands() {
-	RegisterCommand("llen", lLenList)
-	RegisterCommand("lindex", lIndexList)
-	RegisterCommand("lpos", lPosList)
-	RegisterCommand("lpop", lPopList)
-	RegisterCommand("rpop", rPopList)
-	RegisterCommand("lpush", lPushList)
-	RegisterCommand("lpushx", lPushXList)
-	RegisterCommand("rpush", rPushList)
-	RegisterCommand("rpushx", rPushXList)
-	RegisterCommand("lindex", lIndexList)
-	RegisterCommand("lset", lSetList)
-	RegisterCommand("lrem", lRemList)
-	RegisterCommand("ltrim", lTrimList)
-	RegisterCommand("lrange", lRangeList)
-	RegisterCommand("lmove", lMoveList)
+	RegisterCommand("llen", lLenList, flagReadOnly)
+	RegisterCommand("lindex", lIndexList, flagReadOnly)
+	RegisterCommand("lpos", lPosList, flagReadOnly)
+	RegisterCommand("lpop", lPopList, flagNoActiveActive)
+	RegisterCommand("rpop", rPopList, flagNoActiveActive)
+	RegisterCommand("lpush", lPushList, flagNoActiveActive)
+	RegisterCommand("lpushx", lPushXList, flagNoActiveActive)
+	RegisterCommand("rpush", rPushList, flagNoActiveActive)
+	RegisterCommand("rpushx", rPushXList, flagNoActiveActive)
+	RegisterCommand("lindex", lIndexList, flagReadOnly)
+	RegisterCommand("lset", lSetList, flagNoActiveActive)
+	RegisterCommand("lrem", lRemList, flagNoActiveActive)
+	RegisterCommand("ltrim", lTrimList, flagNoActiveActive)
+	RegisterCommand("lrange", lRangeList, flagReadOnly)
+	RegisterCommand("lmove", lMoveList, flagNoActiveActive)
+	RegisterCommand("linsert", lInsertList, flagNoActiveActive)
+	RegisterCommand("lmpop", lMPopList, flagNoActiveActive)
+	RegisterCommand("rpoplpush", rPopLPushList, flagNoActiveActive)
 }
@@ -2,18 +2,11 @@ package memdb
 
 import (
 	"bytes"
-	"easyRedis/config"
 	"easyRedis/resp"
 	"fmt"
 	"testing"
 )
 
-func init() {
-	config.Configures = &config.Config{
-		ShardNum: 100,
-	}
-}
-
 func TestLPosList(t *testing.T) {
 	m := NewMemDb()
 	lPushList(m, [][]byte{[]byte("lpush"), []byte("l1"), []byte("d"), []byte("b"), []byte("a"), []byte("c"), []byte("b"), []byte("a")})
@@ -83,3 +76,93 @@ func TestLRemList(t *testing.T) {
 		t.Error("lrem error")
 	}
 }
+
+func TestPopCountList(t *testing.T) {
+	m := newTestDb()
+	exec(m, "rpush", "l", "a", "b", "c", "d")
+
+	if res := exec(m, "lpop", "l"); res != "$1\r\na\r\n" {
+		t.Errorf("lpop reply %q", res)
+	}
+	if res := exec(m, "lpop", "l", "0"); res != "*0\r\n" {
+		t.Errorf("lpop count 0 reply %q", res)
+	}
+	if res := exec(m, "rpop", "l", "2"); res != "*2\r\n$1\r\nd\r\n$1\r\nc\r\n" {
+		t.Errorf("rpop count reply %q", res)
+	}
+	if res := exec(m, "lpop", "l", "5"); res != "*1\r\n$1\r\nb\r\n" {
+		t.Errorf("lpop count over length reply %q", res)
+	}
+	if _, ok := m.db.Get("l"); ok {
+		t.Error("an empty list must be deleted")
+	}
+	if res := exec(m, "lpop", "l", "1"); res != "*-1\r\n" {
+		t.Errorf("lpop count on a missing key reply %q", res)
+	}
+	if res := exec(m, "rpop", "l"); res != "$-1\r\n" {
+		t.Errorf("rpop on a missing key reply %q", res)
+	}
+	if res := exec(m, "rpop", "l", "-1"); res != "-ERR value is out of range, must be positive\r\n" {
+		t.Errorf("rpop negative count reply %q", res)
+	}
+}
+
+func TestLInsertList(t *testing.T) {
+	m := newTestDb()
+	exec(m, "rpush", "l", "a", "c", "c")
+
+	if res := exec(m, "linsert", "l", "before", "c", "b"); res != ":4\r\n" {
+		t.Errorf("linsert before reply %q", res)
+	}
+	if res := exec(m, "linsert", "l", "after", "c", "c"); res != ":5\r\n" {
+		t.Errorf("linsert after reply %q", res)
+	}
+	if res := exec(m, "lrange", "l", "0", "-1"); res != "*5\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nc\r\n$1\r\nc\r\n$1\r\nc\r\n" {
+		t.Errorf("list after linsert %q", res)
+	}
+	if res := exec(m, "linsert", "l", "after", "x", "y"); res != ":-1\r\n" {
+		t.Errorf("linsert without pivot reply %q", res)
+	}
+	if res := exec(m, "linsert", "nokey", "after", "x", "y"); res != ":0\r\n" {
+		t.Errorf("linsert on a missing key reply %q", res)
+	}
+	if res := exec(m, "linsert", "l", "middle", "x", "y"); res != "-ERR syntax error\r\n" {
+		t.Errorf("linsert bad position reply %q", res)
+	}
+}
+
+func TestLMPopRPopLPushList(t *testing.T) {
+	m := newTestDb()
+	exec(m, "rpush", "l2", "a", "b", "c")
+
+	if res := exec(m, "lmpop", "2", "l1", "l2", "right", "count", "2"); res != "*2\r\n$2\r\nl2\r\n*2\r\n$1\r\nc\r\n$1\r\nb\r\n" {
+		t.Errorf("lmpop reply %q", res)
+	}
+	if res := exec(m, "lmpop", "2", "l1", "l2", "left"); res != "*2\r\n$2\r\nl2\r\n*1\r\n$1\r\na\r\n" {
+		t.Errorf("lmpop left reply %q", res)
+	}
+	if res := exec(m, "lmpop", "2", "l1", "l2", "left"); res != "*-1\r\n" {
+		t.Errorf("lmpop on empty lists reply %q", res)
+	}
+	if res := exec(m, "lmpop", "0", "l1", "left"); res != "-ERR numkeys should be greater than 0\r\n" {
+		t.Errorf("lmpop numkeys 0 reply %q", res)
+	}
+	if res := exec(m, "lmpop", "1", "l1", "left", "count", "0"); res != "-ERR count should be greater than 0\r\n" {
+		t.Errorf("lmpop count 0 reply %q", res)
+	}
+	if res := exec(m, "lmpop", "9223372036854775807", "l1", "left"); res != "-ERR syntax error\r\n" {
+		t.Errorf("lmpop huge numkeys reply %q", res)
+	}
+
+	exec(m, "rpush", "src", "1", "2")
+	if res := exec(m, "rpoplpush", "src", "dst"); res != "$1\r\n2\r\n" {
+		t.Errorf("rpoplpush reply %q", res)
+	}
+	exec(m, "rpoplpush", "src", "dst")
+	if res := exec(m, "lrange", "dst", "0", "-1"); res != "*2\r\n$1\r\n1\r\n$1\r\n2\r\n" {
+		t.Errorf("rpoplpush destination %q", res)
+	}
+	if res := exec(m, "rpoplpush", "src", "dst"); res != "$-1\r\n" {
+		t.Errorf("rpoplpush on a missing key reply %q", res)
+	}
+}
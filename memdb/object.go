@@ -0,0 +1,121 @@
+package memdb
+
+import (
+	"easyRedis/datastructure"
+	"easyRedis/logger"
+	"easyRedis/resp"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// object.go implements OBJECT ENCODING, FREQ, IDLETIME, REFCOUNT and HELP.
+// OBJECT is a no-touch command, inspecting a key doesn't change its idle time or frequency.
+
+var objectHelp = []string{
+	"OBJECT <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"ENCODING <key>",
+	"    Return the kind of internal representation used in order to store the value",
+	"    associated with a <key>.",
+	"FREQ <key>",
+	"    Return the access frequency index of the <key>. The returned integer is",
+	"    proportional to the logarithm of the recent access frequency of the key.",
+	"IDLETIME <key>",
+	"    Return the idle time of the <key>, that is the approximated number of",
+	"    seconds elapsed since the last access to the key.",
+	"REFCOUNT <key>",
+	"    Return the number of references of the value associated with the specified",
+	"    <key>.",
+	"HELP",
+	"    Print this help.",
+}
+
+// the string encodings of redis: an integer, a short string allocated with its object, or a raw string
+const (
+	encodingInt    = "int"
+	encodingEmbstr = "embstr"
+	encodingRaw    = "raw"
+	// embstrMaxLen is the longest string redis embeds in its object
+	embstrMaxLen = 44
+)
+
+func objectKey(m *MemDb, cmd [][]byte) resp.RedisData {
+	if strings.ToLower(string(cmd[0])) != "object" {
+		logger.Error("objectKey Function: cmdName is not object")
+		return resp.NewErrorData("server error")
+	}
+	if len(cmd) < 2 {
+		return resp.NewErrorData("ERR wrong number of arguments for 'object' command")
+	}
+	sub := strings.ToLower(string(cmd[1]))
+	if sub == "help" && len(cmd) == 2 {
+		res := make([]resp.RedisData, len(objectHelp))
+		for i, line := range objectHelp {
+			res[i] = resp.NewStringData(line)
+		}
+		return resp.NewArrayData(res)
+	}
+	switch sub {
+	case "encoding", "freq", "idletime", "refcount":
+		if len(cmd) != 3 {
+			return resp.NewErrorData(fmt.Sprintf("ERR wrong number of arguments for 'object|%s' command", sub))
+		}
+	default:
+		return resp.NewErrorData(fmt.Sprintf("ERR unknown subcommand '%s'. Try OBJECT HELP.", cmd[1]))
+	}
+
+	key := string(cmd[2])
+	if !m.CheckTTL(key) {
+		return resp.NewBulkData(nil)
+	}
+	m.locks.RLock(key)
+	defer m.locks.RUnlock(key)
+
+	// the stats of a key are stored with its value
+	e := m.db.entry(key)
+	if e == nil {
+		return resp.NewBulkData(nil)
+	}
+	switch sub {
+	case "encoding":
+		return resp.NewBulkData([]byte(objectEncoding(e.val)))
+	case "refcount":
+		// values are never shared between keys
+		return resp.NewIntData(1)
+	}
+	if sub == "freq" {
+		return resp.NewIntData(int64(e.access.freq()))
+	}
+	return resp.NewIntData(int64(math.Floor(e.access.idleTime().Seconds())))
+}
+
+// objectEncoding returns the name of the internal representation of val, the same as redis
+func objectEncoding(val any) string {
+	switch v := val.(type) {
+	case []byte:
+		if len(v) <= 20 {
+			if n, err := strconv.ParseInt(string(v), 10, 64); err == nil && strconv.FormatInt(n, 10) == string(v) {
+				return encodingInt
+			}
+		}
+		if len(v) <= embstrMaxLen {
+			return encodingEmbstr
+		}
+		return encodingRaw
+	case *datastructure.List:
+		return v.Encoding()
+	case *datastructure.Hash:
+		return v.Encoding()
+	case *datastructure.Set:
+		return v.Encoding()
+	case *datastructure.SortSet:
+		return v.Encoding()
+	}
+	logger.Error("objectEncoding function: value is not string|list|hash|set|zset")
+	return "unknown"
+}
+
+func RegisterObjectCommands() {
+	RegisterCommand("object", objectKey, flagReadOnly, flagNoTouch)
+}
@@ -0,0 +1,124 @@
+package memdb
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestObjectEncoding(t *testing.T) {
+	m := newTestDb()
+	exec(m, "set", "int", "-12345")
+	exec(m, "set", "embstr", "012")
+	exec(m, "set", "raw", strings.Repeat("a", 45))
+	exec(m, "rpush", "list", "a", "b")
+	exec(m, "hset", "hash", "f", "v")
+	exec(m, "sadd", "intset", "1", "2")
+	exec(m, "sadd", "set", "a")
+	exec(m, "zadd", "zset", "1", "a")
+	for key, encoding := range map[string]string{
+		"int": "int", "embstr": "embstr", "raw": "raw", "list": "listpack", "hash": "listpack",
+		"intset": "intset", "set": "listpack", "zset": "listpack",
+	} {
+		if res := exec(m, "object", "encoding", key); res != "$"+strconv.Itoa(len(encoding))+"\r\n"+encoding+"\r\n" {
+			t.Errorf("encoding of %s reply %q", key, res)
+		}
+	}
+	for i := 0; i < 200; i++ {
+		exec(m, "zadd", "zset", strconv.Itoa(i), "m"+strconv.Itoa(i))
+	}
+	if res := exec(m, "object", "encoding", "zset"); res != "$8\r\nskiplist\r\n" {
+		t.Errorf("encoding of a large zset reply %q", res)
+	}
+	if res := exec(m, "object", "encoding", "nokey"); res != "$-1\r\n" {
+		t.Errorf("encoding of a missing key reply %q", res)
+	}
+	if res := exec(m, "object", "refcount", "list"); res != ":1\r\n" {
+		t.Errorf("refcount reply %q", res)
+	}
+}
+
+func TestObjectArgs(t *testing.T) {
+	m := newTestDb()
+	if res := exec(m, "object", "help"); !strings.HasPrefix(res, "*15\r\n+OBJECT <subcommand>") {
+		t.Errorf("object help reply %q", res)
+	}
+	if res := exec(m, "object", "foo", "k"); !strings.HasPrefix(res, "-ERR unknown subcommand 'foo'") {
+		t.Errorf("unknown subcommand reply %q", res)
+	}
+	if res := exec(m, "object", "encoding"); !strings.HasPrefix(res, "-ERR wrong number of arguments") {
+		t.Errorf("object encoding without key reply %q", res)
+	}
+}
+
+func TestObjectAccessStats(t *testing.T) {
+	m := newTestDb()
+	exec(m, "set", "k", "v")
+	if res := exec(m, "object", "freq", "k"); res != ":5\r\n" {
+		t.Errorf("freq of a new key reply %q", res)
+	}
+	// pretend the key was last accessed 3 minutes ago
+	m.db.stats("k").stamp.Store(uint64(time.Now().Add(-3*time.Minute).UnixMilli())<<8 | lfuInitVal)
+	if res := exec(m, "object", "idletime", "k"); res != ":180\r\n" {
+		t.Errorf("idletime reply %q", res)
+	}
+	if res := exec(m, "object", "freq", "k"); res != ":2\r\n" {
+		t.Errorf("decayed freq reply %q", res)
+	}
+	// the introspection commands don't touch the key
+	exec(m, "type", "k")
+	exec(m, "exists", "k")
+	exec(m, "ttl", "k")
+	if res := exec(m, "object", "idletime", "k"); res != ":180\r\n" {
+		t.Errorf("idletime after no-touch commands reply %q", res)
+	}
+	exec(m, "get", "k")
+	if res := exec(m, "object", "idletime", "k"); res != ":0\r\n" {
+		t.Errorf("idletime after get reply %q", res)
+	}
+	for i := 0; i < 1000; i++ {
+		exec(m, "get", "k")
+	}
+	freq, _ := strconv.Atoi(strings.Trim(exec(m, "object", "freq", "k"), ":\r\n"))
+	if freq <= 5 || freq >= 255 {
+		t.Errorf("freq after 1000 gets is %d", freq)
+	}
+	exec(m, "del", "k")
+	if m.db.stats("k") != nil {
+		t.Error("the stats of a deleted key are kept")
+	}
+}
+
+// a command touches each of its keys once, however many times it reads them
+func TestObjectTouchOncePerCommand(t *testing.T) {
+	m := newTestDb()
+	exec(m, "set", "k", "v")
+	// the first touch of a new key always increments its counter, the next ones rarely do
+	exec(m, "mget", "k", "k", "k", "k")
+	if res := exec(m, "object", "freq", "k"); res != ":6\r\n" {
+		t.Errorf("freq after one mget reply %q", res)
+	}
+	exec(m, "set", "n", "1")
+	exec(m, "incr", "n")
+	if res := exec(m, "object", "freq", "n"); res != ":6\r\n" {
+		t.Errorf("freq after one incr reply %q", res)
+	}
+}
+
+func TestObjectRestoredStats(t *testing.T) {
+	m := newTestDb()
+	exec(m, "set", "k", "v")
+	payload := dumpOf(t, m, "k")
+	exec(m, "restore", "idle", "0", payload, "idletime", "1000")
+	if res := exec(m, "object", "idletime", "idle"); res != ":1000\r\n" {
+		t.Errorf("idletime of a key restored with IDLETIME 1000 reply %q", res)
+	}
+	exec(m, "restore", "freq", "0", payload, "freq", "100")
+	if res := exec(m, "object", "freq", "freq"); res != ":100\r\n" {
+		t.Errorf("freq of a key restored with FREQ 100 reply %q", res)
+	}
+	if res := exec(m, "object", "idletime", "freq"); res != ":0\r\n" {
+		t.Errorf("idletime of a key restored without IDLETIME reply %q", res)
+	}
+}
@@ -0,0 +1,648 @@
+package memdb
+
+import (
+	"context"
+	"crypto/sha1"
+	"easyRedis/config"
+	"easyRedis/datastructure"
+	"easyRedis/logger"
+	"easyRedis/resp"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+// script.go implements lua scripting: EVAL, EVAL_RO, EVALSHA, EVALSHA_RO and SCRIPT.
+// A script runs atomically: the locks of all keys declared in KEYS are held during the whole execution,
+// and the commands called by the script run on a script context that shares the data of the database
+// but has its own locks, so that the executors don't try to lock the keys again.
+// Like in redis cluster, a script can only use the keys declared in KEYS: executors lock their keys
+// before using them, so the private locks reject the other keys before they are read or written.
+
+const (
+	errUnkillable = "UNKILLABLE Sorry the script already executed write commands against the dataset. " +
+		"You can either wait the script termination or kill the server in a hard way using the SHUTDOWN NOSAVE command."
+	errNotBusy = "NOTBUSY No scripts in execution right now."
+)
+
+// scriptEngine holds the script cache and the running scripts
+type scriptEngine struct {
+	mu      sync.RWMutex
+	scripts map[string]*lua.FunctionProto
+
+	runMu   sync.Mutex
+	running map[*scriptRun]struct{}
+}
+
+// scriptRun is a running script.
+// A script that has written can't be stopped, since its writes can't be rolled back:
+// the time limit only logs it, and SCRIPT KILL replies UNKILLABLE.
+type scriptRun struct {
+	cancel   context.CancelFunc
+	readOnly bool
+	loading  bool // the code of a function library is running, commands can't be called
+	timer    *time.Timer
+
+	// mu makes the first write and stopping the script exclusive
+	mu      sync.Mutex
+	written bool
+	stopped bool
+	killed  bool // stopped by SCRIPT KILL rather than by the time limit
+}
+
+// write marks the script as written before it calls a write command, it returns false if the script is stopped
+func (r *scriptRun) write() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stopped {
+		return false
+	}
+	r.written = true
+	return true
+}
+
+// stop stops the script unless it has written, byUser is true for SCRIPT KILL
+func (r *scriptRun) stop(byUser bool) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.written {
+		return false
+	}
+	r.stopped, r.killed = true, byUser
+	r.cancel()
+	return true
+}
+
+func newScriptEngine() *scriptEngine {
+	return &scriptEngine{
+		scripts: make(map[string]*lua.FunctionProto),
+		running: make(map[*scriptRun]struct{}),
+	}
+}
+
+func sha1Hex(s []byte) string {
+	sum := sha1.Sum(s)
+	return hex.EncodeToString(sum[:])
+}
+
+// compileScript compiles the lua source code of a script
+func compileScript(name string, src []byte) (*lua.FunctionProto, error) {
+	chunk, err := parse.Parse(strings.NewReader(string(src)), name)
+	if err != nil {
+		return nil, err
+	}
+	return lua.Compile(chunk, name)
+}
+
+// load compiles and caches a script, returns the sha1 digest of the script
+func (e *scriptEngine) load(src []byte) (string, *lua.FunctionProto, error) {
+	sha := sha1Hex(src)
+	e.mu.RLock()
+	proto, ok := e.scripts[sha]
+	e.mu.RUnlock()
+	if ok {
+		return sha, proto, nil
+	}
+	proto, err := compileScript("user_script", src)
+	if err != nil {
+		return "", nil, err
+	}
+	e.mu.Lock()
+	e.scripts[sha] = proto
+	e.mu.Unlock()
+	return sha, proto, nil
+}
+
+func (e *scriptEngine) get(sha string) (*lua.FunctionProto, bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	proto, ok := e.scripts[strings.ToLower(sha)]
+	return proto, ok
+}
+
+func (e *scriptEngine) exists(sha string) bool {
+	_, ok := e.get(sha)
+	return ok
+}
+
+func (e *scriptEngine) flush() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.scripts = make(map[string]*lua.FunctionProto)
+}
+
+// start registers a running script, the returned context is done when the script is stopped.
+// A script running longer than lua-time-limit is stopped unless it has written, which is logged instead.
+func (e *scriptEngine) start(readOnly bool) (context.Context, *scriptRun) {
+	ctx, cancel := context.WithCancel(context.Background())
+	run := &scriptRun{cancel: cancel, readOnly: readOnly}
+	if limit := config.Configures.LuaTimeLimit; limit > 0 {
+		run.timer = time.AfterFunc(time.Duration(limit)*time.Millisecond, func() {
+			if !run.stop(false) {
+				logger.Warning(fmt.Sprintf("Slow script detected: still in execution after %d milliseconds, "+
+					"it has written the dataset so it can't be stopped", limit))
+			}
+		})
+	}
+	e.runMu.Lock()
+	e.running[run] = struct{}{}
+	e.runMu.Unlock()
+	return ctx, run
+}
+
+func (e *scriptEngine) finish(run *scriptRun) {
+	if run.timer != nil {
+		run.timer.Stop()
+	}
+	run.cancel()
+	e.runMu.Lock()
+	delete(e.running, run)
+	e.runMu.Unlock()
+}
+
+// kill stops all running scripts that have not written the dataset
+func (e *scriptEngine) kill() resp.RedisData {
+	e.runMu.Lock()
+	defer e.runMu.Unlock()
+	if len(e.running) == 0 {
+		return resp.NewErrorData(errNotBusy)
+	}
+	killed := 0
+	for run := range e.running {
+		if run.stop(true) {
+			killed++
+		}
+	}
+	if killed == 0 {
+		return resp.NewErrorData(errUnkillable)
+	}
+	return resp.NewStringData("OK")
+}
+
+// scriptContext returns a copy of m used to execute the commands called by a script.
+// The caller holds the real locks of keys, the copy has private locks.
+func (m *MemDb) scriptContext(keys []string) *MemDb {
+	declared := make(map[string]struct{}, len(keys))
+	for _, key := range keys {
+		declared[key] = struct{}{}
+	}
+	return &MemDb{
+		db:           m.db,
+		ttlKeys:      m.ttlKeys,
+		fieldTTLKeys: m.fieldTTLKeys,
+		locks:        newScriptLocks(declared),
+		delay:        m.delay,
+		aa:           m.aa,
+		scripts:      m.scripts,
+		functions:    m.functions,
+		origin:       m.root(),
+		slowlog:      m.slowlog,
+	}
+}
+
+// undeclaredKey is the panic value of scriptLocks for a key that is not declared in KEYS, recovered by execScriptCommand
+type undeclaredKey string
+
+// scriptLocks are the private locks of a script context, locking a key that is not declared panics with undeclaredKey
+type scriptLocks struct {
+	*datastructure.Locks
+	declared map[string]struct{}
+}
+
+func newScriptLocks(declared map[string]struct{}) *scriptLocks {
+	return &scriptLocks{Locks: datastructure.NewLocks(1), declared: declared}
+}
+
+func (l *scriptLocks) check(keys ...string) {
+	for _, key := range keys {
+		if _, ok := l.declared[key]; !ok {
+			panic(undeclaredKey(key))
+		}
+	}
+}
+
+func (l *scriptLocks) Lock(key string) {
+	l.check(key)
+	l.Locks.Lock(key)
+}
+
+func (l *scriptLocks) RLock(key string) {
+	l.check(key)
+	l.Locks.RLock(key)
+}
+
+func (l *scriptLocks) LockMulti(keys []string) {
+	l.check(keys...)
+	l.Locks.LockMulti(keys)
+}
+
+func (l *scriptLocks) RLockMulti(keys []string) {
+	l.check(keys...)
+	l.Locks.RLockMulti(keys)
+}
+
+// execScriptCommand executes a command called by a script on the script context sm.
+// A command using a key that is not declared in KEYS is stopped when it locks the key, and an error is returned.
+func execScriptCommand(sm *MemDb, cmd [][]byte) (res resp.RedisData) {
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		key, ok := r.(undeclaredKey)
+		if !ok {
+			panic(r)
+		}
+		// the command may have stopped while holding private locks, they are replaced
+		sm.locks = newScriptLocks(sm.locks.(*scriptLocks).declared)
+		res = resp.NewErrorData(fmt.Sprintf("ERR Script attempted to access key '%s' that is not declared in KEYS", string(key)))
+	}()
+	return sm.ExecCommand(cmd)
+}
+
+// scriptEnv is what the redis api of a lua state works on: the script context running the commands
+// and the running script. A lua state kept for several calls, like the states of function libraries,
+// gets the env of each call.
+type scriptEnv struct {
+	sm  *MemDb
+	run *scriptRun
+}
+
+// newLuaState creates a lua state with the redis api, commands called by the script are executed on env.sm
+func newLuaState(ctx context.Context, env *scriptEnv) *lua.LState {
+	L := lua.NewState(lua.Options{SkipOpenLibs: true})
+	for _, lib := range []struct {
+		name string
+		fn   lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		L.Push(L.NewFunction(lib.fn))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+	for _, name := range []string{"dofile", "loadfile", "require", "module"} {
+		L.SetGlobal(name, lua.LNil)
+	}
+
+	api := L.NewTable()
+	api.RawSetString("call", L.NewFunction(func(L *lua.LState) int {
+		return redisCall(L, env.sm, env.run, true)
+	}))
+	api.RawSetString("pcall", L.NewFunction(func(L *lua.LState) int {
+		return redisCall(L, env.sm, env.run, false)
+	}))
+	api.RawSetString("error_reply", L.NewFunction(func(L *lua.LState) int {
+		t := L.NewTable()
+		t.RawSetString("err", lua.LString(L.CheckString(1)))
+		L.Push(t)
+		return 1
+	}))
+	api.RawSetString("status_reply", L.NewFunction(func(L *lua.LState) int {
+		t := L.NewTable()
+		t.RawSetString("ok", lua.LString(L.CheckString(1)))
+		L.Push(t)
+		return 1
+	}))
+	api.RawSetString("sha1hex", L.NewFunction(func(L *lua.LState) int {
+		L.Push(lua.LString(sha1Hex([]byte(L.CheckString(1)))))
+		return 1
+	}))
+	api.RawSetString("log", L.NewFunction(func(L *lua.LState) int {
+		level := L.CheckInt(1)
+		msg := make([]any, 0, L.GetTop()-1)
+		for i := 2; i <= L.GetTop(); i++ {
+			msg = append(msg, L.ToStringMeta(L.Get(i)).String())
+		}
+		switch level {
+		case 0:
+			logger.Debug(msg...)
+		case 1, 2:
+			logger.Info(msg...)
+		default:
+			logger.Warning(msg...)
+		}
+		return 0
+	}))
+	api.RawSetString("LOG_DEBUG", lua.LNumber(0))
+	api.RawSetString("LOG_VERBOSE", lua.LNumber(1))
+	api.RawSetString("LOG_NOTICE", lua.LNumber(2))
+	api.RawSetString("LOG_WARNING", lua.LNumber(3))
+	L.SetGlobal("redis", api)
+	L.SetContext(ctx)
+	return L
+}
+
+// redisCall implements redis.call and redis.pcall.
+// redis.call raises a lua error if the command replies an error, redis.pcall returns the error as a table.
+func redisCall(L *lua.LState, sm *MemDb, run *scriptRun, raise bool) int {
+	fail := func(msg string) int {
+		t := L.NewTable()
+		t.RawSetString("err", lua.LString(msg))
+		if raise {
+			L.Error(t, 1)
+			return 0
+		}
+		L.Push(t)
+		return 1
+	}
+
+	if run.loading {
+		return fail("ERR redis.call and redis.pcall can not be called while loading a library")
+	}
+	n := L.GetTop()
+	if n == 0 {
+		return fail("ERR Please specify at least one argument for this redis lib call")
+	}
+	cmd := make([][]byte, n)
+	for i := 1; i <= n; i++ {
+		switch v := L.Get(i).(type) {
+		case lua.LString:
+			cmd[i-1] = []byte(v)
+		case lua.LNumber:
+			cmd[i-1] = []byte(v.String())
+		default:
+			return fail("ERR Lua redis lib command arguments must be strings or integers")
+		}
+	}
+	cmdName := strings.ToLower(string(cmd[0]))
+	command, ok := CmdTable[cmdName]
+	if !ok {
+		return fail("ERR Unknown Redis command called from script")
+	}
+	if command.noScript() {
+		return fail("ERR This Redis command is not allowed from script")
+	}
+	if !command.readOnly() {
+		if run.readOnly {
+			return fail("ERR Write commands are not allowed from read-only scripts.")
+		}
+		if !run.write() {
+			return fail("ERR Script was stopped before it could write")
+		}
+	}
+
+	res := execScriptCommand(sm, cmd)
+	if errData, isErr := res.(*resp.ErrorData); isErr {
+		return fail(errData.Error())
+	}
+	L.Push(respToLua(L, res))
+	return 1
+}
+
+// respToLua converts a command reply to a lua value
+func respToLua(L *lua.LState, data resp.RedisData) lua.LValue {
+	switch v := data.(type) {
+	case *resp.IntData:
+		return lua.LNumber(v.Data())
+	case *resp.Float64Data:
+		return lua.LNumber(v.Data())
+	case *resp.BulkData:
+		if v.Data() == nil {
+			return lua.LFalse
+		}
+		return lua.LString(v.Data())
+	case *resp.StringData:
+		t := L.NewTable()
+		t.RawSetString("ok", lua.LString(v.Data()))
+		return t
+	case *resp.ErrorData:
+		t := L.NewTable()
+		t.RawSetString("err", lua.LString(v.Error()))
+		return t
+	case *resp.ArrayData:
+		if v.Data() == nil {
+			return lua.LFalse
+		}
+		t := L.CreateTable(len(v.Data()), 0)
+		for _, item := range v.Data() {
+			t.Append(respToLua(L, item))
+		}
+		return t
+	case nil:
+		return lua.LFalse
+	}
+	return lua.LString(data.ByteData())
+}
+
+// luaToResp converts a lua value returned by a script to a reply
+func luaToResp(v lua.LValue) resp.RedisData {
+	switch lv := v.(type) {
+	case lua.LNumber:
+		return resp.NewIntData(int64(lv))
+	case lua.LString:
+		return resp.NewBulkData([]byte(lv))
+	case lua.LBool:
+		if lv {
+			return resp.NewIntData(1)
+		}
+		return resp.NewBulkData(nil)
+	case *lua.LTable:
+		if errMsg, ok := lv.RawGetString("err").(lua.LString); ok {
+			return resp.NewErrorData(string(errMsg))
+		}
+		if status, ok := lv.RawGetString("ok").(lua.LString); ok {
+			return resp.NewStringData(string(status))
+		}
+		res := make([]resp.RedisData, 0, lv.Len())
+		for i := 1; ; i++ {
+			item := lv.RawGetInt(i)
+			if item == lua.LNil {
+				break
+			}
+			res = append(res, luaToResp(item))
+		}
+		return resp.NewArrayData(res)
+	}
+	return resp.NewBulkData(nil)
+}
+
+// luaError converts an error raised by a script to a reply
+func luaError(err error, run *scriptRun) resp.RedisData {
+	run.mu.Lock()
+	stopped, killed := run.stopped, run.killed
+	run.mu.Unlock()
+	if killed {
+		return resp.NewErrorData("ERR Script killed by user with SCRIPT KILL...")
+	}
+	if stopped {
+		return resp.NewErrorData(fmt.Sprintf("ERR Script killed after exceeding the time limit of %d milliseconds", config.Configures.LuaTimeLimit))
+	}
+	apiErr, ok := err.(*lua.ApiError)
+	if !ok {
+		return resp.NewErrorData("ERR " + err.Error())
+	}
+	if t, isTable := apiErr.Object.(*lua.LTable); isTable {
+		if errMsg, isStr := t.RawGetString("err").(lua.LString); isStr {
+			return resp.NewErrorData(string(errMsg))
+		}
+	}
+	return resp.NewErrorData("ERR Error running script: " + apiErr.Object.String())
+}
+
+// parseKeysArgs splits numkeys key [key ...] arg [arg ...]
+func parseKeysArgs(cmd [][]byte) (keys, args [][]byte, errData resp.RedisData) {
+	numKeys, err := strconv.Atoi(string(cmd[0]))
+	if err != nil {
+		return nil, nil, resp.NewErrorData("ERR value is not an integer or out of range")
+	}
+	if numKeys < 0 {
+		return nil, nil, resp.NewErrorData("ERR Number of keys can't be negative")
+	}
+	if numKeys > len(cmd)-1 {
+		return nil, nil, resp.NewErrorData("ERR Number of keys can't be greater than number of args")
+	}
+	return cmd[1 : numKeys+1], cmd[numKeys+1:], nil
+}
+
+func luaArray(L *lua.LState, items [][]byte) *lua.LTable {
+	t := L.CreateTable(len(items), 0)
+	for _, item := range items {
+		t.Append(lua.LString(item))
+	}
+	return t
+}
+
+// runScript executes a lua function atomically under the locks of keys.
+// prepare returns the lua state with the function to call and its arguments pushed, the number of
+// arguments, and release that is called with the state once the call returns, failed if it raised an error.
+func (m *MemDb) runScript(keys [][]byte, readOnly bool,
+	prepare func(ctx context.Context, env *scriptEnv) (L *lua.LState, nargs int, release func(failed bool), err error)) resp.RedisData {
+	lockKeys := make([]string, len(keys))
+	for i, key := range keys {
+		lockKeys[i] = string(key)
+		m.CheckTTL(lockKeys[i])
+	}
+	if len(lockKeys) > 0 {
+		m.locks.LockMulti(lockKeys)
+		defer m.locks.UnlockMulti(lockKeys)
+	}
+
+	ctx, run := m.scripts.start(readOnly)
+	defer m.scripts.finish(run)
+	L, nargs, release, err := prepare(ctx, &scriptEnv{sm: m.scriptContext(lockKeys), run: run})
+	if err != nil {
+		return luaError(err, run)
+	}
+	if err = L.PCall(nargs, 1, nil); err != nil {
+		release(true)
+		return luaError(err, run)
+	}
+	res := luaToResp(L.Get(-1))
+	release(false)
+	return res
+}
+
+// evalProto runs a compiled script with KEYS and ARGV in a new lua state
+func (m *MemDb) evalProto(proto *lua.FunctionProto, keys, args [][]byte, readOnly bool) resp.RedisData {
+	return m.runScript(keys, readOnly, func(ctx context.Context, env *scriptEnv) (*lua.LState, int, func(bool), error) {
+		L := newLuaState(ctx, env)
+		L.SetGlobal("KEYS", luaArray(L, keys))
+		L.SetGlobal("ARGV", luaArray(L, args))
+		L.Push(L.NewFunctionFromProto(proto))
+		return L, 0, func(bool) { L.Close() }, nil
+	})
+}
+
+func evalScript(m *MemDb, cmd [][]byte) resp.RedisData {
+	cmdName := strings.ToLower(string(cmd[0]))
+	if cmdName != "eval" && cmdName != "eval_ro" && cmdName != "evalsha" && cmdName != "evalsha_ro" {
+		logger.Error("evalScript function: cmdName is not eval, eval_ro, evalsha or evalsha_ro")
+		return resp.NewErrorData("server error")
+	}
+	if len(cmd) < 3 {
+		return resp.NewErrorData(fmt.Sprintf("ERR wrong number of arguments for '%s' command", cmdName))
+	}
+	keys, args, errData := parseKeysArgs(cmd[2:])
+	if errData != nil {
+		return errData
+	}
+
+	var proto *lua.FunctionProto
+	if cmdName == "eval" || cmdName == "eval_ro" {
+		var err error
+		_, proto, err = m.scripts.load(cmd[1])
+		if err != nil {
+			return resp.NewErrorData("ERR Error compiling script (new function): " + err.Error())
+		}
+	} else {
+		var ok bool
+		proto, ok = m.scripts.get(string(cmd[1]))
+		if !ok {
+			return resp.NewErrorData("NOSCRIPT No matching script. Please use EVAL.")
+		}
+	}
+	return m.evalProto(proto, keys, args, strings.HasSuffix(cmdName, "_ro"))
+}
+
+func scriptCommand(m *MemDb, cmd [][]byte) resp.RedisData {
+	cmdName := strings.ToLower(string(cmd[0]))
+	if cmdName != "script" {
+		logger.Error("scriptCommand function: cmdName is not script")
+		return resp.NewErrorData("server error")
+	}
+	if len(cmd) < 2 {
+		return resp.NewErrorData("ERR wrong number of arguments for 'script' command")
+	}
+	subCmd := strings.ToLower(string(cmd[1]))
+	switch subCmd {
+	case "load":
+		if len(cmd) != 3 {
+			return resp.NewErrorData("ERR wrong number of arguments for 'script|load' command")
+		}
+		sha, _, err := m.scripts.load(cmd[2])
+		if err != nil {
+			return resp.NewErrorData("ERR Error compiling script (new function): " + err.Error())
+		}
+		return resp.NewBulkData([]byte(sha))
+	case "exists":
+		if len(cmd) < 3 {
+			return resp.NewErrorData("ERR wrong number of arguments for 'script|exists' command")
+		}
+		res := make([]resp.RedisData, 0, len(cmd)-2)
+		for _, sha := range cmd[2:] {
+			if m.scripts.exists(string(sha)) {
+				res = append(res, resp.NewIntData(1))
+			} else {
+				res = append(res, resp.NewIntData(0))
+			}
+		}
+		return resp.NewArrayData(res)
+	case "flush":
+		if len(cmd) > 3 {
+			return resp.NewErrorData("ERR wrong number of arguments for 'script|flush' command")
+		}
+		if len(cmd) == 3 {
+			mode := strings.ToLower(string(cmd[2]))
+			if mode != "sync" && mode != "async" {
+				return resp.NewErrorData("ERR SCRIPT FLUSH only support SYNC|ASYNC option")
+			}
+		}
+		m.scripts.flush()
+		return resp.NewStringData("OK")
+	case "kill":
+		if len(cmd) != 2 {
+			return resp.NewErrorData("ERR wrong number of arguments for 'script|kill' command")
+		}
+		return m.scripts.kill()
+	default:
+		return resp.NewErrorData(fmt.Sprintf("ERR unknown subcommand '%s'. Try SCRIPT HELP.", cmd[1]))
+	}
+}
+
+func RegisterScriptCommands() {
+	RegisterCommand("eval", evalScript, flagNoScript)
+	RegisterCommand("eval_ro", evalScript, flagNoScript, flagReadOnly)
+	RegisterCommand("evalsha", evalScript, flagNoScript)
+	RegisterCommand("evalsha_ro", evalScript, flagNoScript, flagReadOnly)
+	RegisterCommand("script", scriptCommand, flagNoScript)
+}
@@ -0,0 +1,183 @@
+package memdb
+
+import (
+	"easyRedis/config"
+	"easyRedis/logger"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newScriptDb() *MemDb {
+	// slow scripts are logged
+	if err := logger.Setup(&config.Config{LogDir: os.TempDir()}); err == nil {
+		logger.Disable()
+	}
+	return newTestDb()
+}
+
+func TestEvalScript(t *testing.T) {
+	mem := newScriptDb()
+
+	res := exec(mem, "eval", "redis.call('set', KEYS[1], ARGV[1]) return redis.call('get', KEYS[1])", "1", "k", "v")
+	if res != "$1\r\nv\r\n" {
+		t.Errorf("eval reply %q", res)
+	}
+
+	// conversions between lua values and replies
+	res = exec(mem, "eval", "return {1, 'a', 3.9, true, false, {ok='fine'}}", "0")
+	if res != "*6\r\n:1\r\n$1\r\na\r\n:3\r\n:1\r\n$-1\r\n+fine\r\n" {
+		t.Errorf("eval conversion reply %q", res)
+	}
+	res = exec(mem, "eval", "return redis.call('incr', KEYS[1])", "1", "k")
+	if !strings.HasPrefix(res, "-") {
+		t.Errorf("redis.call error should be raised, reply %q", res)
+	}
+	res = exec(mem, "eval", "local r = redis.pcall('incr', KEYS[1]) return type(r) == 'table' and r.err ~= nil", "1", "k")
+	if res != ":1\r\n" {
+		t.Errorf("redis.pcall should return the error, reply %q", res)
+	}
+	res = exec(mem, "eval", "return redis.error_reply('MY error')", "0")
+	if res != "-MY error\r\n" {
+		t.Errorf("error_reply %q", res)
+	}
+	res = exec(mem, "eval", "return 1", "2", "a")
+	if !strings.HasPrefix(res, "-ERR Number of keys") {
+		t.Errorf("numkeys check reply %q", res)
+	}
+	res = exec(mem, "eval", "return redis.call('eval', 'return 1', '0')", "0")
+	if !strings.Contains(res, "not allowed from script") {
+		t.Errorf("nested eval reply %q", res)
+	}
+
+	// read only scripts
+	res = exec(mem, "eval_ro", "return redis.call('get', KEYS[1])", "1", "k")
+	if res != "$1\r\nv\r\n" {
+		t.Errorf("eval_ro reply %q", res)
+	}
+	res = exec(mem, "eval_ro", "return redis.call('set', KEYS[1], 'x')", "1", "k")
+	if !strings.Contains(res, "Write commands are not allowed from read-only scripts") {
+		t.Errorf("eval_ro write reply %q", res)
+	}
+}
+
+func TestScriptCommands(t *testing.T) {
+	mem := newScriptDb()
+
+	script := "return ARGV[1]"
+	sha := sha1Hex([]byte(script))
+	if res := exec(mem, "evalsha", sha, "0", "a"); !strings.HasPrefix(res, "-NOSCRIPT") {
+		t.Errorf("evalsha before load reply %q", res)
+	}
+	if res := exec(mem, "script", "load", script); res != "$40\r\n"+sha+"\r\n" {
+		t.Errorf("script load reply %q", res)
+	}
+	if res := exec(mem, "evalsha", strings.ToUpper(sha), "0", "a"); res != "$1\r\na\r\n" {
+		t.Errorf("evalsha reply %q", res)
+	}
+	if res := exec(mem, "script", "exists", sha, "nope"); res != "*2\r\n:1\r\n:0\r\n" {
+		t.Errorf("script exists reply %q", res)
+	}
+	exec(mem, "script", "flush")
+	if res := exec(mem, "script", "exists", sha); res != "*1\r\n:0\r\n" {
+		t.Errorf("script exists after flush reply %q", res)
+	}
+	if res := exec(mem, "script", "load", "return +"); !strings.HasPrefix(res, "-ERR Error compiling script") {
+		t.Errorf("script load syntax error reply %q", res)
+	}
+	if res := exec(mem, "script", "kill"); !strings.HasPrefix(res, "-NOTBUSY") {
+		t.Errorf("script kill reply %q", res)
+	}
+}
+
+func TestScriptAtomic(t *testing.T) {
+	mem := newScriptDb()
+
+	// concurrent read-modify-write scripts must not lose updates
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			exec(mem, "eval", "local v = tonumber(redis.call('get', KEYS[1]) or '0') redis.call('set', KEYS[1], tostring(v + 1))", "1", "cnt")
+		}()
+	}
+	wg.Wait()
+	if res := exec(mem, "get", "cnt"); res != "$2\r\n50\r\n" {
+		t.Errorf("get cnt %q, expect 50", res)
+	}
+}
+
+// a script can only use the keys declared in KEYS, the others are not locked for it
+func TestScriptUndeclaredKeys(t *testing.T) {
+	mem := newScriptDb()
+	exec(mem, "set", "other", "1")
+	exec(mem, "sadd", "s", "a")
+
+	res := exec(mem, "eval", "return redis.call('get', 'other')", "0")
+	if !strings.Contains(res, "ERR Script attempted to access key 'other' that is not declared in KEYS") {
+		t.Errorf("undeclared read reply %q", res)
+	}
+	res = exec(mem, "eval", "redis.call('set', KEYS[1], 'v') return redis.call('set', 'other', '2')", "1", "k")
+	if !strings.Contains(res, "not declared in KEYS") {
+		t.Errorf("undeclared write reply %q", res)
+	}
+	if res = exec(mem, "get", "other"); res != "$1\r\n1\r\n" {
+		t.Errorf("undeclared key must not be written, get reply %q", res)
+	}
+
+	// the command is rejected before it writes, and the script can go on with its keys
+	res = exec(mem, "eval", "local r = redis.pcall('sunionstore', 'dst', KEYS[1]) "+
+		"redis.call('sadd', KEYS[1], 'b') return {r.err ~= nil, redis.call('scard', KEYS[1])}", "1", "s")
+	if res != "*2\r\n:1\r\n:2\r\n" {
+		t.Errorf("pcall with an undeclared key reply %q", res)
+	}
+	if res = exec(mem, "exists", "dst"); res != ":0\r\n" {
+		t.Errorf("undeclared destination must not be written, exists reply %q", res)
+	}
+}
+
+func TestScriptTimeLimit(t *testing.T) {
+	mem := newScriptDb()
+	limit := config.Configures.LuaTimeLimit
+	config.Configures.LuaTimeLimit = 50
+	defer func() { config.Configures.LuaTimeLimit = limit }()
+
+	res := exec(mem, "eval", "while true do end", "0")
+	if !strings.Contains(res, "exceeding the time limit") {
+		t.Errorf("time limit reply %q", res)
+	}
+
+	// a script that has written is not stopped by the time limit, since its writes can't be rolled back
+	config.Configures.LuaTimeLimit = 1
+	res = exec(mem, "eval", "redis.call('set', KEYS[1], '1') local i = 0 while i < 1000000 do i = i + 1 end "+
+		"redis.call('set', KEYS[1], '2') return i", "1", "w")
+	if res != ":1000000\r\n" || exec(mem, "get", "w") != "$1\r\n2\r\n" {
+		t.Errorf("written script over the time limit reply %q", res)
+	}
+
+	// SCRIPT KILL stops a running read only script, but not a script that has written
+	config.Configures.LuaTimeLimit = 0
+	done := make(chan string)
+	go func() {
+		done <- exec(mem, "eval", "redis.call('get', KEYS[1]) while true do end", "1", "a")
+	}()
+	time.Sleep(20 * time.Millisecond)
+	if res = exec(mem, "script", "kill"); res != "+OK\r\n" {
+		t.Errorf("script kill reply %q", res)
+	}
+	if res = <-done; !strings.Contains(res, "killed by user") {
+		t.Errorf("killed script reply %q", res)
+	}
+
+	go func() {
+		done <- exec(mem, "eval", "redis.call('set', KEYS[1], 'b') local i = 0 while i < 3000000 do i = i + 1 end return i", "1", "a")
+	}()
+	time.Sleep(20 * time.Millisecond)
+	if res = exec(mem, "script", "kill"); !strings.HasPrefix(res, "-UNKILLABLE") && !strings.HasPrefix(res, "-NOTBUSY") {
+		t.Errorf("script kill reply %q", res)
+	}
+	<-done
+}
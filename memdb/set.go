@@ -4,7 +4,7 @@ import (
 	"easyRedis/datastructure"
 	"easyRedis/logger"
 	"easyRedis/resp"
-	"math"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -183,6 +183,36 @@ func sDiffStoreSet(m *MemDb, cmd [][]byte) resp.RedisData {
 	return resp.NewIntData(int64(diffRes.Len()))
 }
 
+// interSets returns the sets of keys ordered by size, the smallest first.
+// It returns nil if one of the keys is missing or empty, the intersection is then empty.
+// The caller must hold the locks of keys.
+func (m *MemDb) interSets(keys []string) ([]*datastructure.Set, resp.RedisData) {
+	sets := make([]*datastructure.Set, 0, len(keys))
+	empty := false
+	for _, key := range keys {
+		temp, ok := m.db.Get(key)
+		if !ok {
+			empty = true
+			continue
+		}
+		set, ok := temp.(*datastructure.Set)
+		if !ok {
+			return nil, resp.NewErrorData("WRONGTYPE Operation against a key holding the wrong kind of value")
+		}
+		if set.Len() == 0 {
+			empty = true
+		}
+		sets = append(sets, set)
+	}
+	if empty {
+		return nil, nil
+	}
+	sort.Slice(sets, func(i, j int) bool {
+		return sets[i].Len() < sets[j].Len()
+	})
+	return sets, nil
+}
+
 func sInterSet(m *MemDb, cmd [][]byte) resp.RedisData {
 	if strings.ToLower(string(cmd[0])) != "sinter" {
 		logger.Error("sInterSet Function: cmdName is not sinter")
@@ -195,35 +225,23 @@ func sInterSet(m *MemDb, cmd [][]byte) resp.RedisData {
 
 	keys := make([]string, 0, len(cmd)-1)
 	for i := 1; i < len(cmd); i++ {
+		m.CheckTTL(string(cmd[i]))
 		keys = append(keys, string(cmd[i]))
 	}
 
 	m.locks.RLockMulti(keys)
 	defer m.locks.RUnlockMulti(keys)
 
-	// find the shortest set as the primary set to decrease the time complexity
-	sets := make([]*datastructure.Set, 0)
-	shortestSet := 0
-	shortestLen := math.MaxInt
-
-	for _, key := range keys {
-		temp, ok := m.db.Get(key)
-		if ok {
-			set, ok := temp.(*datastructure.Set)
-			if !ok {
-				return resp.NewErrorData("WRONGTYPE Operation against a key holding the wrong kind of value")
-			}
-			sets = append(sets, set)
-			if set.Len() < shortestLen {
-				shortestLen = set.Len()
-				shortestSet = len(sets) - 1
-			}
-		}
+	sets, errData := m.interSets(keys)
+	if errData != nil {
+		return errData
 	}
-	primSet := sets[shortestSet]
-	sets = append(sets[:shortestSet], sets[shortestSet+1:]...)
-	interSet := primSet.Intersect(sets...)
 	res := make([]resp.RedisData, 0)
+	if sets == nil {
+		return resp.NewArrayData(res)
+	}
+	// iterate over the smallest set to decrease the time complexity
+	interSet := sets[0].Intersect(sets[1:]...)
 	for _, member := range interSet.Member() {
 		res = append(res, resp.NewBulkData([]byte(member)))
 	}
@@ -242,46 +260,78 @@ func sInterStoreSet(m *MemDb, cmd [][]byte) resp.RedisData {
 	desKey := string(cmd[1])
 	keys := make([]string, 0, len(cmd)-2)
 	for i := 2; i < len(cmd); i++ {
-		key := string(cmd[i])
-		keys = append(keys, key)
-	}
-	if len(keys) == 0 {
-		return resp.NewIntData(0)
+		m.CheckTTL(string(cmd[i]))
+		keys = append(keys, string(cmd[i]))
 	}
-	m.locks.RLockMulti(keys)
+	m.CheckTTL(desKey)
+	lockKeys := append([]string{desKey}, keys...)
+	m.locks.LockMulti(lockKeys)
+	defer m.locks.UnlockMulti(lockKeys)
 
-	// find the shortest set as primary set to decrease the time complexity
-	sets := make([]*datastructure.Set, 0)
-	shortestSet := 0
-	shortestLen := math.MaxInt
-	for _, key := range keys {
-		temp, ok := m.db.Get(key)
-		if ok {
-			set, ok := temp.(*datastructure.Set)
-			if !ok {
-				m.locks.RUnlockMulti(keys)
-				return resp.NewErrorData("WRONGTYPE Operation against a key holding the wrong kind of value")
-			}
-			sets = append(sets, set)
-			if set.Len() < shortestLen {
-				shortestLen = set.Len()
-				shortestSet = len(sets) - 1
-			}
-		}
+	sets, errData := m.interSets(keys)
+	if errData != nil {
+		return errData
+	}
+	interSet := datastructure.NewSet()
+	if sets != nil {
+		interSet = sets[0].Intersect(sets[1:]...)
 	}
-	primSet := sets[shortestSet]
-	sets = append(sets[:shortestSet], sets[shortestSet+1:]...)
-	interSet := primSet.Intersect(sets...)
-	m.locks.RUnlockMulti(keys)
 
+	// the destination is overwritten, and deleted if the intersection is empty
+	m.db.Delete(desKey)
+	m.DelTTL(desKey)
 	if interSet.Len() != 0 {
-		m.locks.Lock(desKey)
 		m.db.Set(desKey, interSet)
-		m.locks.Unlock(desKey)
 	}
 	return resp.NewIntData(int64(interSet.Len()))
 }
 
+func sInterCardSet(m *MemDb, cmd [][]byte) resp.RedisData {
+	if strings.ToLower(string(cmd[0])) != "sintercard" {
+		logger.Error("sInterCardSet Function: cmdName is not sintercard")
+		return resp.NewErrorData("server error")
+	}
+	if len(cmd) < 3 {
+		return resp.NewErrorData("wrong number of arguments for 'sintercard' command")
+	}
+
+	numKeys, err := strconv.Atoi(string(cmd[1]))
+	if err != nil || numKeys <= 0 {
+		return resp.NewErrorData("ERR numkeys should be greater than 0")
+	}
+	if numKeys > len(cmd)-2 {
+		return resp.NewErrorData("ERR Number of keys can't be greater than number of args")
+	}
+	limit := 0
+	for i := 2 + numKeys; i < len(cmd); i++ {
+		if strings.ToLower(string(cmd[i])) != "limit" || i+1 >= len(cmd) {
+			return resp.NewErrorData("ERR syntax error")
+		}
+		limit, err = strconv.Atoi(string(cmd[i+1]))
+		if err != nil || limit < 0 {
+			return resp.NewErrorData("ERR LIMIT can't be negative")
+		}
+		i++
+	}
+
+	keys := make([]string, 0, numKeys)
+	for _, k := range cmd[2 : 2+numKeys] {
+		m.CheckTTL(string(k))
+		keys = append(keys, string(k))
+	}
+	m.locks.RLockMulti(keys)
+	defer m.locks.RUnlockMulti(keys)
+
+	sets, errData := m.interSets(keys)
+	if errData != nil {
+		return errData
+	}
+	if sets == nil {
+		return resp.NewIntData(0)
+	}
+	return resp.NewIntData(int64(sets[0].IntersectCard(limit, sets[1:]...)))
+}
+
 func sIsMemberSet(m *MemDb, cmd [][]byte) resp.RedisData {
 	if strings.ToLower(string(cmd[0])) != "sismember" {
 		logger.Error("sIsMemberSet Function: cmdName is not sismember")
@@ -311,6 +361,38 @@ func sIsMemberSet(m *MemDb, cmd [][]byte) resp.RedisData {
 	return resp.NewIntData(0)
 }
 
+func sMIsMemberSet(m *MemDb, cmd [][]byte) resp.RedisData {
+	if strings.ToLower(string(cmd[0])) != "smismember" {
+		logger.Error("sMIsMemberSet Function: cmdName is not smismember")
+		return resp.NewErrorData("server error")
+	}
+
+	if len(cmd) < 3 {
+		return resp.NewErrorData("wrong number of arguments for 'smismember' command")
+	}
+	key := string(cmd[1])
+	m.CheckTTL(key)
+
+	m.locks.RLock(key)
+	defer m.locks.RUnlock(key)
+
+	var set *datastructure.Set
+	if temp, ok := m.db.Get(key); ok {
+		if set, ok = temp.(*datastructure.Set); !ok {
+			return resp.NewErrorData("WRONGTYPE Operation against a key holding the wrong kind of value")
+		}
+	}
+	res := make([]resp.RedisData, 0, len(cmd)-2)
+	for _, member := range cmd[2:] {
+		if set != nil && set.Has(string(member)) {
+			res = append(res, resp.NewIntData(1))
+		} else {
+			res = append(res, resp.NewIntData(0))
+		}
+	}
+	return resp.NewArrayData(res)
+}
+
 func sMembersSet(m *MemDb, cmd [][]byte) resp.RedisData {
 	if strings.ToLower(string(cmd[0])) != "smembers" {
 		logger.Error("sMembersSet Function: cmdName is not smembers")
@@ -617,18 +699,20 @@ func sUnionStoreSet(m *MemDb, cmd [][]byte) resp.RedisData {
 
 func RegisterSetCommands() {
 	RegisterCommand("sadd", sAddSet)
-	RegisterCommand("scard", sCardSet)
-	RegisterCommand("sdiff", sDiffSet)
+	RegisterCommand("scard", sCardSet, flagReadOnly)
+	RegisterCommand("sdiff", sDiffSet, flagReadOnly)
 	RegisterCommand("sdiffstore", sDiffStoreSet)
-	RegisterCommand("sinter", sInterSet)
+	RegisterCommand("sinter", sInterSet, flagReadOnly)
 	RegisterCommand("sinterstore", sInterStoreSet)
-	RegisterCommand("sismember", sIsMemberSet)
-	RegisterCommand("smembers", sMembersSet)
+	RegisterCommand("sintercard", sInterCardSet, flagReadOnly)
+	RegisterCommand("sismember", sIsMemberSet, flagReadOnly)
+	RegisterCommand("smismember", sMIsMemberSet, flagReadOnly)
+	RegisterCommand("smembers", sMembersSet, flagReadOnly)
 	RegisterCommand("smove", sMoveSet)
 	RegisterCommand("spop", sPopSet)
-	RegisterCommand("srandmember", sRandMemberSet)
+	RegisterCommand("srandmember", sRandMemberSet, flagReadOnly)
 	RegisterCommand("srem", sRemSet)
-	RegisterCommand("sunion", sUnionSet)
+	RegisterCommand("sunion", sUnionSet, flagReadOnly)
 	RegisterCommand("sunionstore", sUnionStoreSet)
 
 }
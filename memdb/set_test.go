@@ -0,0 +1,78 @@
+package memdb
+
+import (
+	"testing"
+)
+
+func TestSMIsMemberSet(t *testing.T) {
+	mem := newTestDb()
+	exec(mem, "sadd", "s", "a", "b")
+	if res := exec(mem, "smismember", "s", "a", "c", "b"); res != "*3\r\n:1\r\n:0\r\n:1\r\n" {
+		t.Errorf("smismember reply %q", res)
+	}
+	if res := exec(mem, "smismember", "missing", "a"); res != "*1\r\n:0\r\n" {
+		t.Errorf("smismember of a missing key reply %q", res)
+	}
+	exec(mem, "set", "str", "v")
+	if res := exec(mem, "smismember", "str", "a"); res[0] != '-' {
+		t.Errorf("smismember of a string reply %q", res)
+	}
+}
+
+func TestSInterCardSet(t *testing.T) {
+	mem := newTestDb()
+	exec(mem, "sadd", "s1", "a", "b", "c", "d")
+	exec(mem, "sadd", "s2", "b", "c", "d", "e")
+	exec(mem, "sadd", "s3", "c", "d")
+	if res := exec(mem, "sintercard", "3", "s1", "s2", "s3"); res != ":2\r\n" {
+		t.Errorf("sintercard reply %q", res)
+	}
+	if res := exec(mem, "sintercard", "2", "s1", "s2", "limit", "2"); res != ":2\r\n" {
+		t.Errorf("sintercard limit reply %q", res)
+	}
+	if res := exec(mem, "sintercard", "2", "s1", "s2", "limit", "0"); res != ":3\r\n" {
+		t.Errorf("sintercard limit 0 reply %q", res)
+	}
+	if res := exec(mem, "sintercard", "2", "s1", "missing"); res != ":0\r\n" {
+		t.Errorf("sintercard with a missing key reply %q", res)
+	}
+	if res := exec(mem, "sintercard", "0", "s1"); res != "-ERR numkeys should be greater than 0\r\n" {
+		t.Errorf("sintercard numkeys 0 reply %q", res)
+	}
+	if res := exec(mem, "sintercard", "1", "s1", "limit", "-1"); res != "-ERR LIMIT can't be negative\r\n" {
+		t.Errorf("sintercard negative limit reply %q", res)
+	}
+	exec(mem, "set", "str", "v")
+	if res := exec(mem, "sintercard", "2", "missing", "str"); res[0] != '-' {
+		t.Errorf("sintercard with a string reply %q", res)
+	}
+}
+
+func TestSInterStoreSet(t *testing.T) {
+	mem := newTestDb()
+	exec(mem, "sadd", "s1", "a", "b", "c")
+	exec(mem, "sadd", "s2", "b", "c", "d")
+	if res := exec(mem, "sinterstore", "dst", "s1", "s2"); res != ":2\r\n" {
+		t.Errorf("sinterstore reply %q", res)
+	}
+	if res := sortedMembers(mem, "dst"); res != "$1,$1,*2,b,c" {
+		t.Errorf("sinterstore members %q", res)
+	}
+	if res := exec(mem, "sinter", "s1", "missing"); res != "*0\r\n" {
+		t.Errorf("sinter with a missing key reply %q", res)
+	}
+	if res := exec(mem, "sinter", "missing1", "missing2"); res != "*0\r\n" {
+		t.Errorf("sinter of missing keys reply %q", res)
+	}
+	// an empty intersection deletes the destination
+	if res := exec(mem, "sinterstore", "dst", "s1", "missing"); res != ":0\r\n" {
+		t.Errorf("sinterstore with a missing key reply %q", res)
+	}
+	if res := exec(mem, "scard", "dst"); res != ":0\r\n" {
+		t.Errorf("sinterstore kept the destination: %q", res)
+	}
+	// the destination may be one of the sources
+	if res := exec(mem, "sinterstore", "s1", "s1", "s2"); res != ":2\r\n" {
+		t.Errorf("sinterstore into a source reply %q", res)
+	}
+}
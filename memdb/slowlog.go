@@ -0,0 +1,195 @@
+package memdb
+
+import (
+	"easyRedis/logger"
+	"easyRedis/resp"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// slowlog.go implements SLOWLOG GET, LEN, RESET and HELP.
+//
+// ExecClientCommand times every command, the ones slower than slowlog-log-slower-than are kept
+// in a ring of the latest slowlog-max-len entries. Blocking commands are not kept, because their
+// duration is mostly the time waiting for a key. The arguments are truncated like redis,
+// so that a huge command doesn't make the log huge.
+
+var slowlogHelp = []string{
+	"SLOWLOG <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"GET [<count>]",
+	"    Return top <count> entries from the slowlog (default: 10, -1 mean all).",
+	"    Entries are made of:",
+	"    id, timestamp, time in microseconds, arguments array, client IP and port,",
+	"    client name",
+	"LEN",
+	"    Return the length of the slowlog.",
+	"RESET",
+	"    Reset the slowlog.",
+	"HELP",
+	"    Print this help.",
+}
+
+const (
+	// slowlogMaxArgc is the max number of arguments kept, the last one kept tells how many are dropped
+	slowlogMaxArgc = 32
+	// slowlogMaxArgLen is the max length of an argument kept
+	slowlogMaxArgLen = 128
+	// slowlogDefaultGet is the number of entries replied by SLOWLOG GET without a count
+	slowlogDefaultGet = 10
+)
+
+type slowlogEntry struct {
+	id       int64
+	start    time.Time
+	duration time.Duration
+	args     [][]byte
+	addr     string
+	name     string
+}
+
+// slowLog is a ring of the latest slow commands, entries[head] is the oldest one once the ring is full
+type slowLog struct {
+	mu sync.Mutex
+	// slower is the threshold of the commands kept, a negative one disables the log
+	slower  time.Duration
+	maxLen  int
+	entries []slowlogEntry
+	head    int
+	nextID  int64
+}
+
+// newSlowLog creates a slow log keeping maxLen commands slower than slowerThan microseconds
+func newSlowLog(slowerThan int, maxLen int) *slowLog {
+	return &slowLog{slower: time.Duration(slowerThan) * time.Microsecond, maxLen: maxLen}
+}
+
+// log keeps cmd of client in the log if duration reaches the threshold, client may be nil
+func (s *slowLog) log(start time.Time, duration time.Duration, cmd [][]byte, client *Client) {
+	if s.slower < 0 || duration < s.slower {
+		return
+	}
+	entry := slowlogEntry{start: start, duration: duration, args: truncateArgs(cmd)}
+	if client != nil {
+		entry.addr, entry.name = client.Addr, client.Name
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry.id = s.nextID
+	s.nextID++
+	if s.maxLen <= 0 {
+		return
+	}
+	if len(s.entries) < s.maxLen {
+		s.entries = append(s.entries, entry)
+		return
+	}
+	s.entries[s.head] = entry
+	s.head = (s.head + 1) % len(s.entries)
+}
+
+// latest returns the latest count entries from the newest one, all of them if count is negative
+func (s *slowLog) latest(count int) []slowlogEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if count < 0 || count > len(s.entries) {
+		count = len(s.entries)
+	}
+	res := make([]slowlogEntry, count)
+	for i := range res {
+		res[i] = s.entries[(s.head+len(s.entries)-1-i)%len(s.entries)]
+	}
+	return res
+}
+
+func (s *slowLog) len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.entries)
+}
+
+// reset removes the entries, the ids keep growing
+func (s *slowLog) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = nil
+	s.head = 0
+}
+
+// truncateArgs copies the arguments of cmd, keeping at most slowlogMaxArgc arguments
+// of at most slowlogMaxArgLen bytes
+func truncateArgs(cmd [][]byte) [][]byte {
+	argc := len(cmd)
+	if argc > slowlogMaxArgc {
+		argc = slowlogMaxArgc
+	}
+	args := make([][]byte, argc)
+	for i := range args {
+		if i == slowlogMaxArgc-1 && len(cmd) > slowlogMaxArgc {
+			args[i] = []byte(fmt.Sprintf("... (%d more arguments)", len(cmd)-slowlogMaxArgc+1))
+		} else if len(cmd[i]) > slowlogMaxArgLen {
+			args[i] = []byte(fmt.Sprintf("%s... (%d more bytes)", cmd[i][:slowlogMaxArgLen], len(cmd[i])-slowlogMaxArgLen))
+		} else {
+			args[i] = append([]byte(nil), cmd[i]...)
+		}
+	}
+	return args
+}
+
+func slowlogCommand(m *MemDb, cmd [][]byte) resp.RedisData {
+	if strings.ToLower(string(cmd[0])) != "slowlog" {
+		logger.Error("slowlogCommand Function: cmdName is not slowlog")
+		return resp.NewErrorData("server error")
+	}
+	if len(cmd) < 2 {
+		return resp.NewErrorData("ERR wrong number of arguments for 'slowlog' command")
+	}
+	sub := strings.ToLower(string(cmd[1]))
+	switch {
+	case sub == "help" && len(cmd) == 2:
+		res := make([]resp.RedisData, len(slowlogHelp))
+		for i, line := range slowlogHelp {
+			res[i] = resp.NewStringData(line)
+		}
+		return resp.NewArrayData(res)
+	case sub == "len" && len(cmd) == 2:
+		return resp.NewIntData(int64(m.slowlog.len()))
+	case sub == "reset" && len(cmd) == 2:
+		m.slowlog.reset()
+		return resp.NewStringData("OK")
+	case sub == "get" && len(cmd) <= 3:
+		count := slowlogDefaultGet
+		if len(cmd) == 3 {
+			n, err := strconv.Atoi(string(cmd[2]))
+			if err != nil || n < -1 {
+				return resp.NewErrorData("ERR count should be greater than or equal to -1")
+			}
+			count = n
+		}
+		entries := m.slowlog.latest(count)
+		res := make([]resp.RedisData, len(entries))
+		for i, entry := range entries {
+			args := make([]resp.RedisData, len(entry.args))
+			for j, arg := range entry.args {
+				args[j] = resp.NewBulkData(arg)
+			}
+			res[i] = resp.NewArrayData([]resp.RedisData{
+				resp.NewIntData(entry.id),
+				resp.NewIntData(entry.start.Unix()),
+				resp.NewIntData(entry.duration.Microseconds()),
+				resp.NewArrayData(args),
+				resp.NewBulkData([]byte(entry.addr)),
+				resp.NewBulkData([]byte(entry.name)),
+			})
+		}
+		return resp.NewArrayData(res)
+	}
+	return resp.NewErrorData(fmt.Sprintf("ERR unknown subcommand or wrong number of arguments for '%s'. Try SLOWLOG HELP.", cmd[1]))
+}
+
+func RegisterSlowlogCommands() {
+	RegisterCommand("slowlog", slowlogCommand, flagReadOnly)
+}
@@ -0,0 +1,85 @@
+package memdb
+
+import (
+	"easyRedis/config"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func newSlowlogDb(t *testing.T, slowerThan, maxLen int) *MemDb {
+	withConfig(t, &config.Config{ShardNum: 100, SlowlogLogSlowerThan: slowerThan, SlowlogMaxLen: maxLen})
+	return newTestDb()
+}
+
+func TestSlowlog(t *testing.T) {
+	m := newSlowlogDb(t, 0, 3)
+	client := NewClient("127.0.0.1:5000")
+	if res := execClient(m, client, "client", "setname", "worker"); res != "+OK\r\n" {
+		t.Fatalf("client setname reply %q", res)
+	}
+	for i := 0; i < 4; i++ {
+		execClient(m, client, "set", "k", strconv.Itoa(i))
+	}
+	// the ring keeps the latest 3 commands, from the newest one
+	entries := m.slowlog.latest(-1)
+	if len(entries) != 3 || exec(m, "slowlog", "len") != ":3\r\n" {
+		t.Fatalf("slowlog has %d entries", len(entries))
+	}
+	for i, entry := range entries {
+		if entry.id != int64(4-i) || string(entry.args[2]) != strconv.Itoa(3-i) ||
+			entry.addr != "127.0.0.1:5000" || entry.name != "worker" {
+			t.Errorf("entry %d is %+v", i, entry)
+		}
+	}
+
+	res := exec(m, "slowlog", "get", "1")
+	if !strings.HasPrefix(res, "*1\r\n*6\r\n:5\r\n") ||
+		!strings.HasSuffix(res, "*2\r\n$7\r\nslowlog\r\n$3\r\nlen\r\n$0\r\n\r\n$0\r\n\r\n") {
+		t.Errorf("slowlog get 1 reply %q", res)
+	}
+	if res = exec(m, "slowlog", "get", "-2"); !strings.HasPrefix(res, "-ERR count") {
+		t.Errorf("slowlog get -2 reply %q", res)
+	}
+	if exec(m, "slowlog", "reset") != "+OK\r\n" || len(m.slowlog.latest(-1)) != 1 {
+		t.Error("slowlog reset doesn't remove the entries")
+	}
+
+	// a negative threshold disables the log
+	m = newSlowlogDb(t, -1, 3)
+	exec(m, "set", "k", "v")
+	if exec(m, "slowlog", "len") != ":0\r\n" {
+		t.Error("slowlog is not disabled")
+	}
+}
+
+func TestSlowlogTruncateArgs(t *testing.T) {
+	cmd := [][]byte{[]byte("mset")}
+	for i := 0; i < 40; i++ {
+		cmd = append(cmd, []byte(strings.Repeat("x", 130)))
+	}
+	args := truncateArgs(cmd)
+	if len(args) != slowlogMaxArgc || string(args[0]) != "mset" ||
+		string(args[1]) != strings.Repeat("x", 128)+"... (2 more bytes)" ||
+		string(args[slowlogMaxArgc-1]) != "... (10 more arguments)" {
+		t.Errorf("truncated args %q", args)
+	}
+}
+
+func TestClientName(t *testing.T) {
+	m := newSlowlogDb(t, -1, 0)
+	client := NewClient("127.0.0.1:5000")
+	if res := execClient(m, client, "client", "getname"); res != "$-1\r\n" {
+		t.Errorf("client getname without a name reply %q", res)
+	}
+	if res := execClient(m, client, "client", "setname", "a b"); !strings.HasPrefix(res, "-ERR Client names") {
+		t.Errorf("client setname with a space reply %q", res)
+	}
+	execClient(m, client, "client", "setname", "app")
+	if res := execClient(m, client, "client", "getname"); res != "$3\r\napp\r\n" {
+		t.Errorf("client getname reply %q", res)
+	}
+	if res := exec(m, "client", "getname"); !strings.HasPrefix(res, "-ERR") {
+		t.Errorf("client without a connection reply %q", res)
+	}
+}
@@ -0,0 +1,300 @@
+package memdb
+
+import (
+	"bytes"
+	"easyRedis/datastructure"
+	"easyRedis/logger"
+	"easyRedis/resp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// sort.go implements SORT and SORT_RO on lists, sets and sorted sets.
+//
+// The elements are read under the lock of the key, the BY and GET patterns then lock
+// each key they refer to on its own, like MGET does.
+
+type sortArgs struct {
+	by      string
+	hasBy   bool
+	gets    []string
+	desc    bool
+	alpha   bool
+	offset  int
+	count   int
+	store   string
+	doStore bool
+}
+
+// sortElem is an element with its sort weight
+type sortElem struct {
+	val    []byte
+	score  float64
+	weight []byte
+}
+
+func parseSortArgs(cmd [][]byte, readOnly bool) (*sortArgs, resp.RedisData) {
+	args := &sortArgs{count: -1}
+	syntaxErr := resp.NewErrorData("ERR syntax error")
+	for i := 0; i < len(cmd); i++ {
+		switch strings.ToLower(string(cmd[i])) {
+		case "asc":
+			args.desc = false
+		case "desc":
+			args.desc = true
+		case "alpha":
+			args.alpha = true
+		case "limit":
+			if i+2 >= len(cmd) {
+				return nil, syntaxErr
+			}
+			offset, err1 := strconv.Atoi(string(cmd[i+1]))
+			count, err2 := strconv.Atoi(string(cmd[i+2]))
+			if err1 != nil || err2 != nil {
+				return nil, resp.NewErrorData("ERR value is not an integer or out of range")
+			}
+			args.offset, args.count = offset, count
+			i += 2
+		case "by":
+			if i+1 >= len(cmd) {
+				return nil, syntaxErr
+			}
+			args.by = string(cmd[i+1])
+			args.hasBy = true
+			i++
+		case "get":
+			if i+1 >= len(cmd) {
+				return nil, syntaxErr
+			}
+			args.gets = append(args.gets, string(cmd[i+1]))
+			i++
+		case "store":
+			if readOnly || i+1 >= len(cmd) {
+				return nil, syntaxErr
+			}
+			args.store = string(cmd[i+1])
+			args.doStore = true
+			i++
+		default:
+			return nil, syntaxErr
+		}
+	}
+	return args, nil
+}
+
+// sortLookup returns the value pattern refers to for elem, nil if it doesn't exist.
+// The first '*' of pattern is replaced by elem, and "key->field" refers to a field of a hash.
+func (m *MemDb) sortLookup(pattern string, elem []byte) []byte {
+	if pattern == "#" {
+		return elem
+	}
+	star := strings.IndexByte(pattern, '*')
+	if star < 0 {
+		return nil
+	}
+	rest, field := pattern[star+1:], ""
+	if arrow := strings.Index(rest, "->"); arrow >= 0 && arrow+2 < len(rest) {
+		rest, field = rest[:arrow], rest[arrow+2:]
+	}
+	key := pattern[:star] + string(elem) + rest
+
+	if !m.CheckTTL(key) {
+		return nil
+	}
+	m.locks.RLock(key)
+	defer m.locks.RUnlock(key)
+	temp, ok := m.db.Get(key)
+	if !ok {
+		return nil
+	}
+	var val []byte
+	switch v := temp.(type) {
+	case []byte:
+		if field == "" {
+			val = v
+		}
+	case *datastructure.Hash:
+		if field != "" && v.Exist(field) {
+			val = v.Get(field)
+		}
+	}
+	if val == nil {
+		return nil
+	}
+	// copy the value, it may be changed once the lock is released
+	res := make([]byte, len(val))
+	copy(res, val)
+	return res
+}
+
+// sortElements returns a copy of the elements of key, sorted sets in score order
+func (m *MemDb) sortElements(key string) ([][]byte, bool, resp.RedisData) {
+	m.CheckTTL(key)
+	m.locks.RLock(key)
+	defer m.locks.RUnlock(key)
+	temp, ok := m.db.Get(key)
+	if !ok {
+		return nil, false, nil
+	}
+	var elems [][]byte
+	isSortSet := false
+	switch v := temp.(type) {
+	case *datastructure.List:
+		elems = v.Range(0, -1)
+	case *datastructure.Set:
+		for _, member := range v.Member() {
+			elems = append(elems, []byte(member))
+		}
+	case *datastructure.SortSet:
+		isSortSet = true
+		for _, item := range v.Range(0, -1) {
+			elems = append(elems, []byte(item.Key()))
+		}
+	default:
+		return nil, false, resp.NewErrorData("WRONGTYPE Operation against a key holding the wrong kind of value")
+	}
+	res := make([][]byte, len(elems))
+	for i, elem := range elems {
+		res[i] = append([]byte(nil), elem...)
+	}
+	return res, isSortSet, nil
+}
+
+func sortKey(m *MemDb, cmd [][]byte) resp.RedisData {
+	cmdName := strings.ToLower(string(cmd[0]))
+	if cmdName != "sort" && cmdName != "sort_ro" {
+		logger.Error("sortKey Function: cmdName is not sort or sort_ro")
+		return resp.NewErrorData("server error")
+	}
+	if len(cmd) < 2 {
+		return resp.NewErrorData("ERR wrong number of arguments for '" + cmdName + "' command")
+	}
+	args, errData := parseSortArgs(cmd[2:], cmdName == "sort_ro")
+	if errData != nil {
+		return errData
+	}
+	values, isSortSet, errData := m.sortElements(string(cmd[1]))
+	if errData != nil {
+		return errData
+	}
+
+	// a BY pattern without '*' means no sorting
+	dontSort := args.hasBy && !strings.Contains(args.by, "*")
+	elems := make([]*sortElem, len(values))
+	for i, val := range values {
+		elems[i] = &sortElem{val: val}
+	}
+	if dontSort {
+		// sorted sets keep the score order, reversed by DESC
+		if isSortSet && args.desc {
+			for i, j := 0, len(elems)-1; i < j; i, j = i+1, j-1 {
+				elems[i], elems[j] = elems[j], elems[i]
+			}
+		}
+	} else {
+		for _, elem := range elems {
+			elem.weight = elem.val
+			if args.hasBy {
+				elem.weight = m.sortLookup(args.by, elem.val)
+			}
+			if args.alpha || elem.weight == nil {
+				continue
+			}
+			score, err := strconv.ParseFloat(string(elem.weight), 64)
+			if err != nil {
+				return resp.NewErrorData("ERR One or more scores can't be converted into double")
+			}
+			elem.score = score
+		}
+		sort.SliceStable(elems, func(i, j int) bool {
+			cmp := sortCompare(elems[i], elems[j], args.alpha)
+			if args.desc {
+				return cmp > 0
+			}
+			return cmp < 0
+		})
+	}
+
+	// apply LIMIT
+	start, end := args.offset, len(elems)
+	if start < 0 {
+		start = 0
+	}
+	if start > len(elems) {
+		start = len(elems)
+	}
+	if args.count >= 0 && args.count < end-start {
+		end = start + args.count
+	}
+	elems = elems[start:end]
+
+	var result [][]byte
+	for _, elem := range elems {
+		if len(args.gets) == 0 {
+			result = append(result, elem.val)
+			continue
+		}
+		for _, pattern := range args.gets {
+			result = append(result, m.sortLookup(pattern, elem.val))
+		}
+	}
+
+	if args.doStore {
+		return m.sortStore(args.store, result)
+	}
+	res := make([]resp.RedisData, len(result))
+	for i, val := range result {
+		res[i] = resp.NewBulkData(val)
+	}
+	return resp.NewArrayData(res)
+}
+
+// sortCompare compares the weights, missing weights come first and ties are broken by the elements
+func sortCompare(a, b *sortElem, alpha bool) int {
+	cmp := 0
+	if alpha {
+		if a.weight == nil || b.weight == nil {
+			if a.weight != nil {
+				cmp = 1
+			} else if b.weight != nil {
+				cmp = -1
+			}
+		} else {
+			cmp = bytes.Compare(a.weight, b.weight)
+		}
+	} else if a.score < b.score {
+		cmp = -1
+	} else if a.score > b.score {
+		cmp = 1
+	}
+	if cmp == 0 {
+		cmp = bytes.Compare(a.val, b.val)
+	}
+	return cmp
+}
+
+// sortStore replaces dest with a list of the result, missing values are stored as empty strings
+func (m *MemDb) sortStore(dest string, result [][]byte) resp.RedisData {
+	m.locks.Lock(dest)
+	defer m.locks.Unlock(dest)
+	m.db.Delete(dest)
+	m.DelTTL(dest)
+	if len(result) == 0 {
+		return resp.NewIntData(0)
+	}
+	list := datastructure.NewList()
+	for _, val := range result {
+		if val == nil {
+			val = []byte{}
+		}
+		list.RPush(val)
+	}
+	m.db.Set(dest, list)
+	return resp.NewIntData(int64(len(result)))
+}
+
+func RegisterSortCommands() {
+	RegisterCommand("sort", sortKey)
+	RegisterCommand("sort_ro", sortKey, flagReadOnly)
+}
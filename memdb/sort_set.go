@@ -4,7 +4,6 @@ import (
 	"easyRedis/datastructure"
 	"easyRedis/logger"
 	"easyRedis/resp"
-	"fmt"
 	"math"
 	"strconv"
 	"strings"
@@ -59,6 +58,8 @@ func zAdd(m *MemDb, cmd [][]byte) resp.RedisData {
 	}
 
 	key := string(cmd[1])
+	// wake the clients blocked on key once it is unlocked
+	defer m.signalKey(key)
 	m.locks.Lock(key)
 	defer m.locks.Unlock(key)
 	temp, ok := m.db.Get(key)
@@ -77,8 +78,8 @@ func zAdd(m *MemDb, cmd [][]byte) resp.RedisData {
 	}
 	if nx {
 		if incr {
-			member := sortSet.GetMember(members[0])
-			if member != nil {
+			_, exist := sortSet.GetScore(members[0])
+			if exist {
 				return resp.NewBulkData(nil)
 			}
 			item := &datastructure.StItem{
@@ -91,8 +92,8 @@ func zAdd(m *MemDb, cmd [][]byte) resp.RedisData {
 		var result int64
 		if ch {
 			for i := 0; i < len(members); i++ {
-				member := sortSet.GetMember(members[i])
-				if member == nil {
+				_, exist := sortSet.GetScore(members[i])
+				if !exist {
 					item := &datastructure.StItem{
 						F: scores[i],
 						K: members[i],
@@ -105,8 +106,8 @@ func zAdd(m *MemDb, cmd [][]byte) resp.RedisData {
 		}
 
 		for i := 0; i < len(members); i++ {
-			member := sortSet.GetMember(members[i])
-			if member == nil {
+			_, exist := sortSet.GetScore(members[i])
+			if !exist {
 				item := &datastructure.StItem{
 					F: scores[i],
 					K: members[i],
@@ -119,35 +120,38 @@ func zAdd(m *MemDb, cmd [][]byte) resp.RedisData {
 	}
 	if xx {
 		if incr {
-			member := sortSet.GetMember(members[0])
-			if member == nil {
+			old, exist := sortSet.GetScore(members[0])
+			if !exist {
 				return resp.NewBulkData(nil)
 			}
 			item := &datastructure.StItem{
-				F: scores[0] + member.Score(),
+				F: scores[0] + old,
 				K: members[0],
 			}
 			sortSet.Remove(members[0])
 			sortSet.Add(item)
-			return resp.NewFloat64Data(scores[0] + member.Score())
+			return resp.NewFloat64Data(scores[0] + old)
 		}
 
 		if ch {
 			var result int64
 			for i := 0; i < len(members); i++ {
-				member := sortSet.GetMember(members[i])
-				if member == nil {
+				_, exist := sortSet.GetScore(members[i])
+				if !exist {
 					continue
 				}
-				member.SetScore(scores[i])
+				sortSet.Add(&datastructure.StItem{
+					F: scores[i],
+					K: members[i],
+				})
 				result++
 			}
 			return resp.NewIntData(result)
 		}
 
 		for i := 0; i < len(members); i++ {
-			member := sortSet.GetMember(members[i])
-			if member != nil {
+			_, exist := sortSet.GetScore(members[i])
+			if exist {
 				item := &datastructure.StItem{
 					F: scores[i],
 					K: members[i],
@@ -159,32 +163,32 @@ func zAdd(m *MemDb, cmd [][]byte) resp.RedisData {
 		return resp.NewIntData(0)
 	}
 	if incr {
-		member := sortSet.GetMember(members[0])
+		old, exist := sortSet.GetScore(members[0])
 		item := &datastructure.StItem{
 			F: scores[0],
 			K: members[0],
 		}
-		if member == nil {
+		if !exist {
 			sortSet.Add(item)
 			return resp.NewFloat64Data(scores[0])
 		}
 		sortSet.Remove(members[0])
 		sortSet.Add(item)
-		return resp.NewFloat64Data(member.Score() + scores[0])
+		return resp.NewFloat64Data(old + scores[0])
 	}
 	var result int64
 	if ch {
 		for i := 0; i < len(members); i++ {
-			member := sortSet.GetMember(members[i])
+			old, exist := sortSet.GetScore(members[i])
 			item := &datastructure.StItem{
 				F: scores[i],
 				K: members[i],
 			}
-			if member == nil {
+			if !exist {
 				sortSet.Add(item)
 				result++
 			} else {
-				if scores[i] != member.Score() {
+				if scores[i] != old {
 					sortSet.Remove(members[i])
 					sortSet.Add(item)
 					result++
@@ -194,8 +198,8 @@ func zAdd(m *MemDb, cmd [][]byte) resp.RedisData {
 		return resp.NewIntData(result)
 	}
 	for i := 0; i < len(members); i++ {
-		member := sortSet.GetMember(members[i])
-		if member == nil {
+		_, exist := sortSet.GetScore(members[i])
+		if !exist {
 			item := &datastructure.StItem{
 				F: scores[i],
 				K: members[i],
@@ -230,90 +234,6 @@ func zCard(m *MemDb, cmd [][]byte) resp.RedisData {
 	return resp.NewIntData(sortSet.Count())
 }
 
-func zDiff(m *MemDb, cmd [][]byte) resp.RedisData {
-	if strings.ToLower(string(cmd[0])) != "zdiff" {
-		logger.Error("zDiff Function: cmdName is not zdiff")
-		return resp.NewErrorData("server error")
-	}
-	if len(cmd) < 3 {
-		return resp.NewErrorData("ERR wrong number of arguments for 'zdiff' command")
-	}
-	numsKey, err := strconv.Atoi(string(cmd[1]))
-
-	if err != nil {
-		return resp.NewErrorData("ERR value is not an integer or out of range")
-	}
-	if cmd[1][0] == '0' || numsKey <= 0 { //前导0的情况，例如001
-		return resp.NewErrorData("ERR value is not an integer or out of range")
-	}
-	var keys []string
-	var withScores bool
-	for i := 2; i < len(cmd); i++ {
-		if strings.ToLower(string(cmd[i])) == "withscores" {
-			if i == len(cmd)-1 {
-				withScores = true
-				break
-			}
-			return resp.NewErrorData("ERR syntax error")
-		}
-		keys = append(keys, string(cmd[i]))
-	}
-
-	if len(keys) != numsKey {
-		return resp.NewErrorData("ERR syntax error")
-	}
-	m.locks.RLockMulti(keys)
-	defer m.locks.RUnlockMulti(keys)
-
-	temp, ok := m.db.Get(keys[0])
-	if !ok {
-		return resp.NewArrayData(nil)
-	}
-
-	firstSortSet, ok := temp.(*datastructure.SortSet)
-	if !ok {
-		return resp.NewErrorData("WRONGTYPE Operation against a key holding the wrong kind of value")
-	}
-	sortSetKeys := firstSortSet.GetAllKeys()
-
-	for k := 1; k < len(keys); k++ {
-		temp, ok := m.db.Get(keys[k])
-		if !ok {
-			continue
-		}
-		sortSet, ok := temp.(*datastructure.SortSet)
-		if !ok {
-			return resp.NewErrorData("WRONGTYPE Operation against a key holding the wrong kind of value")
-		}
-		if sortSetKeys == nil {
-			return resp.NewArrayData(nil)
-		}
-		tempStr := make([]string, 0, len(sortSetKeys))
-		for i := 0; i < len(sortSetKeys); i++ {
-			members := sortSet.GetMember(sortSetKeys[i])
-			if members == nil {
-				tempStr = append(tempStr, sortSetKeys[i])
-			}
-		}
-		sortSetKeys = tempStr
-	}
-	var result []resp.RedisData
-	if withScores {
-		for _, v := range sortSetKeys {
-			f := firstSortSet.Score(v)
-			sf := strconv.FormatFloat(f, 'f', -1, 64)
-			result = append(result, resp.NewBulkData([]byte(v)), resp.NewBulkData([]byte(sf)))
-		}
-		return resp.NewArrayData(result)
-	}
-	for _, v := range sortSetKeys {
-		result = append(result, resp.NewBulkData([]byte(v)))
-	}
-
-	return resp.NewArrayData(result)
-
-}
-
 func zCount(m *MemDb, cmd [][]byte) resp.RedisData {
 	if strings.ToLower(string(cmd[0])) != "zcount" {
 		logger.Error("zCount Function: cmdName is not zcount")
@@ -358,7 +278,6 @@ func zCount(m *MemDb, cmd [][]byte) resp.RedisData {
 			}
 		}
 	}
-	fmt.Println(min, max, MinInf, MaxInf)
 	if MinInf && MaxInf && strings.ToLower(string(cmd[2])) == strings.ToLower(string(cmd[3])) {
 		MinInf = false
 		MaxInf = false
@@ -376,7 +295,6 @@ func zCount(m *MemDb, cmd [][]byte) resp.RedisData {
 	defer m.locks.RUnlock(key)
 
 	temp, ok := m.db.Get(key)
-	fmt.Println(ok)
 	if !ok {
 		return resp.NewIntData(0)
 	}
@@ -384,90 +302,7 @@ func zCount(m *MemDb, cmd [][]byte) resp.RedisData {
 	if !ok {
 		return resp.NewErrorData("WRONGTYPE Operation against a key holding the wrong kind of value")
 	}
-	s := sortSet.RangeByScore(findRange)
-	for i := 0; i < len(s); i++ {
-		fmt.Println(s[i].Score())
-	}
-	return resp.NewIntData(int64(len(s)))
-}
-
-func zDiffStore(m *MemDb, cmd [][]byte) resp.RedisData {
-	if strings.ToLower(string(cmd[0])) != "zdiffstore" {
-		logger.Error("zDiffStore Function: cmdName is not zdiffstore")
-		return resp.NewErrorData("server error")
-	}
-	if len(cmd) < 4 {
-		return resp.NewErrorData("error: commands is invalid")
-	}
-
-	numsKey, err := strconv.Atoi(string(cmd[2]))
-	if err != nil || err == nil && numsKey <= 0 {
-		return resp.NewErrorData("ERR at least 1 input key is needed for 'zdiffstore' command")
-	}
-	if numsKey != len(cmd)-3 {
-		return resp.NewErrorData("ERR syntax error")
-	}
-
-	desKey := string(cmd[1])
-	keys := make([]string, 0, len(cmd)-3)
-	for i := 3; i < len(cmd); i++ {
-		keys = append(keys, string(cmd[i]))
-	}
-
-	tempKeys := make([]string, 0, 1+len(keys))
-	tempKeys = append(tempKeys, desKey)
-	tempKeys = append(tempKeys, keys...)
-	m.locks.LockMulti(tempKeys)
-	defer m.locks.UnlockMulti(tempKeys)
-
-	temp, ok := m.db.Get(desKey)
-	if !ok {
-		temp = datastructure.NewDefaultSortSet()
-
-	}
-	desSortSet, ok := temp.(*datastructure.SortSet)
-	if !ok {
-		return resp.NewErrorData("WRONGTYPE Operation against a key holding the wrong kind of value")
-	}
-	t, ok := m.db.Get(keys[0])
-	if !ok {
-		return resp.NewIntData(0)
-	}
-	sortSet1, ok := t.(*datastructure.SortSet)
-	if !ok {
-		return resp.NewErrorData("WRONGTYPE Operation against a key holding the wrong kind of value")
-	}
-	sortSetHelp := datastructure.NewDefaultSortSet()
-	for key, score := range sortSet1.GetAllKeysAndScores() {
-		item := &datastructure.StItem{
-			F: score,
-			K: key,
-		}
-		sortSetHelp.Add(item)
-	}
-	var count int64
-	for i := 1; i < len(keys); i++ {
-		temp, ok = m.db.Get(keys[i])
-		if !ok {
-			continue
-		}
-		sortSet, ok := temp.(*datastructure.SortSet)
-		if !ok {
-			return resp.NewErrorData("WRONGTYPE Operation against a key holding the wrong kind of value")
-		}
-		for key, score := range sortSet.GetAllKeysAndScores() {
-			item := &datastructure.StItem{
-				F: score,
-				K: key,
-			}
-			if sortSetHelp.Add(item) > 0 {
-				count++
-			}
-		}
-	}
-	m.db.Delete(desKey)
-	m.db.Set(desKey, desSortSet)
-	return resp.NewIntData(count)
+	return resp.NewIntData(int64(len(sortSet.RangeByScore(findRange))))
 }
 
 func zIncrBy(m *MemDb, cmd [][]byte) resp.RedisData {
@@ -484,6 +319,7 @@ func zIncrBy(m *MemDb, cmd [][]byte) resp.RedisData {
 	if err != nil {
 		return resp.NewErrorData("ERR value is not a valid float")
 	}
+	defer m.signalKey(key)
 	m.locks.Lock(key)
 	defer m.locks.Unlock(key)
 	temp, ok := m.db.Get(key)
@@ -502,359 +338,218 @@ func zIncrBy(m *MemDb, cmd [][]byte) resp.RedisData {
 	return resp.NewFloat64Data(score + incr)
 }
 
-func zInterStore(m *MemDb, cmd [][]byte) resp.RedisData {
-	if strings.ToLower(string(cmd[0])) != "zinterstore" {
-		logger.Error("zInterStore Function: cmdName is not zinterstore")
-		return resp.NewErrorData("server error")
-	}
-	if len(cmd) < 4 {
-		return resp.NewErrorData("error: commands is invalid")
-	}
-	desKey := string(cmd[1])
-	numKeys, err := strconv.Atoi(string(cmd[2]))
-	if err != nil || (err == nil && (numKeys <= 0 || cmd[2][0] == '0')) {
-		return resp.NewErrorData("ERR at least 1 input key is needed for 'zinterstore' command")
-	}
-	var weights, aggregate, sum, min, max bool
-	var keys []string
-	var weight []float64
-	i := 3
-	for i = 3; i < len(cmd); i++ {
-		tempKey := string(cmd[i])
-		if strings.ToLower(tempKey) == "weights" {
-			weights = true
-			break
-		}
-		if strings.ToLower(tempKey) == "aggregate" {
-			aggregate = true
-			break
-		}
-		keys = append(keys, tempKey)
-	}
-	k := i + 1
-	if weights {
-		for ; k < len(cmd); k++ {
-			if strings.ToLower(string(cmd[k])) == "aggregate" {
-				break
-			}
-			tempWeight, err := strconv.ParseFloat(string(cmd[k]), 10)
-			if err != nil {
-				fmt.Println(string(cmd[k]))
-				return resp.NewErrorData("ERR weight value is not a float")
-			}
-			weight = append(weight, tempWeight)
-		}
-	}
-	var l int
-	if weights {
-		l = k + 1
+// popSortSet pops up to count members with the lowest scores, or the highest if max,
+// and deletes key once the sorted set is empty. The caller must hold the lock of key.
+func (m *MemDb) popSortSet(key string, sortSet *datastructure.SortSet, max bool, count int) []datastructure.ISortSet {
+	var items []datastructure.ISortSet
+	if max {
+		items = sortSet.RevRange(0, int64(count)-1)
 	} else {
-		l = k
-	}
-	if aggregate {
-		for ; l < len(cmd); l++ {
-			switch string(cmd[l]) {
-			case "min":
-				min = true
-			case "max":
-				max = true
-			case "sum":
-				sum = true
-			default:
-				return resp.NewErrorData("ERR syntax error")
-			}
-		}
+		items = sortSet.Range(0, int64(count)-1)
 	}
-	if (min && max) || (min && sum) || (max && sum) || len(keys) != len(weight) || (aggregate && !min && !max && !sum) {
-		return resp.NewErrorData("ERR syntax error")
+	for _, item := range items {
+		sortSet.Remove(item.Key())
 	}
-
-	tempKeys := []string{desKey}
-	tempKeys = append(tempKeys, keys...)
-	m.locks.LockMulti(tempKeys)
-	m.locks.UnlockMulti(tempKeys)
-
-	desSortSet := datastructure.NewDefaultSortSet()
-	var count int64
-	var minSortSet *datastructure.SortSet
-	minSortSetSize := math.MaxInt64
-	var minSortSetIndex int
-	for i := 0; i < len(keys); i++ {
-		temp, ok := m.db.Get(keys[i])
-		if !ok {
-			return resp.NewIntData(0)
-		}
-		tempSortSet, ok := temp.(*datastructure.SortSet)
-		if !ok {
-			return resp.NewErrorData("WRONGTYPE Operation against a key holding the wrong kind of value")
-		}
-		if tempSortSet.Count() < int64(minSortSetSize) {
-			minSortSetIndex = i
-			minSortSet = tempSortSet
-		}
+	if sortSet.Count() == 0 {
+		m.db.Delete(key)
+		m.DelTTL(key)
 	}
-	for k, s := range minSortSet.GetAllKeysAndScores() {
-		var item *datastructure.StItem
-		if weights {
-			item = &datastructure.StItem{
-				F: s * weight[minSortSetIndex],
-				K: k,
-			}
-		} else {
-			item = &datastructure.StItem{
-				F: s,
-				K: k,
-			}
-		}
-		desSortSet.Add(item)
-	}
-	desSortSetKeys := desSortSet.GetAllKeys()
-	if weights && aggregate {
-		for i := 0; i < len(keys); i++ {
-			temp, _ := m.db.Get(keys[i])
-			tempSortSet, _ := temp.(*datastructure.SortSet)
-			if tempSortSet == minSortSet {
-				continue
-			}
-			for _, key := range desSortSetKeys {
-				if desSortSet.GetMember(key) != nil && tempSortSet.GetMember(key) != nil {
-					if min && desSortSet.Score(key) > tempSortSet.Score(key)*weight[i] {
-						item := &datastructure.StItem{
-							K: key,
-							F: tempSortSet.Score(key) * weight[i],
-						}
-						desSortSet.Remove(key)
-						desSortSet.Add(item)
-					} else if max && desSortSet.Score(key) < tempSortSet.Score(key)*weight[i] {
-						item := &datastructure.StItem{
-							K: key,
-							F: tempSortSet.Score(key) * weight[i],
-						}
-						desSortSet.Remove(key)
-						desSortSet.Add(item)
-
-					} else {
-						item := &datastructure.StItem{
-							K: key,
-							F: tempSortSet.Score(key)*weight[i] + desSortSet.Score(key),
-						}
-						desSortSet.Remove(key)
-						desSortSet.Add(item)
-					}
-					count++
-				} else {
-					desSortSet.Remove(key)
-				}
-
-			}
-		}
-	} else if weights {
-		for i := 0; i < len(keys); i++ {
-			temp, _ := m.db.Get(keys[i])
-			tempSortSet, _ := temp.(*datastructure.SortSet)
-			if tempSortSet == minSortSet {
-				continue
-			}
-			for _, key := range desSortSetKeys {
-				if desSortSet.GetMember(key) != nil && tempSortSet.GetMember(key) != nil {
-					item := &datastructure.StItem{
-						K: key,
-						F: tempSortSet.Score(key)*weight[i] + desSortSet.Score(key),
-					}
-					desSortSet.Remove(key)
-					desSortSet.Add(item)
-					count++
-				} else {
-					desSortSet.Remove(key)
-				}
-			}
-		}
-	} else if aggregate {
-		for i := 0; i < len(keys); i++ {
-			temp, _ := m.db.Get(keys[i])
-			tempSortSet, _ := temp.(*datastructure.SortSet)
-			if tempSortSet == minSortSet {
-				continue
-			}
-			for _, key := range desSortSetKeys {
-				if desSortSet.GetMember(key) != nil && tempSortSet.GetMember(key) != nil {
-					if min && desSortSet.Score(key) > tempSortSet.Score(key) {
-						item := &datastructure.StItem{
-							K: key,
-							F: tempSortSet.Score(key),
-						}
-						desSortSet.Remove(key)
-						desSortSet.Add(item)
-					} else if max && desSortSet.Score(key) < tempSortSet.Score(key) {
-						item := &datastructure.StItem{
-							K: key,
-							F: tempSortSet.Score(key),
-						}
-						desSortSet.Remove(key)
-						desSortSet.Add(item)
-
-					} else {
-						item := &datastructure.StItem{
-							K: key,
-							F: tempSortSet.Score(key) + desSortSet.Score(key),
-						}
-						desSortSet.Remove(key)
-						desSortSet.Add(item)
-					}
-					count++
-				} else {
-					desSortSet.Remove(key)
-				}
-			}
-		}
-
-	} else {
-		for i := 0; i < len(keys); i++ {
-			temp, _ := m.db.Get(keys[i])
-			tempSortSet, _ := temp.(*datastructure.SortSet)
-			if tempSortSet == minSortSet {
-				continue
-			}
-			for _, key := range desSortSetKeys {
-				if desSortSet.GetMember(key) != nil && tempSortSet.GetMember(key) != nil {
-					item := &datastructure.StItem{
-						K: key,
-						F: tempSortSet.Score(key) + desSortSet.Score(key),
-					}
-					desSortSet.Remove(key)
-					desSortSet.Add(item)
-					count++
-				} else {
-					desSortSet.Remove(key)
-				}
-			}
-
-		}
-	}
-	m.db.Delete(desKey)
-	m.db.Set(desKey, desSortSet)
-	return resp.NewIntData(count)
+	return items
 }
 
-func zPopMax(m *MemDb, cmd [][]byte) resp.RedisData {
-	if strings.ToLower(string(cmd[0])) != "zpopmax" {
-		logger.Error("zPopMax Function: cmdName is not zpopmax")
-		return resp.NewErrorData("server error")
-	}
-	if len(cmd) < 2 {
-		return resp.NewErrorData("error: commands is invalid")
+// zPop implements ZPOPMIN and ZPOPMAX, the reply is a flat array of members and scores
+func zPop(m *MemDb, cmd [][]byte, max bool) resp.RedisData {
+	if len(cmd) < 2 || len(cmd) > 3 {
+		return resp.NewErrorData("ERR wrong number of arguments for '" + strings.ToLower(string(cmd[0])) + "' command")
 	}
 	key := string(cmd[1])
 	count := 1
-	var err error
 	if len(cmd) == 3 {
+		var err error
 		count, err = strconv.Atoi(string(cmd[2]))
-		if err != nil {
-			return resp.NewErrorData("ERR value is out of range, must be positive")
-		}
-		if count == 0 {
-			return resp.NewArrayData(nil)
-		}
-		if count < 0 {
+		if err != nil || count < 0 {
 			return resp.NewErrorData("ERR value is out of range, must be positive")
 		}
 	}
+
+	m.CheckTTL(key)
 	m.locks.Lock(key)
 	defer m.locks.Unlock(key)
 
-	temp, ok := m.db.Get(key)
-	if !ok {
-		return resp.NewArrayData(nil)
-	}
-	sortSet, ok := temp.(*datastructure.SortSet)
-	if !ok {
-		return resp.NewErrorData("WRONGTYPE Operation against a key holding the wrong kind of value")
+	sortSet, errData := m.getSortSet(key)
+	if errData != nil {
+		return errData
 	}
 	res := make([]resp.RedisData, 0)
-	for i := 0; i < count; i++ {
-		min := sortSet.Count() - 1
-		max := min
-		t := sortSet.Range(min, max)
-		if t == nil {
-			if len(res) == 0 {
-				return resp.NewBulkData([]byte("empty array"))
-			}
-			break
-		}
-		for _, v := range t {
-			score := strconv.FormatFloat(v.Score(), 'f', -1, 64)
-			res = append(res, resp.NewBulkData([]byte(v.Key())), resp.NewBulkData([]byte(score)))
-			sortSet.Remove(v.Key())
-		}
+	if sortSet == nil || count == 0 {
+		return resp.NewArrayData(res)
+	}
+	for _, item := range m.popSortSet(key, sortSet, max, count) {
+		res = append(res, resp.NewBulkData([]byte(item.Key())), resp.NewBulkData([]byte(strconv.FormatFloat(item.Score(), 'f', -1, 64))))
 	}
-
-	defer func() {
-		if sortSet.Count() == 0 {
-			m.db.Delete(key)
-			m.ttlKeys.Delete(key)
-		}
-	}()
-
 	return resp.NewArrayData(res)
 }
 
+func zPopMax(m *MemDb, cmd [][]byte) resp.RedisData {
+	if strings.ToLower(string(cmd[0])) != "zpopmax" {
+		logger.Error("zPopMax Function: cmdName is not zpopmax")
+		return resp.NewErrorData("server error")
+	}
+	return zPop(m, cmd, true)
+}
+
 func zPopMin(m *MemDb, cmd [][]byte) resp.RedisData {
 	if strings.ToLower(string(cmd[0])) != "zpopmin" {
 		logger.Error("zPopMin Function: cmdName is not zpopmin")
 		return resp.NewErrorData("server error")
 	}
-	if len(cmd) < 2 {
-		return resp.NewErrorData("error: commands is invalid")
+	return zPop(m, cmd, false)
+}
+
+// parseZMPop parses numkeys key [key ...] MIN|MAX [COUNT count] of ZMPOP and BZMPOP
+func parseZMPop(args [][]byte) (keys []string, max bool, count int, errData resp.RedisData) {
+	numKeys, err := strconv.Atoi(string(args[0]))
+	if err != nil || numKeys <= 0 {
+		return nil, false, 0, resp.NewErrorData("ERR numkeys should be greater than 0")
 	}
-	key := string(cmd[1])
-	count := 1
-	var err error
+	if numKeys >= len(args)-1 {
+		return nil, false, 0, resp.NewErrorData("ERR syntax error")
+	}
+	for _, key := range args[1 : 1+numKeys] {
+		keys = append(keys, string(key))
+	}
+	args = args[1+numKeys:]
+	switch strings.ToLower(string(args[0])) {
+	case "min":
+	case "max":
+		max = true
+	default:
+		return nil, false, 0, resp.NewErrorData("ERR syntax error")
+	}
+	count = 1
+	if len(args) == 1 {
+		return
+	}
+	if len(args) != 3 || strings.ToLower(string(args[1])) != "count" {
+		return nil, false, 0, resp.NewErrorData("ERR syntax error")
+	}
+	count, err = strconv.Atoi(string(args[2]))
+	if err != nil || count <= 0 {
+		return nil, false, 0, resp.NewErrorData("ERR count should be greater than 0")
+	}
+	return
+}
 
-	if len(cmd) == 3 {
-		count, err = strconv.Atoi(string(cmd[2]))
-		if err != nil {
-			return resp.NewErrorData("ERR value is out of range, must be positive")
+// zMPopKeys pops from the first non-empty sorted set of keys, it returns nil if they are all empty.
+// The reply is the key and an array of member and score pairs, or the key, member and score
+// when flat is true like BZPOPMIN and BZPOPMAX.
+func (m *MemDb) zMPopKeys(keys []string, max bool, count int, flat bool) resp.RedisData {
+	for _, key := range keys {
+		m.CheckTTL(key)
+	}
+	m.locks.LockMulti(keys)
+	defer m.locks.UnlockMulti(keys)
+
+	for _, key := range keys {
+		sortSet, errData := m.getSortSet(key)
+		if errData != nil {
+			return errData
 		}
-		if count == 0 {
-			return resp.NewBulkData([]byte("empty array"))
+		if sortSet == nil || sortSet.Count() == 0 {
+			continue
 		}
+		items := m.popSortSet(key, sortSet, max, count)
+		if flat {
+			item := items[0]
+			return resp.NewArrayData([]resp.RedisData{
+				resp.NewBulkData([]byte(key)),
+				resp.NewBulkData([]byte(item.Key())),
+				resp.NewBulkData([]byte(strconv.FormatFloat(item.Score(), 'f', -1, 64))),
+			})
+		}
+		elems := make([]resp.RedisData, 0, len(items))
+		for _, item := range items {
+			elems = append(elems, resp.NewArrayData([]resp.RedisData{
+				resp.NewBulkData([]byte(item.Key())),
+				resp.NewBulkData([]byte(strconv.FormatFloat(item.Score(), 'f', -1, 64))),
+			}))
+		}
+		return resp.NewArrayData([]resp.RedisData{resp.NewBulkData([]byte(key)), resp.NewArrayData(elems)})
+	}
+	return nil
+}
+
+func zMPop(m *MemDb, cmd [][]byte) resp.RedisData {
+	if strings.ToLower(string(cmd[0])) != "zmpop" {
+		logger.Error("zMPop Function: cmdName is not zmpop")
+		return resp.NewErrorData("server error")
 	}
-	m.locks.Lock(key)
-	m.locks.Unlock(key)
-	temp, ok := m.db.Get(key)
-	if !ok {
-		return resp.NewBulkData([]byte("empty array"))
+	if len(cmd) < 4 {
+		return resp.NewErrorData("ERR wrong number of arguments for 'zmpop' command")
 	}
-	sortSet, ok := temp.(*datastructure.SortSet)
-	if !ok {
-		return resp.NewErrorData("WRONGTYPE Operation against a key holding the wrong kind of value")
+	keys, max, count, errData := parseZMPop(cmd[1:])
+	if errData != nil {
+		return errData
 	}
-	res := make([]resp.RedisData, 0)
-	for i := 0; i < count; i++ {
-		min := int64(0)
-		max := min
-		t := sortSet.Range(min, max)
-		if t == nil {
-			if len(res) == 0 {
-				return resp.NewBulkData([]byte("empty array"))
-			}
-			break
-		}
-		for _, v := range t {
-			score := strconv.FormatFloat(v.Score(), 'f', -1, 64)
-			res = append(res, resp.NewBulkData([]byte(v.Key())), resp.NewBulkData([]byte(score)))
-			sortSet.Remove(v.Key())
-		}
+	if res := m.zMPopKeys(keys, max, count, false); res != nil {
+		return res
 	}
+	return resp.NewArrayData(nil)
+}
 
-	defer func() {
-		if sortSet.Count() == 0 {
-			m.db.Delete(key)
-			m.ttlKeys.Delete(key)
-		}
-	}()
+// bzPop implements BZPOPMIN and BZPOPMAX
+func bzPop(m *MemDb, cmd [][]byte, max bool) resp.RedisData {
+	if len(cmd) < 3 {
+		return resp.NewErrorData("ERR wrong number of arguments for '" + strings.ToLower(string(cmd[0])) + "' command")
+	}
+	timeout, errData := parseBlockTimeout(cmd[len(cmd)-1])
+	if errData != nil {
+		return errData
+	}
+	keys := make([]string, 0, len(cmd)-2)
+	for _, key := range cmd[1 : len(cmd)-1] {
+		keys = append(keys, string(key))
+	}
+	return m.blockPop(keys, timeout, func() resp.RedisData {
+		return m.zMPopKeys(keys, max, 1, true)
+	})
+}
 
-	return resp.NewArrayData(res)
+func bzPopMax(m *MemDb, cmd [][]byte) resp.RedisData {
+	if strings.ToLower(string(cmd[0])) != "bzpopmax" {
+		logger.Error("bzPopMax Function: cmdName is not bzpopmax")
+		return resp.NewErrorData("server error")
+	}
+	return bzPop(m, cmd, true)
+}
+
+func bzPopMin(m *MemDb, cmd [][]byte) resp.RedisData {
+	if strings.ToLower(string(cmd[0])) != "bzpopmin" {
+		logger.Error("bzPopMin Function: cmdName is not bzpopmin")
+		return resp.NewErrorData("server error")
+	}
+	return bzPop(m, cmd, false)
+}
+
+func bzMPop(m *MemDb, cmd [][]byte) resp.RedisData {
+	if strings.ToLower(string(cmd[0])) != "bzmpop" {
+		logger.Error("bzMPop Function: cmdName is not bzmpop")
+		return resp.NewErrorData("server error")
+	}
+	if len(cmd) < 5 {
+		return resp.NewErrorData("ERR wrong number of arguments for 'bzmpop' command")
+	}
+	timeout, errData := parseBlockTimeout(cmd[1])
+	if errData != nil {
+		return errData
+	}
+	keys, max, count, errData := parseZMPop(cmd[2:])
+	if errData != nil {
+		return errData
+	}
+	return m.blockPop(keys, timeout, func() resp.RedisData {
+		return m.zMPopKeys(keys, max, count, false)
+	})
 }
 
 func zRank(m *MemDb, cmd [][]byte) resp.RedisData {
@@ -885,7 +580,7 @@ func zRank(m *MemDb, cmd [][]byte) resp.RedisData {
 	if !ok {
 		return resp.NewErrorData("WRONGTYPE Operation against a key holding the wrong kind of value")
 	}
-	if sortSet.GetMember(member) == nil {
+	if _, ok := sortSet.GetScore(member); !ok {
 		return resp.NewBulkData(nil)
 	}
 	rank := sortSet.Rank(member)
@@ -926,7 +621,7 @@ func zRevRank(m *MemDb, cmd [][]byte) resp.RedisData {
 	if !ok {
 		return resp.NewErrorData("WRONGTYPE Operation against a key holding the wrong kind of value")
 	}
-	if sortSet.GetMember(member) == nil {
+	if _, ok := sortSet.GetScore(member); !ok {
 		return resp.NewBulkData(nil)
 	}
 
@@ -960,260 +655,326 @@ func zScore(m *MemDb, cmd [][]byte) resp.RedisData {
 	if !ok {
 		return resp.NewErrorData("WRONGTYPE Operation against a key holding the wrong kind of value")
 	}
-	memberNode := sortSet.GetMember(member)
-	if memberNode == nil {
+	score, ok := sortSet.GetScore(member)
+	if !ok {
 		return resp.NewBulkData(nil)
 	}
-	score := sortSet.Score(member)
 	return resp.NewFloat64Data(score)
 }
 
-func zRange(m *MemDb, cmd [][]byte) resp.RedisData {
-	if strings.ToLower(string(cmd[0])) != "zrange" {
-		logger.Error("zRange Function: cmdName is not zrange")
+func zMScore(m *MemDb, cmd [][]byte) resp.RedisData {
+	if strings.ToLower(string(cmd[0])) != "zmscore" {
+		logger.Error("zMScore Function: cmdName is not zmscore")
 		return resp.NewErrorData("server error")
 	}
-	if len(cmd) < 4 || len(cmd) > 5 {
-		return resp.NewErrorData("error: commands is invalid")
+	if len(cmd) < 3 {
+		return resp.NewErrorData("ERR wrong number of arguments for 'zmscore' command")
 	}
 	key := string(cmd[1])
-	start, err := strconv.ParseInt(string(cmd[2]), 10, 64)
-	if err != nil {
-		return resp.NewErrorData("ERR value is not an integer or out of range")
-	}
-	stop, err := strconv.ParseInt(string(cmd[3]), 10, 64)
-	if err != nil {
-		return resp.NewErrorData("ERR value is not an integer or out of range")
-	}
-	var withScores bool
-	if len(cmd) == 5 {
-		if strings.ToLower(string(cmd[4])) == "withscores" {
-			withScores = true
-		} else {
-			return resp.NewErrorData("ERR syntax error")
-		}
-	}
+	m.CheckTTL(key)
 	m.locks.RLock(key)
 	defer m.locks.RUnlock(key)
 
-	temp, ok := m.db.Get(key)
-	if !ok {
-		return resp.NewBulkData([]byte("empty array"))
+	sortSet, errData := m.getSortSet(key)
+	if errData != nil {
+		return errData
 	}
-	sortSet, ok := temp.(*datastructure.SortSet)
-	if !ok {
-		return resp.NewErrorData("WRONGTYPE Operation against a key holding the wrong kind of value")
-	}
-	result := sortSet.Range(start, stop)
-	if len(result) == 0 {
-		return resp.NewBulkData([]byte("empty array"))
-	}
-	var res []resp.RedisData
-	if withScores {
-		res = make([]resp.RedisData, 0, 2*len(result))
-	} else {
-		res = make([]resp.RedisData, 0, len(result))
-	}
-	for i := 0; i < len(result); i++ {
-		if withScores {
-			score := strconv.FormatFloat(result[i].Score(), 'f', -1, 64)
-			key := result[i].Key()
-			res = append(res, resp.NewBulkData([]byte(key)), resp.NewBulkData([]byte(score)))
-		} else {
-			res = append(res, resp.NewBulkData([]byte(result[i].Key())))
+	res := make([]resp.RedisData, 0, len(cmd)-2)
+	for _, member := range cmd[2:] {
+		var score float64
+		ok := sortSet != nil
+		if ok {
+			score, ok = sortSet.GetScore(string(member))
 		}
+		if !ok {
+			res = append(res, resp.NewBulkData(nil))
+			continue
+		}
+		res = append(res, resp.NewBulkData([]byte(strconv.FormatFloat(score, 'f', -1, 64))))
 	}
 	return resp.NewArrayData(res)
 }
 
-func zRevRange(m *MemDb, cmd [][]byte) resp.RedisData {
-	if strings.ToLower(string(cmd[0])) != "zrevrange" {
-		logger.Error("zRevRange Function: cmdName is not zrevrange")
+func zRandMember(m *MemDb, cmd [][]byte) resp.RedisData {
+	if strings.ToLower(string(cmd[0])) != "zrandmember" {
+		logger.Error("zRandMember Function: cmdName is not zrandmember")
 		return resp.NewErrorData("server error")
 	}
-	if len(cmd) < 4 || len(cmd) > 5 {
-		return resp.NewErrorData("error: commands is invalid")
+	if len(cmd) < 2 || len(cmd) > 4 {
+		return resp.NewErrorData("ERR wrong number of arguments for 'zrandmember' command")
 	}
 	key := string(cmd[1])
-	start, err := strconv.ParseInt(string(cmd[2]), 10, 64)
-	if err != nil {
-		return resp.NewErrorData("ERR value is not an integer or out of range")
-	}
-	stop, err := strconv.ParseInt(string(cmd[3]), 10, 64)
-	if err != nil {
-		return resp.NewErrorData("ERR value is not an integer or out of range")
+	hasCount, withScores := len(cmd) > 2, false
+	count := 1
+	if hasCount {
+		var err error
+		count, err = strconv.Atoi(string(cmd[2]))
+		if err != nil {
+			return resp.NewErrorData("ERR value is not an integer or out of range")
+		}
 	}
-	var withScores bool
-	if len(cmd) == 5 {
-		if strings.ToLower(string(cmd[4])) == "withscores" {
-			withScores = true
-		} else {
+	if len(cmd) == 4 {
+		if strings.ToLower(string(cmd[3])) != "withscores" {
 			return resp.NewErrorData("ERR syntax error")
 		}
+		withScores = true
+	}
+	// a negative count is bounded as the reply is built in memory, and like redis
+	// the count of a reply with scores must not overflow once doubled
+	if count < -datastructure.MaxRandomCount || (withScores && count > math.MaxInt/2) {
+		return resp.NewErrorData("ERR value is out of range")
 	}
+
+	m.CheckTTL(key)
 	m.locks.RLock(key)
 	defer m.locks.RUnlock(key)
 
-	temp, ok := m.db.Get(key)
-	if !ok {
-		return resp.NewBulkData([]byte("empty array"))
+	sortSet, errData := m.getSortSet(key)
+	if errData != nil {
+		return errData
 	}
-	sortSet, ok := temp.(*datastructure.SortSet)
-	if !ok {
-		return resp.NewErrorData("WRONGTYPE Operation against a key holding the wrong kind of value")
-	}
-	result := sortSet.RevRange(start, stop)
-	if len(result) == 0 {
-		return resp.NewBulkData([]byte("empty array"))
+	if !hasCount {
+		if sortSet == nil {
+			return resp.NewBulkData(nil)
+		}
+		return resp.NewBulkData([]byte(sortSet.Random(1)[0].Key()))
 	}
-	var res []resp.RedisData
-	if withScores {
-		res = make([]resp.RedisData, 0, 2*len(result))
-	} else {
-		res = make([]resp.RedisData, 0, len(result))
+	res := make([]resp.RedisData, 0)
+	if sortSet == nil {
+		return resp.NewArrayData(res)
 	}
-	for i := 0; i < len(result); i++ {
+	// a negative count may return the same member several times
+	for _, item := range sortSet.Random(count) {
+		res = append(res, resp.NewBulkData([]byte(item.Key())))
 		if withScores {
-			score := strconv.FormatFloat(result[i].Score(), 'f', -1, 64)
-			key := result[i].Key()
-			res = append(res, resp.NewBulkData([]byte(key)), resp.NewBulkData([]byte(score)))
-		} else {
-			res = append(res, resp.NewBulkData([]byte(result[i].Key())))
+			res = append(res, resp.NewBulkData([]byte(strconv.FormatFloat(item.Score(), 'f', -1, 64))))
 		}
 	}
 	return resp.NewArrayData(res)
 }
 
-func zRangeByScore(m *MemDb, cmd [][]byte) resp.RedisData {
-	if strings.ToLower(string(cmd[0])) != "zrangebyscore" {
-		logger.Error("zRangeByScore Function: cmdName is not zrangebyscore")
-		return resp.NewErrorData("server error")
-	}
-	if len(cmd) < 4 || len(cmd) > 8 {
-		return resp.NewErrorData("error: commands is invalid")
+const (
+	rangeAuto = iota
+	rangeByRank
+	rangeByScore
+	rangeByLex
+)
+
+// zRangeSpec is a parsed range of the ZRANGE family
+type zRangeSpec struct {
+	by            int
+	rev           bool
+	withScores    bool
+	offset, count int64
+	// the range by rank
+	start, stop int64
+	scoreRange  *datastructure.SkipListFindRange
+	// a nil lexRange is an empty range
+	lexRange *datastructure.SkipListLexRange
+}
+
+// parseScoreRange parses the min and max of the score range commands, like 1, (1 or -inf
+func parseScoreRange(min, max []byte) (*datastructure.SkipListFindRange, resp.RedisData) {
+	findRange := &datastructure.SkipListFindRange{}
+	var err1, err2 error
+	findRange.Min, findRange.MinBra, err1 = parseScoreBound(min)
+	findRange.Max, findRange.MaxBra, err2 = parseScoreBound(max)
+	if err1 != nil || err2 != nil {
+		return nil, resp.NewErrorData("ERR min or max is not a float")
 	}
-	key := string(cmd[1])
-	var min, max float64
-	var offset, count int64
-	var minBra, maxBra, withscores, limit, minInf, maxInf bool
-	var err error
+	return findRange, nil
+}
 
-	if cmd[2][0] == '(' {
-		min, err = strconv.ParseFloat(string(cmd[2][1:]), 64)
-		minBra = true
-	} else {
-		min, err = strconv.ParseFloat(string(cmd[2]), 64)
+func parseScoreBound(bound []byte) (float64, bool, error) {
+	bra := len(bound) > 0 && bound[0] == '('
+	if bra {
+		bound = bound[1:]
 	}
-	if err != nil {
-		if strings.ToLower(string(cmd[2])) == "-inf" {
-			minInf = true
-		} else {
-			return resp.NewErrorData("ERR min or max is not a float")
+	score, err := strconv.ParseFloat(string(bound), 64)
+	if err == nil && math.IsNaN(score) {
+		err = strconv.ErrSyntax
+	}
+	return score, bra, err
+}
+
+// parseZRange parses min max [BYSCORE|BYLEX] [REV] [LIMIT offset count] [WITHSCORES].
+// The legacy commands preset by and rev, BYSCORE, BYLEX and REV are only accepted when by is rangeAuto.
+func parseZRange(args [][]byte, by int, rev, store bool) (*zRangeSpec, resp.RedisData) {
+	spec := &zRangeSpec{by: by, rev: rev, count: -1}
+	syntaxErr := resp.NewErrorData("ERR syntax error")
+	hasLimit := false
+	for i := 2; i < len(args); i++ {
+		switch opt := strings.ToLower(string(args[i])); {
+		case opt == "withscores" && !store:
+			spec.withScores = true
+		case opt == "limit" && i+2 < len(args):
+			var err1, err2 error
+			spec.offset, err1 = strconv.ParseInt(string(args[i+1]), 10, 64)
+			spec.count, err2 = strconv.ParseInt(string(args[i+2]), 10, 64)
+			if err1 != nil || err2 != nil {
+				return nil, resp.NewErrorData("ERR value is not an integer or out of range")
+			}
+			hasLimit = true
+			i += 2
+		case opt == "rev" && by == rangeAuto:
+			spec.rev = true
+		case opt == "byscore" && by == rangeAuto && spec.by == rangeAuto:
+			spec.by = rangeByScore
+		case opt == "bylex" && by == rangeAuto && spec.by == rangeAuto:
+			spec.by = rangeByLex
+		default:
+			return nil, syntaxErr
 		}
 	}
+	if spec.by == rangeAuto {
+		spec.by = rangeByRank
+	}
+	if hasLimit && spec.by == rangeByRank {
+		return nil, resp.NewErrorData("ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX")
+	}
+	if spec.withScores && spec.by == rangeByLex {
+		return nil, resp.NewErrorData("ERR syntax error, WITHSCORES not supported in combination with BYLEX")
+	}
 
-	if cmd[3][0] == '(' {
-		max, err = strconv.ParseFloat(string(cmd[3][1:]), 64)
-		maxBra = true
-	} else {
-		max, err = strconv.ParseFloat(string(cmd[3]), 64)
+	// the score and lex ranges are given as max min when reversed
+	min, max := args[0], args[1]
+	if spec.rev && spec.by != rangeByRank {
+		min, max = max, min
 	}
-	if err != nil {
-		if strings.ToLower(string(cmd[2])) == "+inf" {
-			maxInf = true
-		} else {
-			return resp.NewErrorData("ERR min or max is not a float")
+	var errData resp.RedisData
+	switch spec.by {
+	case rangeByScore:
+		spec.scoreRange, errData = parseScoreRange(min, max)
+	case rangeByLex:
+		spec.lexRange, errData = parseLexRange(min, max)
+	default:
+		var err1, err2 error
+		spec.start, err1 = strconv.ParseInt(string(min), 10, 64)
+		spec.stop, err2 = strconv.ParseInt(string(max), 10, 64)
+		if err1 != nil || err2 != nil {
+			errData = resp.NewErrorData("ERR value is not an integer or out of range")
 		}
 	}
+	if errData != nil {
+		return nil, errData
+	}
+	return spec, nil
+}
 
-	for i := 4; i < len(cmd); i++ {
-		if strings.ToLower(string(cmd[i])) == "withscores" {
-			withscores = true
-			continue
-		} else if strings.ToLower(string(cmd[i])) == "limit" {
-			limit = true
-			if i+2 != len(cmd)-1 {
-				return resp.NewErrorData("ERR syntax error")
-			}
-			offset, err = strconv.ParseInt(string(cmd[i+1]), 10, 64)
-			if err != nil {
-				return resp.NewErrorData("ERR value is not an integer or out of range")
-			}
-			count, err = strconv.ParseInt(string(cmd[i+2]), 10, 64)
-			if err != nil {
-				return resp.NewErrorData("ERR value is not an integer or out of range")
-			}
-			break
-		} else {
-			return resp.NewErrorData("ERR syntax error")
+// zRangeItems returns the members of sortSet in the range of spec
+func zRangeItems(sortSet *datastructure.SortSet, spec *zRangeSpec) []datastructure.ISortSet {
+	switch spec.by {
+	case rangeByScore:
+		if spec.rev {
+			return sortSet.RevRangeByScoreWithLimit(spec.scoreRange, spec.offset, spec.count)
+		}
+		return sortSet.RangeByScoreWithLimit(spec.scoreRange, spec.offset, spec.count)
+	case rangeByLex:
+		if spec.rev {
+			return sortSet.RevRangeByLex(spec.lexRange, spec.offset, spec.count)
 		}
+		return sortSet.RangeByLex(spec.lexRange, spec.offset, spec.count)
+	default:
+		if spec.rev {
+			return sortSet.RevRange(spec.start, spec.stop)
+		}
+		return sortSet.Range(spec.start, spec.stop)
 	}
+}
 
-	if limit && (offset < 0 || count == 0) {
-		return resp.NewBulkData([]byte("empty array"))
+// zRange implements ZRANGE and the legacy ZREVRANGE, ZRANGEBYSCORE, ZREVRANGEBYSCORE, ZRANGEBYLEX and ZREVRANGEBYLEX
+func zRange(m *MemDb, cmd [][]byte) resp.RedisData {
+	cmdName := strings.ToLower(string(cmd[0]))
+	var by int
+	var rev bool
+	switch cmdName {
+	case "zrange":
+		by = rangeAuto
+	case "zrevrange":
+		by, rev = rangeByRank, true
+	case "zrangebyscore":
+		by = rangeByScore
+	case "zrevrangebyscore":
+		by, rev = rangeByScore, true
+	case "zrangebylex":
+		by = rangeByLex
+	case "zrevrangebylex":
+		by, rev = rangeByLex, true
+	default:
+		logger.Error("zRange Function: cmdName is not a zrange command")
+		return resp.NewErrorData("server error")
 	}
+	if len(cmd) < 4 {
+		return resp.NewErrorData("ERR wrong number of arguments for '" + cmdName + "' command")
+	}
+	spec, errData := parseZRange(cmd[2:], by, rev, false)
+	if errData != nil {
+		return errData
+	}
+	key := string(cmd[1])
 
+	m.CheckTTL(key)
 	m.locks.RLock(key)
 	defer m.locks.RUnlock(key)
 
-	temp, ok := m.db.Get(key)
-	if !ok {
-		return resp.NewBulkData([]byte("empty array"))
+	sortSet, errData := m.getSortSet(key)
+	if errData != nil {
+		return errData
 	}
-	sortSet, ok := temp.(*datastructure.SortSet)
-	if !ok {
-		return resp.NewErrorData("WRONGTYPE Operation against a key holding the wrong kind of value")
-	}
-	findRange := &datastructure.SkipListFindRange{
-		Min:    min,
-		Max:    max,
-		MinBra: minBra,
-		MaxBra: maxBra,
-		MinInf: minInf,
-		MaxInf: maxInf,
-	}
-	result := sortSet.RangeByScore(findRange)
-
-	if int64(len(result)) <= offset {
-		return resp.NewBulkData([]byte("empty array"))
-	}
-	var res []resp.RedisData
-	if count < 0 {
-		for i := offset; i < int64(len(result)); i++ {
-			key := result[i].Key()
-			if withscores {
-				scoreStr := strconv.FormatFloat(result[i].Score(), 'f', -1, 64)
-				res = append(res, resp.NewBulkData([]byte(key)), resp.NewBulkData([]byte(scoreStr)))
-			} else {
-				res = append(res, resp.NewBulkData([]byte(key)))
-			}
-		}
+	res := make([]resp.RedisData, 0)
+	if sortSet == nil {
 		return resp.NewArrayData(res)
-	} else {
-		if limit {
-			for i := offset; i < int64(len(result)) && i < count+offset; i++ {
-				key := result[i].Key()
-				if withscores {
-					scoreStr := strconv.FormatFloat(result[i].Score(), 'f', -1, 64)
-					res = append(res, resp.NewBulkData([]byte(key)), resp.NewBulkData([]byte(scoreStr)))
-				} else {
-					res = append(res, resp.NewBulkData([]byte(key)))
-				}
-			}
-		} else {
-			for i := 0; i < len(result); i++ {
-				key := result[i].Key()
-				if withscores {
-					scoreStr := strconv.FormatFloat(result[i].Score(), 'f', -1, 64)
-					res = append(res, resp.NewBulkData([]byte(key)), resp.NewBulkData([]byte(scoreStr)))
-				} else {
-					res = append(res, resp.NewBulkData([]byte(key)))
-				}
-			}
+	}
+	for _, item := range zRangeItems(sortSet, spec) {
+		res = append(res, resp.NewBulkData([]byte(item.Key())))
+		if spec.withScores {
+			res = append(res, resp.NewBulkData([]byte(strconv.FormatFloat(item.Score(), 'f', -1, 64))))
 		}
+	}
+	return resp.NewArrayData(res)
+}
 
-		return resp.NewArrayData(res)
+func zRangeStore(m *MemDb, cmd [][]byte) resp.RedisData {
+	if strings.ToLower(string(cmd[0])) != "zrangestore" {
+		logger.Error("zRangeStore Function: cmdName is not zrangestore")
+		return resp.NewErrorData("server error")
+	}
+	if len(cmd) < 5 {
+		return resp.NewErrorData("ERR wrong number of arguments for 'zrangestore' command")
 	}
+	spec, errData := parseZRange(cmd[3:], rangeAuto, false, true)
+	if errData != nil {
+		return errData
+	}
+	desKey, key := string(cmd[1]), string(cmd[2])
+
+	m.CheckTTL(desKey)
+	m.CheckTTL(key)
+	keys := []string{desKey, key}
+	m.locks.LockMulti(keys)
+	defer m.locks.UnlockMulti(keys)
+
+	sortSet, errData := m.getSortSet(key)
+	if errData != nil {
+		return errData
+	}
+	var items []datastructure.ISortSet
+	if sortSet != nil {
+		items = zRangeItems(sortSet, spec)
+	}
+	result := datastructure.NewDefaultSortSet()
+	for _, item := range items {
+		result.Add(&datastructure.StItem{F: item.Score(), K: item.Key()})
+	}
+
+	// the destination is overwritten, and deleted if the range is empty
+	m.db.Delete(desKey)
+	m.DelTTL(desKey)
+	if len(items) != 0 {
+		m.db.Set(desKey, result)
+		defer m.signalKey(desKey)
+	}
+	return resp.NewIntData(int64(len(items)))
 }
 
 func zRem(m *MemDb, cmd [][]byte) resp.RedisData {
@@ -1321,7 +1082,6 @@ func zRemRangeByScore(m *MemDb, cmd [][]byte) resp.RedisData {
 	}
 
 	if err1 != nil || err2 != nil {
-		fmt.Println(err1.Error(), err2.Error())
 		return resp.NewErrorData("ERR value is not a float or out of range")
 	}
 	m.locks.Lock(key)
@@ -1344,239 +1104,130 @@ func zRemRangeByScore(m *MemDb, cmd [][]byte) resp.RedisData {
 	return resp.NewIntData(int64(count))
 }
 
-func zUnionStore(m *MemDb, cmd [][]byte) resp.RedisData {
-	if strings.ToLower(string(cmd[0])) != "zunionstore" {
-		logger.Error("zUnionStore Function: cmdName is not zunionstore")
+func parseLexRange(min, max []byte) (*datastructure.SkipListLexRange, resp.RedisData) {
+	errData := resp.NewErrorData("ERR min or max not valid string range item")
+	lexRange := &datastructure.SkipListLexRange{}
+	empty := false
+	switch {
+	case len(min) == 1 && min[0] == '-':
+		lexRange.MinInf = true
+	case len(min) == 1 && min[0] == '+':
+		empty = true
+	case len(min) > 0 && (min[0] == '(' || min[0] == '['):
+		lexRange.Min, lexRange.MinBra = string(min[1:]), min[0] == '('
+	default:
+		return nil, errData
+	}
+	switch {
+	case len(max) == 1 && max[0] == '+':
+		lexRange.MaxInf = true
+	case len(max) == 1 && max[0] == '-':
+		empty = true
+	case len(max) > 0 && (max[0] == '(' || max[0] == '['):
+		lexRange.Max, lexRange.MaxBra = string(max[1:]), max[0] == '('
+	default:
+		return nil, errData
+	}
+	if empty {
+		return nil, nil
+	}
+	return lexRange, nil
+}
+
+func zLexCount(m *MemDb, cmd [][]byte) resp.RedisData {
+	if strings.ToLower(string(cmd[0])) != "zlexcount" {
+		logger.Error("zLexCount Function: cmdName is not zlexcount")
 		return resp.NewErrorData("server error")
 	}
-	if len(cmd) < 4 {
-		return resp.NewErrorData("error: commands is invalid")
-	}
-	desKey := string(cmd[1])
-	numKeys, err := strconv.Atoi(string(cmd[2]))
-	if err != nil || err == nil && (numKeys <= 0 || cmd[2][0] == '0') {
-		return resp.NewErrorData("ERR at least 1 input key is needed for 'zinterstore' command")
-	}
-	var weights, aggregate, sum, min, max bool
-	var keys []string
-	var weight []float64
-	i := 3
-	for i = 3; i < len(cmd); i++ {
-		tempKey := string(cmd[i])
-		if strings.ToLower(tempKey) == "weights" {
-			weights = true
-			break
-		}
-		if strings.ToLower(tempKey) == "aggregate" {
-			aggregate = true
-			break
-		}
-		keys = append(keys, tempKey)
+	if len(cmd) != 4 {
+		return resp.NewErrorData("ERR wrong number of arguments for 'zlexcount' command")
 	}
-	k := i + 1
-	if weights {
-		for ; k < len(cmd); k++ {
-			if strings.ToLower(string(cmd[k])) == "aggregate" {
-				break
-			}
-			tempWeight, err := strconv.ParseFloat(string(cmd[k]), 10)
-			if err != nil {
-				fmt.Println(string(cmd[k]))
-				return resp.NewErrorData("ERR weight value is not a float")
-			}
-			weight = append(weight, tempWeight)
-		}
+	key := string(cmd[1])
+	lexRange, errData := parseLexRange(cmd[2], cmd[3])
+	if errData != nil {
+		return errData
 	}
-	var l int
-	if weights {
-		l = k + 1
-	} else {
-		l = k
-	}
-	if aggregate {
-		for ; l < len(cmd); l++ {
-			switch string(cmd[l]) {
-			case "min":
-				min = true
-			case "max":
-				max = true
-			case "sum":
-				sum = true
-			default:
-				return resp.NewErrorData("ERR syntax error")
-			}
-		}
+
+	m.CheckTTL(key)
+	m.locks.RLock(key)
+	defer m.locks.RUnlock(key)
+
+	sortSet, errData := m.getSortSet(key)
+	if errData != nil {
+		return errData
 	}
-	if (min && max) || (min && sum) || (max && sum) || len(keys) != len(weight) || (aggregate && !min && !max && !sum) {
-		return resp.NewErrorData("ERR syntax error")
+	if sortSet == nil {
+		return resp.NewIntData(0)
 	}
+	return resp.NewIntData(sortSet.LexCount(lexRange))
+}
 
-	tempKeys := []string{desKey}
-	tempKeys = append(tempKeys, keys...)
-	m.locks.LockMulti(tempKeys)
-	m.locks.UnlockMulti(tempKeys)
+func zRemRangeByLex(m *MemDb, cmd [][]byte) resp.RedisData {
+	if strings.ToLower(string(cmd[0])) != "zremrangebylex" {
+		logger.Error("zRemRangeByLex Function: cmdName is not zremrangebylex")
+		return resp.NewErrorData("server error")
+	}
+	if len(cmd) != 4 {
+		return resp.NewErrorData("ERR wrong number of arguments for 'zremrangebylex' command")
+	}
+	key := string(cmd[1])
+	lexRange, errData := parseLexRange(cmd[2], cmd[3])
+	if errData != nil {
+		return errData
+	}
 
-	desSortSet := datastructure.NewDefaultSortSet()
-	var count int64
-	for i := 0; i < len(keys); i++ {
-		temp, ok := m.db.Get(keys[i])
-		if !ok {
-			continue
-		}
-		tempSortSet, ok := temp.(*datastructure.SortSet)
-		if !ok {
-			return resp.NewErrorData("WRONGTYPE Operation against a key holding the wrong kind of value")
-		}
-		tempKeyAndScore := tempSortSet.GetAllKeysAndScores()
-		if weights && aggregate {
-			if min {
-				for k, v := range tempKeyAndScore {
-					item := &datastructure.StItem{
-						K: k,
-						F: v * weight[i],
-					}
-					if desSortSet.GetMember(k) == nil {
-						desSortSet.Add(item)
-						count++
-					} else {
-						if v*weight[i] < desSortSet.Score(k) {
-							desSortSet.Remove(k)
-							desSortSet.Add(item)
-							count++
-						}
-					}
-				}
+	m.CheckTTL(key)
+	m.locks.Lock(key)
+	defer m.locks.Unlock(key)
 
-			} else if max {
-				for k, v := range tempKeyAndScore {
-					item := &datastructure.StItem{
-						K: k,
-						F: v * weight[i],
-					}
-					if desSortSet.GetMember(k) == nil {
-						desSortSet.Add(item)
-						count++
-					} else {
-						if v*weight[i] > desSortSet.Score(k) {
-							desSortSet.Remove(k)
-							desSortSet.Add(item)
-							count++
-						}
-					}
-				}
-			} else {
-				for k, v := range tempKeyAndScore {
-					if desSortSet.GetMember(k) == nil {
-						item := &datastructure.StItem{
-							K: k,
-							F: v * weight[i],
-						}
-						desSortSet.Add(item)
-					} else {
-						score := desSortSet.Score(k)
-						item := &datastructure.StItem{
-							K: k,
-							F: score + v*weight[i],
-						}
-						desSortSet.Remove(k)
-						desSortSet.Add(item)
-					}
-					count++
-				}
-			}
-		} else if weights {
-			for k, v := range tempKeyAndScore {
-				item := &datastructure.StItem{
-					K: k,
-					F: v*weight[i] + desSortSet.Score(k),
-				}
-				if desSortSet.GetMember(k) != nil {
-					desSortSet.Remove(k)
-				}
-				desSortSet.Add(item)
-				count++
-			}
-		} else if aggregate {
-			for k, v := range tempKeyAndScore {
-				if min {
-					item := &datastructure.StItem{
-						K: k,
-						F: v,
-					}
-					if desSortSet.GetMember(k) == nil {
-						desSortSet.Add(item)
-						count++
-					} else {
-						if desSortSet.Score(k) > v {
-							desSortSet.Remove(k)
-							desSortSet.Add(item)
-							count++
-						}
-					}
-				} else if max {
-					item := &datastructure.StItem{
-						K: k,
-						F: v,
-					}
-					if desSortSet.GetMember(k) == nil {
-						desSortSet.Add(item)
-						count++
-					} else {
-						if desSortSet.Score(k) < v {
-							desSortSet.Remove(k)
-							desSortSet.Add(item)
-							count++
-						}
-					}
-				} else {
-					item := &datastructure.StItem{
-						K: k,
-						F: v + desSortSet.Score(k),
-					}
-					if desSortSet.GetMember(k) != nil {
-						desSortSet.Remove(k)
-					}
-					desSortSet.Add(item)
-					count++
-				}
-			}
-		} else {
-			for k, v := range tempKeyAndScore {
-				item := &datastructure.StItem{
-					K: k,
-					F: v + desSortSet.Score(k),
-				}
-				if desSortSet.GetMember(k) != nil {
-					desSortSet.Remove(k)
-				}
-				desSortSet.Add(item)
-				count++
-			}
-		}
+	sortSet, errData := m.getSortSet(key)
+	if errData != nil {
+		return errData
 	}
-	m.db.Delete(desKey)
-	m.db.Set(desKey, desSortSet)
-	return resp.NewIntData(count)
+	if sortSet == nil {
+		return resp.NewIntData(0)
+	}
+	count := sortSet.RemoveRangeByLex(lexRange)
+	if sortSet.Count() == 0 {
+		m.db.Delete(key)
+		m.DelTTL(key)
+	}
+	return resp.NewIntData(int64(count))
 }
 
 func RegisterSortSetCommands() {
 	RegisterCommand("zadd", zAdd)
-	RegisterCommand("zcard", zCard)
-	RegisterCommand("zdiff", zDiff)
-	RegisterCommand("zcount", zCount)
-	RegisterCommand("zdiffstore", zDiffStore)
+	RegisterCommand("zcard", zCard, flagReadOnly)
+	RegisterCommand("zdiff", zSetOpCommand, flagReadOnly)
+	RegisterCommand("zcount", zCount, flagReadOnly)
+	RegisterCommand("zdiffstore", zSetOpStore)
 	RegisterCommand("zincrby", zIncrBy)
-	RegisterCommand("zinterstore", zInterStore)
+	RegisterCommand("zinter", zSetOpCommand, flagReadOnly)
+	RegisterCommand("zintercard", zInterCard, flagReadOnly)
+	RegisterCommand("zinterstore", zSetOpStore)
+	RegisterCommand("zmpop", zMPop)
+	RegisterCommand("zmscore", zMScore, flagReadOnly)
 	RegisterCommand("zpopmax", zPopMax)
 	RegisterCommand("zpopmin", zPopMin)
-	RegisterCommand("zrank", zRank)
-	RegisterCommand("zrevrank", zRevRank)
-	RegisterCommand("zscore", zScore)
-	RegisterCommand("zrange", zRange)
-	RegisterCommand("zrevrange", zRevRange)
-	RegisterCommand("zrangebyscore", zRangeByScore)
+	RegisterCommand("bzpopmax", bzPopMax, flagBlocking, flagClient)
+	RegisterCommand("bzpopmin", bzPopMin, flagBlocking, flagClient)
+	RegisterCommand("bzmpop", bzMPop, flagBlocking, flagClient)
+	RegisterCommand("zrandmember", zRandMember, flagReadOnly)
+	RegisterCommand("zrank", zRank, flagReadOnly)
+	RegisterCommand("zrevrank", zRevRank, flagReadOnly)
+	RegisterCommand("zscore", zScore, flagReadOnly)
+	RegisterCommand("zrange", zRange, flagReadOnly)
+	RegisterCommand("zrevrange", zRange, flagReadOnly)
+	RegisterCommand("zrangestore", zRangeStore)
+	RegisterCommand("zrangebyscore", zRange, flagReadOnly)
+	RegisterCommand("zrevrangebyscore", zRange, flagReadOnly)
+	RegisterCommand("zrangebylex", zRange, flagReadOnly)
+	RegisterCommand("zrevrangebylex", zRange, flagReadOnly)
+	RegisterCommand("zlexcount", zLexCount, flagReadOnly)
 	RegisterCommand("zrem", zRem)
 	RegisterCommand("zremrangebyrank", zRemRangeByRank)
 	RegisterCommand("zremrangebyscore", zRemRangeByScore)
-	RegisterCommand("zunionstore", zUnionStore)
+	RegisterCommand("zremrangebylex", zRemRangeByLex)
+	RegisterCommand("zunion", zSetOpCommand, flagReadOnly)
+	RegisterCommand("zunionstore", zSetOpStore)
 }
@@ -0,0 +1,372 @@
+package memdb
+
+import (
+	"easyRedis/datastructure"
+	"easyRedis/logger"
+	"easyRedis/resp"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// sort_set_op.go implements the union, intersection and difference of sorted sets:
+// ZUNION, ZINTER, ZDIFF, their STORE variants and ZINTERCARD.
+//
+// Like redis, the inputs may also be sets whose members all have a score of 1,
+// and missing keys are empty inputs.
+
+const (
+	zSetUnion = iota
+	zSetInter
+	zSetDiff
+)
+
+// zSetOpArgs holds numkeys key [key ...] [WEIGHTS weight ...] [AGGREGATE SUM|MIN|MAX] [WITHSCORES]
+type zSetOpArgs struct {
+	keys       []string
+	weights    []float64
+	aggregate  string
+	withScores bool
+}
+
+// zSetOpInput is an input of the operations, a sorted set, a set or nil for a missing key
+type zSetOpInput struct {
+	sortSet *datastructure.SortSet
+	set     *datastructure.Set
+	weight  float64
+}
+
+func (in *zSetOpInput) len() int64 {
+	if in.sortSet != nil {
+		return in.sortSet.Count()
+	}
+	if in.set != nil {
+		return int64(in.set.Len())
+	}
+	return 0
+}
+
+// score returns the weighted score of member
+func (in *zSetOpInput) score(member string) (float64, bool) {
+	if in.sortSet != nil {
+		if score, ok := in.sortSet.GetScore(member); ok {
+			return zSetWeighted(score, in.weight), true
+		}
+	} else if in.set != nil && in.set.Has(member) {
+		return in.weight, true
+	}
+	return 0, false
+}
+
+// each calls fn for all the members with their weighted scores, until fn returns false
+func (in *zSetOpInput) each(fn func(member string, score float64) bool) {
+	if in.sortSet != nil {
+		in.sortSet.ForEach(func(member string, score float64) bool {
+			return fn(member, zSetWeighted(score, in.weight))
+		})
+	} else if in.set != nil {
+		for _, member := range in.set.Member() {
+			if !fn(member, in.weight) {
+				return
+			}
+		}
+	}
+}
+
+func zSetWeighted(score, weight float64) float64 {
+	res := score * weight
+	// 0 * inf is 0 like in redis
+	if math.IsNaN(res) {
+		return 0
+	}
+	return res
+}
+
+func zSetAggregate(aggregate string, a, b float64) float64 {
+	switch aggregate {
+	case "min":
+		return math.Min(a, b)
+	case "max":
+		return math.Max(a, b)
+	default:
+		// inf + -inf is 0 like in redis
+		if res := a + b; !math.IsNaN(res) {
+			return res
+		}
+		return 0
+	}
+}
+
+// parseZSetOp parses the arguments from numkeys, WEIGHTS and AGGREGATE are rejected for ZDIFF
+// and WITHSCORES for the STORE variants.
+func parseZSetOp(cmdName string, args [][]byte, op int, store bool) (*zSetOpArgs, resp.RedisData) {
+	numKeys, err := strconv.Atoi(string(args[0]))
+	if err != nil {
+		return nil, resp.NewErrorData("ERR value is not an integer or out of range")
+	}
+	if numKeys <= 0 {
+		return nil, resp.NewErrorData("ERR at least 1 input key is needed for '" + cmdName + "' command")
+	}
+	if numKeys > len(args)-1 {
+		return nil, resp.NewErrorData("ERR syntax error")
+	}
+	opArgs := &zSetOpArgs{aggregate: "sum"}
+	for _, key := range args[1 : 1+numKeys] {
+		opArgs.keys = append(opArgs.keys, string(key))
+	}
+	syntaxErr := resp.NewErrorData("ERR syntax error")
+	for i := 1 + numKeys; i < len(args); i++ {
+		switch strings.ToLower(string(args[i])) {
+		case "weights":
+			if op == zSetDiff || i+numKeys >= len(args) {
+				return nil, syntaxErr
+			}
+			opArgs.weights = opArgs.weights[:0]
+			for _, arg := range args[i+1 : i+1+numKeys] {
+				weight, err := strconv.ParseFloat(string(arg), 64)
+				if err != nil || math.IsNaN(weight) {
+					return nil, resp.NewErrorData("ERR weight value is not a float")
+				}
+				opArgs.weights = append(opArgs.weights, weight)
+			}
+			i += numKeys
+		case "aggregate":
+			if op == zSetDiff || i+1 >= len(args) {
+				return nil, syntaxErr
+			}
+			aggregate := strings.ToLower(string(args[i+1]))
+			if aggregate != "sum" && aggregate != "min" && aggregate != "max" {
+				return nil, syntaxErr
+			}
+			opArgs.aggregate = aggregate
+			i++
+		case "withscores":
+			if store {
+				return nil, syntaxErr
+			}
+			opArgs.withScores = true
+		default:
+			return nil, syntaxErr
+		}
+	}
+	return opArgs, nil
+}
+
+// zSetOpInputs returns the inputs of keys. The caller must hold the locks of keys.
+func (m *MemDb) zSetOpInputs(opArgs *zSetOpArgs) ([]*zSetOpInput, resp.RedisData) {
+	inputs := make([]*zSetOpInput, len(opArgs.keys))
+	for i, key := range opArgs.keys {
+		in := &zSetOpInput{weight: 1}
+		if opArgs.weights != nil {
+			in.weight = opArgs.weights[i]
+		}
+		if temp, ok := m.db.Get(key); ok {
+			switch v := temp.(type) {
+			case *datastructure.SortSet:
+				in.sortSet = v
+			case *datastructure.Set:
+				in.set = v
+			default:
+				return nil, resp.NewErrorData("WRONGTYPE Operation against a key holding the wrong kind of value")
+			}
+		}
+		inputs[i] = in
+	}
+	return inputs, nil
+}
+
+// zSetOp computes the operation on the sorted sets of opArgs.
+// The caller must hold the locks of the keys.
+func (m *MemDb) zSetOp(op int, opArgs *zSetOpArgs) (*datastructure.SortSet, resp.RedisData) {
+	inputs, errData := m.zSetOpInputs(opArgs)
+	if errData != nil {
+		return nil, errData
+	}
+	res := datastructure.NewDefaultSortSet()
+	switch op {
+	case zSetUnion:
+		scores := make(map[string]float64)
+		for _, in := range inputs {
+			in.each(func(member string, score float64) bool {
+				if old, ok := scores[member]; ok {
+					score = zSetAggregate(opArgs.aggregate, old, score)
+				}
+				scores[member] = score
+				return true
+			})
+		}
+		for member, score := range scores {
+			res.Add(&datastructure.StItem{F: score, K: member})
+		}
+	case zSetInter:
+		// iterate over the smallest input, an empty input makes the result empty
+		sort.SliceStable(inputs, func(i, j int) bool {
+			return inputs[i].len() < inputs[j].len()
+		})
+		if inputs[0].len() == 0 {
+			return res, nil
+		}
+		inputs[0].each(func(member string, score float64) bool {
+			for _, in := range inputs[1:] {
+				other, ok := in.score(member)
+				if !ok {
+					return true
+				}
+				score = zSetAggregate(opArgs.aggregate, score, other)
+			}
+			res.Add(&datastructure.StItem{F: score, K: member})
+			return true
+		})
+	case zSetDiff:
+		inputs[0].each(func(member string, score float64) bool {
+			for _, in := range inputs[1:] {
+				if _, ok := in.score(member); ok {
+					return true
+				}
+			}
+			res.Add(&datastructure.StItem{F: score, K: member})
+			return true
+		})
+	}
+	return res, nil
+}
+
+func zSetOpName(cmdName string) (int, bool) {
+	switch cmdName {
+	case "zunion", "zunionstore":
+		return zSetUnion, strings.HasSuffix(cmdName, "store")
+	case "zinter", "zinterstore":
+		return zSetInter, strings.HasSuffix(cmdName, "store")
+	case "zdiff", "zdiffstore":
+		return zSetDiff, strings.HasSuffix(cmdName, "store")
+	}
+	return -1, false
+}
+
+// zSetOpCommand implements ZUNION, ZINTER and ZDIFF
+func zSetOpCommand(m *MemDb, cmd [][]byte) resp.RedisData {
+	cmdName := strings.ToLower(string(cmd[0]))
+	op, store := zSetOpName(cmdName)
+	if op < 0 || store {
+		logger.Error("zSetOpCommand Function: cmdName is not zunion, zinter or zdiff")
+		return resp.NewErrorData("server error")
+	}
+	if len(cmd) < 3 {
+		return resp.NewErrorData("ERR wrong number of arguments for '" + cmdName + "' command")
+	}
+	opArgs, errData := parseZSetOp(cmdName, cmd[1:], op, false)
+	if errData != nil {
+		return errData
+	}
+	for _, key := range opArgs.keys {
+		m.CheckTTL(key)
+	}
+	m.locks.RLockMulti(opArgs.keys)
+	defer m.locks.RUnlockMulti(opArgs.keys)
+
+	result, errData := m.zSetOp(op, opArgs)
+	if errData != nil {
+		return errData
+	}
+	res := make([]resp.RedisData, 0)
+	for _, item := range result.Range(0, -1) {
+		res = append(res, resp.NewBulkData([]byte(item.Key())))
+		if opArgs.withScores {
+			res = append(res, resp.NewBulkData([]byte(strconv.FormatFloat(item.Score(), 'f', -1, 64))))
+		}
+	}
+	return resp.NewArrayData(res)
+}
+
+// zSetOpStore implements ZUNIONSTORE, ZINTERSTORE and ZDIFFSTORE
+func zSetOpStore(m *MemDb, cmd [][]byte) resp.RedisData {
+	cmdName := strings.ToLower(string(cmd[0]))
+	op, store := zSetOpName(cmdName)
+	if op < 0 || !store {
+		logger.Error("zSetOpStore Function: cmdName is not zunionstore, zinterstore or zdiffstore")
+		return resp.NewErrorData("server error")
+	}
+	if len(cmd) < 4 {
+		return resp.NewErrorData("ERR wrong number of arguments for '" + cmdName + "' command")
+	}
+	desKey := string(cmd[1])
+	opArgs, errData := parseZSetOp(cmdName, cmd[2:], op, true)
+	if errData != nil {
+		return errData
+	}
+	lockKeys := append([]string{desKey}, opArgs.keys...)
+	for _, key := range lockKeys {
+		m.CheckTTL(key)
+	}
+	m.locks.LockMulti(lockKeys)
+	defer m.locks.UnlockMulti(lockKeys)
+
+	result, errData := m.zSetOp(op, opArgs)
+	if errData != nil {
+		return errData
+	}
+	// the destination is overwritten, and deleted if the result is empty
+	m.db.Delete(desKey)
+	m.DelTTL(desKey)
+	if result.Count() != 0 {
+		m.db.Set(desKey, result)
+		defer m.signalKey(desKey)
+	}
+	return resp.NewIntData(result.Count())
+}
+
+func zInterCard(m *MemDb, cmd [][]byte) resp.RedisData {
+	if strings.ToLower(string(cmd[0])) != "zintercard" {
+		logger.Error("zInterCard Function: cmdName is not zintercard")
+		return resp.NewErrorData("server error")
+	}
+	if len(cmd) < 3 {
+		return resp.NewErrorData("ERR wrong number of arguments for 'zintercard' command")
+	}
+	numKeys, err := strconv.Atoi(string(cmd[1]))
+	if err != nil || numKeys <= 0 {
+		return resp.NewErrorData("ERR numkeys should be greater than 0")
+	}
+	if numKeys > len(cmd)-2 {
+		return resp.NewErrorData("ERR Number of keys can't be greater than number of args")
+	}
+	limit := 0
+	for i := 2 + numKeys; i < len(cmd); i++ {
+		if strings.ToLower(string(cmd[i])) != "limit" || i+1 >= len(cmd) {
+			return resp.NewErrorData("ERR syntax error")
+		}
+		limit, err = strconv.Atoi(string(cmd[i+1]))
+		if err != nil || limit < 0 {
+			return resp.NewErrorData("ERR LIMIT can't be negative")
+		}
+		i++
+	}
+	opArgs := &zSetOpArgs{}
+	for _, key := range cmd[2 : 2+numKeys] {
+		m.CheckTTL(string(key))
+		opArgs.keys = append(opArgs.keys, string(key))
+	}
+	m.locks.RLockMulti(opArgs.keys)
+	defer m.locks.RUnlockMulti(opArgs.keys)
+
+	inputs, errData := m.zSetOpInputs(opArgs)
+	if errData != nil {
+		return errData
+	}
+	sort.SliceStable(inputs, func(i, j int) bool {
+		return inputs[i].len() < inputs[j].len()
+	})
+	// count the members of the smallest input in all the others, stopping at the limit
+	card := 0
+	inputs[0].each(func(member string, _ float64) bool {
+		for _, in := range inputs[1:] {
+			if _, ok := in.score(member); !ok {
+				return true
+			}
+		}
+		card++
+		return limit == 0 || card < limit
+	})
+	return resp.NewIntData(int64(card))
+}
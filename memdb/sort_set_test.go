@@ -0,0 +1,290 @@
+package memdb
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestZRangeByLex(t *testing.T) {
+	mem := newTestDb()
+	exec(mem, "zadd", "z", "0", "a", "0", "b", "0", "c", "0", "d", "0", "e")
+	if res := exec(mem, "zrangebylex", "z", "-", "+"); res != "*5\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nc\r\n$1\r\nd\r\n$1\r\ne\r\n" {
+		t.Errorf("zrangebylex reply %q", res)
+	}
+	if res := exec(mem, "zrangebylex", "z", "(a", "[c"); res != "*2\r\n$1\r\nb\r\n$1\r\nc\r\n" {
+		t.Errorf("zrangebylex bounds reply %q", res)
+	}
+	if res := exec(mem, "zrangebylex", "z", "-", "+", "limit", "1", "2"); res != "*2\r\n$1\r\nb\r\n$1\r\nc\r\n" {
+		t.Errorf("zrangebylex limit reply %q", res)
+	}
+	if res := exec(mem, "zrevrangebylex", "z", "[d", "-", "limit", "1", "-1"); res != "*3\r\n$1\r\nc\r\n$1\r\nb\r\n$1\r\na\r\n" {
+		t.Errorf("zrevrangebylex reply %q", res)
+	}
+	if res := exec(mem, "zrangebylex", "z", "+", "-"); res != "*0\r\n" {
+		t.Errorf("zrangebylex + - reply %q", res)
+	}
+	if res := exec(mem, "zrangebylex", "z", "a", "+"); res != "-ERR min or max not valid string range item\r\n" {
+		t.Errorf("zrangebylex invalid bound reply %q", res)
+	}
+	if res := exec(mem, "zrangebylex", "missing", "-", "+"); res != "*0\r\n" {
+		t.Errorf("zrangebylex of a missing key reply %q", res)
+	}
+	exec(mem, "set", "str", "v")
+	if res := exec(mem, "zlexcount", "str", "-", "+"); res[0] != '-' {
+		t.Errorf("zlexcount of a string reply %q", res)
+	}
+}
+
+func TestZLexCountRemRangeByLex(t *testing.T) {
+	mem := newTestDb()
+	exec(mem, "zadd", "z", "0", "alpha", "0", "beta", "0", "gamma", "0", "delta")
+	if res := exec(mem, "zlexcount", "z", "[b", "(g"); res != ":2\r\n" {
+		t.Errorf("zlexcount reply %q", res)
+	}
+	if res := exec(mem, "zremrangebylex", "z", "[b", "(g"); res != ":2\r\n" {
+		t.Errorf("zremrangebylex reply %q", res)
+	}
+	if res := exec(mem, "zrangebylex", "z", "-", "+"); res != "*2\r\n$5\r\nalpha\r\n$5\r\ngamma\r\n" {
+		t.Errorf("members after zremrangebylex %q", res)
+	}
+	// removing every member deletes the key
+	if res := exec(mem, "zremrangebylex", "z", "-", "+"); res != ":2\r\n" {
+		t.Errorf("zremrangebylex all reply %q", res)
+	}
+	if res := exec(mem, "zcard", "z"); res != ":0\r\n" {
+		t.Errorf("zcard after removing all %q", res)
+	}
+}
+
+func TestZRangeUnified(t *testing.T) {
+	mem := newTestDb()
+	exec(mem, "zadd", "z", "1", "a", "2", "b", "3", "c", "4", "d", "5", "e")
+	cases := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"zrange", "z", "0", "1"}, "*2\r\n$1\r\na\r\n$1\r\nb\r\n"},
+		{[]string{"zrange", "z", "0", "0", "rev", "withscores"}, "*2\r\n$1\r\ne\r\n$1\r\n5\r\n"},
+		{[]string{"zrange", "z", "-100", "0"}, "*1\r\n$1\r\na\r\n"},
+		{[]string{"zrange", "z", "(1", "3", "byscore"}, "*2\r\n$1\r\nb\r\n$1\r\nc\r\n"},
+		{[]string{"zrange", "z", "+inf", "-inf", "byscore", "rev", "limit", "1", "2", "withscores"}, "*4\r\n$1\r\nd\r\n$1\r\n4\r\n$1\r\nc\r\n$1\r\n3\r\n"},
+		{[]string{"zrange", "z", "[b", "(d", "bylex"}, "*2\r\n$1\r\nb\r\n$1\r\nc\r\n"},
+		{[]string{"zrevrange", "z", "0", "1"}, "*2\r\n$1\r\ne\r\n$1\r\nd\r\n"},
+		{[]string{"zrangebyscore", "z", "-inf", "+inf", "limit", "3", "-1"}, "*2\r\n$1\r\nd\r\n$1\r\ne\r\n"},
+		{[]string{"zrevrangebyscore", "z", "4", "(2", "withscores"}, "*4\r\n$1\r\nd\r\n$1\r\n4\r\n$1\r\nc\r\n$1\r\n3\r\n"},
+		{[]string{"zrevrangebyscore", "z", "2", "4"}, "*0\r\n"},
+		{[]string{"zrange", "z", "0", "1", "limit", "0", "1"}, "-ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX\r\n"},
+		{[]string{"zrange", "z", "-", "+", "bylex", "withscores"}, "-ERR syntax error, WITHSCORES not supported in combination with BYLEX\r\n"},
+		{[]string{"zrangebyscore", "z", "0", "1", "rev"}, "-ERR syntax error\r\n"},
+		{[]string{"zrange", "z", "a", "1", "byscore"}, "-ERR min or max is not a float\r\n"},
+		{[]string{"zrange", "missing", "0", "-1"}, "*0\r\n"},
+	}
+	for _, c := range cases {
+		if res := exec(mem, c.args...); res != c.want {
+			t.Errorf("%v reply %q, want %q", c.args, res, c.want)
+		}
+	}
+}
+
+func TestZRangeStore(t *testing.T) {
+	mem := newTestDb()
+	exec(mem, "zadd", "z", "1", "a", "2", "b", "3", "c")
+	if res := exec(mem, "zrangestore", "dst", "z", "2", "+inf", "byscore"); res != ":2\r\n" {
+		t.Errorf("zrangestore reply %q", res)
+	}
+	if res := exec(mem, "zrange", "dst", "0", "-1", "withscores"); res != "*4\r\n$1\r\nb\r\n$1\r\n2\r\n$1\r\nc\r\n$1\r\n3\r\n" {
+		t.Errorf("zrangestore destination %q", res)
+	}
+	if res := exec(mem, "zrangestore", "dst", "z", "0", "1", "withscores"); res != "-ERR syntax error\r\n" {
+		t.Errorf("zrangestore withscores reply %q", res)
+	}
+	// an empty range deletes the destination
+	if res := exec(mem, "zrangestore", "dst", "z", "5", "10"); res != ":0\r\n" {
+		t.Errorf("zrangestore empty reply %q", res)
+	}
+	if res := exec(mem, "zcard", "dst"); res != ":0\r\n" {
+		t.Errorf("zrangestore kept the destination: %q", res)
+	}
+}
+
+func TestZSetOperations(t *testing.T) {
+	mem := newTestDb()
+	exec(mem, "zadd", "z1", "1", "a", "2", "b", "3", "c")
+	exec(mem, "zadd", "z2", "10", "b", "20", "c", "30", "d")
+	exec(mem, "sadd", "s", "c", "d")
+	cases := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"zunion", "2", "z1", "z2", "withscores"}, "*8\r\n$1\r\na\r\n$1\r\n1\r\n$1\r\nb\r\n$2\r\n12\r\n$1\r\nc\r\n$2\r\n23\r\n$1\r\nd\r\n$2\r\n30\r\n"},
+		{[]string{"zinter", "2", "z1", "z2", "weights", "2", "1", "aggregate", "min", "withscores"}, "*4\r\n$1\r\nb\r\n$1\r\n4\r\n$1\r\nc\r\n$1\r\n6\r\n"},
+		{[]string{"zinter", "3", "z1", "z2", "s", "aggregate", "max"}, "*1\r\n$1\r\nc\r\n"},
+		{[]string{"zinter", "2", "z1", "missing"}, "*0\r\n"},
+		{[]string{"zdiff", "2", "z2", "z1", "withscores"}, "*2\r\n$1\r\nd\r\n$2\r\n30\r\n"},
+		{[]string{"zdiff", "1", "missing"}, "*0\r\n"},
+		{[]string{"zdiff", "2", "z1", "z2", "weights", "1", "1"}, "-ERR syntax error\r\n"},
+		{[]string{"zunion", "0", "z1"}, "-ERR at least 1 input key is needed for 'zunion' command\r\n"},
+		{[]string{"zunion", "2", "z1", "z2", "weights", "1", "x"}, "-ERR weight value is not a float\r\n"},
+		{[]string{"zintercard", "2", "z1", "z2"}, ":2\r\n"},
+		{[]string{"zintercard", "2", "z1", "z2", "limit", "1"}, ":1\r\n"},
+		{[]string{"zinterstore", "dst", "2", "z1", "z2", "weights", "1", "0"}, ":2\r\n"},
+		{[]string{"zrange", "dst", "0", "-1", "withscores"}, "*4\r\n$1\r\nb\r\n$1\r\n2\r\n$1\r\nc\r\n$1\r\n3\r\n"},
+		{[]string{"zdiffstore", "dst", "2", "z1", "z2"}, ":1\r\n"},
+		{[]string{"zunionstore", "dst", "1", "missing"}, ":0\r\n"},
+		{[]string{"zcard", "dst"}, ":0\r\n"},
+		{[]string{"zunionstore", "dst", "2", "z1", "z2", "withscores"}, "-ERR syntax error\r\n"},
+	}
+	for _, c := range cases {
+		if res := exec(mem, c.args...); res != c.want {
+			t.Errorf("%v reply %q, want %q", c.args, res, c.want)
+		}
+	}
+}
+
+func TestZMScoreRandMember(t *testing.T) {
+	mem := newTestDb()
+	exec(mem, "zadd", "z", "1", "a", "2.5", "b")
+	if res := exec(mem, "zmscore", "z", "a", "x", "b"); res != "*3\r\n$1\r\n1\r\n$-1\r\n$3\r\n2.5\r\n" {
+		t.Errorf("zmscore reply %q", res)
+	}
+	if res := exec(mem, "zmscore", "missing", "a"); res != "*1\r\n$-1\r\n" {
+		t.Errorf("zmscore of a missing key reply %q", res)
+	}
+	if res := exec(mem, "zrandmember", "z"); res != "$1\r\na\r\n" && res != "$1\r\nb\r\n" {
+		t.Errorf("zrandmember reply %q", res)
+	}
+	if res := exec(mem, "zrandmember", "z", "5"); res != "*2\r\n$1\r\na\r\n$1\r\nb\r\n" && res != "*2\r\n$1\r\nb\r\n$1\r\na\r\n" {
+		t.Errorf("zrandmember count reply %q", res)
+	}
+	if res := exec(mem, "zrandmember", "z", "-5", "withscores"); !strings.HasPrefix(res, "*10\r\n") {
+		t.Errorf("zrandmember negative count reply %q", res)
+	}
+	if res := exec(mem, "zrandmember", "missing"); res != "$-1\r\n" {
+		t.Errorf("zrandmember of a missing key reply %q", res)
+	}
+	if res := exec(mem, "zrandmember", "missing", "2"); res != "*0\r\n" {
+		t.Errorf("zrandmember count of a missing key reply %q", res)
+	}
+	// a huge count is rejected rather than allocated
+	for _, args := range [][]string{{"-9223372036854775808"}, {"-4000000000"}, {"9223372036854775807", "withscores"}} {
+		if res := exec(mem, append([]string{"zrandmember", "z"}, args...)...); res != "-ERR value is out of range\r\n" {
+			t.Errorf("zrandmember %v reply %q", args, res)
+		}
+	}
+	if res := exec(mem, "zrandmember", "z", "9223372036854775807"); !strings.HasPrefix(res, "*2\r\n") {
+		t.Errorf("zrandmember max count reply %q", res)
+	}
+}
+
+func TestZMPop(t *testing.T) {
+	mem := newTestDb()
+	exec(mem, "zadd", "z", "1", "a", "2", "b", "3", "c")
+	if res := exec(mem, "zpopmin", "z"); res != "*2\r\n$1\r\na\r\n$1\r\n1\r\n" {
+		t.Errorf("zpopmin reply %q", res)
+	}
+	if res := exec(mem, "zmpop", "2", "missing", "z", "max", "count", "5"); res != "*2\r\n$1\r\nz\r\n*2\r\n*2\r\n$1\r\nc\r\n$1\r\n3\r\n*2\r\n$1\r\nb\r\n$1\r\n2\r\n" {
+		t.Errorf("zmpop reply %q", res)
+	}
+	if res := exec(mem, "zmpop", "1", "z", "min"); res != "*-1\r\n" {
+		t.Errorf("zmpop of an empty key reply %q", res)
+	}
+	if res := exec(mem, "zmpop", "1", "z", "min", "count", "0"); res != "-ERR count should be greater than 0\r\n" {
+		t.Errorf("zmpop count 0 reply %q", res)
+	}
+	if res := exec(mem, "zmpop", "0", "z", "min"); res != "-ERR numkeys should be greater than 0\r\n" {
+		t.Errorf("zmpop numkeys 0 reply %q", res)
+	}
+}
+
+func TestZCountAndZRemRangeByScore(t *testing.T) {
+	mem := newTestDb()
+	exec(mem, "zadd", "z", "1", "a", "2", "b", "3", "c")
+	if res := exec(mem, "zcount", "z", "(1", "+inf"); res != ":2\r\n" {
+		t.Errorf("zcount reply %q", res)
+	}
+	if res := exec(mem, "zcount", "missing", "-inf", "+inf"); res != ":0\r\n" {
+		t.Errorf("zcount of a missing key reply %q", res)
+	}
+	// a single bad bound is an error, not a panic
+	if res := exec(mem, "zremrangebyscore", "z", "1", "x"); res != "-ERR value is not a float or out of range\r\n" {
+		t.Errorf("zremrangebyscore with a bad max reply %q", res)
+	}
+	if res := exec(mem, "zremrangebyscore", "z", "-inf", "2"); res != ":2\r\n" {
+		t.Errorf("zremrangebyscore reply %q", res)
+	}
+}
+
+func TestBZPop(t *testing.T) {
+	mem := newTestDb()
+	exec(mem, "zadd", "z", "1", "a", "2", "b")
+	if res := exec(mem, "bzpopmax", "missing", "z", "0"); res != "*3\r\n$1\r\nz\r\n$1\r\nb\r\n$1\r\n2\r\n" {
+		t.Errorf("bzpopmax reply %q", res)
+	}
+	if res := exec(mem, "bzpopmin", "missing", "0.05"); res != "*-1\r\n" {
+		t.Errorf("bzpopmin timeout reply %q", res)
+	}
+	if res := exec(mem, "bzpopmin", "missing", "-1"); res != "-ERR timeout is negative\r\n" {
+		t.Errorf("bzpopmin negative timeout reply %q", res)
+	}
+
+	// a blocked client is woken by a zadd from another client
+	done := make(chan string)
+	go func() {
+		done <- exec(mem, "bzmpop", "0", "1", "w", "min", "count", "2")
+	}()
+	time.Sleep(50 * time.Millisecond)
+	exec(mem, "zadd", "w", "5", "x")
+	select {
+	case res := <-done:
+		if res != "*2\r\n$1\r\nw\r\n*1\r\n*2\r\n$1\r\nx\r\n$1\r\n5\r\n" {
+			t.Errorf("bzmpop reply %q", res)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("bzmpop was not woken by zadd")
+	}
+}
+
+// expectWoken checks that a client blocked on key is woken by write
+func expectWoken(t *testing.T, mem *MemDb, key string, write func()) {
+	t.Helper()
+	done := make(chan string)
+	go func() {
+		done <- exec(mem, "bzpopmin", key, "0")
+	}()
+	time.Sleep(50 * time.Millisecond)
+	write()
+	select {
+	case res := <-done:
+		if !strings.HasPrefix(res, "*3\r\n$"+strconv.Itoa(len(key))+"\r\n"+key+"\r\n") {
+			t.Errorf("bzpopmin %s reply %q", key, res)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("bzpopmin %s was not woken", key)
+	}
+}
+
+func TestBZPopWokenByKeyWrites(t *testing.T) {
+	mem := newTestDb()
+	exec(mem, "zadd", "src", "1", "a")
+	payload := dumpOf(t, mem, "src")
+
+	expectWoken(t, mem, "copied", func() { exec(mem, "copy", "src", "copied") })
+	expectWoken(t, mem, "renamed", func() { exec(mem, "rename", "src", "renamed") })
+	exec(mem, "zadd", "src", "1", "a")
+	expectWoken(t, mem, "renamednx", func() { exec(mem, "renamenx", "src", "renamednx") })
+	expectWoken(t, mem, "restored", func() { exec(mem, "restore", "restored", "0", payload) })
+
+	// the blocking commands are not supported in active-active mode, the merge still signals the key
+	a, b, ab, _ := newReplicas()
+	ch := make(chan struct{}, 1)
+	b.watchers.watch([]string{"merged"}, ch)
+	exec(a, "zadd", "merged", "1", "a")
+	ab.flush(t)
+	select {
+	case <-ch:
+	default:
+		t.Error("the merge of a zadd did not signal the key")
+	}
+}
@@ -0,0 +1,97 @@
+package memdb
+
+import (
+	"easyRedis/datastructure"
+	"strings"
+	"testing"
+)
+
+func newSortDb() *MemDb {
+	mem := newTestDb()
+	list := datastructure.NewList()
+	for _, v := range []string{"3", "1", "10", "2"} {
+		list.RPush([]byte(v))
+	}
+	mem.db.Set("l", list)
+	return mem
+}
+
+func TestSortNumericAlpha(t *testing.T) {
+	mem := newSortDb()
+	if res := exec(mem, "sort", "l"); res != "*4\r\n$1\r\n1\r\n$1\r\n2\r\n$1\r\n3\r\n$2\r\n10\r\n" {
+		t.Errorf("sort reply %q", res)
+	}
+	if res := exec(mem, "sort", "l", "desc", "limit", "1", "2"); res != "*2\r\n$1\r\n3\r\n$1\r\n2\r\n" {
+		t.Errorf("sort desc limit reply %q", res)
+	}
+	if res := exec(mem, "sort", "l", "limit", "9223372036854775807", "9223372036854775807"); res != "*0\r\n" {
+		t.Errorf("sort overflowing limit reply %q", res)
+	}
+	if res := exec(mem, "sort", "l", "limit", "1", "9223372036854775807"); res != "*3\r\n$1\r\n2\r\n$1\r\n3\r\n$2\r\n10\r\n" {
+		t.Errorf("sort huge limit count reply %q", res)
+	}
+	if res := exec(mem, "sort", "l", "alpha"); res != "*4\r\n$1\r\n1\r\n$2\r\n10\r\n$1\r\n2\r\n$1\r\n3\r\n" {
+		t.Errorf("sort alpha reply %q", res)
+	}
+	exec(mem, "sadd", "s", "b", "a", "c")
+	if res := exec(mem, "sort", "s"); res != "-ERR One or more scores can't be converted into double\r\n" {
+		t.Errorf("sort of non numbers reply %q", res)
+	}
+	if res := exec(mem, "sort_ro", "s", "alpha", "desc"); res != "*3\r\n$1\r\nc\r\n$1\r\nb\r\n$1\r\na\r\n" {
+		t.Errorf("sort_ro set alpha reply %q", res)
+	}
+	exec(mem, "zadd", "z", "1", "30", "2", "20", "3", "10")
+	if res := exec(mem, "sort", "z"); res != "*3\r\n$2\r\n10\r\n$2\r\n20\r\n$2\r\n30\r\n" {
+		t.Errorf("sort zset reply %q", res)
+	}
+	if res := exec(mem, "sort", "z", "by", "nosort", "desc", "limit", "0", "2"); res != "*2\r\n$2\r\n10\r\n$2\r\n20\r\n" {
+		t.Errorf("sort zset nosort reply %q", res)
+	}
+	if res := exec(mem, "sort", "nokey"); res != "*0\r\n" {
+		t.Errorf("sort missing key reply %q", res)
+	}
+	exec(mem, "set", "str", "x")
+	if res := exec(mem, "sort", "str"); !strings.HasPrefix(res, "-WRONGTYPE") {
+		t.Errorf("sort string reply %q", res)
+	}
+	if res := exec(mem, "sort_ro", "l", "store", "dst"); res != "-ERR syntax error\r\n" {
+		t.Errorf("sort_ro store reply %q", res)
+	}
+}
+
+func TestSortByGetStore(t *testing.T) {
+	mem := newSortDb()
+	exec(mem, "sadd", "users", "u1", "u2", "u3")
+	exec(mem, "hset", "user:u1", "age", "30", "name", "alice")
+	exec(mem, "hset", "user:u2", "age", "20", "name", "bob")
+	exec(mem, "hset", "user:u3", "name", "carol")
+	exec(mem, "set", "w_u1", "2")
+	exec(mem, "set", "w_u2", "1")
+	exec(mem, "set", "w_u3", "3")
+
+	if res := exec(mem, "sort", "users", "by", "w_*"); res != "*3\r\n$2\r\nu2\r\n$2\r\nu1\r\n$2\r\nu3\r\n" {
+		t.Errorf("sort by reply %q", res)
+	}
+	// u3 has no age, a missing weight counts as 0
+	res := exec(mem, "sort", "users", "by", "user:*->age", "get", "#", "get", "user:*->name", "get", "user:*->none")
+	want := "*9\r\n$2\r\nu3\r\n$5\r\ncarol\r\n$-1\r\n$2\r\nu2\r\n$3\r\nbob\r\n$-1\r\n$2\r\nu1\r\n$5\r\nalice\r\n$-1\r\n"
+	if res != want {
+		t.Errorf("sort by hash field get reply %q", res)
+	}
+	if res := exec(mem, "sort", "users", "by", "user:*->name", "alpha", "desc", "get", "w_*"); res != "*3\r\n$1\r\n3\r\n$1\r\n1\r\n$1\r\n2\r\n" {
+		t.Errorf("sort by alpha get reply %q", res)
+	}
+
+	if res := exec(mem, "sort", "users", "by", "w_*", "get", "user:*->age", "store", "dst"); res != ":3\r\n" {
+		t.Errorf("sort store reply %q", res)
+	}
+	if res := exec(mem, "lrange", "dst", "0", "-1"); res != "*3\r\n$2\r\n20\r\n$2\r\n30\r\n$0\r\n\r\n" {
+		t.Errorf("stored list %q", res)
+	}
+	if res := exec(mem, "sort", "nokey", "store", "dst"); res != ":0\r\n" {
+		t.Errorf("sort store empty reply %q", res)
+	}
+	if _, ok := mem.db.Get("dst"); ok {
+		t.Error("an empty result must delete the destination")
+	}
+}
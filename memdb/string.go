@@ -4,8 +4,10 @@ import (
 	"easyRedis/logger"
 	"easyRedis/resp"
 	"fmt"
+	"math"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // string.go file implements the string commands of redis
@@ -542,20 +544,380 @@ func appendString(m *MemDb, cmd [][]byte) resp.RedisData {
 	return resp.NewIntData(int64(len(newVal)))
 }
 
+func getSetString(m *MemDb, cmd [][]byte) resp.RedisData {
+	if strings.ToLower(string(cmd[0])) != "getset" {
+		logger.Error("getSetString func: cmdName != getset")
+		return resp.NewErrorData("Server error")
+	}
+	if len(cmd) != 3 {
+		return resp.NewErrorData("error: command is invalid")
+	}
+	key := string(cmd[1])
+	m.CheckTTL(key)
+	m.locks.Lock(key)
+	defer m.locks.Unlock(key)
+
+	oldVal, errData := m.getStringValue(key)
+	if errData != nil {
+		return errData
+	}
+	m.db.Set(key, cmd[2])
+	m.DelTTL(key)
+	return resp.NewBulkData(oldVal)
+}
+
+func getDelString(m *MemDb, cmd [][]byte) resp.RedisData {
+	if strings.ToLower(string(cmd[0])) != "getdel" {
+		logger.Error("getDelString func: cmdName != getdel")
+		return resp.NewErrorData("Server error")
+	}
+	if len(cmd) != 2 {
+		return resp.NewErrorData("error: command is invalid")
+	}
+	key := string(cmd[1])
+	m.CheckTTL(key)
+	m.locks.Lock(key)
+	defer m.locks.Unlock(key)
+
+	val, errData := m.getStringValue(key)
+	if errData != nil {
+		return errData
+	}
+	if val == nil {
+		return resp.NewBulkData(nil)
+	}
+	m.db.Delete(key)
+	m.DelTTL(key)
+	return resp.NewBulkData(val)
+}
+
+// maxExpireSeconds is the largest ttl of a key, the ttl task is scheduled with a time.Duration
+const maxExpireSeconds = math.MaxInt64 / int64(time.Second)
+
+// expireSeconds converts the value of an EX, PX, EXAT or PXAT option to seconds from now.
+// TTLs are kept with a second precision, so milliseconds are rounded up.
+// It returns false if the ttl is too large to be kept.
+func expireSeconds(opt string, val int64) (int64, bool) {
+	var secs int64
+	switch opt {
+	case "px":
+		secs = ceilMillis(val)
+	case "exat":
+		secs = val - time.Now().Unix()
+	case "pxat":
+		secs = ceilMillis(val) - time.Now().Unix()
+	default:
+		secs = val
+	}
+	return secs, secs <= maxExpireSeconds
+}
+
+// ceilMillis converts non negative milliseconds to seconds rounding up, without overflowing
+func ceilMillis(ms int64) int64 {
+	secs := ms / 1000
+	if ms%1000 != 0 {
+		secs++
+	}
+	return secs
+}
+
+func getExString(m *MemDb, cmd [][]byte) resp.RedisData {
+	if strings.ToLower(string(cmd[0])) != "getex" {
+		logger.Error("getExString func: cmdName != getex")
+		return resp.NewErrorData("Server error")
+	}
+	if len(cmd) < 2 {
+		return resp.NewErrorData("error: command is invalid")
+	}
+	var opt string
+	var expire int64
+	for i := 2; i < len(cmd); i++ {
+		o := strings.ToLower(string(cmd[i]))
+		switch o {
+		case "ex", "px", "exat", "pxat":
+			if opt != "" || i+1 >= len(cmd) {
+				return resp.NewErrorData("ERR syntax error")
+			}
+			v, err := strconv.ParseInt(string(cmd[i+1]), 10, 64)
+			if err != nil {
+				return resp.NewErrorData("ERR value is not an integer or out of range")
+			}
+			if v <= 0 {
+				return resp.NewErrorData("ERR invalid expire time in 'getex' command")
+			}
+			secs, ok := expireSeconds(o, v)
+			if !ok {
+				return resp.NewErrorData("ERR invalid expire time in 'getex' command")
+			}
+			opt, expire = o, secs
+			i++
+		case "persist":
+			if opt != "" {
+				return resp.NewErrorData("ERR syntax error")
+			}
+			opt = o
+		default:
+			return resp.NewErrorData("ERR syntax error")
+		}
+	}
+
+	key := string(cmd[1])
+	m.CheckTTL(key)
+	m.locks.Lock(key)
+	defer m.locks.Unlock(key)
+
+	val, errData := m.getStringValue(key)
+	if errData != nil {
+		return errData
+	}
+	if val == nil {
+		return resp.NewBulkData(nil)
+	}
+	switch {
+	case opt == "persist":
+		m.DelTTL(key)
+	case opt != "" && expire <= 0:
+		// an expire time in the past deletes the key
+		m.db.Delete(key)
+		m.DelTTL(key)
+	case opt != "":
+		m.SetTTL(key, expire)
+	}
+	return resp.NewBulkData(val)
+}
+
+func mSetNxString(m *MemDb, cmd [][]byte) resp.RedisData {
+	if strings.ToLower(string(cmd[0])) != "msetnx" {
+		logger.Error("mSetNxString func: cmdName != msetnx")
+		return resp.NewErrorData("Server error")
+	}
+	if len(cmd) < 3 || len(cmd)&1 != 1 {
+		return resp.NewErrorData("error: command is invalid")
+	}
+	keys := make([]string, 0, len(cmd)/2)
+	vals := make([][]byte, 0, len(cmd)/2)
+	for i := 1; i < len(cmd); i += 2 {
+		m.CheckTTL(string(cmd[i]))
+		keys = append(keys, string(cmd[i]))
+		vals = append(vals, cmd[i+1])
+	}
+
+	//lock all key, nothing is set if one of the keys exists
+	m.locks.LockMulti(keys)
+	defer m.locks.UnlockMulti(keys)
+	for _, key := range keys {
+		if _, ok := m.db.Get(key); ok {
+			return resp.NewIntData(0)
+		}
+	}
+	for i := 0; i < len(keys); i++ {
+		m.db.Set(keys[i], vals[i])
+	}
+	return resp.NewIntData(1)
+}
+
+func pSetExString(m *MemDb, cmd [][]byte) resp.RedisData {
+	if strings.ToLower(string(cmd[0])) != "psetex" {
+		logger.Error("pSetExString func: cmdName is not psetex")
+		return resp.NewErrorData("Server error")
+	}
+	if len(cmd) != 4 {
+		return resp.NewErrorData("error: command is invalid")
+	}
+	px, err := strconv.ParseInt(string(cmd[2]), 10, 64)
+	if err != nil {
+		return resp.NewErrorData(fmt.Sprintf("error: %s is not a integer", string(cmd[2])))
+	}
+	ttl, ok := expireSeconds("px", px)
+	if px <= 0 || !ok {
+		return resp.NewErrorData("ERR invalid expire time in 'psetex' command")
+	}
+	key := string(cmd[1])
+
+	m.locks.Lock(key)
+	defer m.locks.Unlock(key)
+	m.db.Set(key, cmd[3])
+	m.SetTTL(key, ttl)
+	return resp.NewStringData("OK")
+}
+
+// substrString is the old name of GETRANGE
+func substrString(m *MemDb, cmd [][]byte) resp.RedisData {
+	if strings.ToLower(string(cmd[0])) != "substr" {
+		logger.Error("substrString func: cmdName is not substr")
+		return resp.NewErrorData("Server error")
+	}
+	args := make([][]byte, len(cmd))
+	copy(args, cmd)
+	args[0] = []byte("getrange")
+	return getRangeString(m, args)
+}
+
+// lcsString finds the longest common subsequence of two strings with dynamic programming,
+// the matched ranges are collected walking the table backward like redis.
+func lcsString(m *MemDb, cmd [][]byte) resp.RedisData {
+	if strings.ToLower(string(cmd[0])) != "lcs" {
+		logger.Error("lcsString func: cmdName is not lcs")
+		return resp.NewErrorData("Server error")
+	}
+	if len(cmd) < 3 {
+		return resp.NewErrorData("ERR wrong number of arguments for 'lcs' command")
+	}
+	var getLen, getIdx, withMatchLen bool
+	var minMatchLen int
+	for i := 3; i < len(cmd); i++ {
+		switch strings.ToLower(string(cmd[i])) {
+		case "len":
+			getLen = true
+		case "idx":
+			getIdx = true
+		case "withmatchlen":
+			withMatchLen = true
+		case "minmatchlen":
+			if i+1 >= len(cmd) {
+				return resp.NewErrorData("ERR syntax error")
+			}
+			v, err := strconv.Atoi(string(cmd[i+1]))
+			if err != nil {
+				return resp.NewErrorData("ERR value is not an integer or out of range")
+			}
+			if v > 0 {
+				minMatchLen = v
+			}
+			i++
+		default:
+			return resp.NewErrorData("ERR syntax error")
+		}
+	}
+	if getLen && getIdx {
+		return resp.NewErrorData("ERR If you want both the length and indexes, please just use IDX.")
+	}
+
+	keys := []string{string(cmd[1]), string(cmd[2])}
+	m.CheckTTL(keys[0])
+	m.CheckTTL(keys[1])
+	m.locks.RLockMulti(keys)
+	defer m.locks.RUnlockMulti(keys)
+	a, errData := m.getStringValue(keys[0])
+	if errData != nil {
+		return errData
+	}
+	b, errData := m.getStringValue(keys[1])
+	if errData != nil {
+		return errData
+	}
+	return lcs(a, b, getLen, getIdx, withMatchLen, minMatchLen)
+}
+
+func lcs(a, b []byte, getLen, getIdx, withMatchLen bool, minMatchLen int) resp.RedisData {
+	aLen, bLen := len(a), len(b)
+	// the table takes 4 bytes per cell, refuse it when it is larger than proto-max-bulk-len like redis
+	if uint64(aLen+1)*uint64(bLen+1) > uint64(resp.ProtoMaxBulkLen)/4 {
+		return resp.NewErrorData("ERR Insufficient memory, transient memory for LCS exceeds proto-max-bulk-len")
+	}
+	// dp[i*(bLen+1)+j] is the lcs length of a[:i] and b[:j]
+	width := bLen + 1
+	dp := make([]uint32, (aLen+1)*width)
+	for i := 1; i <= aLen; i++ {
+		for j := 1; j <= bLen; j++ {
+			if a[i-1] == b[j-1] {
+				dp[i*width+j] = dp[(i-1)*width+j-1] + 1
+			} else if dp[(i-1)*width+j] > dp[i*width+j-1] {
+				dp[i*width+j] = dp[(i-1)*width+j]
+			} else {
+				dp[i*width+j] = dp[i*width+j-1]
+			}
+		}
+	}
+	length := int(dp[aLen*width+bLen])
+	if getLen {
+		return resp.NewIntData(int64(length))
+	}
+
+	result := make([]byte, length)
+	matches := make([]resp.RedisData, 0)
+	idx := length
+	// aStart == aLen means that no range is being tracked
+	aStart, aEnd, bStart, bEnd := aLen, 0, 0, 0
+	i, j := aLen, bLen
+	for i > 0 && j > 0 {
+		emit := false
+		if a[i-1] == b[j-1] {
+			result[idx-1] = a[i-1]
+			if aStart == aLen {
+				aStart, aEnd, bStart, bEnd = i-1, i-1, j-1, j-1
+			} else if aStart == i && bStart == j {
+				// the range is contiguous, extend it backward
+				aStart--
+				bStart--
+			} else {
+				emit = true
+			}
+			// emit the range when the start of one of the strings is reached
+			if aStart == 0 || bStart == 0 {
+				emit = true
+			}
+			idx--
+			i--
+			j--
+		} else {
+			if dp[(i-1)*width+j] > dp[i*width+j-1] {
+				i--
+			} else {
+				j--
+			}
+			if aStart != aLen {
+				emit = true
+			}
+		}
+		if emit {
+			matchLen := aEnd - aStart + 1
+			if getIdx && (minMatchLen == 0 || matchLen >= minMatchLen) {
+				match := []resp.RedisData{
+					resp.NewArrayData([]resp.RedisData{resp.NewIntData(int64(aStart)), resp.NewIntData(int64(aEnd))}),
+					resp.NewArrayData([]resp.RedisData{resp.NewIntData(int64(bStart)), resp.NewIntData(int64(bEnd))}),
+				}
+				if withMatchLen {
+					match = append(match, resp.NewIntData(int64(matchLen)))
+				}
+				matches = append(matches, resp.NewArrayData(match))
+			}
+			aStart = aLen
+		}
+	}
+
+	if getIdx {
+		return resp.NewArrayData([]resp.RedisData{
+			resp.NewBulkData([]byte("matches")),
+			resp.NewArrayData(matches),
+			resp.NewBulkData([]byte("len")),
+			resp.NewIntData(int64(length)),
+		})
+	}
+	return resp.NewBulkData(result)
+}
+
 func RegisterStringCommands() {
 	RegisterCommand("set", setString)
-	RegisterCommand("get", getString)
-	RegisterCommand("getrange", getRangeString)
+	RegisterCommand("get", getString, flagReadOnly)
+	RegisterCommand("getrange", getRangeString, flagReadOnly)
 	RegisterCommand("setrange", setRangeString)
-	RegisterCommand("mget", mGetString)
+	RegisterCommand("mget", mGetString, flagReadOnly)
 	RegisterCommand("mset", mSetString)
 	RegisterCommand("setex", setExString)
 	RegisterCommand("setnx", setNxString)
-	RegisterCommand("strlen", strLenString)
+	RegisterCommand("strlen", strLenString, flagReadOnly)
 	RegisterCommand("incr", incrString)
 	RegisterCommand("incrby", incrByString)
 	RegisterCommand("decr", decrString)
 	RegisterCommand("decrby", decrByString)
 	RegisterCommand("incrbyfloat", incrByFloatString)
 	RegisterCommand("append", appendString)
+	RegisterCommand("getset", getSetString)
+	RegisterCommand("getdel", getDelString)
+	RegisterCommand("getex", getExString)
+	RegisterCommand("msetnx", mSetNxString)
+	RegisterCommand("psetex", pSetExString)
+	RegisterCommand("substr", substrString, flagReadOnly)
+	RegisterCommand("lcs", lcsString, flagReadOnly)
 }
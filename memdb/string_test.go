@@ -2,17 +2,12 @@ package memdb
 
 import (
 	"bytes"
-	"easyRedis/config"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 )
 
-func init() {
-	config.Configures = &config.Config{
-		ShardNum: 100,
-	}
-}
-
 func TestSetString(t *testing.T) {
 	mem := NewMemDb()
 
@@ -50,3 +45,140 @@ func TestSetString(t *testing.T) {
 		t.Error("set keepttl error")
 	}
 }
+
+func TestGetSetDelString(t *testing.T) {
+	mem := newTestDb()
+
+	if res := exec(mem, "getset", "a", "1"); res != "$-1\r\n" {
+		t.Errorf("getset on a new key reply %q", res)
+	}
+	exec(mem, "expire", "a", "100")
+	if res := exec(mem, "getset", "a", "2"); res != "$1\r\n1\r\n" {
+		t.Errorf("getset reply %q", res)
+	}
+	if _, ok := mem.ttlKeys.Get("a"); ok {
+		t.Error("getset must clear the ttl")
+	}
+	if res := exec(mem, "getdel", "a"); res != "$1\r\n2\r\n" {
+		t.Errorf("getdel reply %q", res)
+	}
+	if res := exec(mem, "getdel", "a"); res != "$-1\r\n" {
+		t.Errorf("getdel on a deleted key reply %q", res)
+	}
+	exec(mem, "lpush", "l", "a")
+	if res := exec(mem, "getdel", "l"); res != "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n" {
+		t.Errorf("getdel on a list reply %q", res)
+	}
+	if res := exec(mem, "llen", "l"); res != ":1\r\n" {
+		t.Errorf("getdel on a list must keep it, llen reply %q", res)
+	}
+}
+
+func TestGetExString(t *testing.T) {
+	mem := newTestDb()
+	exec(mem, "set", "a", "v")
+
+	if res := exec(mem, "getex", "a", "ex", "100"); res != "$1\r\nv\r\n" {
+		t.Errorf("getex ex reply %q", res)
+	}
+	if ttl, ok := mem.ttlKeys.Get("a"); !ok || ttl.(int64)-time.Now().Unix() < 99 {
+		t.Error("getex ex ttl error")
+	}
+	exec(mem, "getex", "a", "px", "1500")
+	if ttl, ok := mem.ttlKeys.Get("a"); !ok || ttl.(int64)-time.Now().Unix() < 1 || ttl.(int64)-time.Now().Unix() > 2 {
+		t.Error("getex px must round up to seconds")
+	}
+	exec(mem, "getex", "a", "persist")
+	if _, ok := mem.ttlKeys.Get("a"); ok {
+		t.Error("getex persist error")
+	}
+	exec(mem, "getex", "a", "exat", strconv.FormatInt(time.Now().Unix()+50, 10))
+	if ttl, ok := mem.ttlKeys.Get("a"); !ok || ttl.(int64)-time.Now().Unix() < 49 {
+		t.Error("getex exat ttl error")
+	}
+	if res := exec(mem, "getex", "a", "pxat", "1000"); res != "$1\r\nv\r\n" {
+		t.Errorf("getex pxat in the past reply %q", res)
+	}
+	if _, ok := mem.db.Get("a"); ok {
+		t.Error("getex with a past time must delete the key")
+	}
+	if res := exec(mem, "getex", "a", "ex", "0"); res != "-ERR invalid expire time in 'getex' command\r\n" {
+		t.Errorf("getex invalid time reply %q", res)
+	}
+	exec(mem, "set", "a", "v")
+	if res := exec(mem, "getex", "a", "ex", "9223372036854775807"); res != "-ERR invalid expire time in 'getex' command\r\n" {
+		t.Errorf("getex overflowing time reply %q", res)
+	}
+	if _, ok := mem.db.Get("a"); !ok {
+		t.Error("getex with an invalid time must keep the key")
+	}
+	if res := exec(mem, "getex", "a", "ex", "10", "persist"); res != "-ERR syntax error\r\n" {
+		t.Errorf("getex two options reply %q", res)
+	}
+}
+
+func TestMSetNxPSetExString(t *testing.T) {
+	mem := newTestDb()
+
+	if res := exec(mem, "msetnx", "a", "1", "b", "2"); res != ":1\r\n" {
+		t.Errorf("msetnx reply %q", res)
+	}
+	if res := exec(mem, "msetnx", "c", "3", "b", "4"); res != ":0\r\n" {
+		t.Errorf("msetnx on an existing key reply %q", res)
+	}
+	if _, ok := mem.db.Get("c"); ok {
+		t.Error("msetnx must not set any key when one exists")
+	}
+	if res := exec(mem, "psetex", "p", "2500", "v"); res != "+OK\r\n" {
+		t.Errorf("psetex reply %q", res)
+	}
+	if ttl, ok := mem.ttlKeys.Get("p"); !ok || ttl.(int64)-time.Now().Unix() < 2 || ttl.(int64)-time.Now().Unix() > 3 {
+		t.Error("psetex ttl error")
+	}
+	if res := exec(mem, "psetex", "q", "9223372036854775807", "v"); res != "-ERR invalid expire time in 'psetex' command\r\n" {
+		t.Errorf("psetex overflowing time reply %q", res)
+	}
+	if _, ok := mem.db.Get("q"); ok {
+		t.Error("psetex with an invalid time must not set the key")
+	}
+	exec(mem, "set", "s", "This is a string")
+	if res := exec(mem, "substr", "s", "-3", "-1"); res != "$3\r\ning\r\n" {
+		t.Errorf("substr reply %q", res)
+	}
+}
+
+func TestLcsString(t *testing.T) {
+	mem := newTestDb()
+	exec(mem, "mset", "key1", "ohmytext", "key2", "mynewtext")
+
+	if res := exec(mem, "lcs", "key1", "key2"); res != "$6\r\nmytext\r\n" {
+		t.Errorf("lcs reply %q", res)
+	}
+	if res := exec(mem, "lcs", "key1", "key2", "len"); res != ":6\r\n" {
+		t.Errorf("lcs len reply %q", res)
+	}
+	want := "*4\r\n$7\r\nmatches\r\n*2\r\n" +
+		"*2\r\n*2\r\n:4\r\n:7\r\n*2\r\n:5\r\n:8\r\n" +
+		"*2\r\n*2\r\n:2\r\n:3\r\n*2\r\n:0\r\n:1\r\n" +
+		"$3\r\nlen\r\n:6\r\n"
+	if res := exec(mem, "lcs", "key1", "key2", "idx"); res != want {
+		t.Errorf("lcs idx reply %q", res)
+	}
+	want = "*4\r\n$7\r\nmatches\r\n*1\r\n" +
+		"*3\r\n*2\r\n:4\r\n:7\r\n*2\r\n:5\r\n:8\r\n:4\r\n" +
+		"$3\r\nlen\r\n:6\r\n"
+	if res := exec(mem, "lcs", "key1", "key2", "idx", "minmatchlen", "4", "withmatchlen"); res != want {
+		t.Errorf("lcs idx minmatchlen reply %q", res)
+	}
+	if res := exec(mem, "lcs", "key1", "nokey"); res != "$0\r\n\r\n" {
+		t.Errorf("lcs with a missing key reply %q", res)
+	}
+	if res := exec(mem, "lcs", "key1", "key2", "len", "idx"); res != "-ERR If you want both the length and indexes, please just use IDX.\r\n" {
+		t.Errorf("lcs len idx reply %q", res)
+	}
+	big := strings.Repeat("a", 60000)
+	exec(mem, "mset", "big1", big, "big2", big)
+	if res := exec(mem, "lcs", "big1", "big2", "len"); res != "-ERR Insufficient memory, transient memory for LCS exceeds proto-max-bulk-len\r\n" {
+		t.Errorf("lcs on large strings reply %q", res)
+	}
+}
@@ -0,0 +1,388 @@
+package resp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// MaxMultiBulkLen is the max number of arguments of a command, the same as redis
+const MaxMultiBulkLen = 1024 * 1024
+
+// ProtoMaxBulkLen is the max length of an argument, set from proto-max-bulk-len at startup
+var ProtoMaxBulkLen = 512 << 20
+
+// QueryBufferLimit is the max size of a command being read, set from client-query-buffer-limit at startup.
+// 0 means no limit.
+var QueryBufferLimit = 1 << 30
+
+// ErrQueryBufferLimit is returned by ReadCommand when a command doesn't fit in QueryBufferLimit
+var ErrQueryBufferLimit = errors.New("client query buffer limit reached")
+
+// maxHeaderLen is the max length of a line without "\r\n", a longer one is a protocol error
+const maxHeaderLen = 64 << 10
+
+// maxInlineLen is the max length of an inline command, the same as redis
+const maxInlineLen = 64 << 10
+
+const (
+	readerBufSize = 16 << 10
+	// readerMaxKeep is the largest buffer kept when the reader is empty, a larger one made by a big command is released
+	readerMaxKeep = 1 << 20
+)
+
+var (
+	errMultiBulkLen = errors.New("Protocol error: invalid multibulk length")
+	errBulkLen      = errors.New("Protocol error: invalid bulk length")
+)
+
+// Reader reads commands from a connection synchronously.
+// A command is parsed in place in a reusable buffer, the parsing is resumed where it stopped
+// when a command arrives in several reads, so a large command is not parsed again and again.
+// A command is either a multibulk array, or an inline command: a line of arguments separated by spaces,
+// such as typed in telnet, quoted and escaped like in redis-cli.
+type Reader struct {
+	rd  io.Reader
+	buf []byte
+	// the command being parsed starts at buf[start], it is parsed up to buf[pos], the data ends at buf[end]
+	start, pos, end int
+
+	// argc is the number of arguments of the command being parsed, 0 before its header is parsed
+	argc int
+	// bulkLen is the length of the next argument, -1 before its header is parsed
+	bulkLen int
+	// offsets of the arguments parsed so far, in pairs of start and end relative to buf[start],
+	// so that they stay valid when the buffer is moved
+	offsets []int
+	args    [][]byte
+
+	// ready is true if args holds a command parsed by Pending and not returned yet
+	ready bool
+	err   error
+}
+
+func NewReader(rd io.Reader) *Reader {
+	return &Reader{
+		rd:      rd,
+		buf:     make([]byte, readerBufSize),
+		bulkLen: -1,
+	}
+}
+
+// ReadCommand returns the next command.
+// The arguments point into the buffer of the reader, they are only valid until the next call to ReadCommand, Pending or Fill.
+// It returns io.EOF when the connection is closed, and a protocol error if the input is invalid,
+// after which the connection should be closed.
+func (r *Reader) ReadCommand() ([][]byte, error) {
+	if r.ready {
+		r.ready = false
+		return r.args, nil
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	for {
+		ok, err := r.parse()
+		if err != nil {
+			r.err = err
+			return nil, err
+		}
+		if ok {
+			return r.args, nil
+		}
+		if err = r.fill(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Pending reports whether a complete command is already buffered, without reading from the connection
+func (r *Reader) Pending() bool {
+	if !r.ready && r.err == nil {
+		r.ready, r.err = r.parse()
+	}
+	return r.ready
+}
+
+// Fill reads more data into the buffer without parsing it, it returns the error of the read.
+// It lets the caller notice a closed connection while a command runs, the data read is parsed by
+// the next ReadCommand. Like ReadCommand, it must not be called concurrently with the other methods.
+func (r *Reader) Fill() error {
+	return r.fill()
+}
+
+// parse parses a command from the buffered data, it returns false if more data is needed
+func (r *Reader) parse() (bool, error) {
+	for r.argc == 0 {
+		if r.pos < r.end && r.buf[r.pos] != '*' {
+			pos := r.pos
+			ok, err := r.inline()
+			if ok || err != nil || r.pos == pos {
+				return ok, err
+			}
+			// an empty line is skipped
+			continue
+		}
+		line, ok, err := r.line()
+		if !ok || err != nil {
+			return false, err
+		}
+		n, ok := parseLen(line[1:])
+		if !ok || n > MaxMultiBulkLen {
+			return false, errMultiBulkLen
+		}
+		// empty and null arrays are ignored like redis
+		r.start = r.pos
+		if n > 0 {
+			r.argc = n
+			r.offsets = r.offsets[:0]
+		}
+	}
+
+	for len(r.offsets) < 2*r.argc {
+		if r.bulkLen < 0 {
+			line, ok, err := r.line()
+			if !ok || err != nil {
+				return false, err
+			}
+			if len(line) == 0 {
+				return false, errors.New("Protocol error: expected '$', got an empty line")
+			}
+			if line[0] != '$' {
+				return false, fmt.Errorf("Protocol error: expected '$', got '%c'", line[0])
+			}
+			n, ok := parseLen(line[1:])
+			if !ok || n < 0 || n > ProtoMaxBulkLen {
+				return false, errBulkLen
+			}
+			r.bulkLen = n
+		}
+		if r.end-r.pos < r.bulkLen+2 {
+			return false, nil
+		}
+		if r.buf[r.pos+r.bulkLen] != '\r' || r.buf[r.pos+r.bulkLen+1] != '\n' {
+			return false, errBulkLen
+		}
+		r.offsets = append(r.offsets, r.pos-r.start, r.pos+r.bulkLen-r.start)
+		r.pos += r.bulkLen + 2
+		r.bulkLen = -1
+	}
+
+	r.args = r.args[:0]
+	for i := 0; i < len(r.offsets); i += 2 {
+		end := r.start + r.offsets[i+1]
+		r.args = append(r.args, r.buf[r.start+r.offsets[i]:end:end])
+	}
+	r.argc = 0
+	r.start = r.pos
+	return true, nil
+}
+
+// inline parses an inline command. It returns false if the command is not complete, or if it is
+// an empty line, which is skipped.
+func (r *Reader) inline() (bool, error) {
+	i := bytes.IndexByte(r.buf[r.pos:r.end], '\n')
+	if i < 0 {
+		if r.end-r.pos > maxInlineLen {
+			return false, errors.New("Protocol error: too big inline request")
+		}
+		return false, nil
+	}
+	line := r.buf[r.pos : r.pos+i]
+	if len(line) > 0 && line[len(line)-1] == '\r' {
+		line = line[:len(line)-1]
+	}
+	r.pos += i + 1
+	r.start = r.pos
+	args, ok := splitArgs(line, r.args[:0])
+	if !ok {
+		return false, errors.New("Protocol error: unbalanced quotes in request")
+	}
+	r.args = args
+	return len(args) > 0, nil
+}
+
+// splitArgs splits an inline command into arguments like sdssplitargs of redis.
+// The arguments are unescaped in place, an unescaped argument is never longer than its quoted form.
+// It returns false if the quotes are not balanced.
+func splitArgs(line []byte, args [][]byte) ([][]byte, bool) {
+	i, w := 0, 0
+	for {
+		for i < len(line) && isSpace(line[i]) {
+			i++
+		}
+		if i == len(line) {
+			return args, true
+		}
+		begin := w
+		inDouble, inSingle := false, false
+		for done := false; !done; {
+			switch {
+			case inDouble:
+				if i == len(line) {
+					return nil, false
+				}
+				c := line[i]
+				if c == '\\' && i+3 < len(line) && line[i+1] == 'x' && isHex(line[i+2]) && isHex(line[i+3]) {
+					c = hexVal(line[i+2])<<4 | hexVal(line[i+3])
+					i += 3
+				} else if c == '\\' && i+1 < len(line) {
+					i++
+					switch c = line[i]; c {
+					case 'n':
+						c = '\n'
+					case 'r':
+						c = '\r'
+					case 't':
+						c = '\t'
+					case 'b':
+						c = '\b'
+					case 'a':
+						c = '\a'
+					}
+				} else if c == '"' {
+					// the closing quote must be followed by a space or the end
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, false
+					}
+					i++
+					done = true
+					continue
+				}
+				line[w] = c
+				w++
+				i++
+			case inSingle:
+				if i == len(line) {
+					return nil, false
+				}
+				c := line[i]
+				if c == '\\' && i+1 < len(line) && line[i+1] == '\'' {
+					i++
+				} else if c == '\'' {
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, false
+					}
+					i++
+					done = true
+					continue
+				}
+				line[w] = line[i]
+				w++
+				i++
+			case i == len(line) || isSpace(line[i]):
+				done = true
+			case line[i] == '"':
+				inDouble = true
+				i++
+			case line[i] == '\'':
+				inSingle = true
+				i++
+			default:
+				line[w] = line[i]
+				w++
+				i++
+			}
+		}
+		args = append(args, line[begin:w:w])
+	}
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\v' || c == '\f'
+}
+
+func isHex(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+func hexVal(c byte) byte {
+	switch {
+	case c >= 'a':
+		return c - 'a' + 10
+	case c >= 'A':
+		return c - 'A' + 10
+	}
+	return c - '0'
+}
+
+// parseLen parses the length in a header without allocating, -1 is the only negative length
+func parseLen(b []byte) (int, bool) {
+	if len(b) == 2 && b[0] == '-' && b[1] == '1' {
+		return -1, true
+	}
+	if len(b) == 0 || len(b) > 18 {
+		return 0, false
+	}
+	n := 0
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+		n = n*10 + int(c-'0')
+	}
+	return n, true
+}
+
+// line returns the next line without "\r\n", false if the line is not complete
+func (r *Reader) line() ([]byte, bool, error) {
+	i := bytes.IndexByte(r.buf[r.pos:r.end], '\n')
+	if i < 0 {
+		if r.end-r.pos > maxHeaderLen {
+			if r.argc == 0 {
+				return nil, false, errors.New("Protocol error: too big mbulk count string")
+			}
+			return nil, false, errors.New("Protocol error: too big bulk count string")
+		}
+		return nil, false, nil
+	}
+	if i == 0 || r.buf[r.pos+i-1] != '\r' {
+		return nil, false, errors.New("Protocol error: line should end with CRLF")
+	}
+	line := r.buf[r.pos : r.pos+i-1]
+	r.pos += i + 1
+	return line, true, nil
+}
+
+// fill reads more data into the buffer. It grows the buffer to hold the next argument,
+// and moves the command being parsed to the front of the buffer when there is little room after it.
+func (r *Reader) fill() error {
+	if r.start == r.end {
+		if cap(r.buf) > readerMaxKeep {
+			r.buf = make([]byte, readerBufSize)
+		}
+		r.start, r.pos, r.end = 0, 0, 0
+	}
+	// need is the size of the command from its start up to the end of the next argument, or one more byte
+	need := r.end - r.start + 1
+	if r.bulkLen >= 0 && r.pos-r.start+r.bulkLen+2 > need {
+		need = r.pos - r.start + r.bulkLen + 2
+	}
+	if QueryBufferLimit > 0 && need > QueryBufferLimit {
+		return ErrQueryBufferLimit
+	}
+	if need > len(r.buf) {
+		size := 2 * len(r.buf)
+		if QueryBufferLimit > 0 && size > QueryBufferLimit {
+			size = QueryBufferLimit
+		}
+		if size < need {
+			size = need
+		}
+		buf := make([]byte, size)
+		copy(buf, r.buf[r.start:r.end])
+		r.buf = buf
+		r.start, r.pos, r.end = 0, r.pos-r.start, r.end-r.start
+	} else if r.start+need > len(r.buf) || (r.start > 0 && len(r.buf)-r.end < len(r.buf)/4) {
+		copy(r.buf, r.buf[r.start:r.end])
+		r.start, r.pos, r.end = 0, r.pos-r.start, r.end-r.start
+	}
+
+	n, err := r.rd.Read(r.buf[r.end:])
+	r.end += n
+	if n > 0 {
+		return nil
+	}
+	return err
+}
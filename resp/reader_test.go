@@ -0,0 +1,169 @@
+package resp
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// readAll reads all the commands of rd, joining the arguments of each command with spaces
+func readAll(rd io.Reader) ([]string, error) {
+	reader := NewReader(rd)
+	var res []string
+	for {
+		args, err := reader.ReadCommand()
+		if err != nil {
+			return res, err
+		}
+		res = append(res, string(bytes.Join(args, []byte(" "))))
+	}
+}
+
+func TestReadCommand(t *testing.T) {
+	data := "*3\r\n$3\r\nset\r\n$1\r\nk\r\n$0\r\n\r\n*0\r\n*-1\r\n*1\r\n$4\r\nping\r\n"
+	for name, rd := range map[string]io.Reader{
+		"whole":    strings.NewReader(data),
+		"one byte": iotest.OneByteReader(strings.NewReader(data)),
+		"half":     iotest.HalfReader(strings.NewReader(data)),
+	} {
+		cmds, err := readAll(rd)
+		if err != io.EOF || strings.Join(cmds, ",") != "set k ,ping" {
+			t.Errorf("%s: read %q, %v", name, cmds, err)
+		}
+	}
+
+	// an argument larger than the buffer arriving in pieces
+	big := strings.Repeat("x", 3*readerBufSize+5)
+	data = "*2\r\n$3\r\nget\r\n$" + strconv.Itoa(len(big)) + "\r\n" + big + "\r\n*1\r\n$4\r\nquit\r\n"
+	cmds, err := readAll(iotest.HalfReader(strings.NewReader(data)))
+	if err != io.EOF || len(cmds) != 2 || cmds[0] != "get "+big || cmds[1] != "quit" {
+		t.Errorf("read %d commands with a big argument, %v", len(cmds), err)
+	}
+}
+
+func TestReadCommandError(t *testing.T) {
+	for data, expect := range map[string]string{
+		"*" + strconv.Itoa(MaxMultiBulkLen+1) + "\r\n": "Protocol error: invalid multibulk length",
+		"*x\r\n":               "Protocol error: invalid multibulk length",
+		"*1\r\n$-1\r\n":        "Protocol error: invalid bulk length",
+		"*1\r\n$3\r\nabcd\r\n": "Protocol error: invalid bulk length",
+		"*1\r\n+ok\r\n":        "Protocol error: expected '$', got '+'",
+		"*1\r\n" + strings.Repeat("$", maxHeaderLen+1): "Protocol error: too big bulk count string",
+	} {
+		cmds, err := readAll(strings.NewReader(data))
+		if err == nil || err.Error() != expect || len(cmds) != 0 {
+			t.Errorf("read %q: %q, %v", data, cmds, err)
+		}
+	}
+}
+
+func TestReaderLimits(t *testing.T) {
+	defer func(bulk, query int) {
+		ProtoMaxBulkLen, QueryBufferLimit = bulk, query
+	}(ProtoMaxBulkLen, QueryBufferLimit)
+	ProtoMaxBulkLen, QueryBufferLimit = 10, 100
+
+	cmds, err := readAll(strings.NewReader("*2\r\n$3\r\nget\r\n$10\r\n0123456789\r\n*1\r\n$11\r\n"))
+	if err == nil || err.Error() != "Protocol error: invalid bulk length" || len(cmds) != 1 {
+		t.Errorf("read a bulk longer than proto-max-bulk-len: %q, %v", cmds, err)
+	}
+	ProtoMaxBulkLen = 1000
+	// the command doesn't fit in the query buffer limit, it fails before the argument is read
+	cmds, err = readAll(strings.NewReader("*1\r\n$200\r\n"))
+	if err != ErrQueryBufferLimit || len(cmds) != 0 {
+		t.Errorf("read a command larger than the query buffer limit: %q, %v", cmds, err)
+	}
+	// many small arguments
+	cmds, err = readAll(iotest.OneByteReader(strings.NewReader("*50\r\n" + strings.Repeat("$1\r\nx\r\n", 50))))
+	if err != ErrQueryBufferLimit || len(cmds) != 0 {
+		t.Errorf("read a command with many arguments: %q, %v", cmds, err)
+	}
+}
+
+func TestReaderPending(t *testing.T) {
+	// the first read gets two commands and a half
+	reader := NewReader(io.MultiReader(strings.NewReader("*1\r\n$4\r\nping\r\n*1\r\n$4\r\nping\r\n*1\r\n$4"),
+		strings.NewReader("\r\nping\r\n")))
+	if reader.Pending() {
+		t.Fatal("pending before reading")
+	}
+	if _, err := reader.ReadCommand(); err != nil {
+		t.Fatal(err)
+	}
+	if !reader.Pending() {
+		t.Fatal("the second command is not pending")
+	}
+	if args, err := reader.ReadCommand(); err != nil || string(args[0]) != "ping" {
+		t.Fatalf("read pending command %q, %v", args, err)
+	}
+	if reader.Pending() {
+		t.Error("an incomplete command is pending")
+	}
+}
+
+var benchCommand = []byte("*3\r\n$3\r\nset\r\n$8\r\nkey:1234\r\n$16\r\nvalue:0123456789\r\n")
+
+// repeatReader returns the same data forever
+type repeatReader struct {
+	data []byte
+	off  int
+}
+
+func (r *repeatReader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		c := copy(p[n:], r.data[r.off:])
+		n += c
+		r.off = (r.off + c) % len(r.data)
+	}
+	return n, nil
+}
+
+func BenchmarkReadCommand(b *testing.B) {
+	reader := NewReader(&repeatReader{data: benchCommand})
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := reader.ReadCommand(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkParseStream(b *testing.B) {
+	ch := ParseStream(&repeatReader{data: benchCommand})
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		res := <-ch
+		_ = res.Data.(*ArrayData).ToCommand()
+	}
+}
+
+func TestReadInlineCommand(t *testing.T) {
+	data := "PING\r\nset a \"b c\"\n\r\n*2\r\n$3\r\nget\r\n$1\r\na\r\n  get   'x\\'y'  \r\n" +
+		"set k \"\\x41\\n\\\"\\q\"\r\necho 'a\\nb' \"\"\r\n"
+	for name, rd := range map[string]io.Reader{
+		"whole":    strings.NewReader(data),
+		"one byte": iotest.OneByteReader(strings.NewReader(data)),
+	} {
+		cmds, err := readAll(rd)
+		expect := []string{"PING", "set a b c", "get a", "get x'y", "set k A\n\"q", "echo a\\nb "}
+		if err != io.EOF || strings.Join(cmds, ",") != strings.Join(expect, ",") {
+			t.Errorf("%s: read %q, %v", name, cmds, err)
+		}
+	}
+
+	for data, expect := range map[string]string{
+		"set a \"b\r\n":    "Protocol error: unbalanced quotes in request",
+		"set a 'b'c\r\n":   "Protocol error: unbalanced quotes in request",
+		"set a \"b\"c\r\n": "Protocol error: unbalanced quotes in request",
+		"get " + strings.Repeat("k", maxInlineLen): "Protocol error: too big inline request",
+	} {
+		cmds, err := readAll(strings.NewReader(data))
+		if err == nil || err.Error() != expect || len(cmds) != 0 {
+			t.Errorf("read %q: %q, %v", data, cmds, err)
+		}
+	}
+}
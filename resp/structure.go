@@ -9,8 +9,9 @@ import (
 var CRLF = "\r\n"
 
 type RedisData interface {
-	ToBytes() []byte  // return resp transfer format data
-	ByteData() []byte // return byte data
+	ToBytes() []byte            // return resp transfer format data
+	AppendTo(buf []byte) []byte // append resp transfer format data to buf, without intermediate slices
+	ByteData() []byte           // return byte data
 }
 
 type StringData struct {
@@ -48,10 +49,16 @@ func NewBulkData(data []byte) *BulkData {
 }
 
 func (b *BulkData) ToBytes() []byte {
+	return b.AppendTo(nil)
+}
+
+func (b *BulkData) AppendTo(buf []byte) []byte {
 	if b.data == nil {
-		return []byte("$-1" + CRLF)
+		return append(buf, "$-1\r\n"...)
 	}
-	return []byte("$" + strconv.Itoa(len(b.data)) + CRLF + string(b.data) + CRLF)
+	buf = strconv.AppendInt(append(buf, '$'), int64(len(b.data)), 10)
+	buf = append(append(buf, CRLF...), b.data...)
+	return append(buf, CRLF...)
 }
 
 func (b *BulkData) Data() []byte {
@@ -69,7 +76,11 @@ func NewStringData(data string) *StringData {
 }
 
 func (s *StringData) ToBytes() []byte {
-	return []byte("+" + s.data + CRLF)
+	return s.AppendTo(nil)
+}
+
+func (s *StringData) AppendTo(buf []byte) []byte {
+	return append(append(append(buf, '+'), s.data...), CRLF...)
 }
 
 func (s *StringData) ByteData() []byte {
@@ -87,7 +98,11 @@ func NewIntData(data int64) *IntData {
 }
 
 func (i *IntData) ToBytes() []byte {
-	return []byte(":" + strconv.FormatInt(i.data, 10) + CRLF)
+	return i.AppendTo(nil)
+}
+
+func (i *IntData) AppendTo(buf []byte) []byte {
+	return append(strconv.AppendInt(append(buf, ':'), i.data, 10), CRLF...)
 }
 
 func (i *IntData) ByteData() []byte {
@@ -105,7 +120,11 @@ func NewFloat64Data(data float64) *Float64Data {
 }
 
 func (f *Float64Data) ToBytes() []byte {
-	return []byte(":" + strconv.FormatFloat(f.data, 'f', -1, 64) + CRLF)
+	return f.AppendTo(nil)
+}
+
+func (f *Float64Data) AppendTo(buf []byte) []byte {
+	return append(strconv.AppendFloat(append(buf, ':'), f.data, 'f', -1, 64), CRLF...)
 }
 
 func (f *Float64Data) ByteData() []byte {
@@ -123,7 +142,11 @@ func NewErrorData(data string) *ErrorData {
 }
 
 func (e *ErrorData) ToBytes() []byte {
-	return []byte("-" + e.data + CRLF)
+	return e.AppendTo(nil)
+}
+
+func (e *ErrorData) AppendTo(buf []byte) []byte {
+	return append(append(append(buf, '-'), e.data...), CRLF...)
 }
 func (e *ErrorData) ByteData() []byte {
 	return []byte(e.data)
@@ -139,14 +162,18 @@ func NewArrayData(data []RedisData) *ArrayData {
 }
 
 func (a *ArrayData) ToBytes() []byte {
+	return a.AppendTo(nil)
+}
+
+func (a *ArrayData) AppendTo(buf []byte) []byte {
 	if a.data == nil {
-		return []byte("*-1" + CRLF)
+		return append(buf, "*-1\r\n"...)
 	}
-	res := []byte("*" + strconv.Itoa(len(a.data)) + CRLF)
+	buf = append(strconv.AppendInt(append(buf, '*'), int64(len(a.data)), 10), CRLF...)
 	for _, v := range a.data {
-		res = append(res, v.ToBytes()...)
+		buf = v.AppendTo(buf)
 	}
-	return res
+	return buf
 }
 
 func (a *ArrayData) Data() []RedisData {
@@ -176,7 +203,11 @@ func NewPlainData(data string) *PlainData {
 }
 
 func (p *PlainData) ToBytes() []byte {
-	return []byte(p.data + CRLF)
+	return p.AppendTo(nil)
+}
+
+func (p *PlainData) AppendTo(buf []byte) []byte {
+	return append(append(buf, p.data...), CRLF...)
 }
 func (p *PlainData) Data() string {
 	return p.data
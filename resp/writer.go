@@ -0,0 +1,119 @@
+package resp
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// WriterFlushSize is the size of the buffered replies that makes Write flush them
+const WriterFlushSize = 64 << 10
+
+// writerMaxKeep is the largest buffer kept after a flush, a larger one made by a big reply is released
+const writerMaxKeep = 4 * WriterFlushSize
+
+// OutputLimit is the output buffer limit of a client in bytes, see client-output-buffer-limit.
+// The client is disconnected once its buffered replies exceed Hard, or exceed Soft for SoftTime,
+// which includes the time a flush is blocked by a client not reading its replies. 0 disables a limit.
+type OutputLimit struct {
+	Hard     int
+	Soft     int
+	SoftTime time.Duration
+}
+
+// Writer buffers the replies to a connection, so that the replies of a pipeline are sent with few writes.
+// The replies are encoded directly into the buffer, the caller calls Flush when it has no more commands to reply.
+// Once a limit is exceeded the buffered replies are dropped, and Write and Flush return the error.
+type Writer struct {
+	w     io.Writer
+	buf   []byte
+	limit OutputLimit
+	// softSince is when the buffer exceeded the soft limit, zero if it doesn't
+	softSince time.Time
+	err       error
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// SetLimit sets the output buffer limit
+func (w *Writer) SetLimit(limit OutputLimit) {
+	w.limit = limit
+}
+
+// Write appends data to the buffer, and flushes the buffer once it holds WriterFlushSize bytes
+func (w *Writer) Write(data RedisData) error {
+	if w.err != nil {
+		return w.err
+	}
+	w.buf = data.AppendTo(w.buf)
+	if err := w.checkLimit(); err != nil {
+		return err
+	}
+	if len(w.buf) >= WriterFlushSize {
+		return w.Flush()
+	}
+	return nil
+}
+
+// Buffered returns the number of bytes not flushed yet
+func (w *Writer) Buffered() int {
+	return len(w.buf)
+}
+
+// Flush writes the buffered replies to the connection
+func (w *Writer) Flush() error {
+	if w.err != nil {
+		return w.err
+	}
+	if len(w.buf) == 0 {
+		return nil
+	}
+	if err := w.checkLimit(); err != nil {
+		return err
+	}
+	// a client not reading its replies can't block the flush longer than the soft limit allows
+	conn, isConn := w.w.(net.Conn)
+	if isConn && !w.softSince.IsZero() {
+		_ = conn.SetWriteDeadline(w.softSince.Add(w.limit.SoftTime))
+	}
+	_, err := w.w.Write(w.buf)
+	if cap(w.buf) > writerMaxKeep {
+		w.buf = nil
+	} else {
+		w.buf = w.buf[:0]
+	}
+	if isConn && !w.softSince.IsZero() {
+		w.softSince = time.Time{}
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			err = fmt.Errorf("output buffer soft limit of %d bytes exceeded for %v", w.limit.Soft, w.limit.SoftTime)
+		}
+		_ = conn.SetWriteDeadline(time.Time{})
+	}
+	if err != nil {
+		w.err = err
+	}
+	return err
+}
+
+// checkLimit drops the buffered replies and returns an error if they exceed the limit
+func (w *Writer) checkLimit() error {
+	size := len(w.buf)
+	if w.limit.Hard > 0 && size > w.limit.Hard {
+		w.err = fmt.Errorf("output buffer hard limit of %d bytes exceeded by %d bytes", w.limit.Hard, size)
+	} else if w.limit.Soft > 0 && size > w.limit.Soft {
+		if w.softSince.IsZero() {
+			w.softSince = time.Now()
+		} else if time.Since(w.softSince) > w.limit.SoftTime {
+			w.err = fmt.Errorf("output buffer soft limit of %d bytes exceeded for %v", w.limit.Soft, w.limit.SoftTime)
+		}
+	} else {
+		w.softSince = time.Time{}
+	}
+	if w.err != nil {
+		w.buf = nil
+	}
+	return w.err
+}
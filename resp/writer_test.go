@@ -0,0 +1,110 @@
+package resp
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// countingWriter counts the writes to it
+type countingWriter struct {
+	bytes.Buffer
+	writes int
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.writes++
+	return c.Buffer.Write(p)
+}
+
+func TestAppendTo(t *testing.T) {
+	values := []RedisData{
+		NewBulkData([]byte("bulk")), NewBulkData(nil), NewStringData("OK"), NewIntData(-42),
+		NewFloat64Data(1.5), NewErrorData("ERR x"), NewArrayData(nil), NewPlainData("plain"),
+		NewArrayData([]RedisData{NewIntData(1), NewArrayData([]RedisData{NewBulkData([]byte(""))})}),
+	}
+	expect := []string{"$4\r\nbulk\r\n", "$-1\r\n", "+OK\r\n", ":-42\r\n", ":1.5\r\n", "-ERR x\r\n", "*-1\r\n",
+		"plain\r\n", "*2\r\n:1\r\n*1\r\n$0\r\n\r\n"}
+	for i, v := range values {
+		if got := string(v.AppendTo([]byte("prefix"))); got != "prefix"+expect[i] {
+			t.Errorf("AppendTo %d is %q, expect %q", i, got, expect[i])
+		}
+		if got := string(v.ToBytes()); got != expect[i] {
+			t.Errorf("ToBytes %d is %q, expect %q", i, got, expect[i])
+		}
+	}
+}
+
+func TestWriter(t *testing.T) {
+	out := &countingWriter{}
+	w := NewWriter(out)
+	for i := 0; i < 100; i++ {
+		if err := w.Write(NewStringData("PONG")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if out.writes != 0 || w.Buffered() != 700 {
+		t.Fatalf("%d writes and %d bytes buffered before flush", out.writes, w.Buffered())
+	}
+	if err := w.Flush(); err != nil || out.writes != 1 || out.Len() != 700 || w.Buffered() != 0 {
+		t.Fatalf("flush made %d writes of %d bytes: %v", out.writes, out.Len(), err)
+	}
+	if err := w.Flush(); err != nil || out.writes != 1 {
+		t.Error("flushing an empty writer writes")
+	}
+	// a large reply is flushed without waiting for Flush
+	big := NewBulkData([]byte(strings.Repeat("x", WriterFlushSize)))
+	if err := w.Write(big); err != nil || out.writes != 2 || w.Buffered() != 0 {
+		t.Errorf("large reply: %d writes, %d bytes buffered", out.writes, w.Buffered())
+	}
+}
+
+func TestWriterHardLimit(t *testing.T) {
+	out := &countingWriter{}
+	w := NewWriter(out)
+	w.SetLimit(OutputLimit{Hard: 100})
+	if err := w.Write(NewBulkData(make([]byte, 50))); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Write(NewBulkData(make([]byte, 50))); err == nil || !strings.Contains(err.Error(), "hard limit") {
+		t.Fatalf("write over the hard limit: %v", err)
+	}
+	if err := w.Flush(); err == nil || out.writes != 0 || w.Buffered() != 0 {
+		t.Errorf("flush after the hard limit: %v, %d writes", err, out.writes)
+	}
+}
+
+func TestWriterSoftLimit(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	w := NewWriter(server)
+	w.SetLimit(OutputLimit{Soft: 10, SoftTime: 50 * time.Millisecond})
+	// the client doesn't read, so the flush is blocked until the soft time ends
+	start := time.Now()
+	if err := w.Write(NewStringData(strings.Repeat("x", 20))); err != nil {
+		t.Fatal(err)
+	}
+	err := w.Flush()
+	if err == nil || !strings.Contains(err.Error(), "soft limit") || time.Since(start) > time.Second {
+		t.Fatalf("flush to a client not reading: %v after %v", err, time.Since(start))
+	}
+
+	// a client reading its replies is not disconnected
+	server, client = net.Pipe()
+	defer client.Close()
+	go io.Copy(io.Discard, client)
+	w = NewWriter(server)
+	w.SetLimit(OutputLimit{Soft: 10, SoftTime: 50 * time.Millisecond})
+	for i := 0; i < 3; i++ {
+		if err := w.Write(NewStringData(strings.Repeat("x", 20))); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(30 * time.Millisecond)
+	}
+}
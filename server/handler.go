@@ -1,69 +1,175 @@
 package server
 
 import (
+	"easyRedis/config"
 	"easyRedis/logger"
 	"easyRedis/memdb"
 	"easyRedis/resp"
 	"io"
 	"net"
+	"sync/atomic"
+	"time"
 )
 
 // Handler handles all client requests to the server
 // It holds a MemDb instance to exchange data with clients
+// outputLimit is the output buffer limit of the clients, the normal class of client-output-buffer-limit
+// maxClients is the max number of connected clients, and timeout closes a client idle for that long, 0 disables them
 
 type Handler struct {
-	memDb *memdb.MemDb
+	memDb       *memdb.MemDb
+	outputLimit resp.OutputLimit
+	maxClients  int
+	timeout     time.Duration
+	// clients is the number of connected clients
+	clients atomic.Int64
 }
 
 func NewHandler() *Handler {
+	limit := config.Configures.ClientOutputBufferLimits[config.ClientClassNormal]
 	return &Handler{
 		memDb: memdb.NewMemDb(),
+		outputLimit: resp.OutputLimit{
+			Hard:     limit.Hard,
+			Soft:     limit.Soft,
+			SoftTime: time.Duration(limit.SoftSeconds) * time.Second,
+		},
+		maxClients: config.Configures.MaxClients,
+		timeout:    time.Duration(config.Configures.Timeout) * time.Second,
 	}
 }
 
 func (h *Handler) Handle(conn net.Conn) {
+	defer h.clients.Add(-1)
+	if n := h.clients.Add(1); h.maxClients > 0 && n > int64(h.maxClients) {
+		logger.Warning("Rejecting client ", conn.RemoteAddr().String(), ": max number of clients reached")
+		_, _ = conn.Write([]byte("-ERR max number of clients reached\r\n"))
+		if err := conn.Close(); err != nil {
+			logger.Error(err)
+		}
+		return
+	}
+
+	var rd io.Reader = conn
+	if h.timeout > 0 {
+		rd = &idleReader{conn: conn, timeout: h.timeout}
+	}
+	reader := resp.NewReader(rd)
+	client := memdb.NewClient(conn.RemoteAddr().String())
+	client.WatchClose = func() (<-chan struct{}, func()) {
+		return watchClose(conn, reader)
+	}
+	// the replies are buffered while more commands of a pipeline are buffered by the reader
+	writer := resp.NewWriter(conn)
+	writer.SetLimit(h.outputLimit)
 	defer func() {
+		// the client may be gone already, a failed flush is not logged
+		_ = writer.Flush()
 		err := conn.Close()
 		if err != nil {
 			logger.Error(err)
 		}
-		// 关闭timeWheel
-		h.memDb.Stop()
 	}()
 
-	ch := resp.ParseStream(conn)
-	for parseRes := range ch {
-		if parseRes.Err != nil {
-			if parseRes.Err == io.EOF {
+	for {
+		args, err := reader.ReadCommand()
+		if err != nil {
+			if err == io.EOF {
 				logger.Info("Close connection ", conn.RemoteAddr().String())
+			} else if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				logger.Info("Closing idle client ", conn.RemoteAddr().String())
+			} else if err == resp.ErrQueryBufferLimit {
+				logger.Warning("Closing client ", conn.RemoteAddr().String(), " that reached max query buffer length")
 			} else {
-				logger.Panic("Handle connection ", conn.RemoteAddr().String(), "panic: ", parseRes.Err.Error())
+				logger.Error("Handle connection ", conn.RemoteAddr().String(), " error: ", err.Error())
+				// a protocol error is replied before the connection is closed, like redis
+				_ = writer.Write(resp.NewErrorData("ERR " + err.Error()))
 			}
 			return
 		}
-		if parseRes.Data == nil {
-			logger.Error("empty parsedRes.Data from ", conn.RemoteAddr().String())
-			continue
+		res := h.memDb.ExecClientCommand(client, copyCommand(args))
+		if res == nil {
+			res = resp.NewErrorData("unknown error")
 		}
-		arrayData, ok := parseRes.Data.(*resp.ArrayData)
-		if !ok {
-			logger.Error("parsedRes.Data is not ArrayData from ", conn.RemoteAddr().String())
-			continue
+
+		err = writer.Write(res)
+		if err == nil && !reader.Pending() {
+			err = writer.Flush()
+		}
+		if err != nil {
+			logger.Warning("Closing client ", conn.RemoteAddr().String(), ": ", err.Error())
+			return
 		}
-		cmd := arrayData.ToCommand()
-		res := h.memDb.ExecCommand(cmd)
+	}
+}
 
-		if res != nil {
-			_, err := conn.Write(res.ToBytes())
-			if err != nil {
-				logger.Error("Write response to ", conn.RemoteAddr().String(), " error: ", err.Error())
+// watchClose reads from conn while a command of the client is blocked, and closes the returned channel
+// once the connection is closed. The commands sent meanwhile are buffered by reader for later.
+// stop ends the read by moving the read deadline to the past, and waits for it.
+func watchClose(conn net.Conn, reader *resp.Reader) (<-chan struct{}, func()) {
+	closed := make(chan struct{})
+	finished := make(chan struct{})
+	var stopped atomic.Bool
+	go func() {
+		defer close(finished)
+		for !stopped.Load() {
+			err := reader.Fill()
+			if err == nil {
+				continue
+			}
+			// a timeout is either stop or the idle timeout, which doesn't apply to a blocked client
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
 			}
-		} else {
-			errData := resp.NewErrorData("unknown error")
-			_, err := conn.Write(errData.ToBytes())
-			if err != nil {
-				logger.Error("write response to ", conn.RemoteAddr().String(), " error: ", err.Error())
+			if err != resp.ErrQueryBufferLimit {
+				close(closed)
+			}
+			return
+		}
+	}()
+	stop := func() {
+		stopped.Store(true)
+		// the idle reader may push the deadline again before its read, so it is retried until the read ends
+		for {
+			_ = conn.SetReadDeadline(time.Unix(1, 0))
+			select {
+			case <-finished:
+				_ = conn.SetReadDeadline(time.Time{})
+				return
+			case <-time.After(time.Millisecond):
 			}
 		}
 	}
+	return closed, stop
+}
+
+// idleReader reads from a client that is closed once it sends nothing for timeout.
+// The deadline only runs while the handler waits for a command, so a client blocked in a command
+// such as BLPOP is not idle however long it waits. There is no pub/sub yet, so no client is subscribed.
+type idleReader struct {
+	conn    net.Conn
+	timeout time.Duration
+}
+
+func (r *idleReader) Read(p []byte) (int, error) {
+	if err := r.conn.SetReadDeadline(time.Now().Add(r.timeout)); err != nil {
+		return 0, err
+	}
+	return r.conn.Read(p)
+}
+
+// copyCommand copies the arguments out of the buffer of the reader in a single allocation,
+// because the executors may keep them, such as the value of SET
+func copyCommand(args [][]byte) [][]byte {
+	size := 0
+	for _, arg := range args {
+		size += len(arg)
+	}
+	buf := make([]byte, 0, size)
+	cmd := make([][]byte, len(args))
+	for i, arg := range args {
+		buf = append(buf, arg...)
+		cmd[i] = buf[len(buf)-len(arg) : len(buf) : len(buf)]
+	}
+	return cmd
 }
@@ -0,0 +1,271 @@
+package server
+
+import (
+	"bytes"
+	"easyRedis/config"
+	"easyRedis/logger"
+	"easyRedis/memdb"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func init() {
+	config.Configures = &config.Config{ShardNum: 100, LogLevel: "debug", LogDir: "/tmp", SlowlogLogSlowerThan: 10000}
+	if err := logger.Setup(config.Configures); err == nil {
+		logger.Disable()
+	}
+	memdb.RegisterKeyCommands()
+	memdb.RegisterStringCommands()
+	memdb.RegisterSortSetCommands()
+}
+
+// serve starts a handler on a loopback listener and returns a connection to it
+func serve(tb testing.TB) net.Conn {
+	handler := NewHandler()
+	conn, err := net.Dial("tcp", startHandler(tb, handler))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+// startHandler serves handler on a loopback listener with a listen backlog like server.Start, and returns its address
+func startHandler(tb testing.TB, handler *Handler) string {
+	listener, err := listen("127.0.0.1", 0, 16)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go handler.Handle(conn)
+		}
+	}()
+	tb.Cleanup(func() {
+		_ = listener.Close()
+		handler.memDb.Stop()
+	})
+	return listener.Addr().String()
+}
+
+func TestHandlePipeline(t *testing.T) {
+	conn := serve(t)
+	var req bytes.Buffer
+	for i := 0; i < 1000; i++ {
+		req.WriteString("*3\r\n$3\r\nset\r\n$1\r\nk\r\n$" + strconv.Itoa(len(strconv.Itoa(i))) + "\r\n" + strconv.Itoa(i) + "\r\n")
+		req.WriteString("*2\r\n$3\r\nget\r\n$1\r\nk\r\n")
+	}
+	if _, err := conn.Write(req.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	var expect bytes.Buffer
+	for i := 0; i < 1000; i++ {
+		expect.WriteString("+OK\r\n$" + strconv.Itoa(len(strconv.Itoa(i))) + "\r\n" + strconv.Itoa(i) + "\r\n")
+	}
+	got := make([]byte, expect.Len())
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, expect.Bytes()) {
+		t.Error("replies of the pipeline are not in order")
+	}
+}
+
+func TestHandleInline(t *testing.T) {
+	conn := serve(t)
+	// inline commands like printf 'PING\r\n' | nc, mixed with a multibulk one
+	if _, err := conn.Write([]byte("PING\r\nset a 'b c'\n*2\r\n$3\r\nget\r\n$1\r\na\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	expect := "+PONG\r\n+OK\r\n$3\r\nb c\r\n"
+	got := make([]byte, len(expect))
+	if _, err := io.ReadFull(conn, got); err != nil || string(got) != expect {
+		t.Errorf("inline replies %q, %v", got, err)
+	}
+}
+
+func TestMaxClients(t *testing.T) {
+	handler := NewHandler()
+	handler.maxClients = 1
+	addr := startHandler(t, handler)
+	first, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	ping := []byte("PING\r\n")
+	reply := make([]byte, len("+PONG\r\n"))
+	if _, err = first.Write(ping); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = io.ReadFull(first, reply); err != nil || string(reply) != "+PONG\r\n" {
+		t.Fatalf("first client got %q, %v", reply, err)
+	}
+
+	second, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	if got, err := io.ReadAll(second); err != nil || string(got) != "-ERR max number of clients reached\r\n" {
+		t.Fatalf("client over maxclients got %q, %v", got, err)
+	}
+
+	// the slot is released when the first client leaves
+	_ = first.Close()
+	waitClients(t, handler, 0)
+	third, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer third.Close()
+	if _, err = third.Write(ping); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = io.ReadFull(third, reply); err != nil || string(reply) != "+PONG\r\n" {
+		t.Errorf("client after a disconnection got %q, %v", reply, err)
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	handler := NewHandler()
+	handler.timeout = 100 * time.Millisecond
+	conn, err := net.Dial("tcp", startHandler(t, handler))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// a client sending commands more often than the timeout stays connected
+	reply := make([]byte, len("+PONG\r\n"))
+	for i := 0; i < 4; i++ {
+		time.Sleep(50 * time.Millisecond)
+		if _, err = conn.Write([]byte("PING\r\n")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = io.ReadFull(conn, reply); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a client blocked longer than the timeout is not idle
+	if _, err = conn.Write([]byte("BZPOPMIN zset 0.3\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	nilReply := make([]byte, len("*-1\r\n"))
+	if _, err = io.ReadFull(conn, nilReply); err != nil || string(nilReply) != "*-1\r\n" {
+		t.Fatalf("blocked client got %q, %v", nilReply, err)
+	}
+	start := time.Now()
+	if _, err = conn.Read(reply); err != io.EOF || time.Since(start) > time.Second {
+		t.Errorf("idle client is not closed: %v after %v", err, time.Since(start))
+	}
+}
+
+// waitClients waits until handler serves n clients
+func waitClients(t *testing.T, handler *Handler, n int64) {
+	t.Helper()
+	for deadline := time.Now().Add(2 * time.Second); handler.clients.Load() != n; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("handler serves %d clients, expect %d", handler.clients.Load(), n)
+		}
+	}
+}
+
+// request sends a command on conn and checks its reply
+func request(t *testing.T, conn net.Conn, cmd string, expect string) {
+	t.Helper()
+	if _, err := conn.Write([]byte(cmd + "\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	got := make([]byte, len(expect))
+	if _, err := io.ReadFull(conn, got); err != nil || string(got) != expect {
+		t.Fatalf("%s replies %q, %v, expect %q", cmd, got, err, expect)
+	}
+}
+
+func TestBlockedClientClosed(t *testing.T) {
+	handler := NewHandler()
+	addr := startHandler(t, handler)
+	blocked, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = blocked.Write([]byte("BZPOPMIN closed 0\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	_ = blocked.Close()
+	waitClients(t, handler, 0)
+
+	// the element pushed after the blocked client is gone stays in the key
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	request(t, conn, "ZADD closed 1 a", ":1\r\n")
+	request(t, conn, "ZPOPMIN closed", "*2\r\n$1\r\na\r\n$1\r\n1\r\n")
+}
+
+func TestBlockedClientPipeline(t *testing.T) {
+	addr := startHandler(t, NewHandler())
+	blocked, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer blocked.Close()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// the command sent while the client is blocked is replied after the blocking one
+	if _, err = blocked.Write([]byte("BZPOPMIN pipeline 0\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if _, err = blocked.Write([]byte("PING\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	request(t, conn, "ZADD pipeline 1 a", ":1\r\n")
+	expect := "*3\r\n$8\r\npipeline\r\n$1\r\na\r\n$1\r\n1\r\n+PONG\r\n"
+	got := make([]byte, len(expect))
+	if _, err = io.ReadFull(blocked, got); err != nil || string(got) != expect {
+		t.Errorf("blocked client got %q, %v", got, err)
+	}
+}
+
+// BenchmarkHandlePipeline sends GETs in pipelines of different sizes like redis-benchmark -P
+func BenchmarkHandlePipeline(b *testing.B) {
+	for _, pipeline := range []int{1, 16, 128} {
+		b.Run("P"+strconv.Itoa(pipeline), func(b *testing.B) {
+			conn := serve(b)
+			if _, err := conn.Write([]byte("*3\r\n$3\r\nset\r\n$3\r\nkey\r\n$3\r\nval\r\n")); err != nil {
+				b.Fatal(err)
+			}
+			reply := make([]byte, 5)
+			if _, err := io.ReadFull(conn, reply); err != nil {
+				b.Fatal(err)
+			}
+			req := bytes.Repeat([]byte("*2\r\n$3\r\nget\r\n$3\r\nkey\r\n"), pipeline)
+			reply = make([]byte, len("$3\r\nval\r\n")*pipeline)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for sent := 0; sent < b.N; sent += pipeline {
+				if _, err := conn.Write(req); err != nil {
+					b.Fatal(err)
+				}
+				if _, err := io.ReadFull(conn, reply); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,17 @@
+//go:build !unix
+
+package server
+
+import (
+	"easyRedis/logger"
+	"net"
+	"strconv"
+)
+
+// listen listens on a TCP address, the backlog of pending connections is the system default on this platform
+func listen(host string, port int, backlog int) (net.Listener, error) {
+	if backlog > 0 {
+		logger.Warning("tcp-backlog is not supported on this platform, the system default is used")
+	}
+	return net.Listen("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+}
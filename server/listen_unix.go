@@ -0,0 +1,67 @@
+//go:build unix
+
+package server
+
+import (
+	"easyRedis/logger"
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// listen listens on a TCP address with the given backlog of pending connections, which net.Listen
+// doesn't allow to set. The kernel caps the backlog to somaxconn. A backlog <= 0 uses the system default,
+// and so does a listener that can't be made with the backlog, like one on a host name.
+func listen(host string, port int, backlog int) (net.Listener, error) {
+	address := net.JoinHostPort(host, strconv.Itoa(port))
+	if backlog <= 0 {
+		return net.Listen("tcp", address)
+	}
+	listener, err := listenBacklog(host, port, backlog)
+	if err != nil {
+		logger.Warning("Listening with tcp-backlog ", backlog, " on ", address, " failed, the system default is used: ", err.Error())
+		return net.Listen("tcp", address)
+	}
+	return listener, nil
+}
+
+// listenBacklog makes the listening socket with the syscalls, host must be an ip address
+func listenBacklog(host string, port int, backlog int) (net.Listener, error) {
+	address := net.JoinHostPort(host, strconv.Itoa(port))
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, &net.AddrError{Err: "invalid ip address", Addr: host}
+	}
+	var family int
+	var sa syscall.Sockaddr
+	if ip4 := ip.To4(); ip4 != nil {
+		addr := &syscall.SockaddrInet4{Port: port}
+		copy(addr.Addr[:], ip4)
+		family, sa = syscall.AF_INET, addr
+	} else {
+		addr := &syscall.SockaddrInet6{Port: port}
+		copy(addr.Addr[:], ip)
+		family, sa = syscall.AF_INET6, addr
+	}
+
+	fd, err := syscall.Socket(family, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	syscall.CloseOnExec(fd)
+	// the same options as net.Listen
+	if err = syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1); err == nil {
+		if err = syscall.Bind(fd, sa); err == nil {
+			err = syscall.Listen(fd, backlog)
+		}
+	}
+	if err != nil {
+		_ = syscall.Close(fd)
+		return nil, &net.OpError{Op: "listen", Net: "tcp", Err: err}
+	}
+	// FileListener dups the socket, the file is closed in any case
+	file := os.NewFile(uintptr(fd), address)
+	defer file.Close()
+	return net.FileListener(file)
+}
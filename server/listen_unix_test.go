@@ -0,0 +1,60 @@
+//go:build unix
+
+package server
+
+import (
+	"net"
+	"testing"
+)
+
+// acceptOne checks that listener accepts a connection
+func acceptOne(t *testing.T, listener net.Listener) {
+	t.Helper()
+	defer listener.Close()
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	accepted, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = accepted.Close()
+}
+
+func TestListenBacklog(t *testing.T) {
+	for _, host := range []string{"127.0.0.1", "::1"} {
+		listener, err := listenBacklog(host, 0, 16)
+		if err != nil {
+			if host == "::1" {
+				t.Logf("no ipv6 loopback: %v", err)
+				continue
+			}
+			t.Fatal(err)
+		}
+		acceptOne(t, listener)
+	}
+}
+
+func TestListenFallback(t *testing.T) {
+	// a host name can't be bound with the backlog, net.Listen resolves it
+	if _, err := listenBacklog("localhost", 0, 16); err == nil {
+		t.Fatal("listenBacklog on a host name should fail")
+	}
+	listener, err := listen("localhost", 0, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	acceptOne(t, listener)
+
+	// a port in use is still an error
+	used, err := listen("127.0.0.1", 0, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer used.Close()
+	if _, err = listen("127.0.0.1", used.Addr().(*net.TCPAddr).Port, 16); err == nil {
+		t.Error("listen on a port in use should fail")
+	}
+}
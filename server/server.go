@@ -2,17 +2,21 @@ package server
 
 import (
 	"easyRedis/config"
+	"easyRedis/crdt"
+	"easyRedis/datastructure"
 	"easyRedis/logger"
+	"easyRedis/resp"
 	"log"
 	"net"
-	"strconv"
+	"path/filepath"
 	"sync"
+	"time"
 )
 
 // Start starts a simple redis server
 func Start(cfg *config.Config) error {
 
-	listener, err := net.Listen("tcp", cfg.Host+":"+strconv.Itoa(cfg.Port))
+	listener, err := listen(cfg.Host, cfg.Port, cfg.TCPBacklog)
 	if err != nil {
 		log.Panicln(err)
 		return err
@@ -26,8 +30,34 @@ func Start(cfg *config.Config) error {
 
 	logger.Info("server listen at ", cfg.Host, ":", cfg.Port)
 
+	datastructure.Limits = datastructure.EncodingLimits{
+		HashMaxListpackEntries: cfg.HashMaxListpackEntries,
+		HashMaxListpackValue:   cfg.HashMaxListpackValue,
+		SetMaxIntsetEntries:    cfg.SetMaxIntsetEntries,
+		SetMaxListpackEntries:  cfg.SetMaxListpackEntries,
+		SetMaxListpackValue:    cfg.SetMaxListpackValue,
+		ZSetMaxListpackEntries: cfg.ZSetMaxListpackEntries,
+		ZSetMaxListpackValue:   cfg.ZSetMaxListpackValue,
+		ListMaxListpackSize:    cfg.ListMaxListpackSize,
+		ListCompressDepth:      cfg.ListCompressDepth,
+	}
+	resp.ProtoMaxBulkLen = cfg.ProtoMaxBulkLen
+	resp.QueryBufferLimit = cfg.ClientQueryBufferLimit
+
 	var wg sync.WaitGroup
 	handler := NewHandler()
+	if err = handler.memDb.LoadFunctions(filepath.Join(cfg.Dir, "functions.dump")); err != nil {
+		logger.Error(err)
+		return err
+	}
+	if cfg.ActiveActive {
+		peers := make([]crdt.Peer, 0, len(cfg.Peers))
+		for _, addr := range cfg.Peers {
+			peers = append(peers, crdt.NewTCPPeer(addr, cfg.NodeID))
+		}
+		handler.memDb.EnableActiveActive(cfg.NodeID, peers...)
+		logger.Info("active-active mode enabled, node ", cfg.NodeID, ", peers ", cfg.Peers)
+	}
 
 	for {
 		conn, err := listener.Accept()
@@ -36,6 +66,7 @@ func Start(cfg *config.Config) error {
 			break
 		}
 		logger.Info(conn.RemoteAddr().String(), " connected")
+		setKeepAlive(conn, cfg.TCPKeepAlive)
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
@@ -43,5 +74,24 @@ func Start(cfg *config.Config) error {
 		}()
 	}
 	wg.Wait()
+	handler.memDb.Stop()
 	return nil
 }
+
+// setKeepAlive sends TCP keepalive probes on an idle connection every period seconds,
+// so that a dead peer is detected. 0 disables them.
+func setKeepAlive(conn net.Conn, period int) {
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return
+	}
+	if period <= 0 {
+		_ = tcpConn.SetKeepAlive(false)
+		return
+	}
+	if err := tcpConn.SetKeepAlive(true); err != nil {
+		logger.Warning("set keepalive of ", conn.RemoteAddr().String(), " error: ", err.Error())
+		return
+	}
+	_ = tcpConn.SetKeepAlivePeriod(time.Duration(period) * time.Second)
+}